@@ -3,9 +3,10 @@ package alicloud
 import (
 	"context"
 	"github.com/prometheus/client_golang/prometheus"
-	"github.com/prometheus/client_golang/prometheus/promhttp"
 	log "github.com/sirupsen/logrus"
+	"github.wdf.sap.corp/DBaaS/cloud-provider-exporter/pkg/cloud/objectstore"
 	"github.wdf.sap.corp/DBaaS/cloud-provider-exporter/pkg/config"
+	"github.wdf.sap.corp/DBaaS/cloud-provider-exporter/pkg/constant"
 	"github.wdf.sap.corp/DBaaS/cloud-provider-exporter/pkg/vault"
 	"net/http"
 )
@@ -13,6 +14,7 @@ import (
 type AliExporter struct {
 	quotaCollector  *MetricsCollectorAliQuota
 	healthCollector *MetricsCollectorAliHealth
+	bucketCollector *MetricsCollectorAliVaultBucket
 }
 
 func (e *AliExporter) StartExporter(ctx context.Context, config *config.Config, credential vault.CloudCredentials, logger log.FieldLogger) {
@@ -20,19 +22,33 @@ func (e *AliExporter) StartExporter(ctx context.Context, config *config.Config,
 	prometheus.MustRegister(e.quotaCollector)
 	e.healthCollector = NewMetricsCollectorAliHealth(config, credential, logger)
 	prometheus.MustRegister(e.healthCollector)
-	//http.HandleFunc(constant.VaultMonitorPath, func(w http.ResponseWriter, r *http.Request) {
-	//	vaultBackupMonitorHandler(w, r, config, credential)
-	//})
+	go e.healthCollector.Run(ctx)
+	if vaultBucketConfigured(config) {
+		e.bucketCollector = NewMetricsCollectorAliVaultBucket(config, credential)
+		e.registerVaultBucket(http.DefaultServeMux)
+	}
 }
 
-func (e *AliExporter) Scrape(ctx context.Context) {
-	e.quotaCollector.scrape(ctx)
+// vaultBucketConfigured is true if object store targets or buckets of accounts are configured
+func vaultBucketConfigured(config *config.Config) bool {
+	if len(objectstore.Targets(config)) > 0 {
+		return true
+	}
+	if config.AliCloud != nil {
+		for _, account := range config.AliCloud.Accounts {
+			if account.VaultBackupBucket != nil {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// registerVaultBucket mounts the metrics of the object store targets
+func (e *AliExporter) registerVaultBucket(mux *http.ServeMux) {
+	mux.Handle(constant.VaultMonitorPath, objectstore.Handler(e.bucketCollector))
 }
 
-func vaultBackupMonitorHandler(w http.ResponseWriter, r *http.Request, config *config.Config, credential vault.CloudCredentials) {
-	vaultBucketCollector := NewMetricsCollectorAliVaultBucket(config, credential)
-	registry := prometheus.NewRegistry()
-	registry.MustRegister(vaultBucketCollector)
-	h := promhttp.HandlerFor(registry, promhttp.HandlerOpts{})
-	h.ServeHTTP(w, r)
+func (e *AliExporter) Scrape(ctx context.Context) {
+	e.quotaCollector.scrape(ctx)
 }
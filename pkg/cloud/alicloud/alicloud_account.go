@@ -0,0 +1,174 @@
+package alicloud
+
+import (
+	"github.com/aliyun/alibaba-cloud-sdk-go/sdk/auth"
+	"github.com/aliyun/alibaba-cloud-sdk-go/sdk/auth/credentials"
+	"github.com/aliyun/alibaba-cloud-sdk-go/sdk/requests"
+	"github.com/aliyun/alibaba-cloud-sdk-go/services/sts"
+	"github.com/aliyun/aliyun-oss-go-sdk/oss"
+	log "github.com/sirupsen/logrus"
+	"github.wdf.sap.corp/DBaaS/cloud-provider-exporter/pkg/cloud/objectstore"
+	"github.wdf.sap.corp/DBaaS/cloud-provider-exporter/pkg/config"
+	"github.wdf.sap.corp/DBaaS/cloud-provider-exporter/pkg/vault"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	roleSessionName = "cloud-provider-exporter"
+	// the SDK only accepts role sessions between 15 minutes and an hour
+	minSessionDuration     = 900
+	maxSessionDuration     = 3600
+	credentialRefreshScale = 0.8
+)
+
+// StsClient Mock sts.Client for test
+type StsClient interface {
+	AssumeRole(request *sts.AssumeRoleRequest) (response *sts.AssumeRoleResponse, err error)
+}
+
+// Account is an Alibaba Cloud account the collectors read from, either the one of the access key
+// or one reached by assuming a RAM role.
+type Account struct {
+	ID         string
+	RoleArn    string
+	Buckets    []*config.VaultBackupBucketConfig // the object store targets monitored in the account
+	Credential auth.Credential
+
+	accessKeyID     string
+	accessKeySecret string
+	duration        int
+}
+
+// Accounts returns the configured accounts, or the account of the access key if none are configured.
+func Accounts(conf *config.Config, cred vault.CloudCredentials, logger log.FieldLogger) []*Account {
+	var accountConfigs []config.AliCloudAccountConfig
+	duration := maxSessionDuration
+	if conf.AliCloud != nil {
+		accountConfigs = conf.AliCloud.Accounts
+		if len(accountConfigs) == 0 {
+			accountConfigs = []config.AliCloudAccountConfig{{AccountID: conf.AliCloud.AccountID, RoleArn: conf.AliCloud.RoleArn}}
+		}
+		if d := int(conf.AliCloud.SessionDuration); d != 0 {
+			if d < minSessionDuration || d > maxSessionDuration {
+				logger.Errorf("Session duration %d is out of range [%d, %d], using %d", d, minSessionDuration, maxSessionDuration, maxSessionDuration)
+			} else {
+				duration = d
+			}
+		}
+	} else {
+		accountConfigs = []config.AliCloudAccountConfig{{}}
+	}
+
+	accounts := make([]*Account, 0, len(accountConfigs))
+	for _, c := range accountConfigs {
+		a := &Account{
+			ID:              c.AccountID,
+			RoleArn:         c.RoleArn,
+			accessKeyID:     cred[vault.AliCloudAccessKeyID],
+			accessKeySecret: cred[vault.AliCloudSecretAccessKey],
+			duration:        duration,
+		}
+		if a.ID == "" {
+			a.ID = accountIDOfRole(c.RoleArn)
+		}
+		if c.VaultBackupBucket != nil {
+			a.Buckets = []*config.VaultBackupBucketConfig{c.VaultBackupBucket}
+		} else {
+			a.Buckets = objectstore.Targets(conf)
+		}
+		if a.RoleArn != "" {
+			// the SDK signer assumes the role again before the session expires
+			a.Credential = credentials.NewRamRoleArnCredential(a.accessKeyID, a.accessKeySecret, a.RoleArn, roleSessionName, duration)
+		} else {
+			a.Credential = credentials.NewAccessKeyCredential(a.accessKeyID, a.accessKeySecret)
+		}
+		accounts = append(accounts, a)
+	}
+	return accounts
+}
+
+// accountIDOfRole returns the account of a role ARN like acs:ram::<account>:role/<name>.
+func accountIDOfRole(roleArn string) string {
+	parts := strings.Split(roleArn, ":")
+	if len(parts) != 5 || parts[0] != "acs" || parts[1] != "ram" {
+		return ""
+	}
+	return parts[3]
+}
+
+// OssOptions returns the OSS client options authenticating as the account, the access key is replaced by
+// a provider of role credentials when a role is assumed.
+func (a *Account) OssOptions(region string, logger log.FieldLogger) ([]oss.ClientOption, error) {
+	if a.RoleArn == "" {
+		return nil, nil
+	}
+	client, err := sts.NewClientWithAccessKey(region, a.accessKeyID, a.accessKeySecret)
+	if err != nil {
+		return nil, err
+	}
+	return []oss.ClientOption{oss.SetCredentialsProvider(&roleCredentialsProvider{client: client, account: a, log: logger})}, nil
+}
+
+type roleCredentials struct {
+	accessKeyID     string
+	accessKeySecret string
+	securityToken   string
+}
+
+func (c *roleCredentials) GetAccessKeyID() string {
+	return c.accessKeyID
+}
+
+func (c *roleCredentials) GetAccessKeySecret() string {
+	return c.accessKeySecret
+}
+
+func (c *roleCredentials) GetSecurityToken() string {
+	return c.securityToken
+}
+
+// roleCredentialsProvider hands out the credentials of an assumed role to the OSS client, and assumes
+// the role again once most of the session has passed.
+type roleCredentialsProvider struct {
+	client  StsClient
+	account *Account
+	log     log.FieldLogger
+
+	mu          sync.Mutex
+	credentials *roleCredentials
+	refreshAt   time.Time
+}
+
+func (p *roleCredentialsProvider) GetCredentials() oss.Credentials {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.credentials != nil && time.Now().Before(p.refreshAt) {
+		return p.credentials
+	}
+
+	r := sts.CreateAssumeRoleRequest()
+	r.RoleArn = p.account.RoleArn
+	r.RoleSessionName = roleSessionName
+	r.DurationSeconds = requests.NewInteger(p.account.duration)
+	response, err := p.client.AssumeRole(r)
+	if err != nil {
+		p.log.Errorf("Error while assuming role %s: %v", p.account.RoleArn, err)
+		if p.credentials == nil {
+			return &roleCredentials{}
+		}
+		return p.credentials
+	}
+	p.credentials = &roleCredentials{
+		accessKeyID:     response.Credentials.AccessKeyId,
+		accessKeySecret: response.Credentials.AccessKeySecret,
+		securityToken:   response.Credentials.SecurityToken,
+	}
+	lifetime := time.Duration(p.account.duration) * time.Second
+	if expiration, err := time.Parse(time.RFC3339, response.Credentials.Expiration); err == nil {
+		lifetime = time.Until(expiration)
+	}
+	p.refreshAt = time.Now().Add(time.Duration(float64(lifetime) * credentialRefreshScale))
+	return p.credentials
+}
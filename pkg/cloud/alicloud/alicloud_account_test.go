@@ -0,0 +1,83 @@
+package alicloud
+
+import (
+	"errors"
+	"github.com/aliyun/alibaba-cloud-sdk-go/sdk/auth/credentials"
+	"github.com/aliyun/alibaba-cloud-sdk-go/services/sts"
+	log "github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.wdf.sap.corp/DBaaS/cloud-provider-exporter/pkg/config"
+	"github.wdf.sap.corp/DBaaS/cloud-provider-exporter/pkg/vault"
+	"testing"
+	"time"
+)
+
+type MockStsClient struct {
+	calls int
+	err   error
+}
+
+func (m *MockStsClient) AssumeRole(request *sts.AssumeRoleRequest) (response *sts.AssumeRoleResponse, err error) {
+	m.calls++
+	if m.err != nil {
+		return nil, m.err
+	}
+	return &sts.AssumeRoleResponse{Credentials: sts.Credentials{
+		AccessKeyId:     "STS.key" + string(rune('0'+m.calls)),
+		AccessKeySecret: "secret",
+		SecurityToken:   "token",
+		Expiration:      time.Now().Add(time.Hour).UTC().Format(time.RFC3339),
+	}}, nil
+}
+
+func TestAlicloudAccounts(t *testing.T) {
+	cred := vault.CloudCredentials{
+		vault.AliCloudAccessKeyID:     "111",
+		vault.AliCloudSecretAccessKey: "222",
+	}
+	accounts := Accounts(&config.Config{}, cred, &log.Logger{})
+	assert.Len(t, accounts, 1)
+	assert.IsType(t, &credentials.AccessKeyCredential{}, accounts[0].Credential)
+
+	conf := &config.Config{AliCloud: &config.AliCloudConfig{
+		SessionDuration: 7200,
+		Accounts: []config.AliCloudAccountConfig{
+			{RoleArn: "acs:ram::1234567890123456:role/exporter"},
+			{AccountID: "other", RoleArn: "acs:ram::6543210987654321:role/exporter"},
+		},
+	}}
+	accounts = Accounts(conf, cred, &log.Logger{})
+	assert.Len(t, accounts, 2)
+	assert.Equal(t, "1234567890123456", accounts[0].ID)
+	assert.Equal(t, "other", accounts[1].ID)
+	credential := accounts[0].Credential.(*credentials.RamRoleArnCredential)
+	assert.Equal(t, "acs:ram::1234567890123456:role/exporter", credential.RoleArn)
+	assert.Equal(t, maxSessionDuration, credential.RoleSessionExpiration)
+
+	assert.Equal(t, "", accountIDOfRole("arn:aws:iam::123:role/exporter"))
+
+	// only an assumed role replaces the access key of the OSS client
+	options, err := accounts[0].OssOptions("cn-shanghai", &log.Logger{})
+	assert.NoError(t, err)
+	assert.Len(t, options, 1)
+	options, err = Accounts(&config.Config{}, cred, &log.Logger{})[0].OssOptions("cn-shanghai", &log.Logger{})
+	assert.NoError(t, err)
+	assert.Nil(t, options)
+}
+
+func TestAlicloudRoleCredentialsProvider(t *testing.T) {
+	client := &MockStsClient{}
+	provider := &roleCredentialsProvider{client: client, account: &Account{RoleArn: "acs:ram::123:role/exporter", duration: 3600}, log: &log.Logger{}}
+
+	assert.Equal(t, "STS.key1", provider.GetCredentials().GetAccessKeyID())
+	assert.Equal(t, "token", provider.GetCredentials().GetSecurityToken())
+	assert.Equal(t, 1, client.calls)
+
+	// the role is assumed again before the session expires, and the last credentials are kept on errors
+	provider.refreshAt = time.Now().Add(-time.Second)
+	assert.Equal(t, "STS.key2", provider.GetCredentials().GetAccessKeyID())
+	provider.refreshAt = time.Now().Add(-time.Second)
+	client.err = errors.New("denied")
+	assert.Equal(t, "STS.key2", provider.GetCredentials().GetAccessKeyID())
+	assert.Equal(t, 3, client.calls)
+}
@@ -1,95 +1,246 @@
 package alicloud
 
 import (
+	"context"
 	"encoding/json"
+	"fmt"
 	"github.com/prometheus/client_golang/prometheus"
 	log "github.com/sirupsen/logrus"
 	"github.wdf.sap.corp/DBaaS/cloud-provider-exporter/pkg/cloud/common"
 	"github.wdf.sap.corp/DBaaS/cloud-provider-exporter/pkg/config"
+	"github.wdf.sap.corp/DBaaS/cloud-provider-exporter/pkg/constant"
 	"github.wdf.sap.corp/DBaaS/cloud-provider-exporter/pkg/vault"
-	"io"
-	"io/ioutil"
 	"net/http"
+	"net/url"
+	"strings"
 	"time"
 )
 
+const (
+	statusEventURL      = "https://status.aliyun.com/api/status/listProductEventForRegionInLast24Hours"
+	defaultHealthWindow = 24 * time.Hour
+	// statusEventCategory is the category of all events, the status API has no event types
+	statusEventCategory = "ProductEvent"
+)
+
+// closedStates are the event states and severities telling that an event is over.
+var closedStates = map[string]struct{}{
+	"RESOLVED":  {},
+	"RECOVERED": {},
+	"FINISHED":  {},
+	"CLOSED":    {},
+	"NORMAL":    {},
+}
+
+// StatusClient Mock status.aliyun.com for test
+type StatusClient interface {
+	ListProductEvents(ctx context.Context, region string) ([]StatusEvent, error)
+}
+
+type statusEventList struct {
+	Success bool          `json:"success"`
+	Code    int           `json:"code"`
+	Info    string        `json:"info"`
+	Data    []StatusEvent `json:"data"`
+}
+
+type StatusEvent struct {
+	EventID              string `json:"eventId"`
+	ProductID            string `json:"productId"`
+	RegionID             string `json:"regionId"`
+	Title                string `json:"title"`
+	CurrentState         string `json:"currentState"`
+	CurrentStateSeverity string `json:"currentStateSeverity"`
+	// StartTime and EndTime are unix seconds, EndTime is 0 while the event lasts
+	StartTime int64 `json:"startTime"`
+	EndTime   int64 `json:"endTime"`
+}
+
+type StatusClientWrapper struct {
+	client *http.Client
+	url    string
+}
+
+func (cw *StatusClientWrapper) ListProductEvents(ctx context.Context, region string) ([]StatusEvent, error) {
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, cw.url+"?regionId="+url.QueryEscape(region), nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := cw.client.Do(request)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %d while listing events of %s", resp.StatusCode, region)
+	}
+	list := &statusEventList{}
+	if err = json.NewDecoder(resp.Body).Decode(list); err != nil {
+		return nil, err
+	}
+	if !list.Success {
+		return nil, fmt.Errorf("listing events of %s failed with code %d: %s", region, list.Code, list.Info)
+	}
+	return list.Data, nil
+}
+
+func NewStatusClientWrapper() *StatusClientWrapper {
+	return &StatusClientWrapper{client: http.DefaultClient, url: statusEventURL}
+}
+
+// MetricsCollectorAliHealth reads the public status page. Its events are the same for every account, so they are
+// fetched once and exported for each of the configured accounts, like the quota and bucket metrics.
 type MetricsCollectorAliHealth struct {
-	conf *config.Config
-	cred vault.CloudCredentials
+	conf       *config.Config
+	client     StatusClient
+	accountIDs []string
+	regions    []string
+	window     time.Duration
+	poller     *common.HealthPoller
+	log        log.FieldLogger
 }
 
 func NewMetricsCollectorAliHealth(config *config.Config, cred vault.CloudCredentials, logger log.FieldLogger) *MetricsCollectorAliHealth {
-
 	m := &MetricsCollectorAliHealth{}
 	m.conf = config
-	m.cred = cred
+	m.log = logger
+	m.client = NewStatusClientWrapper()
+	for _, account := range Accounts(config, cred, logger) {
+		m.accountIDs = append(m.accountIDs, account.ID)
+	}
+	m.regions = []string{config.Region}
+	m.window = defaultHealthWindow
+	if config.AliCloud != nil {
+		if len(config.AliCloud.HealthRegions) > 0 {
+			m.regions = config.AliCloud.HealthRegions
+		}
+		if config.AliCloud.HealthWindow > 0 {
+			m.window = time.Duration(config.AliCloud.HealthWindow) * time.Hour
+		}
+	}
 
 	eventLabel := []string{
 		"eventID",
+		"title",
+		"cloudService",
+		"eventRegion",
 		"startTime",
+		"endTime",
 		"lastUpdatedTime",
+		"status",
 		"level",
+		constant.LabelAccountID,
 	}
 
 	entityLabel := []string{
 		"eventID",
 		"affectedService",
 		"affectedRegions",
+		constant.LabelAccountID,
 	}
-	eventOpenTotalLabel := []string{"eventType"}
-	eventCloseTotalLabel := []string{"eventType"}
+	// the severity changes while an event lasts, the counters are by product so an event opens and closes the same series
+	eventOpenTotalLabel := []string{"cloudService", constant.LabelAccountID}
+	eventCloseTotalLabel := []string{"cloudService", constant.LabelAccountID}
 
 	common.InitHealthCounterVec("AliCloud", eventLabel, entityLabel, eventOpenTotalLabel, eventCloseTotalLabel)
+	m.poller = common.NewHealthPoller(m, config, logger)
 	return m
 }
 
 func (m *MetricsCollectorAliHealth) Describe(ch chan<- *prometheus.Desc) {
-	common.HealthEvent.Describe(ch)
-	common.AffectedEntity.Describe(ch)
+	m.poller.Describe(ch)
 }
 
 func (m *MetricsCollectorAliHealth) Collect(ch chan<- prometheus.Metric) {
-	eventUrl := "https://status.aliyun.com/api/status/listProductEventForRegionInLast24Hours?regionId="
-	region := m.conf.Region
-
-	eventUrl += region
-	eventResp, err := http.Get(eventUrl)
+	m.poller.Collect(ch)
+}
 
-	if err != nil {
-		log.Fatal("An error occurred sending http request:", err)
-	}
+// Run polls the AliCloud status API in the background until ctx is cancelled.
+func (m *MetricsCollectorAliHealth) Run(ctx context.Context) {
+	m.poller.Run(ctx)
+}
 
-	defer func(Body io.ReadCloser) {
-		err := Body.Close()
+// FetchEvents lists the events of every configured region. The status API only serves the last 24 hours,
+// so it is queried in full and the events that ended before the window are dropped; a window longer than
+// a day is built up from the events the poller keeps between polls.
+func (m *MetricsCollectorAliHealth) FetchEvents(ctx context.Context, since time.Time) ([]*common.HealthEventRecord, error) {
+	now := time.Now()
+	records := make([]*common.HealthEventRecord, 0)
+	for _, region := range m.regions {
+		events, err := m.client.ListProductEvents(ctx, region)
 		if err != nil {
-			log.Errorf("An error occurred: %v", err)
+			return nil, err
 		}
-	}(eventResp.Body)
-
-	eventRespBody, err := ioutil.ReadAll(eventResp.Body)
+		for _, event := range events {
+			for _, accountID := range m.accountIDs {
+				record := newEventRecord(event, region, accountID, now)
+				if record.Closed && record.EndTime.Before(now.Add(-m.window)) {
+					continue
+				}
+				records = append(records, record)
+			}
+		}
+	}
+	return records, nil
+}
 
-	if err != nil {
-		log.Fatal("An error occurred reading response body", err)
+func newEventRecord(event StatusEvent, region, accountID string, now time.Time) *common.HealthEventRecord {
+	if event.RegionID != "" {
+		region = event.RegionID
+	}
+	eventID := event.EventID
+	if eventID == "" {
+		// the status API has no ID for most events, product, region and start identify them
+		eventID = fmt.Sprintf("%s-%s-%d", event.ProductID, region, event.StartTime)
+	}
+	startTime := time.Unix(event.StartTime, 0).UTC()
+	var endTime time.Time
+	if event.EndTime > 0 {
+		endTime = time.Unix(event.EndTime, 0).UTC()
 	}
 
-	eventResults := make(map[string]interface{})
-	err = json.Unmarshal(eventRespBody, &eventResults)
-	if err != nil {
-		log.Fatal("An error occurred during unmarshal response body", err)
-	}
-
-	if eventResults["success"] == true {
-		for _, result := range eventResults["data"].([]interface{}) {
-			productId := result.(map[string]interface{})["productId"]
-			title := result.(map[string]interface{})["title"]
-			currentStateSeverity := result.(map[string]interface{})["currentStateSeverity"]
-			startTime := time.Unix(int64(result.(map[string]interface{})["startTime"].(float64)), 0).UTC()
-			endTime := time.Unix(int64(result.(map[string]interface{})["endTime"].(float64)), 0).UTC()
-			common.HealthEvent.WithLabelValues(title.(string), startTime.String(), endTime.String(), currentStateSeverity.(string)).Inc()
-			common.AffectedEntity.WithLabelValues(title.(string), productId.(string), region).Inc()
+	closed := false
+	state := strings.ToUpper(event.CurrentState)
+	if state == "" {
+		state = strings.ToUpper(event.CurrentStateSeverity)
+	}
+	if _, ok := closedStates[state]; ok {
+		closed = true
+	} else if event.CurrentState == "" && !endTime.IsZero() && !endTime.After(now) {
+		closed = true
+	}
+	status := "open"
+	lastUpdated := startTime
+	if closed {
+		status = "closed"
+		if !endTime.IsZero() {
+			lastUpdated = endTime
 		}
 	}
+	endLabel := ""
+	if !endTime.IsZero() {
+		endLabel = endTime.String()
+	}
 
-	common.HealthEvent.Collect(ch)
-	common.AffectedEntity.Collect(ch)
+	// the records of the accounts are kept apart by the poller
+	id := eventID
+	if accountID != "" {
+		id = accountID + "/" + eventID
+	}
+	return &common.HealthEventRecord{
+		ID:              id,
+		SourceID:        eventID,
+		Service:         event.ProductID,
+		Category:        statusEventCategory,
+		Closed:          closed,
+		StartTime:       startTime,
+		EndTime:         endTime,
+		LastUpdatedTime: lastUpdated,
+		Services:        []string{event.ProductID},
+		Regions:         []string{region},
+		EventLabels: []string{eventID, event.Title, event.ProductID, region, startTime.String(), endLabel, lastUpdated.String(),
+			status, event.CurrentStateSeverity, accountID},
+		CounterLabels: []string{event.ProductID, accountID},
+		Entities:      [][]string{{eventID, event.ProductID, region, accountID}},
+	}
 }
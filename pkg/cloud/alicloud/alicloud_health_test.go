@@ -1,6 +1,8 @@
 package alicloud
 
 import (
+	"context"
+	"fmt"
 	"github.com/jarcoal/httpmock"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
@@ -10,9 +12,12 @@ import (
 	"github.wdf.sap.corp/DBaaS/cloud-provider-exporter/pkg/vault"
 	"net/http"
 	"testing"
+	"time"
 )
 
-var events = "{\n  \"data\": [\n    {\n      \"productId\": \"dummyProduct\",\n      \"title\": \"dummyTitile\",\n      \"currentStateSeverity\": \"ALARM\",\n      \"startTime\": 1665735021,\n      \"endTime\": 1665735058\n    },\n    {\n      \"productId\": \"dummyProduct2\",\n      \"title\": \"dummyTitile2\",\n      \"currentStateSeverity\": \"NOTIFICATION\",\n      \"startTime\": 1665734921,\n      \"endTime\": 1665735058\n    }\n  ],\n  \"total\": 0,\n  \"info\": \"成功处理\",\n  \"code\": 200,\n  \"success\": true,\n  \"httpCode\": 200,\n  \"requestId\": null\n}"
+var events = "{\n  \"data\": [\n    {\n      \"productId\": \"dummyProduct\",\n      \"title\": \"dummyTitile\",\n      \"currentStateSeverity\": \"ALARM\",\n      \"startTime\": %d,\n      \"endTime\": 0\n    },\n    {\n      \"productId\": \"dummyProduct2\",\n      \"title\": \"dummyTitile2\",\n      \"currentStateSeverity\": \"NOTIFICATION\",\n      \"startTime\": %d,\n      \"endTime\": %d\n    },\n    {\n      \"productId\": \"dummyProduct3\",\n      \"title\": \"dummyTitile3\",\n      \"currentStateSeverity\": \"NOTIFICATION\",\n      \"startTime\": %d,\n      \"endTime\": %d\n    }\n  ],\n  \"total\": 0,\n  \"info\": \"成功处理\",\n  \"code\": 200,\n  \"success\": true,\n  \"httpCode\": 200,\n  \"requestId\": null\n}"
+
+var failure = "{\"data\": null, \"info\": \"invalid region\", \"code\": 400, \"success\": false}"
 
 func TestAlicloudHealth(t *testing.T) {
 	uri := "metrics"
@@ -29,17 +34,114 @@ func TestAlicloudHealth(t *testing.T) {
 		Region:            "cn-shanghai",
 	}
 
+	now := time.Now().Truncate(time.Second)
+	opened := now.Add(-time.Hour)
+	closed := now.Add(-2 * time.Hour)
+	expired := now.Add(-48 * time.Hour)
+	body := fmt.Sprintf(events, opened.Unix(), closed.Unix(), closed.Add(time.Minute).Unix(), expired.Unix(), expired.Add(time.Minute).Unix())
+
 	httpmock.Activate()
-	responderGetMetrics := httpmock.NewStringResponder(http.StatusOK, events)
+	defer httpmock.DeactivateAndReset()
+	responderGetMetrics := httpmock.NewStringResponder(http.StatusOK, body)
 	httpmock.RegisterResponder("GET", "https://status.aliyun.com/api/status/listProductEventForRegionInLast24Hours?regionId=cn-shanghai", responderGetMetrics)
 
+	healthCollector := NewMetricsCollectorAliHealth(conf, cred, &log.Logger{})
+	assert.NoError(t, healthCollector.poller.Poll(context.TODO()))
 	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		healthCollector := NewMetricsCollectorAliHealth(conf, cred, &log.Logger{})
 		registry := prometheus.NewRegistry()
 		registry.MustRegister(healthCollector)
 		h := promhttp.HandlerFor(registry, promhttp.HandlerOpts{})
 		h.ServeHTTP(w, r)
 	})
-	assert.HTTPBodyContains(t, handler, "GET", uri, nil, "cpe_health_events{eventID=\"dummyTitile\",lastUpdatedTime=\"2022-10-14 08:10:58 +0000 UTC\",level=\"ALARM\",startTime=\"2022-10-14 08:10:21 +0000 UTC\"} 1")
-	assert.HTTPBodyContains(t, handler, "GET", uri, nil, "cpe_health_events{eventID=\"dummyTitile2\",lastUpdatedTime=\"2022-10-14 08:10:58 +0000 UTC\",level=\"NOTIFICATION\",startTime=\"2022-10-14 08:08:41 +0000 UTC\"} 1")
+	openID := fmt.Sprintf("dummyProduct-cn-shanghai-%d", opened.Unix())
+	closedID := fmt.Sprintf("dummyProduct2-cn-shanghai-%d", closed.Unix())
+	assert.HTTPBodyContains(t, handler, "GET", uri, nil, fmt.Sprintf("cpe_health_events{AccountID=\"\",cloudService=\"dummyProduct\",endTime=\"\",eventID=\"%s\",eventRegion=\"cn-shanghai\",lastUpdatedTime=\"%s\",level=\"ALARM\",startTime=\"%s\",status=\"open\",title=\"dummyTitile\"} 1",
+		openID, opened.UTC(), opened.UTC()))
+	assert.HTTPBodyContains(t, handler, "GET", uri, nil, fmt.Sprintf("cpe_health_events{AccountID=\"\",cloudService=\"dummyProduct2\",endTime=\"%s\",eventID=\"%s\",eventRegion=\"cn-shanghai\",lastUpdatedTime=\"%s\",level=\"NOTIFICATION\",startTime=\"%s\",status=\"closed\",title=\"dummyTitile2\"} 1",
+		closed.Add(time.Minute).UTC(), closedID, closed.Add(time.Minute).UTC(), closed.UTC()))
+	assert.HTTPBodyContains(t, handler, "GET", uri, nil, "cpe_health_events_opened_total{AccountID=\"\",cloudService=\"dummyProduct\"} 1")
+	assert.HTTPBodyContains(t, handler, "GET", uri, nil, "cpe_health_events_closed_total{AccountID=\"\",cloudService=\"dummyProduct2\"} 1")
+	assert.HTTPBodyNotContains(t, handler, "GET", uri, nil, "dummyTitile3")
+}
+
+func TestAlicloudHealthSeverityChange(t *testing.T) {
+	conf := &config.Config{Region: "cn-shanghai"}
+	now := time.Now().Truncate(time.Second)
+	event := `{"success": true, "data": [{"productId": "ecs", "title": "dummyTitle", "currentStateSeverity": "%s", "startTime": %d, "endTime": %d}]}`
+	url := "https://status.aliyun.com/api/status/listProductEventForRegionInLast24Hours?regionId=cn-shanghai"
+
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+	httpmock.RegisterResponder("GET", url, httpmock.NewStringResponder(http.StatusOK, fmt.Sprintf(event, "ALARM", now.Add(-time.Hour).Unix(), 0)))
+	healthCollector := NewMetricsCollectorAliHealth(conf, vault.CloudCredentials{}, &log.Logger{})
+	assert.NoError(t, healthCollector.poller.Poll(context.TODO()))
+	httpmock.RegisterResponder("GET", url, httpmock.NewStringResponder(http.StatusOK, fmt.Sprintf(event, "NORMAL", now.Add(-time.Hour).Unix(), now.Unix())))
+	assert.NoError(t, healthCollector.poller.Poll(context.TODO()))
+
+	// the event opens and closes the series of its product, the severity is only exported by the gauge
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(healthCollector)
+	handler := promhttp.HandlerFor(registry, promhttp.HandlerOpts{})
+	assert.HTTPBodyContains(t, handler.ServeHTTP, "GET", "/metrics", nil, "cpe_health_events_opened_total{AccountID=\"\",cloudService=\"ecs\"} 1")
+	assert.HTTPBodyContains(t, handler.ServeHTTP, "GET", "/metrics", nil, "cpe_health_events_closed_total{AccountID=\"\",cloudService=\"ecs\"} 1")
+	assert.HTTPBodyContains(t, handler.ServeHTTP, "GET", "/metrics", nil, "level=\"NORMAL\",startTime=")
+}
+
+func TestAlicloudHealthAccounts(t *testing.T) {
+	conf := &config.Config{
+		Region: "cn-shanghai",
+		AliCloud: &config.AliCloudConfig{Accounts: []config.AliCloudAccountConfig{
+			{RoleArn: "acs:ram::1234567890123456:role/exporter"},
+			{RoleArn: "acs:ram::6543210987654321:role/exporter"},
+		}},
+	}
+	now := time.Now().Truncate(time.Second)
+	body := fmt.Sprintf(events, now.Unix(), now.Unix(), now.Unix(), now.Unix(), now.Unix())
+
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+	httpmock.RegisterResponder("GET", "https://status.aliyun.com/api/status/listProductEventForRegionInLast24Hours?regionId=cn-shanghai", httpmock.NewStringResponder(http.StatusOK, body))
+
+	healthCollector := NewMetricsCollectorAliHealth(conf, vault.CloudCredentials{}, &log.Logger{})
+	assert.NoError(t, healthCollector.poller.Poll(context.TODO()))
+	// the status page is read once for all accounts
+	assert.Equal(t, 1, httpmock.GetTotalCallCount())
+	assert.Len(t, healthCollector.poller.Events(), 6)
+
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(healthCollector)
+	handler := promhttp.HandlerFor(registry, promhttp.HandlerOpts{})
+	eventID := fmt.Sprintf("dummyProduct-cn-shanghai-%d", now.Unix())
+	for _, accountID := range []string{"1234567890123456", "6543210987654321"} {
+		assert.HTTPBodyContains(t, handler.ServeHTTP, "GET", "/metrics", nil, fmt.Sprintf("cpe_health_events_affected{AccountID=\"%s\",affectedRegions=\"cn-shanghai\",affectedService=\"dummyProduct\",eventID=\"%s\"} 1", accountID, eventID))
+		assert.HTTPBodyContains(t, handler.ServeHTTP, "GET", "/metrics", nil, fmt.Sprintf("cpe_health_events_opened_total{AccountID=\"%s\",cloudService=\"dummyProduct\"} 1", accountID))
+		assert.HTTPBodyContains(t, handler.ServeHTTP, "GET", "/metrics", nil, fmt.Sprintf("cpe_health_events_closed_total{AccountID=\"%s\",cloudService=\"dummyProduct2\"} 1", accountID))
+	}
+	// the duration of an event closed in both accounts is observed once
+	assert.HTTPBodyContains(t, handler.ServeHTTP, "GET", "/metrics", nil, "cpe_health_event_duration_seconds_count{cloudService=\"dummyProduct2\",eventType=\"ProductEvent\"} 1")
+}
+
+func TestAlicloudHealthRegions(t *testing.T) {
+	conf := &config.Config{
+		Region:   "cn-shanghai",
+		AliCloud: &config.AliCloudConfig{HealthRegions: []string{"cn-hangzhou", "cn-beijing"}},
+	}
+	now := time.Now()
+	body := fmt.Sprintf(events, now.Unix(), now.Unix(), now.Unix(), now.Unix(), now.Unix())
+
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+	httpmock.RegisterResponder("GET", "https://status.aliyun.com/api/status/listProductEventForRegionInLast24Hours?regionId=cn-hangzhou", httpmock.NewStringResponder(http.StatusOK, body))
+	httpmock.RegisterResponder("GET", "https://status.aliyun.com/api/status/listProductEventForRegionInLast24Hours?regionId=cn-beijing", httpmock.NewStringResponder(http.StatusOK, failure))
+
+	healthCollector := NewMetricsCollectorAliHealth(conf, vault.CloudCredentials{}, &log.Logger{})
+	_, err := healthCollector.FetchEvents(context.TODO(), time.Time{})
+	assert.EqualError(t, err, "listing events of cn-beijing failed with code 400: invalid region")
+
+	httpmock.RegisterResponder("GET", "https://status.aliyun.com/api/status/listProductEventForRegionInLast24Hours?regionId=cn-beijing", httpmock.NewStringResponder(http.StatusOK, body))
+	records, err := healthCollector.FetchEvents(context.TODO(), time.Time{})
+	assert.NoError(t, err)
+	assert.Len(t, records, 6)
+	assert.Equal(t, []string{"cn-hangzhou"}, records[0].Regions)
+	assert.Equal(t, []string{"cn-beijing"}, records[3].Regions)
 }
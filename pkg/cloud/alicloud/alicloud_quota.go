@@ -2,6 +2,10 @@ package alicloud
 
 import (
 	"context"
+	"fmt"
+	"github.com/aliyun/alibaba-cloud-sdk-go/sdk"
+	"github.com/aliyun/alibaba-cloud-sdk-go/sdk/requests"
+	"github.com/aliyun/alibaba-cloud-sdk-go/services/ecs"
 	"github.com/aliyun/alibaba-cloud-sdk-go/services/quotas"
 	"github.com/patrickmn/go-cache"
 	"github.com/prometheus/client_golang/prometheus"
@@ -10,73 +14,257 @@ import (
 	"github.wdf.sap.corp/DBaaS/cloud-provider-exporter/pkg/config"
 	"github.wdf.sap.corp/DBaaS/cloud-provider-exporter/pkg/constant"
 	"github.wdf.sap.corp/DBaaS/cloud-provider-exporter/pkg/vault"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
 )
 
+const quotaPageSize = 100
+
+// QuotasClient Mock quotas.Client for test
 type QuotasClient interface {
 	ListProductQuotas(request *quotas.ListProductQuotasRequest) (response *quotas.ListProductQuotasResponse, err error)
+	ListQuotaApplications(request *quotas.ListQuotaApplicationsRequest) (response *quotas.ListQuotaApplicationsResponse, err error)
+}
+
+// EcsClient Mock ecs.Client for test
+type EcsClient interface {
+	DescribeAccountAttributes(request *ecs.DescribeAccountAttributesRequest) (response *ecs.DescribeAccountAttributesResponse, err error)
 }
 
+// ProdCodeList is the default list of products whose quotas are collected
 var ProdCodeList = []string{"ecs", "nat", "eip", "vpc", "slb", "ros"}
 
+// ecsVcpuAttributes pairs the ECS account attributes holding the vCPU limits with the ones holding their usage
+var ecsVcpuAttributes = map[string]string{
+	"max-postpaid-instance-vcpu-count": "used-postpaid-instance-vcpu-count",
+	"max-spot-instance-vcpu-count":     "used-spot-instance-vcpu-count",
+}
+
 type MetricsCollectorAliQuota struct {
-	conf   *config.Config
-	log    log.FieldLogger
-	client QuotasClient
+	conf     *config.Config
+	log      log.FieldLogger
+	accounts []*quotaAccount
+	products []string
+
+	application  *prometheus.Desc
+	mu           sync.RWMutex
+	applications map[string]*application
+}
+
+// quotaAccount holds the clients reading the quotas of one account
+type quotaAccount struct {
+	id        string
+	client    QuotasClient
+	ecsClient EcsClient
 }
 
 type Result struct {
 	quotaResult      *common.QuotaResult
 	productId        string
 	quotaDescription string
+	dimensions       string
+	accountID        string
+}
+
+type application struct {
+	quotas.QuotaApplicationsItem
+	accountID string
 }
 
 func NewMetricsCollectorAliQuota(config *config.Config, cred vault.CloudCredentials, logger log.FieldLogger) *MetricsCollectorAliQuota {
-	client, err := quotas.NewClientWithAccessKey(config.Region, cred[vault.AliCloudAccessKeyID], cred[vault.AliCloudSecretAccessKey])
-	if err != nil {
-		logger.Errorf("Error while getting client: ", err)
-	}
 	m := &MetricsCollectorAliQuota{}
 	m.conf = config
 	m.log = logger
 	m.log.Infof("Initialize AliCloud Quota client")
-	m.client = client
-	common.InitQuotaGaugeVec("AliCloud", []string{constant.LabelProductCode, constant.LabelQuotaName, constant.LabelQuotaCode, constant.LabelQuotaDescription, constant.LabelUnit})
+	for _, account := range Accounts(config, cred, logger) {
+		client, err := quotas.NewClientWithOptions(config.Region, sdk.NewConfig(), account.Credential)
+		if err != nil {
+			logger.Errorf("Error while getting client of account %s: %v", account.ID, err)
+		}
+		ecsClient, err := ecs.NewClientWithOptions(config.Region, sdk.NewConfig(), account.Credential)
+		if err != nil {
+			logger.Errorf("Error while getting ECS client of account %s: %v", account.ID, err)
+		}
+		m.accounts = append(m.accounts, &quotaAccount{account.ID, client, ecsClient})
+	}
+	m.products = ProdCodeList
+	if config.AliCloud != nil && len(config.AliCloud.QuotaProducts) > 0 {
+		m.products = config.AliCloud.QuotaProducts
+	}
+	common.InitQuotaGaugeVec("AliCloud", []string{constant.LabelProductCode, constant.LabelQuotaName, constant.LabelQuotaCode, constant.LabelQuotaDescription, constant.LabelUnit, constant.LabelDimensions, constant.LabelAccountID})
+	m.application = prometheus.NewDesc(
+		constant.QuotaApplication,
+		strings.Join([]string{"AliCloud", constant.HelpQuotaApplication}, " "),
+		[]string{constant.LabelProductCode, constant.LabelQuotaName, constant.LabelQuotaCode, constant.LabelDimensions, constant.LabelApplicationID, constant.LabelStatus, constant.LabelAccountID}, nil,
+	)
 	return m
 }
 
 func (m *MetricsCollectorAliQuota) Describe(ch chan<- *prometheus.Desc) {
 	common.QuotaLimit.Describe(ch)
 	common.QuotaCurrent.Describe(ch)
+	ch <- m.application
 }
 
 func (m *MetricsCollectorAliQuota) Collect(ch chan<- prometheus.Metric) {
 	m.log.Infof("Start retrieve data from cache")
 	for _, item := range common.QuotaCache.Items() {
 		result := item.Object.(*Result)
-		m.log.WithFields(log.Fields{"product": result.productId, "quotaName": result.quotaResult.QuotaName, "quotaCode": result.quotaResult.QuotaCode, "quotaDescription": result.quotaDescription, "current": result.quotaResult.CurrentValue, "limit": result.quotaResult.LimitValue}).Infof("retrieve data from cache")
-		common.QuotaCurrent.WithLabelValues(result.productId, result.quotaResult.QuotaName, result.quotaResult.QuotaCode, result.quotaDescription, result.quotaResult.Unit).Set(result.quotaResult.CurrentValue)
-		common.QuotaLimit.WithLabelValues(result.productId, result.quotaResult.QuotaName, result.quotaResult.QuotaCode, result.quotaDescription, result.quotaResult.Unit).Set(result.quotaResult.LimitValue)
+		m.log.WithFields(log.Fields{"product": result.productId, "quotaName": result.quotaResult.QuotaName, "quotaCode": result.quotaResult.QuotaCode, "quotaDescription": result.quotaDescription, "dimensions": result.dimensions, "accountID": result.accountID, "current": result.quotaResult.CurrentValue, "limit": result.quotaResult.LimitValue}).Infof("retrieve data from cache")
+		common.QuotaCurrent.WithLabelValues(result.productId, result.quotaResult.QuotaName, result.quotaResult.QuotaCode, result.quotaDescription, result.quotaResult.Unit, result.dimensions, result.accountID).Set(result.quotaResult.CurrentValue)
+		common.QuotaLimit.WithLabelValues(result.productId, result.quotaResult.QuotaName, result.quotaResult.QuotaCode, result.quotaDescription, result.quotaResult.Unit, result.dimensions, result.accountID).Set(result.quotaResult.LimitValue)
 	}
 	common.QuotaLimit.Collect(ch)
 	common.QuotaCurrent.Collect(ch)
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	for _, a := range m.applications {
+		ch <- prometheus.MustNewConstMetric(m.application, prometheus.GaugeValue, a.DesireValue, a.ProductCode, a.QuotaName, a.QuotaArn, formatDimensions(a.Dimension), a.ApplicationId, a.Status, a.accountID)
+	}
 }
 
 func (m *MetricsCollectorAliQuota) scrape(ctx context.Context) {
 	m.log.Infof("Start collect AliCloud quota metrics")
-	for _, prod := range ProdCodeList {
-		r := quotas.CreateListProductQuotasRequest()
-		r.ProductCode = prod
-		response, err := m.client.ListProductQuotas(r)
+	applications := make(map[string]*application)
+	for _, account := range m.accounts {
+		for _, prod := range m.products {
+			if err := m.scrapeProductQuotas(account, prod); err != nil {
+				m.log.Errorf("Error while listing quotas of %s in account %s: %v", prod, account.id, err)
+			}
+			if err := m.scrapeQuotaApplications(account, prod, applications); err != nil {
+				m.log.Errorf("Error while listing quota applications of %s in account %s: %v", prod, account.id, err)
+			}
+			if prod == "ecs" {
+				if err := m.scrapeEcsVcpuQuotas(account); err != nil {
+					m.log.Errorf("Error while describing ECS account attributes of account %s: %v", account.id, err)
+				}
+			}
+		}
+	}
+	m.mu.Lock()
+	m.applications = applications
+	m.mu.Unlock()
+	m.log.Infof("End collect AliCloud quota metrics")
+}
+
+// scrapeProductQuotas caches the used quotas of a product, one entry per quota and dimension combination.
+func (m *MetricsCollectorAliQuota) scrapeProductQuotas(account *quotaAccount, prod string) error {
+	r := quotas.CreateListProductQuotasRequest()
+	r.ProductCode = prod
+	r.MaxResults = requests.NewInteger(quotaPageSize)
+	for {
+		response, err := account.client.ListProductQuotas(r)
 		if err != nil {
-			m.log.Errorf("Error while traversing product resource list: ", err)
+			return err
 		}
 		for _, quota := range response.Quotas {
-			if quota.TotalUsage != 0 {
-				quotaResult := &common.QuotaResult{QuotaName: quota.QuotaName, QuotaCode: quota.QuotaArn, LimitValue: quota.TotalQuota, CurrentValue: quota.TotalUsage, Unit: quota.QuotaUnit}
-				result := &Result{quotaResult, prod, quota.QuotaDescription}
-				common.QuotaCache.Set(quota.QuotaArn, result, cache.DefaultExpiration)
+			if quota.TotalUsage == 0 {
+				continue
 			}
+			dimensions := formatDimensions(quota.Dimensions)
+			quotaResult := &common.QuotaResult{QuotaName: quota.QuotaName, QuotaCode: quota.QuotaArn, LimitValue: quota.TotalQuota, CurrentValue: quota.TotalUsage, Unit: quota.QuotaUnit}
+			result := &Result{quotaResult, prod, quota.QuotaDescription, dimensions, account.id}
+			common.QuotaCache.Set(quotaKey(account.id, quota.QuotaArn, dimensions), result, cache.DefaultExpiration)
+		}
+		if response.NextToken == "" {
+			return nil
 		}
+		r.NextToken = response.NextToken
 	}
-	m.log.Infof("End collect AliCloud quota metrics")
+}
+
+// scrapeQuotaApplications keeps the latest application of each quota and dimension combination of a product.
+func (m *MetricsCollectorAliQuota) scrapeQuotaApplications(account *quotaAccount, prod string, applications map[string]*application) error {
+	r := quotas.CreateListQuotaApplicationsRequest()
+	r.ProductCode = prod
+	r.MaxResults = requests.NewInteger(quotaPageSize)
+	for {
+		response, err := account.client.ListQuotaApplications(r)
+		if err != nil {
+			return err
+		}
+		for _, item := range response.QuotaApplications {
+			key := quotaKey(account.id, item.QuotaArn, formatDimensions(item.Dimension))
+			if latest, ok := applications[key]; !ok || latest.ApplyTime < item.ApplyTime {
+				applications[key] = &application{item, account.id}
+			}
+		}
+		if response.NextToken == "" {
+			return nil
+		}
+		r.NextToken = response.NextToken
+	}
+}
+
+// scrapeEcsVcpuQuotas caches the vCPU quotas of ECS per instance type and zone, which the quota center does not report.
+func (m *MetricsCollectorAliQuota) scrapeEcsVcpuQuotas(account *quotaAccount) error {
+	r := ecs.CreateDescribeAccountAttributesRequest()
+	names := make([]string, 0, 2*len(ecsVcpuAttributes))
+	for max, used := range ecsVcpuAttributes {
+		names = append(names, max, used)
+	}
+	sort.Strings(names)
+	r.AttributeName = &names
+	response, err := account.ecsClient.DescribeAccountAttributes(r)
+	if err != nil {
+		return err
+	}
+
+	values := make(map[string]map[string]float64)
+	for _, attribute := range response.AccountAttributeItems.AccountAttributeItem {
+		byDimensions := make(map[string]float64)
+		for _, item := range attribute.AttributeValues.ValueItem {
+			value, err := strconv.ParseFloat(item.Value, 64)
+			if err != nil {
+				m.log.Errorf("Error while parsing %s of %s: %v", attribute.AttributeName, item.InstanceType, err)
+				continue
+			}
+			byDimensions[ecsDimensions(item)] = value
+		}
+		values[attribute.AttributeName] = byDimensions
+	}
+	for max, used := range ecsVcpuAttributes {
+		name := strings.TrimPrefix(max, "max-")
+		for dimensions, limit := range values[max] {
+			usage := values[used][dimensions]
+			if usage == 0 {
+				continue
+			}
+			quotaResult := &common.QuotaResult{QuotaName: name, QuotaCode: "ecs/" + name, LimitValue: limit, CurrentValue: usage, Unit: "vCPU"}
+			result := &Result{quotaResult, "ecs", "vCPUs of " + strings.ReplaceAll(name, "-", " "), dimensions, account.id}
+			common.QuotaCache.Set(quotaKey(account.id, quotaResult.QuotaCode, dimensions), result, cache.DefaultExpiration)
+		}
+	}
+	return nil
+}
+
+func ecsDimensions(item ecs.ValueItem) string {
+	dimensions := make(map[string]interface{})
+	if item.InstanceType != "" {
+		dimensions["instanceType"] = item.InstanceType
+	}
+	if item.ZoneId != "" {
+		dimensions["zoneId"] = item.ZoneId
+	}
+	if item.InstanceChargeType != "" {
+		dimensions["instanceChargeType"] = item.InstanceChargeType
+	}
+	return formatDimensions(dimensions)
+}
+
+// formatDimensions renders quota dimensions as sorted key=value pairs, so they are stable across scrapes.
+func formatDimensions(dimensions map[string]interface{}) string {
+	pairs := make([]string, 0, len(dimensions))
+	for k, v := range dimensions {
+		pairs = append(pairs, fmt.Sprintf("%s=%v", k, v))
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, ",")
+}
+
+func quotaKey(accountID, code, dimensions string) string {
+	return strings.Join([]string{accountID, code, dimensions}, "|")
 }
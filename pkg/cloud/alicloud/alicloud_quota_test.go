@@ -2,6 +2,8 @@ package alicloud
 
 import (
 	"context"
+	"errors"
+	"github.com/aliyun/alibaba-cloud-sdk-go/services/ecs"
 	"github.com/aliyun/alibaba-cloud-sdk-go/services/quotas"
 	"github.com/patrickmn/go-cache"
 	"github.com/prometheus/client_golang/prometheus"
@@ -59,6 +61,60 @@ func (m *MockQuotasClient) ListProductQuotas(request *quotas.ListProductQuotasRe
 	return result, nil
 }
 
+func (m *MockQuotasClient) ListQuotaApplications(request *quotas.ListQuotaApplicationsRequest) (response *quotas.ListQuotaApplicationsResponse, err error) {
+	return &quotas.ListQuotaApplicationsResponse{}, nil
+}
+
+// MockPagedQuotasClient serves two pages of quotas and applications per product
+type MockPagedQuotasClient struct {
+}
+
+func (m *MockPagedQuotasClient) ListProductQuotas(request *quotas.ListProductQuotasRequest) (response *quotas.ListProductQuotasResponse, err error) {
+	if request.ProductCode != "ecs" {
+		return nil, errors.New("product not found")
+	}
+	if request.NextToken == "" {
+		return &quotas.ListProductQuotasResponse{NextToken: "page2", Quotas: []quotas.QuotasItemInListProductQuotas{
+			{QuotaArn: "acs:quotas:cn-shanghai:123:quota/ecs/q_disk", QuotaName: "disk", TotalQuota: 100, TotalUsage: 10, QuotaUnit: "GiB",
+				Dimensions: map[string]interface{}{"zoneId": "cn-shanghai-a", "regionId": "cn-shanghai"}},
+			{QuotaArn: "acs:quotas:cn-shanghai:123:quota/ecs/q_disk", QuotaName: "disk", TotalQuota: 100, TotalUsage: 20, QuotaUnit: "GiB",
+				Dimensions: map[string]interface{}{"zoneId": "cn-shanghai-b", "regionId": "cn-shanghai"}},
+		}}, nil
+	}
+	return &quotas.ListProductQuotasResponse{Quotas: []quotas.QuotasItemInListProductQuotas{
+		{QuotaArn: "acs:quotas:cn-shanghai:123:quota/ecs/q_images", QuotaName: "images", TotalQuota: 50, TotalUsage: 5},
+		{QuotaArn: "acs:quotas:cn-shanghai:123:quota/ecs/q_unused", QuotaName: "unused", TotalQuota: 50},
+	}}, nil
+}
+
+func (m *MockPagedQuotasClient) ListQuotaApplications(request *quotas.ListQuotaApplicationsRequest) (response *quotas.ListQuotaApplicationsResponse, err error) {
+	if request.NextToken == "" {
+		return &quotas.ListQuotaApplicationsResponse{NextToken: "page2", QuotaApplications: []quotas.QuotaApplicationsItem{
+			{ApplicationId: "app-1", ProductCode: "ecs", QuotaArn: "acs:quotas:cn-shanghai:123:quota/ecs/q_images", QuotaName: "images",
+				Status: "Agree", DesireValue: 60, ApplyTime: "2022-10-01T08:00:00Z"},
+		}}, nil
+	}
+	return &quotas.ListQuotaApplicationsResponse{QuotaApplications: []quotas.QuotaApplicationsItem{
+		{ApplicationId: "app-2", ProductCode: "ecs", QuotaArn: "acs:quotas:cn-shanghai:123:quota/ecs/q_images", QuotaName: "images",
+			Status: "Process", DesireValue: 80, ApplyTime: "2022-10-10T08:00:00Z"},
+	}}, nil
+}
+
+type MockEcsClient struct {
+}
+
+func (m *MockEcsClient) DescribeAccountAttributes(request *ecs.DescribeAccountAttributesRequest) (response *ecs.DescribeAccountAttributesResponse, err error) {
+	attribute := func(name string, values ...ecs.ValueItem) ecs.AccountAttributeItem {
+		return ecs.AccountAttributeItem{AttributeName: name, AttributeValues: ecs.AttributeValues{ValueItem: values}}
+	}
+	return &ecs.DescribeAccountAttributesResponse{AccountAttributeItems: ecs.AccountAttributeItems{AccountAttributeItem: []ecs.AccountAttributeItem{
+		attribute("max-postpaid-instance-vcpu-count",
+			ecs.ValueItem{InstanceType: "ecs.g6", Value: "500"}, ecs.ValueItem{InstanceType: "ecs.r6", Value: "200"}),
+		attribute("used-postpaid-instance-vcpu-count",
+			ecs.ValueItem{InstanceType: "ecs.g6", Value: "64"}, ecs.ValueItem{InstanceType: "ecs.r6", Value: "0"}),
+	}}}, nil
+}
+
 func TestAliCloudQuota(t *testing.T) {
 	uri := "/metrics"
 	conf := &config.Config{}
@@ -67,7 +123,7 @@ func TestAliCloudQuota(t *testing.T) {
 		vault.AliCloudSecretAccessKey: "AliCloudSecretAccessKey",
 	}
 	quotaCollector := NewMetricsCollectorAliQuota(conf, cred, &log.Logger{})
-	quotaCollector.client = &MockQuotasClient{}
+	quotaCollector.accounts = []*quotaAccount{{"", &MockQuotasClient{}, &MockEcsClient{}}}
 	common.QuotaCache = &MockQuotaCache{}
 	registry := prometheus.NewRegistry()
 	registry.MustRegister(quotaCollector)
@@ -78,7 +134,34 @@ func TestAliCloudQuota(t *testing.T) {
 	})
 
 	for _, prod := range ProdCodeList {
-		assert.HTTPBodyContains(t, handler, "GET", uri, nil, "cpe_quota_current{ProductCode=\""+prod+"\",QuotaCode=\"code\",QuotaDescription=\"desc\",QuotaName=\"name\",Unit=\"unit\"} 30")
-		assert.HTTPBodyContains(t, handler, "GET", uri, nil, "cpe_quota_limit{ProductCode=\""+prod+"\",QuotaCode=\"code\",QuotaDescription=\"desc\",QuotaName=\"name\",Unit=\"unit\"} 100")
+		assert.HTTPBodyContains(t, handler, "GET", uri, nil, "cpe_quota_current{AccountID=\"\",Dimensions=\"\",ProductCode=\""+prod+"\",QuotaCode=\"code\",QuotaDescription=\"desc\",QuotaName=\"name\",Unit=\"unit\"} 30")
+		assert.HTTPBodyContains(t, handler, "GET", uri, nil, "cpe_quota_limit{AccountID=\"\",Dimensions=\"\",ProductCode=\""+prod+"\",QuotaCode=\"code\",QuotaDescription=\"desc\",QuotaName=\"name\",Unit=\"unit\"} 100")
 	}
 }
+
+func TestAliCloudQuotaPagination(t *testing.T) {
+	uri := "/metrics"
+	conf := &config.Config{AliCloud: &config.AliCloudConfig{QuotaProducts: []string{"ecs", "unknown"}}}
+	quotaCollector := NewMetricsCollectorAliQuota(conf, vault.CloudCredentials{}, &log.Logger{})
+	quotaCollector.accounts = []*quotaAccount{{"123", &MockPagedQuotasClient{}, &MockEcsClient{}}, {"456", &MockPagedQuotasClient{}, &MockEcsClient{}}}
+	common.QuotaCache = cache.New(time.Minute, time.Minute)
+	quotaCollector.scrape(context.TODO())
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(quotaCollector)
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		h := promhttp.HandlerFor(registry, promhttp.HandlerOpts{})
+		h.ServeHTTP(w, r)
+	})
+
+	assert.Len(t, common.QuotaCache.Items(), 8)
+	assert.HTTPBodyContains(t, handler, "GET", uri, nil, "cpe_quota_current{AccountID=\"123\",Dimensions=\"regionId=cn-shanghai,zoneId=cn-shanghai-a\",ProductCode=\"ecs\",QuotaCode=\"acs:quotas:cn-shanghai:123:quota/ecs/q_disk\",QuotaDescription=\"\",QuotaName=\"disk\",Unit=\"GiB\"} 10")
+	assert.HTTPBodyContains(t, handler, "GET", uri, nil, "cpe_quota_current{AccountID=\"123\",Dimensions=\"regionId=cn-shanghai,zoneId=cn-shanghai-b\",ProductCode=\"ecs\",QuotaCode=\"acs:quotas:cn-shanghai:123:quota/ecs/q_disk\",QuotaDescription=\"\",QuotaName=\"disk\",Unit=\"GiB\"} 20")
+	assert.HTTPBodyContains(t, handler, "GET", uri, nil, "cpe_quota_limit{AccountID=\"123\",Dimensions=\"\",ProductCode=\"ecs\",QuotaCode=\"acs:quotas:cn-shanghai:123:quota/ecs/q_images\",QuotaDescription=\"\",QuotaName=\"images\",Unit=\"\"} 50")
+	assert.HTTPBodyContains(t, handler, "GET", uri, nil, "cpe_quota_current{AccountID=\"123\",Dimensions=\"instanceType=ecs.g6\",ProductCode=\"ecs\",QuotaCode=\"ecs/postpaid-instance-vcpu-count\",QuotaDescription=\"vCPUs of postpaid instance vcpu count\",QuotaName=\"postpaid-instance-vcpu-count\",Unit=\"vCPU\"} 64")
+	assert.HTTPBodyContains(t, handler, "GET", uri, nil, "cpe_quota_limit{AccountID=\"123\",Dimensions=\"instanceType=ecs.g6\",ProductCode=\"ecs\",QuotaCode=\"ecs/postpaid-instance-vcpu-count\",QuotaDescription=\"vCPUs of postpaid instance vcpu count\",QuotaName=\"postpaid-instance-vcpu-count\",Unit=\"vCPU\"} 500")
+	assert.HTTPBodyNotContains(t, handler, "GET", uri, nil, "q_unused")
+	assert.HTTPBodyNotContains(t, handler, "GET", uri, nil, "ecs.r6")
+	assert.HTTPBodyContains(t, handler, "GET", uri, nil, "cpe_quota_application_desired_value{AccountID=\"123\",ApplicationID=\"app-2\",Dimensions=\"\",ProductCode=\"ecs\",QuotaCode=\"acs:quotas:cn-shanghai:123:quota/ecs/q_images\",QuotaName=\"images\",Status=\"Process\"} 80")
+	assert.HTTPBodyNotContains(t, handler, "GET", uri, nil, "app-1")
+	assert.HTTPBodyContains(t, handler, "GET", uri, nil, "cpe_quota_current{AccountID=\"456\",Dimensions=\"instanceType=ecs.g6\",ProductCode=\"ecs\",QuotaCode=\"ecs/postpaid-instance-vcpu-count\",QuotaDescription=\"vCPUs of postpaid instance vcpu count\",QuotaName=\"postpaid-instance-vcpu-count\",Unit=\"vCPU\"} 64")
+}
@@ -1,35 +1,50 @@
 package alicloud
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"github.com/aliyun/aliyun-oss-go-sdk/oss"
 	"github.com/prometheus/client_golang/prometheus"
 	log "github.com/sirupsen/logrus"
-	"github.wdf.sap.corp/DBaaS/cloud-provider-exporter/pkg/cloud/common"
+	"github.wdf.sap.corp/DBaaS/cloud-provider-exporter/pkg/cloud/objectstore"
 	"github.wdf.sap.corp/DBaaS/cloud-provider-exporter/pkg/config"
+	"github.wdf.sap.corp/DBaaS/cloud-provider-exporter/pkg/constant"
 	"github.wdf.sap.corp/DBaaS/cloud-provider-exporter/pkg/vault"
-	"time"
+	"io"
 )
 
 type MetricsCollectorAliVaultBucket struct {
-	conf   *config.Config
-	client IClient
+	conf    *config.Config
+	targets []*objectstore.Target
 }
 
 func NewMetricsCollectorAliVaultBucket(config *config.Config, cred vault.CloudCredentials) *MetricsCollectorAliVaultBucket {
 	m := &MetricsCollectorAliVaultBucket{}
-	client, err := oss.New(config.AliCloud.Endpoint, cred[vault.AliCloudAccessKeyID], cred[vault.AliCloudSecretAccessKey])
-	c := ClientWrapper{client}
-	if err != nil {
-		log.Errorln("Error creating client ", err)
+	for _, account := range Accounts(config, cred, log.StandardLogger()) {
+		options, err := account.OssOptions(config.Region, log.StandardLogger())
+		if err != nil {
+			log.Errorf("Error while getting STS client of account %s: %v", account.ID, err)
+			continue
+		}
+		client, err := oss.New(config.AliCloud.Endpoint, cred[vault.AliCloudAccessKeyID], cred[vault.AliCloudSecretAccessKey], options...)
+		if err != nil {
+			log.Errorln("Error creating client ", err)
+		}
+		m.targets = append(m.targets, objectstore.NewTargets(account.Buckets, &OssLister{ClientWrapper{client}}, account.ID)...)
 	}
-	m.client = c
 	m.conf = config
-	common.InitVaultBackupBucketDesc("", "ALICLOUD", []string{"bucket", "prefix"})
+	objectstore.InitDesc("ALICLOUD", constant.LabelAccountID)
 	return m
 }
 
 type IClient interface {
 	Bucket(name string) (IBucket, error)
+	GetBucketVersioning(bucketName string, options ...oss.Option) (oss.GetBucketVersioningResult, error)
+	GetBucketEncryption(bucketName string, options ...oss.Option) (oss.GetBucketEncryptionResult, error)
+	GetBucketWorm(bucketName string, options ...oss.Option) (oss.WormConfiguration, error)
+	GetBucketLifecycle(bucketName string, options ...oss.Option) (oss.GetBucketLifecycleResult, error)
+	GetBucketACL(bucketName string, options ...oss.Option) (oss.GetBucketACLResult, error)
 }
 
 type ClientWrapper struct {
@@ -42,78 +57,106 @@ func (cw ClientWrapper) Bucket(name string) (IBucket, error) {
 
 type IBucket interface {
 	ListObjects(options ...oss.Option) (oss.ListObjectsResult, error)
+	GetObject(objectKey string, options ...oss.Option) (io.ReadCloser, error)
 }
 
 func (m *MetricsCollectorAliVaultBucket) Describe(ch chan<- *prometheus.Desc) {
-	ch <- common.ListSuccess
-	ch <- common.LastModifiedObjectDate
-	ch <- common.LastModifiedObjectSize
-	ch <- common.ObjectTotal
-	ch <- common.SumSize
-	ch <- common.BiggestSize
+	objectstore.Describe(ch)
 }
+
 func (m *MetricsCollectorAliVaultBucket) Collect(ch chan<- prometheus.Metric) {
-	var lastModified time.Time
-	var numberOfObjects float64
-	var totalSize int64
-	var biggestObjectSize int64
-	var lastObjectSize int64
-	bucketName := m.conf.VaultBackupBucket.Bucket
-	prefix := m.conf.VaultBackupBucket.Prefix
-	bucket, err := m.client.Bucket(bucketName)
+	objectstore.Collect(context.Background(), ch, m.targets, log.StandardLogger())
+}
+
+// OssLister lists the objects of OSS buckets
+type OssLister struct {
+	client IClient
+}
+
+func (l *OssLister) ListObjects(_ context.Context, bucketName, prefix string, fn func(objectstore.Object)) error {
+	bucket, err := l.client.Bucket(bucketName)
 	if err != nil {
-		log.Errorln(err)
-		ch <- prometheus.MustNewConstMetric(
-			common.ListSuccess, prometheus.GaugeValue, 0, bucketName, prefix,
-		)
-		return
+		return err
 	}
-
-	//list all objects in bucket
 	marker := ""
 	for {
 		lsRes, err := bucket.ListObjects(oss.Prefix(prefix), oss.Marker(marker))
 		if err != nil {
-			log.Errorln(err)
-			ch <- prometheus.MustNewConstMetric(
-				common.ListSuccess, prometheus.GaugeValue, 0, bucketName, prefix,
-			)
-			return
+			return err
 		}
 		for _, item := range lsRes.Objects {
-			numberOfObjects++
-			totalSize = totalSize + item.Size
-			if item.LastModified.After(lastModified) {
-				lastModified = item.LastModified
-				lastObjectSize = item.Size
-			}
-			if item.Size > biggestObjectSize {
-				biggestObjectSize = item.Size
-			}
+			fn(objectstore.Object{Key: item.Key, Size: item.Size, LastModified: item.LastModified, MD5: objectstore.ETagMD5(item.ETag)})
 		}
-		if lsRes.IsTruncated {
-			marker = lsRes.NextMarker
-		} else {
-			break
+		if !lsRes.IsTruncated {
+			return nil
 		}
+		marker = lsRes.NextMarker
 	}
+}
+
+func (l *OssLister) ReadObject(_ context.Context, bucketName, key string, offset, length int64) (io.ReadCloser, error) {
+	bucket, err := l.client.Bucket(bucketName)
+	if err != nil {
+		return nil, err
+	}
+	return bucket.GetObject(key, oss.Range(offset, offset+length-1))
+}
 
-	ch <- prometheus.MustNewConstMetric(
-		common.ListSuccess, prometheus.GaugeValue, 1, bucketName, prefix,
-	)
-	ch <- prometheus.MustNewConstMetric(
-		common.LastModifiedObjectDate, prometheus.GaugeValue, float64(lastModified.UnixNano()/1e9), bucketName, prefix,
-	)
-	ch <- prometheus.MustNewConstMetric(
-		common.LastModifiedObjectSize, prometheus.GaugeValue, float64(lastObjectSize), bucketName, prefix,
-	)
-	ch <- prometheus.MustNewConstMetric(
-		common.ObjectTotal, prometheus.GaugeValue, numberOfObjects, bucketName, prefix,
-	)
-	ch <- prometheus.MustNewConstMetric(
-		common.BiggestSize, prometheus.GaugeValue, float64(biggestObjectSize), bucketName, prefix,
-	)
-	ch <- prometheus.MustNewConstMetric(
-		common.SumSize, prometheus.GaugeValue, float64(totalSize), bucketName, prefix,
-	)
+// notConfigured is true for the errors OSS returns for settings a bucket does not have
+func notConfigured(err error) bool {
+	var serviceErr oss.ServiceError
+	if !errors.As(err, &serviceErr) {
+		return false
+	}
+	switch serviceErr.Code {
+	case "NoSuchServerSideEncryptionRule", "NoSuchWORMConfiguration", "NoSuchLifecycle":
+		return true
+	}
+	return false
+}
+
+// InspectBucket checks the settings of a bucket, the checks that fail are left out and the first failure is returned.
+func (l *OssLister) InspectBucket(_ context.Context, bucket string) (map[string]bool, error) {
+	checks := make(map[string]bool)
+	var firstErr error
+	check := func(name string, inspect func() (bool, error)) {
+		compliant, err := inspect()
+		switch {
+		case err == nil:
+			checks[name] = compliant
+		case notConfigured(err):
+			checks[name] = false
+		case firstErr == nil:
+			firstErr = fmt.Errorf("%s: %w", name, err)
+		}
+	}
+	check(objectstore.CheckVersioning, func() (bool, error) {
+		resp, err := l.client.GetBucketVersioning(bucket)
+		return err == nil && resp.Status == string(oss.VersionEnabled), err
+	})
+	check(objectstore.CheckEncryption, func() (bool, error) {
+		resp, err := l.client.GetBucketEncryption(bucket)
+		return err == nil && resp.SSEDefault.SSEAlgorithm != "", err
+	})
+	check(objectstore.CheckObjectLock, func() (bool, error) {
+		resp, err := l.client.GetBucketWorm(bucket)
+		return err == nil && resp.State == "Locked", err
+	})
+	check(objectstore.CheckLifecycle, func() (bool, error) {
+		resp, err := l.client.GetBucketLifecycle(bucket)
+		if err != nil {
+			return false, err
+		}
+		for _, rule := range resp.Rules {
+			if rule.Status == "Enabled" {
+				return true, nil
+			}
+		}
+		return false, nil
+	})
+	check(objectstore.CheckPublicAccessBlocked, func() (bool, error) {
+		resp, err := l.client.GetBucketACL(bucket)
+		return err == nil && resp.ACL == string(oss.ACLPrivate), err
+	})
+	return checks, firstErr
 }
@@ -1,6 +1,7 @@
 package alicloud
 
 import (
+	"bytes"
 	"github.com/aliyun/aliyun-oss-go-sdk/oss"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
@@ -8,6 +9,7 @@ import (
 	"github.wdf.sap.corp/DBaaS/cloud-provider-exporter/pkg/config"
 	"github.wdf.sap.corp/DBaaS/cloud-provider-exporter/pkg/constant"
 	"github.wdf.sap.corp/DBaaS/cloud-provider-exporter/pkg/vault"
+	"io"
 	"net/http"
 	"testing"
 	"time"
@@ -21,6 +23,26 @@ func (m *MockClient) Bucket(name string) (IBucket, error) {
 	return &mockbucket, nil
 }
 
+func (m *MockClient) GetBucketVersioning(bucketName string, options ...oss.Option) (oss.GetBucketVersioningResult, error) {
+	return oss.GetBucketVersioningResult{Status: "Enabled"}, nil
+}
+
+func (m *MockClient) GetBucketEncryption(bucketName string, options ...oss.Option) (oss.GetBucketEncryptionResult, error) {
+	return oss.GetBucketEncryptionResult{}, oss.ServiceError{Code: "NoSuchServerSideEncryptionRule", StatusCode: http.StatusNotFound}
+}
+
+func (m *MockClient) GetBucketWorm(bucketName string, options ...oss.Option) (oss.WormConfiguration, error) {
+	return oss.WormConfiguration{State: "Locked", RetentionPeriodInDays: 30}, nil
+}
+
+func (m *MockClient) GetBucketLifecycle(bucketName string, options ...oss.Option) (oss.GetBucketLifecycleResult, error) {
+	return oss.GetBucketLifecycleResult{Rules: []oss.LifecycleRule{{ID: "expire", Prefix: "mock_prefix", Status: "Enabled"}}}, nil
+}
+
+func (m *MockClient) GetBucketACL(bucketName string, options ...oss.Option) (oss.GetBucketACLResult, error) {
+	return oss.GetBucketACLResult{}, oss.ServiceError{Code: "AccessDenied", StatusCode: http.StatusForbidden}
+}
+
 type MockBucket struct {
 }
 
@@ -44,6 +66,10 @@ func (m *MockBucket) ListObjects(options ...oss.Option) (oss.ListObjectsResult,
 	}, nil
 }
 
+func (m *MockBucket) GetObject(objectKey string, options ...oss.Option) (io.ReadCloser, error) {
+	return io.NopCloser(bytes.NewReader(make([]byte, 200))), nil
+}
+
 func TestAlicloudVaultBucket(t *testing.T) {
 	uri := constant.VaultMonitorPath
 	cred := vault.CloudCredentials{
@@ -64,16 +90,61 @@ func TestAlicloudVaultBucket(t *testing.T) {
 	}
 	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		vaultBucketCollector := NewMetricsCollectorAliVaultBucket(conf, cred)
-		vaultBucketCollector.client = &MockClient{}
+		for _, target := range vaultBucketCollector.targets {
+			target.Lister = &OssLister{&MockClient{}}
+		}
+		registry := prometheus.NewRegistry()
+		registry.MustRegister(vaultBucketCollector)
+		h := promhttp.HandlerFor(registry, promhttp.HandlerOpts{})
+		h.ServeHTTP(w, r)
+	})
+
+	assert.HTTPBodyContains(t, handler, "GET", uri, nil, "cpe_vault_object_list_success{AccountID=\"\",bucket=\"mock_bucket\",group=\"\",prefix=\"mock_prefix\"} 1")
+	assert.HTTPBodyContains(t, handler, "GET", uri, nil, "cpe_vault_object_last_modified_size_bytes{AccountID=\"\",bucket=\"mock_bucket\",group=\"\",prefix=\"mock_prefix\"} 200")
+	assert.HTTPBodyContains(t, handler, "GET", uri, nil, "cpe_vault_object_count{AccountID=\"\",bucket=\"mock_bucket\",group=\"\",prefix=\"mock_prefix\"} 2")
+	assert.HTTPBodyContains(t, handler, "GET", uri, nil, "cpe_vault_object_max_size_bytes{AccountID=\"\",bucket=\"mock_bucket\",group=\"\",prefix=\"mock_prefix\"} 200")
+	assert.HTTPBodyContains(t, handler, "GET", uri, nil, "cpe_vault_object_size_bytes_total{AccountID=\"\",bucket=\"mock_bucket\",group=\"\",prefix=\"mock_prefix\"} 300")
+	assert.HTTPBodyContains(t, handler, "GET", uri, nil, "cpe_bucket_compliance{AccountID=\"\",bucket=\"mock_bucket\",check=\"versioning\",group=\"\",prefix=\"mock_prefix\"} 1")
+	assert.HTTPBodyContains(t, handler, "GET", uri, nil, "cpe_bucket_compliance{AccountID=\"\",bucket=\"mock_bucket\",check=\"encryption\",group=\"\",prefix=\"mock_prefix\"} 0")
+	assert.HTTPBodyContains(t, handler, "GET", uri, nil, "cpe_bucket_compliance{AccountID=\"\",bucket=\"mock_bucket\",check=\"object_lock\",group=\"\",prefix=\"mock_prefix\"} 1")
+	assert.HTTPBodyContains(t, handler, "GET", uri, nil, "cpe_bucket_compliance{AccountID=\"\",bucket=\"mock_bucket\",check=\"lifecycle\",group=\"\",prefix=\"mock_prefix\"} 1")
+	assert.HTTPBodyNotContains(t, handler, "GET", uri, nil, "check=\"public_access_blocked\"")
+}
+
+func TestAlicloudVaultBucketAccounts(t *testing.T) {
+	uri := constant.VaultMonitorPath
+	conf := &config.Config{
+		VaultBackupBucket: &config.VaultBackupBucketConfig{Bucket: "mock_bucket", Prefix: "mock_prefix"},
+		Region:            "cn-shanghai",
+		AliCloud: &config.AliCloudConfig{
+			Endpoint: "oss-cn-shanghai.aliyuncs.com",
+			Accounts: []config.AliCloudAccountConfig{
+				{RoleArn: "acs:ram::111:role/exporter"},
+				{RoleArn: "acs:ram::222:role/exporter", VaultBackupBucket: &config.VaultBackupBucketConfig{Bucket: "other_bucket", Prefix: "other_prefix"}},
+			},
+		},
+	}
+	vaultBucketCollector := NewMetricsCollectorAliVaultBucket(conf, vault.CloudCredentials{})
+	for _, target := range vaultBucketCollector.targets {
+		target.Lister = &OssLister{&MockClient{}}
+	}
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		registry := prometheus.NewRegistry()
 		registry.MustRegister(vaultBucketCollector)
 		h := promhttp.HandlerFor(registry, promhttp.HandlerOpts{})
 		h.ServeHTTP(w, r)
 	})
 
-	assert.HTTPBodyContains(t, handler, "GET", uri, nil, "cpe_vault_object_list_success{bucket=\"mock_bucket\",prefix=\"mock_prefix\"} 1")
-	assert.HTTPBodyContains(t, handler, "GET", uri, nil, "cpe_vault_object_last_modified_size_bytes{bucket=\"mock_bucket\",prefix=\"mock_prefix\"} 200")
-	assert.HTTPBodyContains(t, handler, "GET", uri, nil, "cpe_vault_object_count{bucket=\"mock_bucket\",prefix=\"mock_prefix\"} 2")
-	assert.HTTPBodyContains(t, handler, "GET", uri, nil, "cpe_vault_object_max_size_bytes{bucket=\"mock_bucket\",prefix=\"mock_prefix\"} 200")
-	assert.HTTPBodyContains(t, handler, "GET", uri, nil, "cpe_vault_object_size_bytes_total{bucket=\"mock_bucket\",prefix=\"mock_prefix\"} 300")
+	assert.HTTPBodyContains(t, handler, "GET", uri, nil, "cpe_vault_object_count{AccountID=\"111\",bucket=\"mock_bucket\",group=\"\",prefix=\"mock_prefix\"} 2")
+	assert.HTTPBodyContains(t, handler, "GET", uri, nil, "cpe_vault_object_count{AccountID=\"222\",bucket=\"other_bucket\",group=\"\",prefix=\"other_prefix\"} 2")
+}
+
+func TestVaultBucketConfigured(t *testing.T) {
+	assert.False(t, vaultBucketConfigured(&config.Config{}))
+	assert.False(t, vaultBucketConfigured(&config.Config{AliCloud: &config.AliCloudConfig{Accounts: []config.AliCloudAccountConfig{{AccountID: "111"}}}}))
+	assert.True(t, vaultBucketConfigured(&config.Config{VaultBackupBucket: &config.VaultBackupBucketConfig{Bucket: "vault"}}))
+	// the bucket of an account alone is monitored too
+	assert.True(t, vaultBucketConfigured(&config.Config{AliCloud: &config.AliCloudConfig{Accounts: []config.AliCloudAccountConfig{
+		{AccountID: "111", VaultBackupBucket: &config.VaultBackupBucketConfig{Bucket: "vault"}},
+	}}}))
 }
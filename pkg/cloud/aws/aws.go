@@ -9,7 +9,9 @@ import (
 	"github.wdf.sap.corp/DBaaS/cloud-provider-exporter/pkg/cloud/aws/monitor"
 	"github.wdf.sap.corp/DBaaS/cloud-provider-exporter/pkg/cloud/aws/quota"
 	"github.wdf.sap.corp/DBaaS/cloud-provider-exporter/pkg/cloud/aws/vault_bucket"
+	"github.wdf.sap.corp/DBaaS/cloud-provider-exporter/pkg/cloud/objectstore"
 	"github.wdf.sap.corp/DBaaS/cloud-provider-exporter/pkg/config"
+	"github.wdf.sap.corp/DBaaS/cloud-provider-exporter/pkg/constant"
 	"github.wdf.sap.corp/DBaaS/cloud-provider-exporter/pkg/vault"
 	"net/http"
 )
@@ -25,30 +27,51 @@ func (e *AwsExporter) StartExporter(ctx context.Context, config *config.Config,
 	e.quotaCollector = quota.NewMetricsCollectorAwsQuota(config, credential, logger)
 	prometheus.MustRegister(e.quotaCollector)
 
-	//e.healthCollector = health.NewMetricsCollectorAwsHealth(config, credential, logger)
-	//prometheus.MustRegister(e.healthCollector)
-	//
-	//e.cloudWatchCollector = monitor.NewMetricsCollectorAwsMonitor(config, credential, logger)
-	//http.HandleFunc(constant.MetricsMonitorPath, func(w http.ResponseWriter, r *http.Request) {
-	//	handler(w, r, e.cloudWatchCollector)
-	//})
-
-	//if config.Project == "hc-vault" {
-	//	e.bucketCollector = vault_bucket.NewMetricsCollectorAWSVaultBucket(config, credential, logger)
-	//	http.HandleFunc(constant.VaultMonitorPath, func(w http.ResponseWriter, r *http.Request) {
-	//		handler(w, r, e.bucketCollector)
-	//	})
-	//}
+	e.healthCollector = health.NewMetricsCollectorAwsHealth(config, credential, logger)
+	e.registerHealth(config, http.DefaultServeMux, prometheus.DefaultRegisterer)
+	go e.healthCollector.Run(ctx)
+
+	if cloudWatchConfigured(config) {
+		e.cloudWatchCollector = monitor.NewMetricsCollectorAwsMonitor(config, credential, logger)
+		e.registerCloudWatch(http.DefaultServeMux)
+	}
+
+	if len(objectstore.Targets(config)) > 0 {
+		e.bucketCollector = vault_bucket.NewMetricsCollectorAWSVaultBucket(config, credential, logger)
+		e.registerVaultBucket(http.DefaultServeMux)
+	}
 }
 
-func (e *AwsExporter) Scrape(ctx context.Context) {
-	e.quotaCollector.Scrape(ctx)
-	//e.cloudWatchCollector.Scrape(ctx)
+// registerHealth registers the health collector and mounts its webhook if one is configured
+func (e *AwsExporter) registerHealth(config *config.Config, mux *http.ServeMux, registerer prometheus.Registerer) {
+	registerer.MustRegister(e.healthCollector)
+	if config.Health != nil && config.Health.Webhook != nil {
+		mux.Handle(constant.HealthWebhookAwsPath, e.healthCollector.WebhookHandler())
+	}
+}
+
+// cloudWatchConfigured is true if CloudWatch jobs or static jobs are configured
+func cloudWatchConfigured(config *config.Config) bool {
+	if config.Aws == nil {
+		return false
+	}
+	return len(config.Aws.CloudWatchMetricsConf.Jobs) > 0 || len(config.Aws.CloudWatchMetricsConf.Static) > 0
 }
 
-func handler(w http.ResponseWriter, r *http.Request, collector prometheus.Collector) {
-	registry := prometheus.NewRegistry()
-	registry.MustRegister(collector)
-	h := promhttp.HandlerFor(registry, promhttp.HandlerOpts{})
-	h.ServeHTTP(w, r)
+// registerCloudWatch mounts the CloudWatch metrics, the collector gathers itself since a registry rejects the
+// several samples per series of the aggregation all
+func (e *AwsExporter) registerCloudWatch(mux *http.ServeMux) {
+	mux.Handle(constant.MetricsMonitorPath, promhttp.HandlerFor(e.cloudWatchCollector, promhttp.HandlerOpts{}))
+}
+
+// registerVaultBucket mounts the metrics of the object store targets
+func (e *AwsExporter) registerVaultBucket(mux *http.ServeMux) {
+	mux.Handle(constant.VaultMonitorPath, objectstore.Handler(e.bucketCollector))
+}
+
+func (e *AwsExporter) Scrape(ctx context.Context) {
+	e.quotaCollector.Scrape(ctx)
+	if e.cloudWatchCollector != nil {
+		e.cloudWatchCollector.Scrape(ctx)
+	}
 }
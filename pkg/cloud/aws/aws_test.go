@@ -0,0 +1,89 @@
+package aws
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	log "github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.wdf.sap.corp/DBaaS/cloud-provider-exporter/pkg/cloud/aws/health"
+	"github.wdf.sap.corp/DBaaS/cloud-provider-exporter/pkg/cloud/aws/monitor"
+	"github.wdf.sap.corp/DBaaS/cloud-provider-exporter/pkg/cloud/aws/vault_bucket"
+	"github.wdf.sap.corp/DBaaS/cloud-provider-exporter/pkg/config"
+	"github.wdf.sap.corp/DBaaS/cloud-provider-exporter/pkg/vault"
+)
+
+var healthEvent = `{
+  "detail-type": "AWS Health Event",
+  "source": "aws.health",
+  "account": "123456789012",
+  "region": "eu-central-1",
+  "detail": {
+    "eventArn": "arn:aws:health:eu-central-1::event/EC2/AWS_EC2_OPERATIONAL_ISSUE/AWS_EC2_OPERATIONAL_ISSUE_7f35c8ae",
+    "service": "EC2",
+    "eventTypeCode": "AWS_EC2_OPERATIONAL_ISSUE",
+    "eventTypeCategory": "issue",
+    "eventScopeCode": "ACCOUNT_SPECIFIC",
+    "startTime": "Fri, 27 Jan 2023 06:02:51 GMT",
+    "lastUpdatedTime": "Fri, 27 Jan 2023 09:01:22 GMT",
+    "statusCode": "open",
+    "eventRegion": "eu-central-1"
+  }
+}`
+
+func TestRegisterHealth(t *testing.T) {
+	cred := vault.CloudCredentials{
+		vault.AwsAccessKeyID:     "accessKeyID",
+		vault.AwsSecretAccessKey: "secretAccessKey",
+	}
+	conf := &config.Config{Region: "eu-central-1", Aws: &config.AwsConfig{}}
+
+	// without a webhook only the collector is registered
+	e := &AwsExporter{healthCollector: health.NewMetricsCollectorAwsHealth(conf, cred, &log.Logger{})}
+	mux := http.NewServeMux()
+	e.registerHealth(conf, mux, prometheus.NewRegistry())
+	_, pattern := mux.Handler(httptest.NewRequest(http.MethodPost, "/webhook/aws/health", nil))
+	assert.Empty(t, pattern)
+
+	conf.Health = &config.HealthConfig{Webhook: &config.HealthWebhookConfig{Token: "secret"}}
+	e = &AwsExporter{healthCollector: health.NewMetricsCollectorAwsHealth(conf, cred, &log.Logger{})}
+	mux = http.NewServeMux()
+	registry := prometheus.NewRegistry()
+	e.registerHealth(conf, mux, registry)
+	request := httptest.NewRequest(http.MethodPost, "/webhook/aws/health", strings.NewReader(healthEvent))
+	request.Header.Set("X-Webhook-Token", "secret")
+	recorder := httptest.NewRecorder()
+	mux.ServeHTTP(recorder, request)
+	assert.Equal(t, http.StatusOK, recorder.Code)
+
+	handler := promhttp.HandlerFor(registry, promhttp.HandlerOpts{})
+	assert.HTTPBodyContains(t, handler.ServeHTTP, "GET", "/metrics", nil, "eventID=\"arn:aws:health:eu-central-1::event/EC2/AWS_EC2_OPERATIONAL_ISSUE/AWS_EC2_OPERATIONAL_ISSUE_7f35c8ae\"")
+	assert.HTTPBodyContains(t, handler.ServeHTTP, "GET", "/metrics", nil, "cpe_health_events_opened_total{availabilityZone=\"\",cloudService=\"EC2\",eventType=\"issue\"} 1")
+}
+
+func TestRegisterCloudWatch(t *testing.T) {
+	assert.False(t, cloudWatchConfigured(&config.Config{}))
+	assert.False(t, cloudWatchConfigured(&config.Config{Aws: &config.AwsConfig{}}))
+	assert.True(t, cloudWatchConfigured(&config.Config{Aws: &config.AwsConfig{CloudWatchMetricsConf: config.CloudWatchMetricsConf{Jobs: []*config.Job{{Type: "ec2"}}}}}))
+	assert.True(t, cloudWatchConfigured(&config.Config{Aws: &config.AwsConfig{CloudWatchMetricsConf: config.CloudWatchMetricsConf{Static: []*config.Static{{Name: "vcpus"}}}}}))
+
+	e := &AwsExporter{cloudWatchCollector: &monitor.MetricsCollectorAwsMonitor{}}
+	mux := http.NewServeMux()
+	e.registerCloudWatch(mux)
+	recorder := httptest.NewRecorder()
+	mux.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/monitor", nil))
+	assert.Equal(t, http.StatusOK, recorder.Code)
+}
+
+func TestRegisterVaultBucket(t *testing.T) {
+	conf := &config.Config{Region: "eu-central-1", VaultBackupBucket: &config.VaultBackupBucketConfig{Bucket: "vault", Prefix: "vault"}}
+	e := &AwsExporter{bucketCollector: vault_bucket.NewMetricsCollectorAWSVaultBucket(conf, vault.CloudCredentials{}, &log.Logger{})}
+	mux := http.NewServeMux()
+	e.registerVaultBucket(mux)
+	_, pattern := mux.Handler(httptest.NewRequest(http.MethodGet, "/bucket", nil))
+	assert.Equal(t, "/bucket", pattern)
+}
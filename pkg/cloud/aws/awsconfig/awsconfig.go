@@ -0,0 +1,147 @@
+package awsconfig
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awsConf "github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.wdf.sap.corp/DBaaS/cloud-provider-exporter/pkg/config"
+	"github.wdf.sap.corp/DBaaS/cloud-provider-exporter/pkg/vault"
+	"os"
+	"strings"
+)
+
+const (
+	PartitionAws      = "aws"
+	PartitionChina    = "aws-cn"
+	PartitionGovCloud = "aws-us-gov"
+
+	// DefaultEndpoint is the key of the endpoint override used by every service without its own
+	DefaultEndpoint = "default"
+
+	// S3 compatible stores mostly ignore the region, but it is part of the signature
+	defaultS3CompatibleRegion = "us-east-1"
+)
+
+// healthRegions are the regions serving the Health API, which has a single endpoint in each partition
+var healthRegions = map[string]string{
+	PartitionAws:      "us-east-1",
+	PartitionChina:    "cn-northwest-1",
+	PartitionGovCloud: "us-gov-west-1",
+}
+
+// Partition returns the configured partition, the commercial one by default.
+func Partition(conf *config.Config) string {
+	if conf.Aws == nil || conf.Aws.Partition == "" {
+		return PartitionAws
+	}
+	return conf.Aws.Partition
+}
+
+// HealthRegion returns the region serving the Health API of the configured partition.
+func HealthRegion(conf *config.Config) string {
+	return healthRegions[Partition(conf)]
+}
+
+func partitionOfRegion(region string) string {
+	switch {
+	case strings.HasPrefix(region, "cn-"):
+		return PartitionChina
+	case strings.HasPrefix(region, "us-gov-"):
+		return PartitionGovCloud
+	default:
+		return PartitionAws
+	}
+}
+
+// Validate checks that the partition is known and that the region belongs to it.
+func Validate(conf *config.Config) error {
+	name := Partition(conf)
+	if _, ok := healthRegions[name]; !ok {
+		return fmt.Errorf("unknown partition %s", name)
+	}
+	if partitionOfRegion(conf.Region) != name {
+		return fmt.Errorf("region %s is not in partition %s", conf.Region, name)
+	}
+	return nil
+}
+
+// StaticCredentials returns the provider of the access key stored in vault.
+func StaticCredentials(cred vault.CloudCredentials) aws.CredentialsProvider {
+	return credentials.StaticCredentialsProvider{Value: aws.Credentials{
+		AccessKeyID:     cred[vault.AwsAccessKeyID],
+		SecretAccessKey: cred[vault.AwsSecretAccessKey],
+	}}
+}
+
+// Load returns the config of the SDK clients in the configured region, with the configured endpoint overrides.
+func Load(ctx context.Context, conf *config.Config, provider aws.CredentialsProvider) (aws.Config, error) {
+	if err := Validate(conf); err != nil {
+		return aws.Config{}, err
+	}
+	options := []func(*awsConf.LoadOptions) error{awsConf.WithCredentialsProvider(provider), awsConf.WithRegion(conf.Region)}
+	if conf.Aws != nil && len(conf.Aws.Endpoints) > 0 {
+		options = append(options, awsConf.WithEndpointResolverWithOptions(NewEndpointResolver(conf.Aws.Endpoints, Partition(conf))))
+	}
+	return awsConf.LoadDefaultConfig(ctx, options...)
+}
+
+// LoadS3Compatible returns the config of the S3 clients of an S3 compatible store, with the access key of the
+// endpoint or else the given provider.
+func LoadS3Compatible(ctx context.Context, endpoint *config.S3EndpointConfig, provider aws.CredentialsProvider) (aws.Config, error) {
+	if endpoint.Endpoint == "" {
+		return aws.Config{}, errors.New("the endpoint of the S3 compatible store is missing")
+	}
+	region := endpoint.Region
+	if region == "" {
+		region = defaultS3CompatibleRegion
+	}
+	if endpoint.AccessKeyID != "" {
+		provider = credentials.NewStaticCredentialsProvider(endpoint.AccessKeyID, endpoint.SecretAccessKey, "")
+	}
+	options := []func(*awsConf.LoadOptions) error{
+		awsConf.WithCredentialsProvider(provider),
+		awsConf.WithRegion(region),
+		awsConf.WithEndpointResolverWithOptions(NewEndpointResolver(map[string]string{"s3": endpoint.Endpoint}, PartitionAws)),
+	}
+	if endpoint.CABundle != "" {
+		bundle, err := os.ReadFile(endpoint.CABundle)
+		if err != nil {
+			return aws.Config{}, err
+		}
+		options = append(options, awsConf.WithCustomCABundle(bytes.NewReader(bundle)))
+	}
+	return awsConf.LoadDefaultConfig(ctx, options...)
+}
+
+// NewEndpointResolver resolves the services to the URLs of endpoints, keyed by the service ID in lower case
+// without spaces (e.g. s3, servicequotas, resourcegroupstaggingapi) or by default for every service. The
+// services without an override are resolved by the SDK.
+func NewEndpointResolver(endpoints map[string]string, partitionID string) aws.EndpointResolverWithOptions {
+	urls := make(map[string]string, len(endpoints))
+	for service, url := range endpoints {
+		urls[serviceKey(service)] = url
+	}
+	return aws.EndpointResolverWithOptionsFunc(func(service, region string, options ...interface{}) (aws.Endpoint, error) {
+		url, ok := urls[serviceKey(service)]
+		if !ok {
+			url, ok = urls[DefaultEndpoint]
+		}
+		if !ok {
+			return aws.Endpoint{}, &aws.EndpointNotFoundError{}
+		}
+		return aws.Endpoint{
+			URL:               url,
+			PartitionID:       partitionID,
+			SigningRegion:     region,
+			HostnameImmutable: true,
+		}, nil
+	})
+}
+
+func serviceKey(service string) string {
+	return strings.ToLower(strings.ReplaceAll(service, " ", ""))
+}
@@ -0,0 +1,85 @@
+package awsconfig
+
+import (
+	"context"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/servicequotas"
+	"github.com/stretchr/testify/assert"
+	"github.wdf.sap.corp/DBaaS/cloud-provider-exporter/pkg/config"
+	"github.wdf.sap.corp/DBaaS/cloud-provider-exporter/pkg/vault"
+	"testing"
+)
+
+func TestAwsPartition(t *testing.T) {
+	conf := &config.Config{Region: "eu-central-1"}
+	assert.Nil(t, Validate(conf))
+	assert.Equal(t, "us-east-1", HealthRegion(conf))
+
+	conf = &config.Config{Region: "cn-north-1", Aws: &config.AwsConfig{Partition: PartitionChina}}
+	assert.Nil(t, Validate(conf))
+	assert.Equal(t, "cn-northwest-1", HealthRegion(conf))
+
+	conf = &config.Config{Region: "us-gov-east-1", Aws: &config.AwsConfig{Partition: PartitionGovCloud}}
+	assert.Nil(t, Validate(conf))
+	assert.Equal(t, "us-gov-west-1", HealthRegion(conf))
+
+	conf = &config.Config{Region: "cn-north-1"}
+	assert.EqualError(t, Validate(conf), "region cn-north-1 is not in partition aws")
+	conf = &config.Config{Region: "eu-central-1", Aws: &config.AwsConfig{Partition: "aws-iso"}}
+	assert.EqualError(t, Validate(conf), "unknown partition aws-iso")
+	_, err := Load(context.TODO(), conf, StaticCredentials(vault.CloudCredentials{}))
+	assert.EqualError(t, err, "unknown partition aws-iso")
+}
+
+func TestAwsEndpointResolver(t *testing.T) {
+	resolver := NewEndpointResolver(map[string]string{
+		"default":        "http://localhost:4566",
+		"Service Quotas": "https://quotas.local",
+	}, PartitionChina)
+
+	endpoint, err := resolver.ResolveEndpoint(servicequotas.ServiceID, "cn-north-1")
+	assert.Nil(t, err)
+	assert.Equal(t, "https://quotas.local", endpoint.URL)
+	assert.Equal(t, "cn-north-1", endpoint.SigningRegion)
+	assert.Equal(t, PartitionChina, endpoint.PartitionID)
+
+	endpoint, err = resolver.ResolveEndpoint(s3.ServiceID, "cn-north-1")
+	assert.Nil(t, err)
+	assert.Equal(t, "http://localhost:4566", endpoint.URL)
+
+	resolver = NewEndpointResolver(map[string]string{"s3": "http://localhost:9000"}, PartitionAws)
+	_, err = resolver.ResolveEndpoint(servicequotas.ServiceID, "eu-central-1")
+	assert.IsType(t, &aws.EndpointNotFoundError{}, err)
+
+	conf := &config.Config{Region: "eu-central-1", Aws: &config.AwsConfig{Endpoints: map[string]string{"s3": "http://localhost:9000"}}}
+	cfg, err := Load(context.TODO(), conf, StaticCredentials(vault.CloudCredentials{}))
+	assert.Nil(t, err)
+	assert.Equal(t, "eu-central-1", cfg.Region)
+	endpoint, err = cfg.EndpointResolverWithOptions.ResolveEndpoint(s3.ServiceID, cfg.Region)
+	assert.Nil(t, err)
+	assert.Equal(t, "http://localhost:9000", endpoint.URL)
+}
+
+func TestLoadS3Compatible(t *testing.T) {
+	fallback := StaticCredentials(vault.CloudCredentials{vault.AwsAccessKeyID: "exporter", vault.AwsSecretAccessKey: "secret"})
+	_, err := LoadS3Compatible(context.TODO(), &config.S3EndpointConfig{}, fallback)
+	assert.EqualError(t, err, "the endpoint of the S3 compatible store is missing")
+
+	cfg, err := LoadS3Compatible(context.TODO(), &config.S3EndpointConfig{Endpoint: "http://localhost:9000"}, fallback)
+	assert.NoError(t, err)
+	assert.Equal(t, "us-east-1", cfg.Region)
+	creds, _ := cfg.Credentials.Retrieve(context.TODO())
+	assert.Equal(t, "exporter", creds.AccessKeyID)
+	endpoint, _ := cfg.EndpointResolverWithOptions.ResolveEndpoint(s3.ServiceID, cfg.Region)
+	assert.Equal(t, "http://localhost:9000", endpoint.URL)
+
+	cfg, err = LoadS3Compatible(context.TODO(), &config.S3EndpointConfig{Endpoint: "http://localhost:9000", Region: "minio", AccessKeyID: "minio", SecretAccessKey: "minio123"}, fallback)
+	assert.NoError(t, err)
+	assert.Equal(t, "minio", cfg.Region)
+	creds, _ = cfg.Credentials.Retrieve(context.TODO())
+	assert.Equal(t, "minio", creds.AccessKeyID)
+
+	_, err = LoadS3Compatible(context.TODO(), &config.S3EndpointConfig{Endpoint: "http://localhost:9000", CABundle: "missing.pem"}, fallback)
+	assert.Error(t, err)
+}
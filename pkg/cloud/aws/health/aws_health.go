@@ -3,15 +3,16 @@ package health
 import (
 	"context"
 	"github.com/aws/aws-sdk-go-v2/aws"
-	awsConf "github.com/aws/aws-sdk-go-v2/config"
-	"github.com/aws/aws-sdk-go-v2/credentials"
 	"github.com/aws/aws-sdk-go-v2/service/health"
 	"github.com/aws/aws-sdk-go-v2/service/health/types"
+	"github.com/aws/aws-sdk-go-v2/service/resourcegroupstaggingapi"
 	"github.com/prometheus/client_golang/prometheus"
 	log "github.com/sirupsen/logrus"
+	"github.wdf.sap.corp/DBaaS/cloud-provider-exporter/pkg/cloud/aws/awsconfig"
 	"github.wdf.sap.corp/DBaaS/cloud-provider-exporter/pkg/cloud/common"
 	"github.wdf.sap.corp/DBaaS/cloud-provider-exporter/pkg/config"
 	"github.wdf.sap.corp/DBaaS/cloud-provider-exporter/pkg/vault"
+	"time"
 )
 
 type IHealthClient interface {
@@ -19,24 +20,34 @@ type IHealthClient interface {
 	DescribeAffectedEntities(context.Context, *health.DescribeAffectedEntitiesInput, ...func(*health.Options)) (*health.DescribeAffectedEntitiesOutput, error)
 }
 
+// ITaggingClient Mock resourcegroupstaggingapi.Client for test
+type ITaggingClient interface {
+	GetResources(context.Context, *resourcegroupstaggingapi.GetResourcesInput, ...func(*resourcegroupstaggingapi.Options)) (*resourcegroupstaggingapi.GetResourcesOutput, error)
+}
+
 type MetricsCollectorAwsHealth struct {
-	conf         *config.Config
-	healthClient IHealthClient
-	log          log.FieldLogger
+	conf          *config.Config
+	healthClient  IHealthClient
+	taggingClient ITaggingClient
+	poller        *common.HealthPoller
+	snsVerifier   *snsVerifier
+	log           log.FieldLogger
 }
 
 func NewMetricsCollectorAwsHealth(config *config.Config, cred vault.CloudCredentials, logger log.FieldLogger) *MetricsCollectorAwsHealth {
 	m := &MetricsCollectorAwsHealth{}
 	m.conf = config
 	m.log = logger
-	cfg, err := awsConf.LoadDefaultConfig(context.TODO(), awsConf.WithCredentialsProvider(credentials.StaticCredentialsProvider{Value: aws.Credentials{
-		AccessKeyID:     cred[vault.AwsAccessKeyID],
-		SecretAccessKey: cred[vault.AwsSecretAccessKey],
-	}}), awsConf.WithRegion(config.Region))
+	cfg, err := awsconfig.Load(context.TODO(), config, awsconfig.StaticCredentials(cred))
 	if err != nil {
 		m.log.Fatal(err)
 	}
-	m.healthClient = health.NewFromConfig(cfg)
+	// the Health API is served from a single region of the partition
+	m.healthClient = health.NewFromConfig(cfg, func(o *health.Options) {
+		o.Region = awsconfig.HealthRegion(config)
+	})
+	m.taggingClient = resourcegroupstaggingapi.NewFromConfig(cfg)
+	m.snsVerifier = newSNSVerifier()
 	eventLabel := []string{
 		"eventID",
 		"cloudService",
@@ -64,21 +75,25 @@ func NewMetricsCollectorAwsHealth(config *config.Config, cred vault.CloudCredent
 	openTotalLabel := []string{"eventType", "availabilityZone", "cloudService"}
 	closeTotalLabel := []string{"eventType", "availabilityZone", "cloudService"}
 	common.InitHealthCounterVec("AWS", eventLabel, entityLabel, openTotalLabel, closeTotalLabel)
+	m.poller = common.NewHealthPoller(m, config, logger)
+	m.poller.SetFilter(config.Aws.HealthFilter)
 	return m
 }
 
 func (m *MetricsCollectorAwsHealth) Describe(ch chan<- *prometheus.Desc) {
-	common.HealthEvent.Describe(ch)
-	common.AffectedEntity.Describe(ch)
-	common.HealthOpenedTotal.Describe(ch)
-	common.HealthClosedTotal.Describe(ch)
+	m.poller.Describe(ch)
 }
 
 func (m *MetricsCollectorAwsHealth) Collect(ch chan<- prometheus.Metric) {
-	common.HealthOpenedTotal.Reset()
-	common.HealthClosedTotal.Reset()
-	common.HealthEvent.Reset()
-	common.AffectedEntity.Reset()
+	m.poller.Collect(ch)
+}
+
+// Run polls AWS Health in the background until ctx is cancelled.
+func (m *MetricsCollectorAwsHealth) Run(ctx context.Context) {
+	m.poller.Run(ctx)
+}
+
+func (m *MetricsCollectorAwsHealth) FetchEvents(ctx context.Context, since time.Time) ([]*common.HealthEventRecord, error) {
 	var eventArn [][]*string
 	var events []types.Event
 	var HealthEventStatusCodes []types.EventStatusCode
@@ -90,34 +105,35 @@ func (m *MetricsCollectorAwsHealth) Collect(ch chan<- prometheus.Metric) {
 		HealthEventTypeCategories = append(HealthEventTypeCategories, types.EventTypeCategory(EventTypeCategory))
 	}
 	eventFilter := &types.EventFilter{EventStatusCodes: HealthEventStatusCodes, EventTypeCategories: HealthEventTypeCategories} // closed, open, upcoming
+	if !since.IsZero() {
+		eventFilter.LastUpdatedTimes = []types.DateTimeRange{{From: aws.Time(since)}}
+	}
 	eventParams := &health.DescribeEventsInput{Filter: eventFilter}
 	eventPaginator := health.NewDescribeEventsPaginator(m.healthClient, eventParams)
 	for eventPaginator.HasMorePages() {
-		output, err := eventPaginator.NextPage(context.TODO())
+		output, err := eventPaginator.NextPage(ctx)
 		if err != nil {
-			m.log.Fatal(err)
+			return nil, err
 		}
 		events = append(events, output.Events...)
 	}
 
-	m.log.Infof("The number of total events: ", len(events))
+	m.log.Infof("The number of updated events: %d", len(events))
 	if len(events) == 0 {
-		return
+		return nil, nil
 	}
 
 	var arnList []*string
 	regionMap := make(map[string]string)
+	records := make(map[string]*common.HealthEventRecord)
+	result := make([]*common.HealthEventRecord, 0, len(events))
 	for _, event := range events {
-		common.HealthEvent.WithLabelValues(aws.ToString(event.Arn), aws.ToString(event.Service), aws.ToString(event.Region), aws.ToTime(event.StartTime).String(),
-			string(event.StatusCode), aws.ToTime(event.LastUpdatedTime).String(), aws.ToString(event.EventTypeCode),
-			string(event.EventTypeCategory), string(event.EventScopeCode), aws.ToString(event.AvailabilityZone)).Inc()
-		regionMap[aws.ToString(event.Arn)] = aws.ToString(event.Region)
+		arn := aws.ToString(event.Arn)
+		record := newEventRecord(event)
+		records[arn] = record
+		regionMap[arn] = aws.ToString(event.Region)
+		result = append(result, record)
 
-		if event.StatusCode == types.EventStatusCodeClosed {
-			common.HealthClosedTotal.WithLabelValues(string(event.EventTypeCategory), aws.ToString(event.AvailabilityZone), aws.ToString(event.Service)).Inc()
-		} else if event.StatusCode == types.EventStatusCodeOpen {
-			common.HealthOpenedTotal.WithLabelValues(string(event.EventTypeCategory), aws.ToString(event.AvailabilityZone), aws.ToString(event.Service)).Inc()
-		}
 		arnList = append(arnList, event.Arn)
 		if len(arnList) == 10 {
 			eventArn = append(eventArn, arnList)
@@ -128,27 +144,67 @@ func (m *MetricsCollectorAwsHealth) Collect(ch chan<- prometheus.Metric) {
 		eventArn = append(eventArn, arnList)
 	}
 
-	var entities []types.AffectedEntity
-
 	for _, arn := range eventArn {
-		entities = entities[:0]
 		entityFilter := &types.EntityFilter{EventArns: aws.ToStringSlice(arn)}
 		entityParams := &health.DescribeAffectedEntitiesInput{Filter: entityFilter}
 		entityPaginator := health.NewDescribeAffectedEntitiesPaginator(m.healthClient, entityParams)
 		for entityPaginator.HasMorePages() {
-			output, err := entityPaginator.NextPage(context.TODO())
+			output, err := entityPaginator.NextPage(ctx)
 			if err != nil {
-				m.log.Fatal(err)
+				return nil, err
+			}
+			for _, entity := range output.Entities {
+				record, ok := records[aws.ToString(entity.EventArn)]
+				if !ok {
+					continue
+				}
+				addAffectedEntity(record, regionMap[record.ID], entity)
 			}
-			entities = append(entities, output.Entities...)
 		}
-		for _, entity := range entities {
-			common.AffectedEntity.WithLabelValues(aws.ToString(entity.EventArn), aws.ToString(entity.AwsAccountId), regionMap[aws.ToString(entity.EventArn)], aws.ToString(entity.EntityArn),
-				string(entity.StatusCode), aws.ToString(entity.EntityValue), aws.ToString(entity.EntityUrl), aws.ToTime(entity.LastUpdatedTime).String()).Inc()
+	}
+	return result, nil
+}
+
+func newEventRecord(event types.Event) *common.HealthEventRecord {
+	arn := aws.ToString(event.Arn)
+	return &common.HealthEventRecord{
+		ID:              arn,
+		Service:         aws.ToString(event.Service),
+		Category:        string(event.EventTypeCategory),
+		Closed:          event.StatusCode == types.EventStatusCodeClosed,
+		StartTime:       aws.ToTime(event.StartTime),
+		EndTime:         aws.ToTime(event.EndTime),
+		LastUpdatedTime: aws.ToTime(event.LastUpdatedTime),
+		Regions:         []string{aws.ToString(event.Region)},
+		EventLabels: []string{arn, aws.ToString(event.Service), aws.ToString(event.Region), aws.ToTime(event.StartTime).String(),
+			string(event.StatusCode), aws.ToTime(event.LastUpdatedTime).String(), aws.ToString(event.EventTypeCode),
+			string(event.EventTypeCategory), string(event.EventScopeCode), aws.ToString(event.AvailabilityZone)},
+		CounterLabels: []string{string(event.EventTypeCategory), aws.ToString(event.AvailabilityZone), aws.ToString(event.Service)},
+	}
+}
+
+func addAffectedEntity(record *common.HealthEventRecord, region string, entity types.AffectedEntity) {
+	record.Resources = append(record.Resources, aws.ToString(entity.EntityArn))
+	record.Entities = append(record.Entities, []string{record.ID, aws.ToString(entity.AwsAccountId), region, aws.ToString(entity.EntityArn),
+		string(entity.StatusCode), aws.ToString(entity.EntityValue), aws.ToString(entity.EntityUrl), aws.ToTime(entity.LastUpdatedTime).String()})
+}
+
+// ResourceMatcher reports the resources visible through the tagging API as ours.
+func (m *MetricsCollectorAwsHealth) ResourceMatcher(ctx context.Context) (func(resource string) bool, error) {
+	resources := make(map[string]struct{})
+	paginator := resourcegroupstaggingapi.NewGetResourcesPaginator(m.taggingClient, &resourcegroupstaggingapi.GetResourcesInput{ResourcesPerPage: aws.Int32(100)})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, err
+		}
+		for _, mapping := range page.ResourceTagMappingList {
+			resources[aws.ToString(mapping.ResourceARN)] = struct{}{}
 		}
 	}
-	common.HealthEvent.Collect(ch)
-	common.AffectedEntity.Collect(ch)
-	common.HealthOpenedTotal.Collect(ch)
-	common.HealthClosedTotal.Collect(ch)
+	m.log.Infof("%d tagged resources found for health filter", len(resources))
+	return func(resource string) bool {
+		_, ok := resources[resource]
+		return ok
+	}, nil
 }
@@ -29,7 +29,7 @@ func (m *MockHealthClient) DescribeAffectedEntities(ctx context.Context, input *
 				EntityArn:       aws.String("dummyEntityArn"),
 				EntityUrl:       aws.String("dummyEntityUrl"),
 				EntityValue:     aws.String("dummyValue"),
-				EventArn:        aws.String("dummyEventArn"),
+				EventArn:        aws.String(input.Filter.EventArns[0]),
 				LastUpdatedTime: aws.Time(t),
 				StatusCode:      types.EntityStatusCodeImpaired,
 			},
@@ -98,6 +98,7 @@ func TestAwsHealth(t *testing.T) {
 	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		healthCollector := NewMetricsCollectorAwsHealth(conf, cred, &log.Logger{})
 		healthCollector.healthClient = &MockHealthClient{}
+		_ = healthCollector.poller.Poll(context.TODO())
 		registry := prometheus.NewRegistry()
 		registry.MustRegister(healthCollector)
 		h := promhttp.HandlerFor(registry, promhttp.HandlerOpts{})
@@ -105,7 +106,7 @@ func TestAwsHealth(t *testing.T) {
 	})
 
 	assert.HTTPBodyContains(t, handler, "GET", uri, nil, "cpe_health_events{availabilityZone=\"dummyAZ\",cloudService=\"dummyService\",eventID=\"dummyArn\",eventRegion=\"dummyRegion\",eventScopeCode=\"NONE\",eventType=\"issue\",eventTypeCode=\"dummyEventTypeCode\",lastUpdatedTime=\"2016-07-27 00:57:46 +0000 UTC\",startTime=\"2016-07-27 00:57:46 +0000 UTC\",statusCode=\"open\"} 1")
-	assert.HTTPBodyContains(t, handler, "GET", uri, nil, "cpe_health_events_affected{accountID=\"dummyAccountID\",affectedRegions=\"\",entityArn=\"dummyEntityArn\",entityUrl=\"dummyEntityUrl\",entityValue=\"dummyValue\",eventID=\"dummyEventArn\",lastUpdatedTime=\"2016-07-27 00:57:46 +0000 UTC\",statusCode=\"IMPAIRED\"} 1")
+	assert.HTTPBodyContains(t, handler, "GET", uri, nil, "cpe_health_events_affected{accountID=\"dummyAccountID\",affectedRegions=\"dummyRegion\",entityArn=\"dummyEntityArn\",entityUrl=\"dummyEntityUrl\",entityValue=\"dummyValue\",eventID=\"dummyArn\",lastUpdatedTime=\"2016-07-27 00:57:46 +0000 UTC\",statusCode=\"IMPAIRED\"} 1")
 	assert.HTTPBodyContains(t, handler, "GET", uri, nil, "cpe_health_events_opened_total{availabilityZone=\"dummyAZ\",cloudService=\"dummyService\",eventType=\"issue\"} 1")
 	assert.HTTPBodyContains(t, handler, "GET", uri, nil, "cpe_health_events_closed_total{availabilityZone=\"dummyAZ1\",cloudService=\"dummyService1\",eventType=\"issue\"} 2")
 }
@@ -0,0 +1,341 @@
+package health
+
+import (
+	"context"
+	"crypto"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/health/types"
+	"github.wdf.sap.corp/DBaaS/cloud-provider-exporter/pkg/cloud/common"
+	"github.wdf.sap.corp/DBaaS/cloud-provider-exporter/pkg/config"
+)
+
+const (
+	snsMessageTypeHeader = "x-amz-sns-message-type"
+	healthEventSource    = "aws.health"
+)
+
+var snsHostPattern = regexp.MustCompile(`^sns\.[a-z0-9-]+\.amazonaws\.com(\.cn)?$`)
+
+// snsMessage is the envelope SNS posts to HTTP(S) subscriptions.
+type snsMessage struct {
+	Type             string `json:"Type"`
+	MessageID        string `json:"MessageId"`
+	Token            string `json:"Token"`
+	TopicArn         string `json:"TopicArn"`
+	Subject          string `json:"Subject"`
+	Message          string `json:"Message"`
+	Timestamp        string `json:"Timestamp"`
+	SignatureVersion string `json:"SignatureVersion"`
+	Signature        string `json:"Signature"`
+	SigningCertURL   string `json:"SigningCertURL"`
+	SubscribeURL     string `json:"SubscribeURL"`
+}
+
+// eventBridgeEvent is an AWS Health event as emitted by EventBridge.
+type eventBridgeEvent struct {
+	ID         string            `json:"id"`
+	DetailType string            `json:"detail-type"`
+	Source     string            `json:"source"`
+	Account    string            `json:"account"`
+	Time       string            `json:"time"`
+	Region     string            `json:"region"`
+	Detail     healthEventDetail `json:"detail"`
+}
+
+type healthEventDetail struct {
+	EventArn          string `json:"eventArn"`
+	Service           string `json:"service"`
+	EventTypeCode     string `json:"eventTypeCode"`
+	EventTypeCategory string `json:"eventTypeCategory"`
+	EventScopeCode    string `json:"eventScopeCode"`
+	StartTime         string `json:"startTime"`
+	EndTime           string `json:"endTime"`
+	LastUpdatedTime   string `json:"lastUpdatedTime"`
+	StatusCode        string `json:"statusCode"`
+	EventRegion       string `json:"eventRegion"`
+	AvailabilityZone  string `json:"availabilityZone"`
+	AffectedAccount   string `json:"affectedAccount"`
+	AffectedEntities  []struct {
+		EntityValue     string `json:"entityValue"`
+		EntityArn       string `json:"entityArn"`
+		Status          string `json:"status"`
+		LastUpdatedTime string `json:"lastUpdatedTime"`
+	} `json:"affectedEntities"`
+}
+
+// WebhookHandler receives AWS Health events pushed by SNS or by an EventBridge API destination
+// and merges them into the collected events.
+func (m *MetricsCollectorAwsHealth) WebhookHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conf := common.WebhookConfig(m.conf)
+		isSNS := r.Header.Get(snsMessageTypeHeader) != ""
+		source := "eventbridge"
+		if isSNS {
+			source = "sns"
+		}
+		body, ok := common.ReadWebhookRequest(w, r, conf, source)
+		if !ok {
+			return
+		}
+		if isSNS {
+			m.handleSNS(w, r.Context(), body, conf)
+			return
+		}
+		// API destinations deliver the bare event, only the shared token authenticates it
+		if conf == nil || conf.Token == "" {
+			m.log.Errorf("EventBridge health event rejected, no webhook token configured")
+			common.WebhookDone(w, "eventbridge", common.WebhookRejected, http.StatusUnauthorized)
+			return
+		}
+		m.handleEvent(w, body, "eventbridge")
+	})
+}
+
+func (m *MetricsCollectorAwsHealth) handleSNS(w http.ResponseWriter, ctx context.Context, body []byte, conf *config.HealthWebhookConfig) {
+	msg := &snsMessage{}
+	if err := json.Unmarshal(body, msg); err != nil {
+		m.log.Errorf("Invalid SNS message: %v", err)
+		common.WebhookDone(w, "sns", common.WebhookInvalid, http.StatusBadRequest)
+		return
+	}
+	if conf != nil && len(conf.TopicArns) > 0 && !contains(conf.TopicArns, msg.TopicArn) {
+		m.log.Errorf("SNS message from unexpected topic %s rejected", msg.TopicArn)
+		common.WebhookDone(w, "sns", common.WebhookRejected, http.StatusForbidden)
+		return
+	}
+	if err := m.snsVerifier.verify(ctx, msg); err != nil {
+		m.log.Errorf("SNS message %s rejected: %v", msg.MessageID, err)
+		common.WebhookDone(w, "sns", common.WebhookRejected, http.StatusForbidden)
+		return
+	}
+
+	switch msg.Type {
+	case "SubscriptionConfirmation":
+		if err := confirmSubscription(ctx, msg.SubscribeURL); err != nil {
+			m.log.Errorf("Error while confirming SNS subscription to %s: %v", msg.TopicArn, err)
+			common.WebhookDone(w, "sns", common.WebhookInvalid, http.StatusBadGateway)
+			return
+		}
+		m.log.Infof("SNS subscription to %s confirmed", msg.TopicArn)
+		common.WebhookDone(w, "sns", common.WebhookAccepted, http.StatusOK)
+	case "Notification":
+		m.handleEvent(w, []byte(msg.Message), "sns")
+	default:
+		common.WebhookDone(w, "sns", common.WebhookIgnored, http.StatusOK)
+	}
+}
+
+func (m *MetricsCollectorAwsHealth) handleEvent(w http.ResponseWriter, body []byte, source string) {
+	event := &eventBridgeEvent{}
+	if err := json.Unmarshal(body, event); err != nil {
+		m.log.Errorf("Invalid health event: %v", err)
+		common.WebhookDone(w, source, common.WebhookInvalid, http.StatusBadRequest)
+		return
+	}
+	if event.Source != healthEventSource {
+		// acknowledge to stop redelivery of events the rule should not have matched
+		common.WebhookDone(w, source, common.WebhookIgnored, http.StatusOK)
+		return
+	}
+	if event.Detail.EventArn == "" {
+		common.WebhookDone(w, source, common.WebhookInvalid, http.StatusBadRequest)
+		return
+	}
+	m.poller.Merge([]*common.HealthEventRecord{event.record()})
+	m.log.Infof("Health event %s received through %s", event.Detail.EventArn, source)
+	common.WebhookDone(w, source, common.WebhookAccepted, http.StatusOK)
+}
+
+// record converts the event to the record FetchEvents would have built for it.
+func (e *eventBridgeEvent) record() *common.HealthEventRecord {
+	d := e.Detail
+	region := d.EventRegion
+	if region == "" {
+		region = e.Region
+	}
+	lastUpdated := parseEventTime(d.LastUpdatedTime)
+	if lastUpdated == nil {
+		lastUpdated = parseEventTime(e.Time)
+	}
+	record := newEventRecord(types.Event{
+		Arn:               aws.String(d.EventArn),
+		Service:           aws.String(d.Service),
+		Region:            aws.String(region),
+		AvailabilityZone:  aws.String(d.AvailabilityZone),
+		StartTime:         parseEventTime(d.StartTime),
+		EndTime:           parseEventTime(d.EndTime),
+		LastUpdatedTime:   lastUpdated,
+		StatusCode:        types.EventStatusCode(d.StatusCode),
+		EventTypeCode:     aws.String(d.EventTypeCode),
+		EventTypeCategory: types.EventTypeCategory(d.EventTypeCategory),
+		EventScopeCode:    types.EventScopeCode(d.EventScopeCode),
+	})
+	account := d.AffectedAccount
+	if account == "" {
+		account = e.Account
+	}
+	for _, entity := range d.AffectedEntities {
+		entityUpdated := parseEventTime(entity.LastUpdatedTime)
+		if entityUpdated == nil {
+			entityUpdated = lastUpdated
+		}
+		addAffectedEntity(record, region, types.AffectedEntity{
+			EventArn:        aws.String(d.EventArn),
+			AwsAccountId:    aws.String(account),
+			EntityArn:       aws.String(entity.EntityArn),
+			EntityValue:     aws.String(entity.EntityValue),
+			StatusCode:      types.EntityStatusCode(entity.Status),
+			LastUpdatedTime: entityUpdated,
+		})
+	}
+	return record
+}
+
+// parseEventTime reads the RFC 1123 times of health events, and the RFC 3339 time of the envelope.
+func parseEventTime(value string) *time.Time {
+	for _, layout := range []string{time.RFC1123, time.RFC3339} {
+		if t, err := time.Parse(layout, value); err == nil {
+			return aws.Time(t.UTC())
+		}
+	}
+	return nil
+}
+
+// snsVerifier checks SNS message signatures against the signing certificates, which are cached by URL.
+type snsVerifier struct {
+	mu    sync.Mutex
+	certs map[string]*x509.Certificate
+}
+
+func newSNSVerifier() *snsVerifier {
+	return &snsVerifier{certs: make(map[string]*x509.Certificate)}
+}
+
+func (v *snsVerifier) verify(ctx context.Context, msg *snsMessage) error {
+	var hash crypto.Hash
+	var digest []byte
+	switch msg.SignatureVersion {
+	case "1":
+		sum := sha1.Sum([]byte(msg.stringToSign()))
+		hash, digest = crypto.SHA1, sum[:]
+	case "2":
+		sum := sha256.Sum256([]byte(msg.stringToSign()))
+		hash, digest = crypto.SHA256, sum[:]
+	default:
+		return fmt.Errorf("unsupported signature version %q", msg.SignatureVersion)
+	}
+	signature, err := base64.StdEncoding.DecodeString(msg.Signature)
+	if err != nil {
+		return err
+	}
+	cert, err := v.certificate(ctx, msg.SigningCertURL)
+	if err != nil {
+		return err
+	}
+	key, ok := cert.PublicKey.(*rsa.PublicKey)
+	if !ok {
+		return errors.New("signing certificate has no RSA key")
+	}
+	return rsa.VerifyPKCS1v15(key, hash, digest, signature)
+}
+
+func (v *snsVerifier) certificate(ctx context.Context, certURL string) (*x509.Certificate, error) {
+	if !trustedSNSURL(certURL) {
+		return nil, fmt.Errorf("untrusted signing certificate URL %s", certURL)
+	}
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if cert, ok := v.certs[certURL]; ok {
+		return cert, nil
+	}
+	body, err := get(ctx, certURL)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(body)
+	if block == nil {
+		return nil, errors.New("signing certificate is not PEM encoded")
+	}
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	v.certs[certURL] = cert
+	return cert, nil
+}
+
+// stringToSign builds the canonical form SNS signs, see
+// https://docs.aws.amazon.com/sns/latest/dg/sns-verify-signature-of-message.html
+func (msg *snsMessage) stringToSign() string {
+	fields := [][2]string{{"Message", msg.Message}, {"MessageId", msg.MessageID}}
+	if msg.Type == "Notification" {
+		if msg.Subject != "" {
+			fields = append(fields, [2]string{"Subject", msg.Subject})
+		}
+		fields = append(fields, [2]string{"Timestamp", msg.Timestamp})
+	} else {
+		fields = append(fields, [2]string{"SubscribeURL", msg.SubscribeURL}, [2]string{"Timestamp", msg.Timestamp}, [2]string{"Token", msg.Token})
+	}
+	fields = append(fields, [2]string{"TopicArn", msg.TopicArn}, [2]string{"Type", msg.Type})
+
+	var b strings.Builder
+	for _, f := range fields {
+		b.WriteString(f[0] + "\n" + f[1] + "\n")
+	}
+	return b.String()
+}
+
+func confirmSubscription(ctx context.Context, subscribeURL string) error {
+	if !trustedSNSURL(subscribeURL) {
+		return fmt.Errorf("untrusted subscribe URL %s", subscribeURL)
+	}
+	_, err := get(ctx, subscribeURL)
+	return err
+}
+
+func trustedSNSURL(rawURL string) bool {
+	u, err := url.Parse(rawURL)
+	return err == nil && u.Scheme == "https" && snsHostPattern.MatchString(u.Hostname())
+}
+
+func get(ctx context.Context, rawURL string) ([]byte, error) {
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, rawURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := http.DefaultClient.Do(request)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %d from %s", resp.StatusCode, rawURL)
+	}
+	return io.ReadAll(resp.Body)
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,160 @@
+package health
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jarcoal/httpmock"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	log "github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.wdf.sap.corp/DBaaS/cloud-provider-exporter/pkg/config"
+	"github.wdf.sap.corp/DBaaS/cloud-provider-exporter/pkg/vault"
+)
+
+const (
+	signingCertURL = "https://sns.eu-central-1.amazonaws.com/SimpleNotificationService-dummy.pem"
+	subscribeURL   = "https://sns.eu-central-1.amazonaws.com/?Action=ConfirmSubscription&TopicArn=arn:aws:sns:eu-central-1:123456789012:health&Token=dummyToken"
+	topicArn       = "arn:aws:sns:eu-central-1:123456789012:health"
+)
+
+var eventBridgeHealthEvent = `{
+  "version": "0",
+  "id": "7bf73129-1428-4cd3-a780-95db273d1602",
+  "detail-type": "AWS Health Event",
+  "source": "aws.health",
+  "account": "123456789012",
+  "time": "2023-01-27T09:01:22Z",
+  "region": "eu-central-1",
+  "resources": ["i-abcd1111"],
+  "detail": {
+    "eventArn": "arn:aws:health:eu-central-1::event/EC2/AWS_EC2_OPERATIONAL_ISSUE/AWS_EC2_OPERATIONAL_ISSUE_7f35c8ae-af1f-54e6-a526-d0179ed6d68f",
+    "service": "EC2",
+    "eventTypeCode": "AWS_EC2_OPERATIONAL_ISSUE",
+    "eventTypeCategory": "issue",
+    "eventScopeCode": "ACCOUNT_SPECIFIC",
+    "communicationId": "01b0993207d81a09dcd552ebd1e633e36cf1f09a-1",
+    "startTime": "Fri, 27 Jan 2023 06:02:51 GMT",
+    "lastUpdatedTime": "Fri, 27 Jan 2023 09:01:22 GMT",
+    "statusCode": "open",
+    "eventRegion": "eu-central-1",
+    "eventDescription": [{"language": "en_US", "latestDescription": "Increased API error rates."}],
+    "affectedEntities": [{"entityValue": "i-abcd1111"}],
+    "affectedAccount": "123456789012"
+  }
+}`
+
+func newSigner(t *testing.T) (*rsa.PrivateKey, []byte) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "sns.amazonaws.com"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	assert.NoError(t, err)
+	return key, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+}
+
+func signedSNSBody(t *testing.T, key *rsa.PrivateKey, msg *snsMessage) string {
+	msg.TopicArn = topicArn
+	msg.Timestamp = "2023-01-27T09:01:23.000Z"
+	msg.SignatureVersion = "1"
+	msg.SigningCertURL = signingCertURL
+	digest := sha1.Sum([]byte(msg.stringToSign()))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA1, digest[:])
+	assert.NoError(t, err)
+	msg.Signature = base64.StdEncoding.EncodeToString(signature)
+	body, err := json.Marshal(msg)
+	assert.NoError(t, err)
+	return string(body)
+}
+
+func newWebhookCollector(webhook *config.HealthWebhookConfig) *MetricsCollectorAwsHealth {
+	conf := &config.Config{
+		Region: "eu-central-1",
+		Aws:    &config.AwsConfig{},
+		Health: &config.HealthConfig{Webhook: webhook},
+	}
+	cred := vault.CloudCredentials{
+		vault.AwsAccessKeyID:     "accessKeyID",
+		vault.AwsSecretAccessKey: "secretAccessKey",
+	}
+	return NewMetricsCollectorAwsHealth(conf, cred, &log.Logger{})
+}
+
+func post(collector *MetricsCollectorAwsHealth, body string, header http.Header) int {
+	request := httptest.NewRequest(http.MethodPost, "/webhook/aws/health", strings.NewReader(body))
+	for k, v := range header {
+		request.Header[k] = v
+	}
+	recorder := httptest.NewRecorder()
+	collector.WebhookHandler().ServeHTTP(recorder, request)
+	return recorder.Code
+}
+
+func TestAwsHealthWebhookSNS(t *testing.T) {
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+	key, certPEM := newSigner(t)
+	httpmock.RegisterResponder("GET", signingCertURL, httpmock.NewBytesResponder(http.StatusOK, certPEM))
+	httpmock.RegisterResponder("GET", subscribeURL, httpmock.NewStringResponder(http.StatusOK, "<ConfirmSubscriptionResponse/>"))
+
+	collector := newWebhookCollector(&config.HealthWebhookConfig{TopicArns: []string{topicArn}})
+	snsHeader := http.Header{"X-Amz-Sns-Message-Type": {"Notification"}}
+
+	confirmation := signedSNSBody(t, key, &snsMessage{Type: "SubscriptionConfirmation", MessageID: "1", Token: "dummyToken",
+		Message: "You have chosen to subscribe to the topic", SubscribeURL: subscribeURL})
+	assert.Equal(t, http.StatusOK, post(collector, confirmation, http.Header{"X-Amz-Sns-Message-Type": {"SubscriptionConfirmation"}}))
+	assert.Equal(t, 1, httpmock.GetCallCountInfo()["GET "+subscribeURL])
+
+	notification := signedSNSBody(t, key, &snsMessage{Type: "Notification", MessageID: "2", Message: eventBridgeHealthEvent})
+	tampered := strings.Replace(notification, "Increased API error rates", "Nothing happened", 1)
+	assert.Equal(t, http.StatusForbidden, post(collector, tampered, snsHeader))
+	assert.Equal(t, http.StatusOK, post(collector, notification, snsHeader))
+	assert.Equal(t, 1, httpmock.GetCallCountInfo()["GET "+signingCertURL])
+
+	handler := promhttp.HandlerFor(registry(collector), promhttp.HandlerOpts{})
+	assert.HTTPBodyContains(t, handler.ServeHTTP, "GET", "/metrics", nil, "cpe_health_events{availabilityZone=\"\",cloudService=\"EC2\",eventID=\"arn:aws:health:eu-central-1::event/EC2/AWS_EC2_OPERATIONAL_ISSUE/AWS_EC2_OPERATIONAL_ISSUE_7f35c8ae-af1f-54e6-a526-d0179ed6d68f\",eventRegion=\"eu-central-1\",eventScopeCode=\"ACCOUNT_SPECIFIC\",eventType=\"issue\",eventTypeCode=\"AWS_EC2_OPERATIONAL_ISSUE\",lastUpdatedTime=\"2023-01-27 09:01:22 +0000 UTC\",startTime=\"2023-01-27 06:02:51 +0000 UTC\",statusCode=\"open\"} 1")
+	assert.HTTPBodyContains(t, handler.ServeHTTP, "GET", "/metrics", nil, "entityValue=\"i-abcd1111\"")
+	assert.HTTPBodyContains(t, handler.ServeHTTP, "GET", "/metrics", nil, "cpe_health_webhook_requests_total{result=\"rejected\",source=\"sns\"} 1")
+	assert.HTTPBodyContains(t, handler.ServeHTTP, "GET", "/metrics", nil, "cpe_health_webhook_requests_total{result=\"accepted\",source=\"sns\"} 2")
+}
+
+func TestAwsHealthWebhookEventBridge(t *testing.T) {
+	collector := newWebhookCollector(&config.HealthWebhookConfig{Token: "secret"})
+
+	assert.Equal(t, http.StatusUnauthorized, post(collector, eventBridgeHealthEvent, nil))
+	assert.Equal(t, http.StatusOK, post(collector, eventBridgeHealthEvent, http.Header{"X-Webhook-Token": {"secret"}}))
+	assert.Len(t, collector.poller.Events(), 1)
+
+	closed := strings.Replace(eventBridgeHealthEvent, `"statusCode": "open"`, `"statusCode": "closed"`, 1)
+	closed = strings.Replace(closed, "09:01:22 GMT", "11:30:00 GMT", 1)
+	assert.Equal(t, http.StatusOK, post(collector, closed, http.Header{"Authorization": {"Bearer secret"}}))
+
+	handler := promhttp.HandlerFor(registry(collector), promhttp.HandlerOpts{})
+	assert.HTTPBodyContains(t, handler.ServeHTTP, "GET", "/metrics", nil, "cpe_health_events_closed_total{availabilityZone=\"\",cloudService=\"EC2\",eventType=\"issue\"} 1")
+	assert.HTTPBodyContains(t, handler.ServeHTTP, "GET", "/metrics", nil, "cpe_health_webhook_requests_total{result=\"accepted\",source=\"eventbridge\"} 2")
+}
+
+func registry(collector prometheus.Collector) *prometheus.Registry {
+	r := prometheus.NewRegistry()
+	r.MustRegister(collector)
+	return r
+}
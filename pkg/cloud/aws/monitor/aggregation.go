@@ -0,0 +1,128 @@
+package monitor
+
+import (
+	"fmt"
+	"github.wdf.sap.corp/DBaaS/cloud-provider-exporter/pkg/config"
+	"math"
+	"sort"
+	"strings"
+	"time"
+)
+
+// The aggregations of the data points of the window of a metric
+const (
+	aggregationLatest  = "latest"
+	aggregationMax     = "max"
+	aggregationMin     = "min"
+	aggregationAverage = "average"
+	aggregationSum     = "sum"
+	// aggregationAll exports every data point with its timestamp
+	aggregationAll = "all"
+)
+
+func validateAggregations(jobAggregation string, metrics []*config.Metric) error {
+	aggregations := []string{jobAggregation}
+	for _, metric := range metrics {
+		aggregations = append(aggregations, metric.Aggregation)
+	}
+	for _, aggregation := range aggregations {
+		switch aggregation {
+		case "", aggregationLatest, aggregationMax, aggregationMin, aggregationAverage, aggregationSum, aggregationAll:
+		default:
+			return fmt.Errorf("unknown CloudWatch aggregation %s", aggregation)
+		}
+	}
+	return nil
+}
+
+type dataPoint struct {
+	value            *float64
+	timestamp        time.Time
+	includeTimestamp bool
+}
+
+// exportedDatapoints returns the samples of a statistic in ascending order of time
+func exportedDatapoints(c *cloudwatchData, includeTimestamp bool) []dataPoint {
+	if c.GetMetricDataPoint == nil {
+		// without a data point there is no timestamp to export, such metrics are left out
+		if includeTimestamp || c.Aggregation == aggregationAll {
+			return nil
+		}
+		var missing = math.NaN()
+		if c.NilToZero != nil && *c.NilToZero {
+			missing = 0
+		}
+		return []dataPoint{{value: &missing}}
+	}
+	switch c.Aggregation {
+	case "", aggregationLatest:
+		return []dataPoint{{c.GetMetricDataPoint, *c.GetMetricDataTimestamps, includeTimestamp}}
+	case aggregationAll:
+		points := make([]dataPoint, 0, len(c.WindowValues))
+		for i := len(c.WindowValues) - 1; i >= 0; i-- {
+			points = append(points, dataPoint{&c.WindowValues[i], c.WindowTimestamps[i], true})
+		}
+		return points
+	}
+	value := aggregate(c.Aggregation, c.WindowValues)
+	// the newest timestamp of the window keeps the aggregates of consecutive windows in order
+	return []dataPoint{{&value, *c.GetMetricDataTimestamps, includeTimestamp}}
+}
+
+func aggregate(aggregation string, values []float64) float64 {
+	result := values[0]
+	for _, value := range values[1:] {
+		switch aggregation {
+		case aggregationMax:
+			result = math.Max(result, value)
+		case aggregationMin:
+			result = math.Min(result, value)
+		case aggregationAverage, aggregationSum:
+			result += value
+		}
+	}
+	if aggregation == aggregationAverage {
+		result /= float64(len(values))
+	}
+	return result
+}
+
+// seriesKey identifies a series by its name and labels
+func seriesKey(metric *PrometheusMetric) string {
+	labels := make([]string, 0, len(metric.labels))
+	for name, value := range metric.labels {
+		labels = append(labels, name+"="+value)
+	}
+	sort.Strings(labels)
+	return *metric.name + "{" + strings.Join(labels, ",") + "}"
+}
+
+// dropExported leaves out the timestamped samples that are not newer than the last exported ones of their series,
+// they would be out of order or duplicates with another value.
+func (m *MetricsCollectorAwsMonitor) dropExported(metrics []*PrometheusMetric) []*PrometheusMetric {
+	m.timestampsMux.Lock()
+	defer m.timestampsMux.Unlock()
+	// series that are not scraped anymore are forgotten
+	lastTimestamps := make(map[string]time.Time)
+	result := make([]*PrometheusMetric, 0, len(metrics))
+	for _, metric := range metrics {
+		if !metric.includeTimestamp {
+			result = append(result, metric)
+			continue
+		}
+		key := seriesKey(metric)
+		last, exported := m.lastTimestamps[key]
+		if exported && !metric.timestamp.After(last) {
+			if _, ok := lastTimestamps[key]; !ok {
+				lastTimestamps[key] = last
+			}
+			continue
+		}
+		result = append(result, metric)
+		if metric.timestamp.After(lastTimestamps[key]) {
+			lastTimestamps[key] = metric.timestamp
+		}
+	}
+	m.lastTimestamps = lastTimestamps
+	return result
+}
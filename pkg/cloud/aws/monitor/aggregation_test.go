@@ -0,0 +1,126 @@
+package monitor
+
+import (
+	"context"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/stretchr/testify/assert"
+	"github.wdf.sap.corp/DBaaS/cloud-provider-exporter/pkg/config"
+	"math"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestExportedDatapoints(t *testing.T) {
+	newest := time.Date(2022, time.October, 1, 12, 0, 0, 0, time.UTC)
+	values := []float64{4, 1, 7}
+	timestamps := []time.Time{newest, newest.Add(-5 * time.Minute), newest.Add(-10 * time.Minute)}
+	tests := []struct {
+		aggregation  string
+		addTimestamp bool
+		expected     []float64
+		timestamps   []time.Time
+	}{
+		{aggregation: "", expected: []float64{4}},
+		{aggregation: aggregationLatest, addTimestamp: true, expected: []float64{4}, timestamps: []time.Time{newest}},
+		{aggregation: aggregationMax, expected: []float64{7}},
+		{aggregation: aggregationMin, expected: []float64{1}},
+		{aggregation: aggregationAverage, expected: []float64{4}},
+		{aggregation: aggregationSum, addTimestamp: true, expected: []float64{12}, timestamps: []time.Time{newest}},
+		{aggregation: aggregationAll, expected: []float64{7, 1, 4}, timestamps: []time.Time{timestamps[2], timestamps[1], newest}},
+	}
+	for _, test := range tests {
+		t.Run(test.aggregation, func(t *testing.T) {
+			data := &cloudwatchData{
+				GetMetricDataPoint:      &values[0],
+				GetMetricDataTimestamps: &timestamps[0],
+				WindowValues:            values,
+				WindowTimestamps:        timestamps,
+				Aggregation:             test.aggregation,
+			}
+			points := exportedDatapoints(data, test.addTimestamp)
+			var exported []float64
+			var exportedTimestamps []time.Time
+			for _, point := range points {
+				exported = append(exported, *point.value)
+				if point.includeTimestamp {
+					exportedTimestamps = append(exportedTimestamps, point.timestamp)
+				}
+			}
+			assert.Equal(t, test.expected, exported)
+			assert.Equal(t, test.timestamps, exportedTimestamps)
+		})
+	}
+
+	// without data points only the aggregations without timestamps export a value
+	missing := &cloudwatchData{Aggregation: aggregationMax, NilToZero: aws.Bool(true)}
+	assert.Equal(t, 0.0, *exportedDatapoints(missing, false)[0].value)
+	assert.Empty(t, exportedDatapoints(missing, true))
+	missing.Aggregation = aggregationAll
+	assert.Empty(t, exportedDatapoints(missing, false))
+	missing = &cloudwatchData{Aggregation: aggregationSum}
+	assert.True(t, math.IsNaN(*exportedDatapoints(missing, false)[0].value))
+}
+
+func TestValidateAggregations(t *testing.T) {
+	assert.NoError(t, validateAggregations("", []*config.Metric{{Aggregation: aggregationAll}, {}}))
+	assert.EqualError(t, validateAggregations("median", nil), "unknown CloudWatch aggregation median")
+	assert.EqualError(t, validateAggregations(aggregationMax, []*config.Metric{{Aggregation: "p99"}}), "unknown CloudWatch aggregation p99")
+}
+
+func TestDropExported(t *testing.T) {
+	newest := time.Date(2022, time.October, 1, 12, 0, 0, 0, time.UTC)
+	sample := func(name string, value float64, timestamp time.Time, includeTimestamp bool) *PrometheusMetric {
+		return &PrometheusMetric{name: &name, labels: map[string]string{"name": "i-1"}, value: &value, timestamp: timestamp, includeTimestamp: includeTimestamp}
+	}
+	m := &MetricsCollectorAwsMonitor{}
+	first := m.dropExported([]*PrometheusMetric{
+		sample("cpu", 1, newest.Add(-time.Minute), true),
+		sample("cpu", 2, newest, true),
+		sample("network", 3, time.Time{}, false),
+	})
+	assert.Len(t, first, 3)
+
+	// a window overlapping the last one only exports the newer data points
+	second := m.dropExported([]*PrometheusMetric{
+		sample("cpu", 2, newest, true),
+		sample("cpu", 4, newest.Add(time.Minute), true),
+		sample("network", 3, time.Time{}, false),
+	})
+	assert.Len(t, second, 2)
+	assert.Equal(t, 4.0, *second[0].value)
+
+	// without new data points the series is left out but not forgotten
+	assert.Len(t, m.dropExported([]*PrometheusMetric{sample("cpu", 5, newest.Add(time.Minute), true)}), 0)
+	assert.Len(t, m.dropExported([]*PrometheusMetric{sample("cpu", 5, newest, true)}), 0)
+	// series that were not scraped are forgotten
+	assert.Len(t, m.dropExported(nil), 0)
+	assert.Len(t, m.dropExported([]*PrometheusMetric{sample("cpu", 5, newest, true)}), 1)
+}
+
+func TestGatherAllDatapoints(t *testing.T) {
+	client := &MockCloudWatchClient{paginated: true}
+	m := newMockMonitor(client, 1)
+	data := newCloudwatchData(2)
+	for i := range data {
+		data[i].ID = aws.String("i-1")
+		data[i].Namespace = aws.String("ec2")
+		data[i].Region = aws.String("eu-central-1")
+		data[i].AccountId = aws.String("123456789012")
+	}
+	data[0].Aggregation = aggregationAll
+	data[1].Metric, data[1].Aggregation = aws.String("NetworkIn"), aggregationSum
+	cwData := m.scrapeUsingMetricData(context.TODO(), "AWS/EC2", 7200, 0, nil, data)
+	metrics, observedMetricLabels, err := createPrometheusMetricsFromCwData(cwData)
+	assert.NoError(t, err)
+	m.metrics = m.dropExported(ensureLabelConsistencyForMetrics(metrics, observedMetricLabels))
+
+	end := data[0].GetMetricDataTimestamps.UnixMilli()
+	handler := promhttp.HandlerFor(m, promhttp.HandlerOpts{})
+	labels := `{account_id="123456789012",name="i-1",region="eu-central-1"}`
+	// the data points of the second page are an hour older
+	assert.HTTPBodyContains(t, handler.ServeHTTP, "GET", "/", nil, "cpe_aws_ec2_cpuutilization_average"+labels+" 2 "+strconv.FormatInt(end-3600000, 10))
+	assert.HTTPBodyContains(t, handler.ServeHTTP, "GET", "/", nil, "cpe_aws_ec2_cpuutilization_average"+labels+" 1 "+strconv.FormatInt(end, 10))
+	assert.HTTPBodyContains(t, handler.ServeHTTP, "GET", "/", nil, "cpe_aws_ec2_network_in_average"+labels+" 3\n")
+}
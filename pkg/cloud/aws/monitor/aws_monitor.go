@@ -4,8 +4,6 @@ import (
 	"context"
 	"fmt"
 	"github.com/aws/aws-sdk-go-v2/aws"
-	awsConf "github.com/aws/aws-sdk-go-v2/config"
-	"github.com/aws/aws-sdk-go-v2/credentials"
 	"github.com/aws/aws-sdk-go-v2/service/cloudwatch"
 	cwType "github.com/aws/aws-sdk-go-v2/service/cloudwatch/types"
 	"github.com/aws/aws-sdk-go-v2/service/resourcegroupstaggingapi"
@@ -13,10 +11,10 @@ import (
 	"github.com/aws/aws-sdk-go-v2/service/sts"
 	"github.com/prometheus/client_golang/prometheus"
 	log "github.com/sirupsen/logrus"
+	"github.wdf.sap.corp/DBaaS/cloud-provider-exporter/pkg/cloud/aws/awsconfig"
 	"github.wdf.sap.corp/DBaaS/cloud-provider-exporter/pkg/config"
 	"github.wdf.sap.corp/DBaaS/cloud-provider-exporter/pkg/vault"
 	"golang.org/x/exp/maps"
-	"math"
 	"math/rand"
 	"regexp"
 	"strings"
@@ -27,6 +25,10 @@ import (
 const defaultLengthSeconds = int64(300)
 const defaultPeriodSeconds = int32(300)
 
+// maxGetMetricDataQueries is the limit of queries of a GetMetricData call
+const maxGetMetricDataQueries = 500
+const defaultGetMetricDataConcurrency = 5
+
 type cloudwatchData struct {
 	ID                      *string
 	MetricID                *string
@@ -35,14 +37,24 @@ type cloudwatchData struct {
 	Statistics              []string
 	GetMetricDataPoint      *float64
 	GetMetricDataTimestamps *time.Time
-	NilToZero               *bool
-	AddCloudwatchTimestamp  *bool
-	CustomTags              []config.Tag
-	Tags                    []config.Tag
-	Dimensions              []cwType.Dimension
-	Region                  *string
-	AccountId               *string
-	Period                  int32
+	// WindowValues and WindowTimestamps are all data points of the window, newest first, unless only the latest
+	// one is exported
+	WindowValues           []float64
+	WindowTimestamps       []time.Time
+	Aggregation            string
+	NilToZero              *bool
+	AddCloudwatchTimestamp *bool
+	CustomTags             []config.Tag
+	Tags                   []config.Tag
+	Dimensions             []cwType.Dimension
+	Region                 *string
+	AccountId              *string
+	Period                 int32
+}
+
+type ICloudWatchClient interface {
+	ListMetrics(ctx context.Context, params *cloudwatch.ListMetricsInput, optFns ...func(*cloudwatch.Options)) (*cloudwatch.ListMetricsOutput, error)
+	GetMetricData(ctx context.Context, params *cloudwatch.GetMetricDataInput, optFns ...func(*cloudwatch.Options)) (*cloudwatch.GetMetricDataOutput, error)
 }
 
 type MetricsCollectorAwsMonitor struct {
@@ -50,9 +62,17 @@ type MetricsCollectorAwsMonitor struct {
 	conf          *config.Config
 	accountId     string
 	taggingClient *resourcegroupstaggingapi.Client
-	cwClient      *cloudwatch.Client
+	cwClient      ICloudWatchClient
 	stsClient     *sts.Client
-	metrics       []*PrometheusMetric
+	services      serviceConfig
+	// metrics of the last scrape, replaced while they may be collected
+	metrics    []*PrometheusMetric
+	metricsMux sync.RWMutex
+	// lastTimestamps are the newest timestamps exported per series
+	lastTimestamps map[string]time.Time
+	timestampsMux  sync.Mutex
+	// getMetricDataSlots bounds the concurrent GetMetricData calls
+	getMetricDataSlots chan struct{}
 }
 
 type dimValue2Res struct {
@@ -63,10 +83,7 @@ type dimValue2Res struct {
 func NewMetricsCollectorAwsMonitor(config *config.Config, cred vault.CloudCredentials, logger log.FieldLogger) *MetricsCollectorAwsMonitor {
 	m := &MetricsCollectorAwsMonitor{}
 	m.log = logger
-	cfg, err := awsConf.LoadDefaultConfig(context.TODO(), awsConf.WithCredentialsProvider(credentials.StaticCredentialsProvider{Value: aws.Credentials{
-		AccessKeyID:     cred[vault.AwsAccessKeyID],
-		SecretAccessKey: cred[vault.AwsSecretAccessKey],
-	}}), awsConf.WithRegion(config.Region))
+	cfg, err := awsconfig.Load(context.TODO(), config, awsconfig.StaticCredentials(cred))
 	if err != nil {
 		log.Fatal(err)
 	}
@@ -82,26 +99,69 @@ func NewMetricsCollectorAwsMonitor(config *config.Config, cred vault.CloudCreden
 	}
 	m.accountId = aws.ToString(id.Account)
 	m.conf = config
+	m.services, err = newServiceConfig(config.Aws.CloudWatchMetricsConf.Services)
+	if err == nil {
+		err = m.services.validate(&config.Aws.CloudWatchMetricsConf)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+	m.getMetricDataSlots = newGetMetricDataSlots(config.Aws.CloudWatchMetricsConf.GetMetricDataConcurrency)
 	return m
 }
 
+func newGetMetricDataSlots(concurrency int) chan struct{} {
+	if concurrency <= 0 {
+		concurrency = defaultGetMetricDataConcurrency
+	}
+	return make(chan struct{}, concurrency)
+}
+
 func (m *MetricsCollectorAwsMonitor) Describe(ch chan<- *prometheus.Desc) {
+	m.metricsMux.RLock()
+	defer m.metricsMux.RUnlock()
 	for _, metric := range m.metrics {
 		ch <- createDesc(metric)
 	}
 }
 
 func (m *MetricsCollectorAwsMonitor) Collect(ch chan<- prometheus.Metric) {
+	m.metricsMux.RLock()
+	defer m.metricsMux.RUnlock()
 	for _, metric := range m.metrics {
 		ch <- createMetric(metric)
 	}
 }
 
+// getMetricDataWindow is what the queries batched into the same GetMetricData calls have in common
+type getMetricDataWindow struct {
+	namespace      string
+	period         int32
+	length         int64
+	delay          int64
+	roundingPeriod int32
+}
+
+func newGetMetricDataWindow(namespace string, metric *config.Metric, roundingPeriod *int32) getMetricDataWindow {
+	w := getMetricDataWindow{
+		namespace: namespace,
+		period:    metric.Period,
+		length:    getMetricDataInputLength(0, []*config.Metric{metric}),
+		delay:     metric.Delay,
+	}
+	if roundingPeriod != nil {
+		w.roundingPeriod = *roundingPeriod
+	}
+	return w
+}
+
+// collectData gathers the queries of all jobs and metrics before querying them, so the queries of the same window
+// fill the batches together.
 func (m *MetricsCollectorAwsMonitor) collectData(ctx context.Context) []*cloudwatchData {
 	wgJob := sync.WaitGroup{}
 	mux := sync.Mutex{}
 	cfg := m.conf.Aws.CloudWatchMetricsConf
-	result := make([]*cloudwatchData, 0)
+	queries := make(map[getMetricDataWindow][]cloudwatchData)
 	for _, job := range cfg.Jobs {
 		wgJob.Add(1)
 		go func(job *config.Job) {
@@ -110,28 +170,60 @@ func (m *MetricsCollectorAwsMonitor) collectData(ctx context.Context) []*cloudwa
 			var taggedRes []*taggedResource
 			res := m.getTaggedResource(ctx, job, m.conf.Region)
 			taggedRes = append(taggedRes, res...)
-			svc := SupportedServices.GetService(job.Type)
+			svc := m.services.GetService(job.Type)
 			dimFilter := m.getDimensionsFilter(taggedRes, svc)
 			wgMetric := sync.WaitGroup{}
-			for _, metric := range job.Metrics {
+			for _, metric := range getMetricsOfJob(job) {
 				wgMetric.Add(1)
 				m.log.Infof("Start collect data for job - metric: %v - %v", job.Type, metric.Name)
 				go func(metric *config.Metric) {
 					defer wgMetric.Done()
 					m.log.Infof("Start collect full metrics list for %v, in namespace: %v", metric.Name, svc.Namespace)
 					fullMetricsList := m.getFullMetricsListByName(ctx, aws.String(svc.Namespace), aws.String(metric.Name))
-					filteredMetricsList := m.filterMetricsList(dimFilter, fullMetricsList)
+					filteredMetricsList := m.filterMetricsList(dimFilter, job.DimensionNameRequirements, fullMetricsList)
 					data := m.getCloudwatchDataFromMetric(dimFilter, filteredMetricsList, metric, job.Type, m.conf.Region, m.accountId, cfg.ExportedTagsOnMetrics, job.CustomTags)
-					metricsData := m.scrapeDiscoveryJobUsingMetricData(ctx, svc, job, data)
+					window := newGetMetricDataWindow(svc.Namespace, metric, job.RoundingPeriod)
 					mux.Lock()
-					result = append(result, metricsData...)
+					queries[window] = append(queries[window], data...)
 					mux.Unlock()
 				}(metric)
 			}
 			wgMetric.Wait()
 		}(job)
 	}
+	for _, static := range cfg.Static {
+		m.log.Infof("Start collect data for static job: %v in namespace: %v", static.Name, static.Namespace)
+		for _, metric := range getMetricsOfStatic(static) {
+			window := newGetMetricDataWindow(static.Namespace, metric, nil)
+			mux.Lock()
+			queries[window] = append(queries[window], getStaticCloudwatchData(static, []*config.Metric{metric}, m.conf.Region, m.accountId)...)
+			mux.Unlock()
+		}
+	}
 	wgJob.Wait()
+	return m.scrapeWindows(ctx, queries)
+}
+
+// scrapeWindows queries the data of every window, the windows share the slots of the collector
+func (m *MetricsCollectorAwsMonitor) scrapeWindows(ctx context.Context, queries map[getMetricDataWindow][]cloudwatchData) []*cloudwatchData {
+	wg := sync.WaitGroup{}
+	mux := sync.Mutex{}
+	result := make([]*cloudwatchData, 0)
+	for window, data := range queries {
+		wg.Add(1)
+		go func(window getMetricDataWindow, data []cloudwatchData) {
+			defer wg.Done()
+			var roundingPeriod *int32
+			if window.roundingPeriod > 0 {
+				roundingPeriod = &window.roundingPeriod
+			}
+			metricsData := m.scrapeUsingMetricData(ctx, window.namespace, window.length, window.delay, roundingPeriod, data)
+			mux.Lock()
+			result = append(result, metricsData...)
+			mux.Unlock()
+		}(window, data)
+	}
+	wg.Wait()
 	return result
 }
 
@@ -142,64 +234,88 @@ func (m *MetricsCollectorAwsMonitor) Scrape(ctx context.Context) {
 	if err != nil {
 		m.log.Fatal(err)
 	}
+	metrics = m.dropExported(metrics)
+	m.metricsMux.Lock()
 	m.metrics = metrics
+	m.metricsMux.Unlock()
 }
 
-func (m *MetricsCollectorAwsMonitor) scrapeDiscoveryJobUsingMetricData(ctx context.Context, svc *serviceFilter, job *config.Job, cwData []cloudwatchData) []*cloudwatchData {
-	maxMetricCount := 20
+// scrapeUsingMetricData queries the data points of discovery and static jobs in batches, the calls of all jobs share
+// the slots of the collector.
+func (m *MetricsCollectorAwsMonitor) scrapeUsingMetricData(ctx context.Context, namespace string, length, delay int64, roundingPeriod *int32, cwData []cloudwatchData) []*cloudwatchData {
 	wg := sync.WaitGroup{}
 	mux := &sync.Mutex{}
 	var cw []*cloudwatchData
-	length := getMetricDataInputLength(job)
-	cutPoint := 0
-	for cutPoint < len(cwData) {
-		start := cutPoint
-		end := cutPoint + maxMetricCount
+	for start := 0; start < len(cwData); start += maxGetMetricDataQueries {
+		end := start + maxGetMetricDataQueries
 		if end > len(cwData) {
 			end = len(cwData)
 		}
+		m.getMetricDataSlots <- struct{}{}
 		wg.Add(1)
 		go func(input []cloudwatchData) {
-			defer wg.Done()
-			filter := createGetMetricDataInput(input, &svc.Namespace, length, job.Delay, job.RoundingPeriod)
-			data := &cloudwatch.GetMetricDataOutput{}
-			paginator := cloudwatch.NewGetMetricDataPaginator(m.cwClient, filter)
-			for paginator.HasMorePages() {
-				page, err := paginator.NextPage(ctx)
-				if err != nil {
-					log.Fatal(err)
-				}
-				data.MetricDataResults = append(data.MetricDataResults, page.MetricDataResults...)
-			}
-			if data.MetricDataResults != nil {
-				output := make([]*cloudwatchData, 0)
-				for _, MetricDataResult := range data.MetricDataResults {
-					getMetricData := findGetMetricDataById(input, *MetricDataResult.Id)
-					if getMetricData != nil {
-						if len(MetricDataResult.Values) != 0 {
-							getMetricData.GetMetricDataPoint = &MetricDataResult.Values[0]
-							getMetricData.GetMetricDataTimestamps = &MetricDataResult.Timestamps[0]
-						}
-						output = append(output, getMetricData)
-					}
-				}
-				mux.Lock()
-				cw = append(cw, output...)
-				mux.Unlock()
+			defer func() {
+				<-m.getMetricDataSlots
+				wg.Done()
+			}()
+			output, err := m.getMetricData(ctx, namespace, length, delay, roundingPeriod, input)
+			if err != nil {
+				m.log.Errorf("Error while getting the metric data in namespace %v: %v", namespace, err)
 			}
+			mux.Lock()
+			cw = append(cw, output...)
+			mux.Unlock()
 		}(cwData[start:end])
-		cutPoint = end
 	}
 	wg.Wait()
 	return cw
 }
 
+// getMetricData queries a batch and returns the data CloudWatch returned results for, with their newest data point
+func (m *MetricsCollectorAwsMonitor) getMetricData(ctx context.Context, namespace string, length, delay int64, roundingPeriod *int32, input []cloudwatchData) ([]*cloudwatchData, error) {
+	indexById := make(map[string]int, len(input))
+	for i := range input {
+		indexById[*input[i].MetricID] = i
+	}
+	returned := make([]bool, len(input))
+	output := make([]*cloudwatchData, 0, len(input))
+	filter := createGetMetricDataInput(input, &namespace, length, delay, roundingPeriod)
+	paginator := cloudwatch.NewGetMetricDataPaginator(m.cwClient, filter)
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return output, err
+		}
+		for _, result := range page.MetricDataResults {
+			i, ok := indexById[aws.ToString(result.Id)]
+			if !ok {
+				continue
+			}
+			data := &input[i]
+			// the results of a query may continue on the next pages with older data points
+			if data.GetMetricDataPoint == nil && len(result.Values) != 0 {
+				data.GetMetricDataPoint = &result.Values[0]
+				data.GetMetricDataTimestamps = &result.Timestamps[0]
+			}
+			if data.Aggregation != "" && data.Aggregation != aggregationLatest {
+				data.WindowValues = append(data.WindowValues, result.Values...)
+				data.WindowTimestamps = append(data.WindowTimestamps, result.Timestamps...)
+			}
+			if !returned[i] {
+				returned[i] = true
+				output = append(output, data)
+			}
+		}
+	}
+	return output, nil
+}
+
 func (m *MetricsCollectorAwsMonitor) getTaggedResource(ctx context.Context, job *config.Job, region string) []*taggedResource {
 	var resources []*taggedResource
 	var wg sync.WaitGroup
 	mux := &sync.Mutex{}
 	m.log.Infof("Start getting tagged resources for job: %v in region: %v", job.Type, region)
-	svc := SupportedServices.GetService(job.Type)
+	svc := m.services.GetService(job.Type)
 	if len(svc.ResourceFilters) > 0 {
 		var tagFilters []tagTypes.TagFilter
 		for _, tag := range job.SearchTags {
@@ -287,7 +403,8 @@ func (m *MetricsCollectorAwsMonitor) getFullMetricsListByName(ctx context.Contex
 	return output
 }
 
-func (m *MetricsCollectorAwsMonitor) filterMetricsList(dimFilter map[string][]dimValue2Res, fullMetricsList []cwType.Metric) []cwType.Metric {
+// filterMetricsList keeps the metrics of the discovered resources with exactly the required dimension names, if any
+func (m *MetricsCollectorAwsMonitor) filterMetricsList(dimFilter map[string][]dimValue2Res, dimensionNameRequirements []string, fullMetricsList []cwType.Metric) []cwType.Metric {
 	var result []cwType.Metric
 	wg := sync.WaitGroup{}
 	mux := &sync.Mutex{}
@@ -302,15 +419,18 @@ func (m *MetricsCollectorAwsMonitor) filterMetricsList(dimFilter map[string][]di
 		wg.Add(1)
 		go func(dimFilter map[string][]dimValue2Res, metricList []cwType.Metric) {
 			defer wg.Done()
+		metrics:
 			for _, metric := range metricList {
+				if !metricDimensionsMatchNames(metric, dimensionNameRequirements) {
+					continue
+				}
 				for _, d := range metric.Dimensions {
-					if _, ok := dimFilter[aws.ToString(d.Name)]; ok {
-						for _, val := range dimFilter[aws.ToString(d.Name)] {
-							if val.dimVal == aws.ToString(d.Value) {
-								mux.Lock()
-								result = append(result, metric)
-								mux.Unlock()
-							}
+					for _, val := range dimFilter[aws.ToString(d.Name)] {
+						if val.dimVal == aws.ToString(d.Value) {
+							mux.Lock()
+							result = append(result, metric)
+							mux.Unlock()
+							continue metrics
 						}
 					}
 				}
@@ -323,6 +443,63 @@ func (m *MetricsCollectorAwsMonitor) filterMetricsList(dimFilter map[string][]di
 	return result
 }
 
+// metricDimensionsMatchNames is true if the metric has exactly the required dimensions, or nothing is required
+func metricDimensionsMatchNames(metric cwType.Metric, dimensionNameRequirements []string) bool {
+	if len(dimensionNameRequirements) == 0 {
+		return true
+	}
+	if len(metric.Dimensions) != len(dimensionNameRequirements) {
+		return false
+	}
+	for _, name := range dimensionNameRequirements {
+		found := false
+		for _, dimension := range metric.Dimensions {
+			if aws.ToString(dimension.Name) == name {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// getMetricsOfJob returns copies of the metrics of a job with the settings they omit inherited from the job
+func getMetricsOfJob(job *config.Job) []*config.Metric {
+	metrics := make([]*config.Metric, 0, len(job.Metrics))
+	for _, metric := range job.Metrics {
+		m := *metric
+		if len(m.Statistics) == 0 {
+			m.Statistics = job.Statistics
+		}
+		if m.Period == 0 {
+			m.Period = int32(job.Period)
+		}
+		if m.Period == 0 {
+			m.Period = defaultPeriodSeconds
+		}
+		if m.Length == 0 {
+			m.Length = job.Length
+		}
+		if m.Delay == 0 {
+			m.Delay = job.Delay
+		}
+		if m.NilToZero == nil {
+			m.NilToZero = job.NilToZero
+		}
+		if m.AddCloudwatchTimestamp == nil {
+			m.AddCloudwatchTimestamp = job.AddCloudwatchTimestamp
+		}
+		if m.Aggregation == "" {
+			m.Aggregation = job.Aggregation
+		}
+		metrics = append(metrics, &m)
+	}
+	return metrics
+}
+
 func (m *MetricsCollectorAwsMonitor) getCloudwatchDataFromMetric(dimFilter map[string][]dimValue2Res, filteredMetricsList []cwType.Metric, metric *config.Metric, namespace, region, accountId string, tagsOnMetrics config.ExportedTagsOnMetrics, customTags []config.Tag) []cloudwatchData {
 	var result []cloudwatchData
 	var r *taggedResource
@@ -352,6 +529,7 @@ func (m *MetricsCollectorAwsMonitor) getCloudwatchDataFromMetric(dimFilter map[s
 				Statistics:             []string{stats},
 				NilToZero:              metric.NilToZero,
 				AddCloudwatchTimestamp: metric.AddCloudwatchTimestamp,
+				Aggregation:            metric.Aggregation,
 				Tags:                   metricTags,
 				CustomTags:             customTags,
 				Dimensions:             cwMetric.Dimensions,
@@ -365,22 +543,59 @@ func (m *MetricsCollectorAwsMonitor) getCloudwatchDataFromMetric(dimFilter map[s
 	return result
 }
 
-func findGetMetricDataById(getMetricData []cloudwatchData, value string) *cloudwatchData {
-	for _, data := range getMetricData {
-		if *data.MetricID == value {
-			return &data
+// getMetricsOfStatic returns copies of the metrics of a static job with the default period if they omit it
+func getMetricsOfStatic(static *config.Static) []*config.Metric {
+	metrics := make([]*config.Metric, 0, len(static.Metrics))
+	for _, metric := range static.Metrics {
+		m := *metric
+		if m.Period == 0 {
+			m.Period = defaultPeriodSeconds
 		}
+		metrics = append(metrics, &m)
 	}
-	return nil
+	return metrics
 }
 
-func getMetricDataInputLength(job *config.Job) int64 {
+// getStaticCloudwatchData returns the queries of metrics of a static job, named after the job
+func getStaticCloudwatchData(static *config.Static, metrics []*config.Metric, region, accountId string) []cloudwatchData {
+	var dimensions []cwType.Dimension
+	for _, dimension := range static.Dimensions {
+		dimensions = append(dimensions, cwType.Dimension{
+			Name:  aws.String(dimension.Name),
+			Value: aws.String(dimension.Value),
+		})
+	}
+	var result []cloudwatchData
+	for _, metric := range metrics {
+		for _, stats := range metric.Statistics {
+			id := fmt.Sprintf("id_%d", rand.Int())
+			result = append(result, cloudwatchData{
+				ID:                     &static.Name,
+				MetricID:               &id,
+				Metric:                 &metric.Name,
+				Namespace:              &static.Namespace,
+				Statistics:             []string{stats},
+				NilToZero:              metric.NilToZero,
+				AddCloudwatchTimestamp: metric.AddCloudwatchTimestamp,
+				Aggregation:            metric.Aggregation,
+				CustomTags:             static.CustomTags,
+				Dimensions:             dimensions,
+				Region:                 &region,
+				AccountId:              &accountId,
+				Period:                 metric.Period,
+			})
+		}
+	}
+	return result
+}
+
+func getMetricDataInputLength(jobLength int64, metrics []*config.Metric) int64 {
 	length := defaultLengthSeconds
 
-	if job.Length > 0 {
-		length = job.Length
+	if jobLength > 0 {
+		length = jobLength
 	}
-	for _, metric := range job.Metrics {
+	for _, metric := range metrics {
 		if metric.Length > length {
 			length = metric.Length
 		}
@@ -389,46 +604,28 @@ func getMetricDataInputLength(job *config.Job) int64 {
 }
 
 func createGetMetricDataInput(getMetricData []cloudwatchData, namespace *string, length int64, delay int64, configuredRoundingPeriod *int32) (output *cloudwatch.GetMetricDataInput) {
-	var metricsDataQuery []cwType.MetricDataQuery
-	wg := sync.WaitGroup{}
-	mux := &sync.Mutex{}
-	cutPoint := 0
+	metricsDataQuery := make([]cwType.MetricDataQuery, 0, len(getMetricData))
 	roundingPeriod := defaultPeriodSeconds
-	for cutPoint < len(getMetricData) {
-		start := cutPoint
-		end := cutPoint + 50
-		if end > len(getMetricData) {
-			end = len(getMetricData)
+	returnData := true
+	for i := range getMetricData {
+		data := &getMetricData[i]
+		if data.Period < roundingPeriod {
+			roundingPeriod = data.Period
 		}
-		wg.Add(1)
-		go func(input []cloudwatchData) {
-			defer wg.Done()
-			for _, data := range input {
-				if data.Period < roundingPeriod {
-					roundingPeriod = data.Period
-				}
-				metricStat := &cwType.MetricStat{
-					Metric: &cwType.Metric{
-						Dimensions: data.Dimensions,
-						MetricName: data.Metric,
-						Namespace:  namespace,
-					},
-					Period: &data.Period,
-					Stat:   &data.Statistics[0],
-				}
-				ReturnData := true
-				mux.Lock()
-				metricsDataQuery = append(metricsDataQuery, cwType.MetricDataQuery{
-					Id:         data.MetricID,
-					MetricStat: metricStat,
-					ReturnData: &ReturnData,
-				})
-				mux.Unlock()
-			}
-		}(getMetricData[start:end])
-		cutPoint = end
+		metricsDataQuery = append(metricsDataQuery, cwType.MetricDataQuery{
+			Id: data.MetricID,
+			MetricStat: &cwType.MetricStat{
+				Metric: &cwType.Metric{
+					Dimensions: data.Dimensions,
+					MetricName: data.Metric,
+					Namespace:  namespace,
+				},
+				Period: &data.Period,
+				Stat:   &data.Statistics[0],
+			},
+			ReturnData: &returnData,
+		})
 	}
-	wg.Wait()
 
 	if configuredRoundingPeriod != nil {
 		roundingPeriod = *configuredRoundingPeriod
@@ -466,40 +663,23 @@ func createPrometheusMetricsFromCwData(cwd []*cloudwatchData) ([]*PrometheusMetr
 	observedMetricLabels := make(map[string]LabelSet)
 	for _, c := range cwd {
 		for _, statistic := range c.Statistics {
-			var includeTimestamp bool
-			if c.AddCloudwatchTimestamp != nil {
-				includeTimestamp = *c.AddCloudwatchTimestamp
-			}
-			var exportedDatapoint *float64
-			var timestamp time.Time
-			if c.GetMetricDataPoint != nil {
-				exportedDatapoint, timestamp = c.GetMetricDataPoint, *c.GetMetricDataTimestamps
-			}
-			if exportedDatapoint == nil && (c.AddCloudwatchTimestamp == nil || !*c.AddCloudwatchTimestamp) {
-				var nan = math.NaN()
-				exportedDatapoint = &nan
-				includeTimestamp = false
-				//if *c.NilToZero {
-				//	var zero float64 = 0
-				//	exportedDatapoint = &zero
-				//}
-			}
+			includeTimestamp := c.AddCloudwatchTimestamp != nil && *c.AddCloudwatchTimestamp
+			// aliases like ec2 are prefixed with cpe_aws_, namespaces like AWS/EC2 keep their aws_ prefix
 			promNs := strings.ToLower(*c.Namespace)
 			if !strings.HasPrefix(promNs, "aws") {
 				promNs = "cpe_aws_" + promNs
 			}
 			name := promString(promNs) + "_" + strings.ToLower(promString(*c.Metric)) + "_" + strings.ToLower(promString(statistic))
-			if exportedDatapoint != nil {
+			for _, point := range exportedDatapoints(c, includeTimestamp) {
 				promLabels := createPrometheusLabels(c)
 				observedMetricLabels = recordLabelsForMetric(name, promLabels, observedMetricLabels)
-				p := PrometheusMetric{
+				output = append(output, &PrometheusMetric{
 					name:             &name,
 					labels:           promLabels,
-					value:            exportedDatapoint,
-					timestamp:        timestamp,
-					includeTimestamp: includeTimestamp,
-				}
-				output = append(output, &p)
+					value:            point.value,
+					timestamp:        point.timestamp,
+					includeTimestamp: point.includeTimestamp,
+				})
 			}
 		}
 	}
@@ -0,0 +1,435 @@
+package monitor
+
+import (
+	"context"
+	"fmt"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/cloudwatch"
+	cwType "github.com/aws/aws-sdk-go-v2/service/cloudwatch/types"
+	log "github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.wdf.sap.corp/DBaaS/cloud-provider-exporter/pkg/config"
+	"io"
+	"math"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestStaticCloudwatchData(t *testing.T) {
+	static := &config.Static{
+		Name:       "ec2-on-demand-vcpus",
+		Namespace:  "AWS/Usage",
+		CustomTags: []config.Tag{{Key: "quota", Value: "L-1216C47A"}},
+		Dimensions: []config.Dimension{{Name: "Service", Value: "EC2"}, {Name: "Resource", Value: "vCPU"}},
+		Metrics: []*config.Metric{
+			{Name: "ResourceCount", Statistics: []string{"Maximum", "Average"}, Period: 300, Length: 900},
+		},
+	}
+	data := getStaticCloudwatchData(static, getMetricsOfStatic(static), "eu-central-1", "123456789012")
+	assert.Len(t, data, 2)
+	assert.Equal(t, []string{"Maximum"}, data[0].Statistics)
+	assert.Equal(t, []string{"Average"}, data[1].Statistics)
+	assert.NotEqual(t, *data[0].MetricID, *data[1].MetricID)
+	assert.Equal(t, []cwType.Dimension{
+		{Name: aws.String("Service"), Value: aws.String("EC2")},
+		{Name: aws.String("Resource"), Value: aws.String("vCPU")},
+	}, data[0].Dimensions)
+	assert.Equal(t, int64(900), getMetricDataInputLength(0, static.Metrics))
+
+	// the period defaults like the one of the metrics of discovery jobs
+	static.Metrics = append(static.Metrics, &config.Metric{Name: "ResourceCount", Statistics: []string{"Sum"}, Delay: 600})
+	staticMetrics := getMetricsOfStatic(static)
+	assert.Equal(t, int32(300), staticMetrics[1].Period)
+	assert.Equal(t, int64(600), staticMetrics[1].Delay)
+	assert.Equal(t, int32(0), static.Metrics[1].Period)
+	assert.Equal(t, int32(300), getStaticCloudwatchData(static, staticMetrics[1:], "eu-central-1", "123456789012")[0].Period)
+
+	value := 42.0
+	data[0].GetMetricDataPoint = &value
+	data[0].GetMetricDataTimestamps = aws.Time(time.Now())
+	metrics, _, err := createPrometheusMetricsFromCwData([]*cloudwatchData{&data[0]})
+	assert.NoError(t, err)
+	assert.Len(t, metrics, 1)
+	assert.Equal(t, "aws_usage_resource_count_maximum", *metrics[0].name)
+	assert.Equal(t, map[string]string{
+		"name":               "ec2-on-demand-vcpus",
+		"region":             "eu-central-1",
+		"account_id":         "123456789012",
+		"dimension_service":  "EC2",
+		"dimension_resource": "vCPU",
+		"custom_tag_quota":   "L-1216C47A",
+	}, metrics[0].labels)
+	assert.Equal(t, 42.0, *metrics[0].value)
+}
+
+func TestMetricsOfJob(t *testing.T) {
+	yes, no := aws.Bool(true), aws.Bool(false)
+	tests := []struct {
+		name     string
+		job      config.Job
+		expected config.Metric
+	}{
+		{
+			name:     "defaults",
+			job:      config.Job{Metrics: []*config.Metric{{Name: "CPUUtilization"}}},
+			expected: config.Metric{Name: "CPUUtilization", Period: defaultPeriodSeconds},
+		},
+		{
+			name: "inherited from the job",
+			job: config.Job{
+				Statistics: []string{"Maximum"}, Period: 60, Length: 600, Delay: 120, NilToZero: yes, AddCloudwatchTimestamp: yes,
+				Metrics: []*config.Metric{{Name: "CPUUtilization"}},
+			},
+			expected: config.Metric{Name: "CPUUtilization", Statistics: []string{"Maximum"}, Period: 60, Length: 600, Delay: 120, NilToZero: yes, AddCloudwatchTimestamp: yes},
+		},
+		{
+			name: "set by the metric",
+			job: config.Job{
+				Statistics: []string{"Maximum"}, Period: 60, Length: 600, Delay: 120, NilToZero: yes, AddCloudwatchTimestamp: yes,
+				Metrics: []*config.Metric{{Name: "CPUUtilization", Statistics: []string{"Average"}, Period: 300, Length: 900, Delay: 60, NilToZero: no, AddCloudwatchTimestamp: no}},
+			},
+			expected: config.Metric{Name: "CPUUtilization", Statistics: []string{"Average"}, Period: 300, Length: 900, Delay: 60, NilToZero: no, AddCloudwatchTimestamp: no},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			metrics := getMetricsOfJob(&test.job)
+			assert.Equal(t, []*config.Metric{&test.expected}, metrics)
+			// the config is left as is
+			assert.NotSame(t, test.job.Metrics[0], metrics[0])
+		})
+	}
+}
+
+func TestFilterMetricsList(t *testing.T) {
+	dimension := func(name, value string) cwType.Dimension {
+		return cwType.Dimension{Name: aws.String(name), Value: aws.String(value)}
+	}
+	res := &taggedResource{ARN: "arn:aws:elasticloadbalancing:eu-central-1:123456789012:loadbalancer/net/nlb/1"}
+	dimFilter := map[string][]dimValue2Res{
+		"LoadBalancer": {{"net/nlb/1", res}},
+		"TargetGroup":  {{"targetgroup/tg/2", res}},
+	}
+	loadBalancer := cwType.Metric{Dimensions: []cwType.Dimension{dimension("LoadBalancer", "net/nlb/1")}}
+	targetGroup := cwType.Metric{Dimensions: []cwType.Dimension{dimension("LoadBalancer", "net/nlb/1"), dimension("TargetGroup", "targetgroup/tg/2")}}
+	availabilityZone := cwType.Metric{Dimensions: []cwType.Dimension{dimension("LoadBalancer", "net/nlb/1"), dimension("AvailabilityZone", "eu-central-1a")}}
+	other := cwType.Metric{Dimensions: []cwType.Dimension{dimension("LoadBalancer", "net/other/3")}}
+	tests := []struct {
+		name         string
+		requirements []string
+		expected     []cwType.Metric
+	}{
+		{
+			name:     "no requirements",
+			expected: []cwType.Metric{loadBalancer, targetGroup, availabilityZone},
+		},
+		{
+			name:         "load balancer only",
+			requirements: []string{"LoadBalancer"},
+			expected:     []cwType.Metric{loadBalancer},
+		},
+		{
+			name:         "target groups",
+			requirements: []string{"TargetGroup", "LoadBalancer"},
+			expected:     []cwType.Metric{targetGroup},
+		},
+		{
+			name:         "unknown dimension",
+			requirements: []string{"TargetGroup"},
+		},
+	}
+	m := &MetricsCollectorAwsMonitor{log: log.StandardLogger()}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			filtered := m.filterMetricsList(dimFilter, test.requirements, []cwType.Metric{loadBalancer, targetGroup, availabilityZone, other})
+			assert.ElementsMatch(t, test.expected, filtered)
+		})
+	}
+}
+
+func TestCreatePrometheusMetricsName(t *testing.T) {
+	tests := []struct {
+		namespace string
+		expected  string
+	}{
+		{namespace: "ec2", expected: "cpe_aws_ec2_cpuutilization_average"},
+		{namespace: "AWS/EC2", expected: "aws_ec2_cpuutilization_average"},
+		{namespace: "AWS/Usage", expected: "aws_usage_cpuutilization_average"},
+	}
+	for _, test := range tests {
+		t.Run(test.namespace, func(t *testing.T) {
+			data := &cloudwatchData{
+				ID:                      aws.String("arn:aws:ec2:eu-central-1:123456789012:instance/i-1"),
+				Metric:                  aws.String("CPUUtilization"),
+				Namespace:               aws.String(test.namespace),
+				Statistics:              []string{"Average"},
+				GetMetricDataPoint:      aws.Float64(42),
+				GetMetricDataTimestamps: aws.Time(time.Now()),
+				Region:                  aws.String("eu-central-1"),
+				AccountId:               aws.String("123456789012"),
+			}
+			metrics, _, err := createPrometheusMetricsFromCwData([]*cloudwatchData{data})
+			assert.NoError(t, err)
+			assert.Len(t, metrics, 1)
+			assert.Equal(t, test.expected, *metrics[0].name)
+		})
+	}
+}
+
+func TestCreatePrometheusMetricsNilToZero(t *testing.T) {
+	value, timestamp := 42.0, time.Date(2022, time.October, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name             string
+		value            *float64
+		nilToZero        *bool
+		addTimestamp     *bool
+		expected         *float64
+		includeTimestamp bool
+	}{
+		{name: "value", value: &value, expected: &value},
+		{name: "value with timestamp", value: &value, addTimestamp: aws.Bool(true), expected: &value, includeTimestamp: true},
+		{name: "missing", expected: aws.Float64(math.NaN())},
+		{name: "missing as zero", nilToZero: aws.Bool(true), expected: aws.Float64(0)},
+		{name: "missing without zero", nilToZero: aws.Bool(false), expected: aws.Float64(math.NaN())},
+		{name: "missing with timestamp", nilToZero: aws.Bool(true), addTimestamp: aws.Bool(true)},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			data := &cloudwatchData{
+				ID:                     aws.String("arn:aws:ec2:eu-central-1:123456789012:instance/i-1"),
+				Metric:                 aws.String("CPUUtilization"),
+				Namespace:              aws.String("ec2"),
+				Statistics:             []string{"Average"},
+				NilToZero:              test.nilToZero,
+				AddCloudwatchTimestamp: test.addTimestamp,
+				Region:                 aws.String("eu-central-1"),
+				AccountId:              aws.String("123456789012"),
+			}
+			if test.value != nil {
+				data.GetMetricDataPoint, data.GetMetricDataTimestamps = test.value, &timestamp
+			}
+			metrics, _, err := createPrometheusMetricsFromCwData([]*cloudwatchData{data})
+			assert.NoError(t, err)
+			if test.expected == nil {
+				assert.Empty(t, metrics)
+				return
+			}
+			assert.Len(t, metrics, 1)
+			assert.Equal(t, "cpe_aws_ec2_cpuutilization_average", *metrics[0].name)
+			if math.IsNaN(*test.expected) {
+				assert.True(t, math.IsNaN(*metrics[0].value))
+			} else {
+				assert.Equal(t, *test.expected, *metrics[0].value)
+			}
+			assert.Equal(t, test.includeTimestamp, metrics[0].includeTimestamp)
+		})
+	}
+}
+
+// MockCloudWatchClient returns the value 1 for every query, older data points follow on a second page if paginated
+type MockCloudWatchClient struct {
+	paginated bool
+	calls     int
+	mux       sync.Mutex
+}
+
+func (m *MockCloudWatchClient) ListMetrics(ctx context.Context, params *cloudwatch.ListMetricsInput, optFns ...func(*cloudwatch.Options)) (*cloudwatch.ListMetricsOutput, error) {
+	return &cloudwatch.ListMetricsOutput{}, nil
+}
+
+func (m *MockCloudWatchClient) GetMetricData(ctx context.Context, params *cloudwatch.GetMetricDataInput, optFns ...func(*cloudwatch.Options)) (*cloudwatch.GetMetricDataOutput, error) {
+	m.mux.Lock()
+	m.calls++
+	m.mux.Unlock()
+	if len(params.MetricDataQueries) > maxGetMetricDataQueries {
+		return nil, fmt.Errorf("%d queries", len(params.MetricDataQueries))
+	}
+	output := &cloudwatch.GetMetricDataOutput{}
+	value, timestamp := 1.0, *params.EndTime
+	if params.NextToken != nil {
+		value, timestamp = 2.0, timestamp.Add(-time.Hour)
+	} else if m.paginated {
+		output.NextToken = aws.String("next")
+	}
+	for _, query := range params.MetricDataQueries {
+		output.MetricDataResults = append(output.MetricDataResults, cwType.MetricDataResult{
+			Id:         query.Id,
+			Values:     []float64{value},
+			Timestamps: []time.Time{timestamp},
+		})
+	}
+	return output, nil
+}
+
+func newCloudwatchData(n int) []cloudwatchData {
+	data := make([]cloudwatchData, n)
+	for i := range data {
+		data[i] = cloudwatchData{
+			MetricID:   aws.String(fmt.Sprintf("id_%d", i)),
+			Metric:     aws.String("CPUUtilization"),
+			Statistics: []string{"Average"},
+			Period:     int32(60 * (1 + i%5)),
+		}
+	}
+	return data
+}
+
+func newMockMonitor(client ICloudWatchClient, concurrency int) *MetricsCollectorAwsMonitor {
+	logger := log.New()
+	logger.Out = io.Discard
+	return &MetricsCollectorAwsMonitor{log: logger, cwClient: client, getMetricDataSlots: newGetMetricDataSlots(concurrency)}
+}
+
+func TestScrapeUsingMetricData(t *testing.T) {
+	tests := []struct {
+		name      string
+		metrics   int
+		paginated bool
+		calls     int
+	}{
+		{name: "empty", metrics: 0, calls: 0},
+		{name: "single batch", metrics: maxGetMetricDataQueries, calls: 1},
+		{name: "batches", metrics: 2*maxGetMetricDataQueries + 1, calls: 3},
+		{name: "paginated", metrics: maxGetMetricDataQueries + 1, paginated: true, calls: 4},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			client := &MockCloudWatchClient{paginated: test.paginated}
+			data := newCloudwatchData(test.metrics)
+			result := newMockMonitor(client, 2).scrapeUsingMetricData(context.TODO(), "AWS/EC2", 300, 0, nil, data)
+			assert.Equal(t, test.calls, client.calls)
+			assert.Len(t, result, test.metrics)
+			for _, d := range result {
+				// the newest data point of the first page is kept
+				assert.Equal(t, 1.0, *d.GetMetricDataPoint)
+			}
+		})
+	}
+}
+
+func TestScrapeWindows(t *testing.T) {
+	client := &MockCloudWatchClient{}
+	m := newMockMonitor(client, 2)
+	window := newGetMetricDataWindow("AWS/EC2", &config.Metric{Period: 300}, nil)
+	delayed := newGetMetricDataWindow("AWS/EC2", &config.Metric{Period: 300, Delay: 120}, nil)
+	queries := make(map[getMetricDataWindow][]cloudwatchData)
+	// three metrics of 200 queries share two batches, the delayed metric needs its own
+	for i := 0; i < 3; i++ {
+		for _, d := range newCloudwatchData(200) {
+			d.MetricID = aws.String(fmt.Sprintf("%s_%d", *d.MetricID, i))
+			queries[window] = append(queries[window], d)
+		}
+	}
+	queries[delayed] = newCloudwatchData(10)
+	result := m.scrapeWindows(context.TODO(), queries)
+	assert.Equal(t, 3, client.calls)
+	assert.Len(t, result, 610)
+	assert.Equal(t, int64(300), window.length)
+}
+
+func TestScrapeWhileGathering(t *testing.T) {
+	m := newMockMonitor(&MockCloudWatchClient{}, 1)
+	m.conf = &config.Config{Aws: &config.AwsConfig{}}
+	wg := sync.WaitGroup{}
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 100; i++ {
+			m.Scrape(context.TODO())
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 100; i++ {
+			_, err := m.Gather()
+			assert.NoError(t, err)
+		}
+	}()
+	wg.Wait()
+}
+
+func TestCreateGetMetricDataInput(t *testing.T) {
+	data := newCloudwatchData(3)
+	input := createGetMetricDataInput(data, aws.String("AWS/EC2"), 600, 120, nil)
+	assert.Len(t, input.MetricDataQueries, 3)
+	assert.Equal(t, int32(180), *input.MetricDataQueries[2].MetricStat.Period)
+	// the window is rounded to the shortest period
+	assert.Equal(t, 10*time.Minute, input.EndTime.Sub(*input.StartTime))
+	assert.Zero(t, input.EndTime.Add(2*time.Minute).Unix()%60)
+
+	input = createGetMetricDataInput(data, aws.String("AWS/EC2"), 600, 0, aws.Int32(300))
+	assert.Zero(t, input.EndTime.Unix()%300)
+}
+
+// scrapeUsingMetricDataOf20 is the former query path: batches of 20 queries, a goroutine per batch and a linear
+// search of the query of each result
+func (m *MetricsCollectorAwsMonitor) scrapeUsingMetricDataOf20(ctx context.Context, namespace string, length, delay int64, cwData []cloudwatchData) []*cloudwatchData {
+	wg := sync.WaitGroup{}
+	mux := &sync.Mutex{}
+	var cw []*cloudwatchData
+	for start := 0; start < len(cwData); start += 20 {
+		end := start + 20
+		if end > len(cwData) {
+			end = len(cwData)
+		}
+		wg.Add(1)
+		go func(input []cloudwatchData) {
+			defer wg.Done()
+			filter := createGetMetricDataInput(input, &namespace, length, delay, nil)
+			data := &cloudwatch.GetMetricDataOutput{}
+			paginator := cloudwatch.NewGetMetricDataPaginator(m.cwClient, filter)
+			for paginator.HasMorePages() {
+				page, err := paginator.NextPage(ctx)
+				if err != nil {
+					return
+				}
+				data.MetricDataResults = append(data.MetricDataResults, page.MetricDataResults...)
+			}
+			output := make([]*cloudwatchData, 0)
+			for _, result := range data.MetricDataResults {
+				for _, d := range input {
+					if *d.MetricID == *result.Id {
+						d := d
+						if len(result.Values) != 0 {
+							d.GetMetricDataPoint = &result.Values[0]
+							d.GetMetricDataTimestamps = &result.Timestamps[0]
+						}
+						output = append(output, &d)
+						break
+					}
+				}
+			}
+			mux.Lock()
+			cw = append(cw, output...)
+			mux.Unlock()
+		}(cwData[start:end])
+	}
+	wg.Wait()
+	return cw
+}
+
+func BenchmarkScrapeUsingMetricData(b *testing.B) {
+	b.Run("batches of 20", func(b *testing.B) {
+		m := newMockMonitor(&MockCloudWatchClient{}, 1)
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			b.StopTimer()
+			data := newCloudwatchData(50000)
+			b.StartTimer()
+			m.scrapeUsingMetricDataOf20(context.TODO(), "AWS/EC2", 300, 0, data)
+		}
+	})
+	for _, concurrency := range []int{1, defaultGetMetricDataConcurrency} {
+		b.Run(fmt.Sprintf("concurrency %d", concurrency), func(b *testing.B) {
+			m := newMockMonitor(&MockCloudWatchClient{}, concurrency)
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				b.StopTimer()
+				data := newCloudwatchData(50000)
+				b.StartTimer()
+				m.scrapeUsingMetricData(context.TODO(), "AWS/EC2", 300, 0, nil, data)
+			}
+		})
+	}
+}
@@ -2,11 +2,15 @@ package monitor
 
 import (
 	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
 	"regexp"
+	"sort"
 	"strings"
 	"time"
 )
 
+const help = "Help is not implemented yet."
+
 type LabelSet map[string]struct{}
 
 var splitRegexp = regexp.MustCompile(`([a-z0-9])([A-Z])`)
@@ -39,7 +43,7 @@ type PrometheusMetric struct {
 func createDesc(metric *PrometheusMetric) *prometheus.Desc {
 	return prometheus.NewDesc(
 		*metric.name,
-		"Help is not implemented yet.",
+		help,
 		nil,
 		metric.labels,
 	)
@@ -48,7 +52,7 @@ func createDesc(metric *PrometheusMetric) *prometheus.Desc {
 func createMetric(metric *PrometheusMetric) prometheus.Metric {
 	gauge := prometheus.NewGauge(prometheus.GaugeOpts{
 		Name:        *metric.name,
-		Help:        "Help is not implemented yet.",
+		Help:        help,
 		ConstLabels: metric.labels,
 	})
 	gauge.Set(*metric.value)
@@ -60,6 +64,35 @@ func createMetric(metric *PrometheusMetric) prometheus.Metric {
 	return prometheus.NewMetricWithTimestamp(metric.timestamp, gauge)
 }
 
+// Gather returns the metrics of the last scrape. Unlike a registry it allows several samples of a series, as exported
+// with all data points of the window.
+func (m *MetricsCollectorAwsMonitor) Gather() ([]*dto.MetricFamily, error) {
+	families := make(map[string]*dto.MetricFamily)
+	var names []string
+	m.metricsMux.RLock()
+	defer m.metricsMux.RUnlock()
+	for _, metric := range m.metrics {
+		var pb dto.Metric
+		if err := createMetric(metric).Write(&pb); err != nil {
+			return nil, err
+		}
+		family, ok := families[*metric.name]
+		if !ok {
+			helpText := help
+			family = &dto.MetricFamily{Name: metric.name, Help: &helpText, Type: dto.MetricType_GAUGE.Enum()}
+			families[*metric.name] = family
+			names = append(names, *metric.name)
+		}
+		family.Metric = append(family.Metric, &pb)
+	}
+	sort.Strings(names)
+	result := make([]*dto.MetricFamily, 0, len(names))
+	for _, name := range names {
+		result = append(result, families[name])
+	}
+	return result, nil
+}
+
 func promString(text string) string {
 	text = splitRegexp.ReplaceAllString(text, `$1.$2`)
 	return strings.ToLower(replacer.Replace(text))
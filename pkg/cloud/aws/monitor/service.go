@@ -1,6 +1,12 @@
 package monitor
 
-import "github.com/aws/aws-sdk-go-v2/aws"
+import (
+	"errors"
+	"fmt"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.wdf.sap.corp/DBaaS/cloud-provider-exporter/pkg/config"
+	"regexp"
+)
 
 type serviceFilter struct {
 	Namespace        string
@@ -21,6 +27,61 @@ func (sc serviceConfig) GetService(serviceType string) *serviceFilter {
 	return nil
 }
 
+// newServiceConfig returns the service definitions of the config followed by the built-in ones, the first definition
+// of a namespace or alias wins.
+func newServiceConfig(services []*config.Service) (serviceConfig, error) {
+	var sc serviceConfig
+	for _, service := range services {
+		if service.Namespace == "" {
+			return nil, errors.New("the namespace of a CloudWatch service is missing")
+		}
+		sf := serviceFilter{
+			Namespace:       service.Namespace,
+			Alias:           service.Alias,
+			IgnoreLength:    service.IgnoreLength,
+			ResourceFilters: service.ResourceFilters,
+		}
+		for _, dr := range service.DimensionRegexps {
+			r, err := regexp.Compile(dr)
+			if err != nil {
+				return nil, fmt.Errorf("invalid dimension regexp of %s: %w", service.Namespace, err)
+			}
+			if len(r.SubexpNames()) < 2 {
+				return nil, fmt.Errorf("the dimension regexp %s of %s has no named group", dr, service.Namespace)
+			}
+			sf.DimensionRegexps = append(sf.DimensionRegexps, aws.String(dr))
+		}
+		sc = append(sc, sf)
+	}
+	return append(sc, SupportedServices...), nil
+}
+
+// validate checks that the jobs refer to known services and the static jobs are complete
+func (sc serviceConfig) validate(conf *config.CloudWatchMetricsConf) error {
+	for _, job := range conf.Jobs {
+		if sc.GetService(job.Type) == nil {
+			return fmt.Errorf("unknown CloudWatch job type %s", job.Type)
+		}
+		if err := validateAggregations(job.Aggregation, job.Metrics); err != nil {
+			return err
+		}
+	}
+	for _, static := range conf.Static {
+		if static.Name == "" || static.Namespace == "" {
+			return errors.New("the name or namespace of a static CloudWatch job is missing")
+		}
+		for _, metric := range static.Metrics {
+			if len(metric.Statistics) == 0 {
+				return fmt.Errorf("the statistics of the metric %s of the static CloudWatch job %s are missing", metric.Name, static.Name)
+			}
+		}
+		if err := validateAggregations("", static.Metrics); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 var (
 	SupportedServices = serviceConfig{
 		{
@@ -93,6 +154,118 @@ var (
 			DimensionRegexps: []*string{
 				aws.String(":healthcheck/(?P<HealthCheckId>[^/]+)"),
 			},
+		}, {
+			Namespace: "AWS/RDS",
+			Alias:     "rds",
+			ResourceFilters: []string{
+				"rds:db",
+				"rds:cluster",
+			},
+			DimensionRegexps: []*string{
+				aws.String(":cluster:(?P<DBClusterIdentifier>[^/]+)"),
+				aws.String(":db:(?P<DBInstanceIdentifier>[^/]+)"),
+			},
+		}, {
+			Namespace: "AWS/EFS",
+			Alias:     "efs",
+			ResourceFilters: []string{
+				"elasticfilesystem:file-system",
+			},
+			DimensionRegexps: []*string{
+				aws.String("file-system/(?P<FileSystemId>[^/]+)"),
+			},
+		}, {
+			Namespace: "AWS/Lambda",
+			Alias:     "lambda",
+			ResourceFilters: []string{
+				"lambda:function",
+			},
+			DimensionRegexps: []*string{
+				aws.String(":function:(?P<FunctionName>[^/:]+)"),
+			},
+		}, {
+			Namespace: "AWS/SQS",
+			Alias:     "sqs",
+			ResourceFilters: []string{
+				"sqs",
+			},
+			DimensionRegexps: []*string{
+				aws.String("(?P<QueueName>[^:]+)$"),
+			},
+		}, {
+			Namespace: "AWS/SNS",
+			Alias:     "sns",
+			ResourceFilters: []string{
+				"sns",
+			},
+			DimensionRegexps: []*string{
+				aws.String("(?P<TopicName>[^:]+)$"),
+			},
+		}, {
+			Namespace: "AWS/DynamoDB",
+			Alias:     "dynamodb",
+			ResourceFilters: []string{
+				"dynamodb:table",
+			},
+			DimensionRegexps: []*string{
+				aws.String(":table/(?P<TableName>[^/]+)"),
+			},
+		}, {
+			Namespace: "AWS/ElastiCache",
+			Alias:     "ec",
+			ResourceFilters: []string{
+				"elasticache:cluster",
+			},
+			DimensionRegexps: []*string{
+				aws.String("cluster:(?P<CacheClusterId>[^/]+)"),
+			},
+		}, {
+			Namespace: "AWS/EKS",
+			Alias:     "eks",
+			ResourceFilters: []string{
+				"eks:cluster",
+			},
+			DimensionRegexps: []*string{
+				aws.String(":cluster/(?P<ClusterName>[^/]+)$"),
+			},
+		}, {
+			Namespace: "ContainerInsights",
+			Alias:     "containerinsights",
+			ResourceFilters: []string{
+				"eks:cluster",
+			},
+			DimensionRegexps: []*string{
+				aws.String(":cluster/(?P<ClusterName>[^/]+)$"),
+			},
+		}, {
+			Namespace: "AWS/ECS",
+			Alias:     "ecs-svc",
+			ResourceFilters: []string{
+				"ecs:cluster",
+				"ecs:service",
+			},
+			DimensionRegexps: []*string{
+				aws.String(":cluster/(?P<ClusterName>[^/]+)$"),
+				aws.String(":service/(?P<ClusterName>[^/]+)/(?P<ServiceName>[^/]+)$"),
+			},
+		}, {
+			Namespace: "AWS/Kinesis",
+			Alias:     "kinesis",
+			ResourceFilters: []string{
+				"kinesis:stream",
+			},
+			DimensionRegexps: []*string{
+				aws.String(":stream/(?P<StreamName>[^/]+)"),
+			},
+		}, {
+			Namespace: "AWS/AutoScaling",
+			Alias:     "asg",
+			ResourceFilters: []string{
+				"autoscaling:autoScalingGroup",
+			},
+			DimensionRegexps: []*string{
+				aws.String("autoScalingGroupName/(?P<AutoScalingGroupName>[^/]+)"),
+			},
 		}, {
 			Namespace:    "AWS/S3",
 			Alias:        "s3",
@@ -0,0 +1,95 @@
+package monitor
+
+import (
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/stretchr/testify/assert"
+	"github.wdf.sap.corp/DBaaS/cloud-provider-exporter/pkg/config"
+	"testing"
+)
+
+func TestNewServiceConfig(t *testing.T) {
+	sc, err := newServiceConfig([]*config.Service{
+		{
+			Namespace:        "Custom/Queue",
+			Alias:            "queue",
+			ResourceFilters:  []string{"mq:broker"},
+			DimensionRegexps: []string{":broker:(?P<Broker>[^:]+)"},
+		},
+		{Namespace: "AWS/EC2", Alias: "ec2", ResourceFilters: []string{"ec2:instance"}},
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, "Custom/Queue", sc.GetService("queue").Namespace)
+	assert.Equal(t, []*string{aws.String(":broker:(?P<Broker>[^:]+)")}, sc.GetService("Custom/Queue").DimensionRegexps)
+	// the definitions of the config override the built-in ones
+	assert.Empty(t, sc.GetService("ec2").DimensionRegexps)
+	assert.Equal(t, "AWS/RDS", sc.GetService("rds").Namespace)
+	assert.Nil(t, sc.GetService("unknown"))
+
+	_, err = newServiceConfig([]*config.Service{{Alias: "queue"}})
+	assert.EqualError(t, err, "the namespace of a CloudWatch service is missing")
+	_, err = newServiceConfig([]*config.Service{{Namespace: "Custom/Queue", DimensionRegexps: []string{"broker:("}}})
+	assert.Error(t, err)
+	_, err = newServiceConfig([]*config.Service{{Namespace: "Custom/Queue", DimensionRegexps: []string{"broker:.+"}}})
+	assert.EqualError(t, err, "the dimension regexp broker:.+ of Custom/Queue has no named group")
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name string
+		conf config.CloudWatchMetricsConf
+		err  string
+	}{
+		{
+			name: "alias and namespace",
+			conf: config.CloudWatchMetricsConf{Jobs: []*config.Job{{Type: "lambda"}, {Type: "AWS/DynamoDB"}}},
+		},
+		{
+			name: "unknown type",
+			conf: config.CloudWatchMetricsConf{Jobs: []*config.Job{{Type: "ec2"}, {Type: "queue"}}},
+			err:  "unknown CloudWatch job type queue",
+		},
+		{
+			name: "static",
+			conf: config.CloudWatchMetricsConf{Static: []*config.Static{{Name: "vcpus", Namespace: "AWS/Usage"}}},
+		},
+		{
+			name: "static without namespace",
+			conf: config.CloudWatchMetricsConf{Static: []*config.Static{{Name: "vcpus"}}},
+			err:  "the name or namespace of a static CloudWatch job is missing",
+		},
+		{
+			name: "static without statistics",
+			conf: config.CloudWatchMetricsConf{Static: []*config.Static{{Name: "vcpus", Namespace: "AWS/Usage", Metrics: []*config.Metric{{Name: "ResourceCount"}}}}},
+			err:  "the statistics of the metric ResourceCount of the static CloudWatch job vcpus are missing",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := SupportedServices.validate(&test.conf)
+			if test.err == "" {
+				assert.NoError(t, err)
+			} else {
+				assert.EqualError(t, err, test.err)
+			}
+		})
+	}
+}
+
+func TestSupportedServices(t *testing.T) {
+	for _, svc := range SupportedServices {
+		for _, dr := range svc.DimensionRegexps {
+			_, err := newServiceConfig([]*config.Service{{Namespace: svc.Namespace, DimensionRegexps: []string{*dr}}})
+			assert.NoError(t, err, svc.Namespace)
+		}
+	}
+	m := &MetricsCollectorAwsMonitor{}
+	dimFilter := m.getDimensionsFilter([]*taggedResource{
+		{ARN: "arn:aws:rds:eu-central-1:123456789012:db:orders"},
+		{ARN: "arn:aws:lambda:eu-central-1:123456789012:function:rotate:live"},
+	}, SupportedServices.GetService("rds"))
+	assert.Equal(t, "orders", dimFilter["DBInstanceIdentifier"][0].dimVal)
+	dimFilter = m.getDimensionsFilter([]*taggedResource{
+		{ARN: "arn:aws:lambda:eu-central-1:123456789012:function:rotate:live"},
+	}, SupportedServices.GetService("lambda"))
+	assert.Equal(t, "rotate", dimFilter["FunctionName"][0].dimVal)
+}
@@ -3,7 +3,6 @@ package quota
 import (
 	"context"
 	"github.com/aws/aws-sdk-go-v2/aws"
-	awsConf "github.com/aws/aws-sdk-go-v2/config"
 	"github.com/aws/aws-sdk-go-v2/credentials"
 	"github.com/aws/aws-sdk-go-v2/service/cloudwatch"
 	cloudwatchType "github.com/aws/aws-sdk-go-v2/service/cloudwatch/types"
@@ -18,6 +17,7 @@ import (
 	"github.com/patrickmn/go-cache"
 	"github.com/prometheus/client_golang/prometheus"
 	log "github.com/sirupsen/logrus"
+	"github.wdf.sap.corp/DBaaS/cloud-provider-exporter/pkg/cloud/aws/awsconfig"
 	"github.wdf.sap.corp/DBaaS/cloud-provider-exporter/pkg/cloud/common"
 	"github.wdf.sap.corp/DBaaS/cloud-provider-exporter/pkg/config"
 	"github.wdf.sap.corp/DBaaS/cloud-provider-exporter/pkg/constant"
@@ -217,7 +217,7 @@ func NewMetricsCollectorAwsQuota(config *config.Config, cred vault.CloudCredenti
 	m.conf = config
 	m.log = logger
 	appCred := aws.NewCredentialsCache(credentials.NewStaticCredentialsProvider(cred[vault.AwsAccessKeyID], cred[vault.AwsSecretAccessKey], ""))
-	cfg, err := awsConf.LoadDefaultConfig(context.TODO(), awsConf.WithCredentialsProvider(appCred), awsConf.WithRegion(m.conf.Region))
+	cfg, err := awsconfig.Load(context.TODO(), m.conf, appCred)
 	if err != nil {
 		m.log.Errorf("Error while loading default config: ", err)
 	}
@@ -2,26 +2,45 @@ package vault_bucket
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"github.com/aws/aws-sdk-go-v2/aws"
-	awsConf "github.com/aws/aws-sdk-go-v2/config"
-	"github.com/aws/aws-sdk-go-v2/credentials"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/aws/smithy-go"
 	"github.com/prometheus/client_golang/prometheus"
 	log "github.com/sirupsen/logrus"
-	"github.wdf.sap.corp/DBaaS/cloud-provider-exporter/pkg/cloud/common"
+	"github.wdf.sap.corp/DBaaS/cloud-provider-exporter/pkg/cloud/aws/awsconfig"
+	"github.wdf.sap.corp/DBaaS/cloud-provider-exporter/pkg/cloud/objectstore"
 	"github.wdf.sap.corp/DBaaS/cloud-provider-exporter/pkg/config"
 	"github.wdf.sap.corp/DBaaS/cloud-provider-exporter/pkg/vault"
-	"time"
+	"io"
+	"strings"
+	"sync"
 )
 
 type IS3Client interface {
 	ListObjectsV2(ctx context.Context, params *s3.ListObjectsV2Input, optFns ...func(*s3.Options)) (*s3.ListObjectsV2Output, error)
+	GetObject(ctx context.Context, params *s3.GetObjectInput, optFns ...func(*s3.Options)) (*s3.GetObjectOutput, error)
+	HeadObject(ctx context.Context, params *s3.HeadObjectInput, optFns ...func(*s3.Options)) (*s3.HeadObjectOutput, error)
+	GetBucketVersioning(ctx context.Context, params *s3.GetBucketVersioningInput, optFns ...func(*s3.Options)) (*s3.GetBucketVersioningOutput, error)
+	GetBucketEncryption(ctx context.Context, params *s3.GetBucketEncryptionInput, optFns ...func(*s3.Options)) (*s3.GetBucketEncryptionOutput, error)
+	GetObjectLockConfiguration(ctx context.Context, params *s3.GetObjectLockConfigurationInput, optFns ...func(*s3.Options)) (*s3.GetObjectLockConfigurationOutput, error)
+	GetBucketLifecycleConfiguration(ctx context.Context, params *s3.GetBucketLifecycleConfigurationInput, optFns ...func(*s3.Options)) (*s3.GetBucketLifecycleConfigurationOutput, error)
+	GetPublicAccessBlock(ctx context.Context, params *s3.GetPublicAccessBlockInput, optFns ...func(*s3.Options)) (*s3.GetPublicAccessBlockOutput, error)
 }
 
 type MetricsCollectorAWSVaultBucket struct {
 	conf     *config.Config
 	s3Client IS3Client
-	log      log.FieldLogger
+	// endpointClients are the clients of the targets in S3 compatible stores
+	endpointClients map[*config.VaultBackupBucketConfig]IS3Client
+	// endpointErrors are the errors the clients of the other targets in S3 compatible stores failed with
+	endpointErrors map[*config.VaultBackupBucketConfig]error
+	// listers are kept by client, the buckets of targets sharing a client are inspected once per scrape
+	mu      sync.Mutex
+	listers map[IS3Client]*S3Lister
+	log     log.FieldLogger
 }
 
 func NewMetricsCollectorAWSVaultBucket(config *config.Config, cred vault.CloudCredentials, logger log.FieldLogger) *MetricsCollectorAWSVaultBucket {
@@ -29,86 +48,214 @@ func NewMetricsCollectorAWSVaultBucket(config *config.Config, cred vault.CloudCr
 	m.conf = config
 	m.log = logger
 	//s3 hostname: s3.Region.amazonaws.com
-	cfg, err := awsConf.LoadDefaultConfig(context.TODO(), awsConf.WithCredentialsProvider(credentials.StaticCredentialsProvider{Value: aws.Credentials{
-		AccessKeyID:     cred[vault.AwsAccessKeyID],
-		SecretAccessKey: cred[vault.AwsSecretAccessKey],
-	}}), awsConf.WithRegion(config.Region))
+	cfg, err := awsconfig.Load(context.TODO(), config, awsconfig.StaticCredentials(cred))
 	if err != nil {
 		m.log.Fatal(err)
 	}
 	m.s3Client = s3.NewFromConfig(cfg)
-	common.InitVaultBackupBucketDesc("", "AWS", []string{"bucket", "prefix"})
+	m.initEndpointClients(awsconfig.StaticCredentials(cred))
+	objectstore.InitDesc("AWS")
 	return m
 }
 
+func (m *MetricsCollectorAWSVaultBucket) initEndpointClients(provider aws.CredentialsProvider) {
+	m.endpointClients = make(map[*config.VaultBackupBucketConfig]IS3Client)
+	m.endpointErrors = make(map[*config.VaultBackupBucketConfig]error)
+	for _, target := range objectstore.Targets(m.conf) {
+		if target.S3 == nil {
+			continue
+		}
+		client, err := newS3CompatibleClient(target.S3, provider)
+		if err != nil {
+			m.log.Errorf("Error while getting the client of %s at %s: %v", target.Bucket, target.S3.Endpoint, err)
+			m.endpointErrors[target] = err
+			continue
+		}
+		m.endpointClients[target] = client
+	}
+}
+
+func newS3CompatibleClient(endpoint *config.S3EndpointConfig, provider aws.CredentialsProvider) (*s3.Client, error) {
+	cfg, err := awsconfig.LoadS3Compatible(context.TODO(), endpoint, provider)
+	if err != nil {
+		return nil, err
+	}
+	return s3.NewFromConfig(cfg, func(o *s3.Options) {
+		// MinIO and Ceph are mostly addressed without bucket subdomains
+		o.UsePathStyle = endpoint.PathStyle
+		if endpoint.Anonymous {
+			o.Credentials = aws.AnonymousCredentials{}
+		}
+	}), nil
+}
+
 // Describe all the metrics we export
 func (m *MetricsCollectorAWSVaultBucket) Describe(ch chan<- *prometheus.Desc) {
-	ch <- common.ListSuccess
-	ch <- common.LastModifiedObjectDate
-	ch <- common.LastModifiedObjectSize
-	ch <- common.ObjectTotal
-	ch <- common.SumSize
-	ch <- common.BiggestSize
+	objectstore.Describe(ch)
 }
 
 // Collect metrics
 func (m *MetricsCollectorAWSVaultBucket) Collect(ch chan<- prometheus.Metric) {
-	var lastModified time.Time
-	var numberOfObjects float64
-	var totalSize int64
-	var biggestObjectSize int64
-	var lastObjectSize int64
+	var targets []*objectstore.Target
+	for _, target := range objectstore.Targets(m.conf) {
+		var lister objectstore.ObjectLister = m.lister(m.s3Client)
+		if target.S3 != nil {
+			lister = m.lister(m.endpointClients[target])
+			if err, ok := m.endpointErrors[target]; ok {
+				// the target fails to be listed
+				lister = failedLister{err}
+			}
+		}
+		targets = append(targets, objectstore.NewTargets([]*config.VaultBackupBucketConfig{target}, lister)...)
+	}
+	objectstore.Collect(context.TODO(), ch, targets, m.log)
+}
 
-	bucketName := m.conf.VaultBackupBucket.Bucket
-	prefix := m.conf.VaultBackupBucket.Prefix
+// lister returns the lister of a client, created on first use
+func (m *MetricsCollectorAWSVaultBucket) lister(client IS3Client) *S3Lister {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.listers == nil {
+		m.listers = make(map[IS3Client]*S3Lister)
+	}
+	lister, ok := m.listers[client]
+	if !ok {
+		lister = &S3Lister{client}
+		m.listers[client] = lister
+	}
+	return lister
+}
+
+// failedLister fails to list the objects of a target whose client could not be created
+type failedLister struct {
+	err error
+}
 
+func (l failedLister) ListObjects(context.Context, string, string, func(objectstore.Object)) error {
+	return l.err
+}
+
+// S3Lister lists the objects of S3 buckets
+type S3Lister struct {
+	client IS3Client
+}
+
+func (l *S3Lister) ListObjects(ctx context.Context, bucket, prefix string, fn func(objectstore.Object)) error {
 	query := &s3.ListObjectsV2Input{
-		Bucket: &bucketName,
+		Bucket: &bucket,
 		Prefix: &prefix,
 	}
-
-	// Continue making requests until we've listed and compared the date of every object
+	// Continue making requests until we've listed every object
 	truncated := true
 	for truncated {
-		resp, err := m.s3Client.ListObjectsV2(context.TODO(), query)
+		resp, err := l.client.ListObjectsV2(ctx, query)
 		if err != nil {
-			log.Error(err)
-			ch <- prometheus.MustNewConstMetric(
-				common.ListSuccess, prometheus.GaugeValue, 0, bucketName, prefix,
-			)
-			return
+			return err
 		}
 		for _, item := range resp.Contents {
-			numberOfObjects++
-			totalSize = totalSize + item.Size
-			if item.LastModified.After(lastModified) {
-				lastModified = *item.LastModified
-				lastObjectSize = item.Size
-			}
-			if item.Size > biggestObjectSize {
-				biggestObjectSize = item.Size
-			}
+			fn(objectstore.Object{Key: aws.ToString(item.Key), Size: item.Size, LastModified: aws.ToTime(item.LastModified), MD5: objectstore.ETagMD5(aws.ToString(item.ETag))})
 		}
 		query.ContinuationToken = resp.NextContinuationToken
 		truncated = resp.IsTruncated
 	}
+	return nil
+}
 
-	ch <- prometheus.MustNewConstMetric(
-		common.ListSuccess, prometheus.GaugeValue, 1, bucketName, prefix,
-	)
-	ch <- prometheus.MustNewConstMetric(
-		common.LastModifiedObjectDate, prometheus.GaugeValue, float64(lastModified.UnixNano()/1e9), bucketName, prefix,
-	)
-	ch <- prometheus.MustNewConstMetric(
-		common.LastModifiedObjectSize, prometheus.GaugeValue, float64(lastObjectSize), bucketName, prefix,
-	)
-	ch <- prometheus.MustNewConstMetric(
-		common.ObjectTotal, prometheus.GaugeValue, numberOfObjects, bucketName, prefix,
-	)
-	ch <- prometheus.MustNewConstMetric(
-		common.BiggestSize, prometheus.GaugeValue, float64(biggestObjectSize), bucketName, prefix,
-	)
-	ch <- prometheus.MustNewConstMetric(
-		common.SumSize, prometheus.GaugeValue, float64(totalSize), bucketName, prefix,
-	)
+func (l *S3Lister) ReadObject(ctx context.Context, bucket, key string, offset, length int64) (io.ReadCloser, error) {
+	resp, err := l.client.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: &bucket,
+		Key:    &key,
+		Range:  aws.String(fmt.Sprintf("bytes=%d-%d", offset, offset+length-1)),
+	})
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+// ObjectMD5 returns the MD5 of the ETag of an object, nil if it is encrypted with SSE-KMS or SSE-C, whose ETags are
+// not the MD5 of the content.
+func (l *S3Lister) ObjectMD5(ctx context.Context, bucket string, o objectstore.Object) ([]byte, error) {
+	resp, err := l.client.HeadObject(ctx, &s3.HeadObjectInput{Bucket: &bucket, Key: &o.Key})
+	if err != nil {
+		return nil, err
+	}
+	// aws:kms and aws:kms:dsse
+	if strings.HasPrefix(string(resp.ServerSideEncryption), string(types.ServerSideEncryptionAwsKms)) || resp.SSECustomerAlgorithm != nil {
+		return nil, nil
+	}
+	return o.MD5, nil
+}
+
+// notConfigured is true for the errors S3 returns for settings a bucket does not have
+func notConfigured(err error) bool {
+	var apiErr smithy.APIError
+	if !errors.As(err, &apiErr) {
+		return false
+	}
+	switch apiErr.ErrorCode() {
+	case "ServerSideEncryptionConfigurationNotFoundError", "ObjectLockConfigurationNotFoundError",
+		"NoSuchLifecycleConfiguration", "NoSuchPublicAccessBlockConfiguration":
+		return true
+	}
+	return false
+}
+
+// InspectBucket checks the settings of a bucket. S3 compatible stores often lack some of the APIs, the checks
+// they fail are left out and the first failure is returned.
+func (l *S3Lister) InspectBucket(ctx context.Context, bucket string) (map[string]bool, error) {
+	checks := make(map[string]bool)
+	var firstErr error
+	check := func(name string, inspect func() (bool, error)) {
+		compliant, err := inspect()
+		switch {
+		case err == nil:
+			checks[name] = compliant
+		case notConfigured(err):
+			checks[name] = false
+		case firstErr == nil:
+			firstErr = fmt.Errorf("%s: %w", name, err)
+		}
+	}
+	check(objectstore.CheckVersioning, func() (bool, error) {
+		resp, err := l.client.GetBucketVersioning(ctx, &s3.GetBucketVersioningInput{Bucket: &bucket})
+		return err == nil && resp.Status == types.BucketVersioningStatusEnabled, err
+	})
+	check(objectstore.CheckEncryption, func() (bool, error) {
+		resp, err := l.client.GetBucketEncryption(ctx, &s3.GetBucketEncryptionInput{Bucket: &bucket})
+		if err != nil || resp.ServerSideEncryptionConfiguration == nil {
+			return false, err
+		}
+		for _, rule := range resp.ServerSideEncryptionConfiguration.Rules {
+			if rule.ApplyServerSideEncryptionByDefault != nil && rule.ApplyServerSideEncryptionByDefault.SSEAlgorithm != "" {
+				return true, nil
+			}
+		}
+		return false, nil
+	})
+	check(objectstore.CheckObjectLock, func() (bool, error) {
+		resp, err := l.client.GetObjectLockConfiguration(ctx, &s3.GetObjectLockConfigurationInput{Bucket: &bucket})
+		return err == nil && resp.ObjectLockConfiguration != nil && resp.ObjectLockConfiguration.ObjectLockEnabled == types.ObjectLockEnabledEnabled, err
+	})
+	check(objectstore.CheckLifecycle, func() (bool, error) {
+		resp, err := l.client.GetBucketLifecycleConfiguration(ctx, &s3.GetBucketLifecycleConfigurationInput{Bucket: &bucket})
+		if err != nil {
+			return false, err
+		}
+		for _, rule := range resp.Rules {
+			if rule.Status == types.ExpirationStatusEnabled {
+				return true, nil
+			}
+		}
+		return false, nil
+	})
+	check(objectstore.CheckPublicAccessBlocked, func() (bool, error) {
+		resp, err := l.client.GetPublicAccessBlock(ctx, &s3.GetPublicAccessBlockInput{Bucket: &bucket})
+		if err != nil || resp.PublicAccessBlockConfiguration == nil {
+			return false, err
+		}
+		block := resp.PublicAccessBlockConfiguration
+		return block.BlockPublicAcls && block.IgnorePublicAcls && block.BlockPublicPolicy && block.RestrictPublicBuckets, nil
+	})
+	return checks, firstErr
 }
@@ -1,26 +1,43 @@
 package vault_bucket
 
 import (
+	"bytes"
 	"context"
+	"crypto/md5"
+	"encoding/pem"
+	"errors"
+	"fmt"
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
 	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/aws/smithy-go"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 	log "github.com/sirupsen/logrus"
 	"github.com/stretchr/testify/assert"
+	"github.wdf.sap.corp/DBaaS/cloud-provider-exporter/pkg/cloud/objectstore"
 	"github.wdf.sap.corp/DBaaS/cloud-provider-exporter/pkg/config"
 	"github.wdf.sap.corp/DBaaS/cloud-provider-exporter/pkg/constant"
 	"github.wdf.sap.corp/DBaaS/cloud-provider-exporter/pkg/vault"
+	"io"
 	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
 	"testing"
 	"time"
 )
 
 type MockS3Client struct {
+	// kmsEncrypted objects have ETags which are not their MD5
+	kmsEncrypted bool
 }
 
 func (m MockS3Client) ListObjectsV2(ctx context.Context, params *s3.ListObjectsV2Input, optFns ...func(*s3.Options)) (*s3.ListObjectsV2Output, error) {
+	etag := fmt.Sprintf("\"%x\"", md5.Sum(make([]byte, 200)))
+	if m.kmsEncrypted {
+		etag = "\"9c8af9a76df052144598c115ef33e511\""
+	}
 	return &s3.ListObjectsV2Output{
 		Contents: []types.Object{
 			{
@@ -32,6 +49,7 @@ func (m MockS3Client) ListObjectsV2(ctx context.Context, params *s3.ListObjectsV
 				Key:          aws.String("two"),
 				LastModified: aws.Time(time.Date(2020, time.June, 13, 21, 0, 0, 0, time.UTC)),
 				Size:         int64(200),
+				ETag:         aws.String(etag),
 			},
 		},
 		IsTruncated: false,
@@ -42,6 +60,39 @@ func (m MockS3Client) ListObjectsV2(ctx context.Context, params *s3.ListObjectsV
 	}, nil
 }
 
+func (m MockS3Client) GetObject(ctx context.Context, params *s3.GetObjectInput, optFns ...func(*s3.Options)) (*s3.GetObjectOutput, error) {
+	return &s3.GetObjectOutput{Body: io.NopCloser(bytes.NewReader(make([]byte, 200)))}, nil
+}
+
+func (m MockS3Client) HeadObject(ctx context.Context, params *s3.HeadObjectInput, optFns ...func(*s3.Options)) (*s3.HeadObjectOutput, error) {
+	if m.kmsEncrypted {
+		return &s3.HeadObjectOutput{ServerSideEncryption: types.ServerSideEncryptionAwsKms}, nil
+	}
+	return &s3.HeadObjectOutput{ServerSideEncryption: types.ServerSideEncryptionAes256}, nil
+}
+
+func (m MockS3Client) GetBucketVersioning(ctx context.Context, params *s3.GetBucketVersioningInput, optFns ...func(*s3.Options)) (*s3.GetBucketVersioningOutput, error) {
+	return &s3.GetBucketVersioningOutput{Status: types.BucketVersioningStatusEnabled}, nil
+}
+
+func (m MockS3Client) GetBucketEncryption(ctx context.Context, params *s3.GetBucketEncryptionInput, optFns ...func(*s3.Options)) (*s3.GetBucketEncryptionOutput, error) {
+	return &s3.GetBucketEncryptionOutput{ServerSideEncryptionConfiguration: &types.ServerSideEncryptionConfiguration{
+		Rules: []types.ServerSideEncryptionRule{{ApplyServerSideEncryptionByDefault: &types.ServerSideEncryptionByDefault{SSEAlgorithm: types.ServerSideEncryptionAes256}}},
+	}}, nil
+}
+
+func (m MockS3Client) GetObjectLockConfiguration(ctx context.Context, params *s3.GetObjectLockConfigurationInput, optFns ...func(*s3.Options)) (*s3.GetObjectLockConfigurationOutput, error) {
+	return nil, &smithy.GenericAPIError{Code: "ObjectLockConfigurationNotFoundError"}
+}
+
+func (m MockS3Client) GetBucketLifecycleConfiguration(ctx context.Context, params *s3.GetBucketLifecycleConfigurationInput, optFns ...func(*s3.Options)) (*s3.GetBucketLifecycleConfigurationOutput, error) {
+	return &s3.GetBucketLifecycleConfigurationOutput{Rules: []types.LifecycleRule{{Status: types.ExpirationStatusEnabled}}}, nil
+}
+
+func (m MockS3Client) GetPublicAccessBlock(ctx context.Context, params *s3.GetPublicAccessBlockInput, optFns ...func(*s3.Options)) (*s3.GetPublicAccessBlockOutput, error) {
+	return nil, errors.New("access denied")
+}
+
 func TestAWSVaultBucket(t *testing.T) {
 	uri := constant.VaultMonitorPath
 	cred := vault.CloudCredentials{
@@ -66,10 +117,122 @@ func TestAWSVaultBucket(t *testing.T) {
 		h.ServeHTTP(w, r)
 	})
 
-	assert.HTTPBodyContains(t, handler, "GET", uri, nil, "cpe_vault_object_list_success{bucket=\"mock_bucket\",prefix=\"mock_prefix\"} 1")
-	assert.HTTPBodyContains(t, handler, "GET", uri, nil, "cpe_vault_object_last_modified_size_bytes{bucket=\"mock_bucket\",prefix=\"mock_prefix\"} 200")
-	assert.HTTPBodyContains(t, handler, "GET", uri, nil, "cpe_vault_object_count{bucket=\"mock_bucket\",prefix=\"mock_prefix\"} 2")
-	assert.HTTPBodyContains(t, handler, "GET", uri, nil, "cpe_vault_object_max_size_bytes{bucket=\"mock_bucket\",prefix=\"mock_prefix\"} 200")
-	assert.HTTPBodyContains(t, handler, "GET", uri, nil, "cpe_vault_object_size_bytes_total{bucket=\"mock_bucket\",prefix=\"mock_prefix\"} 300")
+	assert.HTTPBodyContains(t, handler, "GET", uri, nil, "cpe_vault_object_list_success{bucket=\"mock_bucket\",group=\"\",prefix=\"mock_prefix\"} 1")
+	assert.HTTPBodyContains(t, handler, "GET", uri, nil, "cpe_vault_object_last_modified_size_bytes{bucket=\"mock_bucket\",group=\"\",prefix=\"mock_prefix\"} 200")
+	assert.HTTPBodyContains(t, handler, "GET", uri, nil, "cpe_vault_object_count{bucket=\"mock_bucket\",group=\"\",prefix=\"mock_prefix\"} 2")
+	assert.HTTPBodyContains(t, handler, "GET", uri, nil, "cpe_vault_object_max_size_bytes{bucket=\"mock_bucket\",group=\"\",prefix=\"mock_prefix\"} 200")
+	assert.HTTPBodyContains(t, handler, "GET", uri, nil, "cpe_vault_object_size_bytes_total{bucket=\"mock_bucket\",group=\"\",prefix=\"mock_prefix\"} 300")
+	assert.HTTPBodyContains(t, handler, "GET", uri, nil, "cpe_vault_object_oldest_age_seconds{bucket=\"mock_bucket\",group=\"\",prefix=\"mock_prefix\"}")
+	assert.HTTPBodyContains(t, handler, "GET", uri, nil, "cpe_bucket_compliance{bucket=\"mock_bucket\",check=\"versioning\",group=\"\",prefix=\"mock_prefix\"} 1")
+	assert.HTTPBodyContains(t, handler, "GET", uri, nil, "cpe_bucket_compliance{bucket=\"mock_bucket\",check=\"encryption\",group=\"\",prefix=\"mock_prefix\"} 1")
+	assert.HTTPBodyContains(t, handler, "GET", uri, nil, "cpe_bucket_compliance{bucket=\"mock_bucket\",check=\"object_lock\",group=\"\",prefix=\"mock_prefix\"} 0")
+	assert.HTTPBodyContains(t, handler, "GET", uri, nil, "cpe_bucket_compliance{bucket=\"mock_bucket\",check=\"lifecycle\",group=\"\",prefix=\"mock_prefix\"} 1")
+	// the public access block could not be read
+	assert.HTTPBodyNotContains(t, handler, "GET", uri, nil, "public_access_blocked")
+}
+
+func TestAWSVaultBucketVerify(t *testing.T) {
+	uri := constant.VaultMonitorPath
+	conf := &config.Config{
+		VaultBackupBucket: &config.VaultBackupBucketConfig{Bucket: "mock_bucket", Prefix: "mock_prefix", Verify: &config.BackupVerifyConfig{}},
+		Region:            "eu-central-1",
+	}
+	vaultBucketCollector := NewMetricsCollectorAWSVaultBucket(conf, vault.CloudCredentials{}, &log.Logger{})
+	vaultBucketCollector.s3Client = &MockS3Client{}
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		registry := prometheus.NewRegistry()
+		registry.MustRegister(vaultBucketCollector)
+		h := promhttp.HandlerFor(registry, promhttp.HandlerOpts{})
+		h.ServeHTTP(w, r)
+	})
+
+	// the ETag of the newest object is the MD5 of its content
+	assert.HTTPBodyContains(t, handler, "GET", uri, nil, "cpe_backup_verify_success{bucket=\"mock_bucket\",group=\"\",prefix=\"mock_prefix\"} 1")
+	assert.HTTPBodyContains(t, handler, "GET", uri, nil, "cpe_backup_verify_duration_seconds{bucket=\"mock_bucket\",group=\"\",prefix=\"mock_prefix\"}")
+
+	// the ETag of an object encrypted with SSE-KMS is not checked
+	vaultBucketCollector.s3Client = &MockS3Client{kmsEncrypted: true}
+	assert.HTTPBodyContains(t, handler, "GET", uri, nil, "cpe_backup_verify_success{bucket=\"mock_bucket\",group=\"\",prefix=\"mock_prefix\"} 1")
+	lister := &S3Lister{&MockS3Client{kmsEncrypted: true}}
+	digest, err := lister.ObjectMD5(context.TODO(), "mock_bucket", objectstore.Object{Key: "two", MD5: objectstore.ETagMD5("\"9c8af9a76df052144598c115ef33e511\"")})
+	assert.NoError(t, err)
+	assert.Nil(t, digest)
+}
+
+func TestS3CompatibleVaultBucket(t *testing.T) {
+	uri := constant.VaultMonitorPath
+	var paths []string
+	var authorized bool
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		paths = append(paths, r.URL.Path)
+		authorized = authorized || r.Header.Get("Authorization") != ""
+		_, _ = w.Write([]byte(`<?xml version="1.0" encoding="UTF-8"?>
+<ListBucketResult xmlns="http://s3.amazonaws.com/doc/2006-03-01/">
+  <Name>minio_bucket</Name><Prefix>etcd</Prefix><KeyCount>2</KeyCount><MaxKeys>1000</MaxKeys><IsTruncated>false</IsTruncated>
+  <Contents><Key>etcd/one</Key><LastModified>2023-06-13T21:00:00.000Z</LastModified><ETag>"abc"</ETag><Size>100</Size></Contents>
+  <Contents><Key>etcd/two</Key><LastModified>2023-06-14T21:00:00.000Z</LastModified><ETag>"def"</ETag><Size>300</Size></Contents>
+</ListBucketResult>`))
+	}))
+	defer server.Close()
+	caBundle := filepath.Join(t.TempDir(), "ca.pem")
+	assert.NoError(t, os.WriteFile(caBundle, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw}), 0600))
+
+	conf := &config.Config{
+		VaultBackupBucket: &config.VaultBackupBucketConfig{Bucket: "mock_bucket", Prefix: "mock_prefix"},
+		ObjectStoreTargets: []*config.VaultBackupBucketConfig{
+			{Bucket: "minio_bucket", Prefix: "etcd", S3: &config.S3EndpointConfig{Endpoint: server.URL, PathStyle: true, Anonymous: true, CABundle: caBundle}},
+			{Bucket: "broken_bucket", Prefix: "etcd", S3: &config.S3EndpointConfig{Endpoint: server.URL, CABundle: "missing.pem"}},
+		},
+		Region: "eu-central-1",
+	}
+	vaultBucketCollector := NewMetricsCollectorAWSVaultBucket(conf, vault.CloudCredentials{}, &log.Logger{})
+	vaultBucketCollector.s3Client = &MockS3Client{}
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		registry := prometheus.NewRegistry()
+		registry.MustRegister(vaultBucketCollector)
+		h := promhttp.HandlerFor(registry, promhttp.HandlerOpts{})
+		h.ServeHTTP(w, r)
+	})
+
+	assert.HTTPBodyContains(t, handler, "GET", uri, nil, "cpe_vault_object_count{bucket=\"mock_bucket\",group=\"\",prefix=\"mock_prefix\"} 2")
+	assert.HTTPBodyContains(t, handler, "GET", uri, nil, "cpe_vault_object_list_success{bucket=\"minio_bucket\",group=\"\",prefix=\"etcd\"} 1")
+	assert.HTTPBodyContains(t, handler, "GET", uri, nil, "cpe_vault_object_count{bucket=\"minio_bucket\",group=\"\",prefix=\"etcd\"} 2")
+	assert.HTTPBodyContains(t, handler, "GET", uri, nil, "cpe_vault_object_last_modified_size_bytes{bucket=\"minio_bucket\",group=\"\",prefix=\"etcd\"} 300")
+	// the target whose client could not be created fails to be listed
+	assert.HTTPBodyContains(t, handler, "GET", uri, nil, "cpe_vault_object_list_success{bucket=\"broken_bucket\",group=\"\",prefix=\"etcd\"} 0")
+	assert.HTTPBodyNotContains(t, handler, "GET", uri, nil, "cpe_vault_object_count{bucket=\"broken_bucket\"")
+	// path style requests without signature
+	assert.Contains(t, paths, "/minio_bucket")
+	assert.False(t, authorized)
+}
+
+type countingS3Client struct {
+	MockS3Client
+	versioningCalls *int
+}
+
+func (m countingS3Client) GetBucketVersioning(ctx context.Context, params *s3.GetBucketVersioningInput, optFns ...func(*s3.Options)) (*s3.GetBucketVersioningOutput, error) {
+	*m.versioningCalls++
+	return m.MockS3Client.GetBucketVersioning(ctx, params, optFns...)
+}
+
+func TestAWSVaultBucketInspectedOnce(t *testing.T) {
+	conf := &config.Config{
+		VaultBackupBucket:  &config.VaultBackupBucketConfig{Bucket: "mock_bucket", Prefix: "vault"},
+		ObjectStoreTargets: []*config.VaultBackupBucketConfig{{Bucket: "mock_bucket", Prefix: "etcd"}},
+		Region:             "eu-central-1",
+	}
+	var versioningCalls int
+	vaultBucketCollector := NewMetricsCollectorAWSVaultBucket(conf, vault.CloudCredentials{}, &log.Logger{})
+	vaultBucketCollector.s3Client = &countingS3Client{versioningCalls: &versioningCalls}
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(vaultBucketCollector)
 
+	// the targets in the same bucket share the settings of the bucket
+	_, err := registry.Gather()
+	assert.NoError(t, err)
+	assert.Equal(t, 1, versioningCalls)
+	_, err = registry.Gather()
+	assert.NoError(t, err)
+	assert.Equal(t, 2, versioningCalls)
 }
@@ -5,43 +5,58 @@ import (
 	"fmt"
 	"github.com/Azure/azure-pipeline-go/pipeline"
 	"github.com/Azure/azure-storage-blob-go/azblob"
+	"github.com/Azure/go-autorest/autorest"
 	"github.com/Azure/go-autorest/autorest/adal"
 	"github.com/Azure/go-autorest/autorest/azure"
+	"github.com/Azure/go-autorest/autorest/azure/auth"
 	"github.com/prometheus/client_golang/prometheus"
-	"github.com/prometheus/client_golang/prometheus/promhttp"
 	log "github.com/sirupsen/logrus"
+	"github.wdf.sap.corp/DBaaS/cloud-provider-exporter/pkg/cloud/objectstore"
 	"github.wdf.sap.corp/DBaaS/cloud-provider-exporter/pkg/config"
+	"github.wdf.sap.corp/DBaaS/cloud-provider-exporter/pkg/constant"
 	"github.wdf.sap.corp/DBaaS/cloud-provider-exporter/pkg/vault"
 	"net/http"
 	"net/url"
+	"strings"
 	"time"
 )
 
 type AzureExporter struct {
 	quotaCollector  *MetricsCollectorAzureRmQuota
 	healthCollector *MetricsCollectorAzureRmHealth
+	bucketCollector *MetricsCollectorAzureVaultBucket
 }
 
 func (e *AzureExporter) StartExporter(ctx context.Context, config *config.Config, credential vault.CloudCredentials, logger log.FieldLogger) {
+	if err := initAzureEnv(config); err != nil {
+		logger.Fatalf("Error while getting Azure environment: %v", err)
+	}
 	e.quotaCollector = NewMetricsCollectorAzureRmQuota(config, credential, logger)
 	prometheus.MustRegister(e.quotaCollector)
-	//e.healthCollector = NewMetricsCollectorAzureRmHealth(config, credential, logger)
-	//prometheus.MustRegister(e.healthCollector)
-	//http.HandleFunc(constant.VaultMonitorPath, func(w http.ResponseWriter, r *http.Request) {
-	//	vaultBackupMonitorHandler(w, r, config, credential)
-	//})
+	e.healthCollector = NewMetricsCollectorAzureRmHealth(config, credential, logger)
+	e.registerHealth(config, http.DefaultServeMux, prometheus.DefaultRegisterer)
+	go e.healthCollector.Run(ctx)
+	if len(objectstore.Targets(config)) > 0 {
+		e.bucketCollector = NewMetricsCollectorAzureVaultBucket(config, credential)
+		e.registerVaultBucket(http.DefaultServeMux)
+	}
 }
 
-func (e *AzureExporter) Scrape(ctx context.Context) {
-	e.quotaCollector.scrape(ctx)
+// registerHealth registers the health collector and mounts its webhook if one is configured
+func (e *AzureExporter) registerHealth(config *config.Config, mux *http.ServeMux, registerer prometheus.Registerer) {
+	registerer.MustRegister(e.healthCollector)
+	if config.Health != nil && config.Health.Webhook != nil {
+		mux.Handle(constant.HealthWebhookAzurePath, e.healthCollector.WebhookHandler())
+	}
 }
 
-func vaultBackupMonitorHandler(w http.ResponseWriter, r *http.Request, config *config.Config, credential vault.CloudCredentials) {
-	vaultBucketCollector := NewMetricsCollectorAzureVaultBucket(config, credential)
-	registry := prometheus.NewRegistry()
-	registry.MustRegister(vaultBucketCollector)
-	h := promhttp.HandlerFor(registry, promhttp.HandlerOpts{})
-	h.ServeHTTP(w, r)
+// registerVaultBucket mounts the metrics of the object store targets
+func (e *AzureExporter) registerVaultBucket(mux *http.ServeMux) {
+	mux.Handle(constant.VaultMonitorPath, objectstore.Handler(e.bucketCollector))
+}
+
+func (e *AzureExporter) Scrape(ctx context.Context) {
+	e.quotaCollector.scrape(ctx)
 }
 
 type AzureClient struct {
@@ -57,18 +72,83 @@ var (
 	AzureEnv *azure.Environment
 )
 
-func initAzureEnv() error {
-	env, err := azure.EnvironmentFromName("AZUREPUBLICCLOUD")
+// environmentNames maps the names of AzureConfig.Environment to the environments of the SDK
+var environmentNames = map[string]string{
+	"":             azure.PublicCloud.Name,
+	"public":       azure.PublicCloud.Name,
+	"china":        azure.ChinaCloud.Name,
+	"usgovernment": azure.USGovernmentCloud.Name,
+}
+
+// newEnvironment returns the configured Azure environment with its endpoints overridden. A custom environment
+// starts from the public cloud, so every endpoint of it has to be configured.
+func newEnvironment(conf *config.AzureConfig) (azure.Environment, error) {
+	if conf == nil {
+		return azure.PublicCloud, nil
+	}
+	name := strings.ToLower(conf.Environment)
+	custom := name == "custom"
+	if n, ok := environmentNames[name]; ok {
+		name = n
+	} else if custom {
+		name = azure.PublicCloud.Name
+	}
+	env, err := azure.EnvironmentFromName(name)
+	if err != nil {
+		return env, err
+	}
+	endpoints := conf.Endpoints
+	if endpoints == nil {
+		endpoints = &config.AzureEndpoints{}
+	}
+	if custom && (endpoints.ActiveDirectory == "" || endpoints.ResourceManager == "" || endpoints.StorageSuffix == "") {
+		return env, fmt.Errorf("the activeDirectory, resourceManager and storageSuffix endpoints are required by a custom environment")
+	}
+	if endpoints.ActiveDirectory != "" {
+		env.ActiveDirectoryEndpoint = endpoints.ActiveDirectory
+	}
+	if endpoints.ResourceManager != "" {
+		env.ResourceManagerEndpoint = endpoints.ResourceManager
+		env.TokenAudience = endpoints.ResourceManager
+	}
+	if endpoints.StorageSuffix != "" {
+		env.StorageEndpointSuffix = endpoints.StorageSuffix
+	}
+	return env, nil
+}
+
+// initAzureEnv sets the environment all clients of the exporter use, the public cloud unless configured otherwise.
+func initAzureEnv(conf *config.Config) error {
+	env, err := newEnvironment(conf.Azure)
+	if err != nil {
+		return err
+	}
 	AzureEnv = &env
-	return err
+	return nil
+}
+
+// resourceManagerURI returns the base URI of the resource manager clients
+func resourceManagerURI() string {
+	if AzureEnv == nil {
+		return strings.TrimSuffix(azure.PublicCloud.ResourceManagerEndpoint, "/")
+	}
+	return strings.TrimSuffix(AzureEnv.ResourceManagerEndpoint, "/")
+}
+
+// newAuthorizer authorizes the resource manager clients with the service principal against the environment.
+func newAuthorizer(cred vault.CloudCredentials) (autorest.Authorizer, error) {
+	clientCredentialConfig := auth.NewClientCredentialsConfig(cred[vault.AzureClientID], cred[vault.AzureClientSecret], cred[vault.AzureTenantID])
+	if AzureEnv != nil {
+		clientCredentialConfig.AADEndpoint = AzureEnv.ActiveDirectoryEndpoint
+		clientCredentialConfig.Resource = AzureEnv.TokenAudience
+	}
+	return clientCredentialConfig.Authorizer()
 }
 
 func GetAzureOAuthConfig(azureTenantID string) (*adal.OAuthConfig, error) {
 	if AzureEnv == nil {
-		err := initAzureEnv()
-		if err != nil {
-			return nil, err
-		}
+		env := azure.PublicCloud
+		AzureEnv = &env
 	}
 	azureOAuthConfig, err := adal.NewOAuthConfig(AzureEnv.ActiveDirectoryEndpoint, azureTenantID)
 	if err != nil {
@@ -97,10 +177,6 @@ func getAzureServicePrincipalToken(clientId, clientSecret, azureTenantID string)
 
 // GetAzureStorageCredentials returns a azblob.Credential object that can be used to authenticate an Azure Blob Storage SDK pipeline
 func GetAzureStorageCredentials(clientId, clientSecret, azureTenantID string) (azblob.Credential, error) {
-	err := initAzureEnv()
-	if err != nil {
-		return nil, err
-	}
 	spt, err := getAzureServicePrincipalToken(clientId, clientSecret, azureTenantID)
 	if err != nil {
 		return nil, err
@@ -139,9 +215,8 @@ func NewAzureClient(clientId, clientSecret, tenantId, subscriptionID, bucketName
 
 func (client *AzureClient) BlobService(accountName string) (*azblob.ServiceURL, error) {
 	if AzureEnv == nil {
-		if err := initAzureEnv(); err != nil {
-			return nil, err
-		}
+		env := azure.PublicCloud
+		AzureEnv = &env
 	}
 	azureURL, err := url.Parse(fmt.Sprintf("https://%s.blob.%s", accountName, AzureEnv.StorageEndpointSuffix))
 	if err != nil {
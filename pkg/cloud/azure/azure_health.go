@@ -1,40 +1,47 @@
 package azure
 
 import (
-	"bytes"
-	"crypto/tls"
-	"encoding/json"
-	"errors"
-	"fmt"
-	"github.com/Azure/go-autorest/autorest"
-	"github.com/Azure/go-autorest/autorest/azure/auth"
+	"context"
+	"github.com/Azure/go-autorest/autorest/azure"
+	"github.com/Azure/go-autorest/autorest/to"
 	"github.com/prometheus/client_golang/prometheus"
 	log "github.com/sirupsen/logrus"
 	"github.wdf.sap.corp/DBaaS/cloud-provider-exporter/pkg/cloud/common"
 	"github.wdf.sap.corp/DBaaS/cloud-provider-exporter/pkg/config"
+	"github.wdf.sap.corp/DBaaS/cloud-provider-exporter/pkg/constant"
 	"github.wdf.sap.corp/DBaaS/cloud-provider-exporter/pkg/vault"
-	"io"
-	"io/ioutil"
-	"net/http"
 	"strings"
+	"sync"
+	"time"
 )
 
 type MetricsCollectorAzureRmHealth struct {
-	authorizer autorest.Authorizer
-	conf       *config.Config
-	cred       vault.CloudCredentials
+	conf               *config.Config
+	eventsClient       HealthEventsClient
+	availabilityClient AvailabilityStatusesClient
+	resourcesClient    ResourcesClient
+	poller             *common.HealthPoller
+	log                log.FieldLogger
+
+	availability *prometheus.GaugeVec
+	mu           sync.RWMutex
+	statuses     [][]string
 }
 
 func NewMetricsCollectorAzureRmHealth(config *config.Config, cred vault.CloudCredentials, logger log.FieldLogger) *MetricsCollectorAzureRmHealth {
-	clientCredentialConfig := auth.NewClientCredentialsConfig(cred[vault.AzureClientID], cred[vault.AzureClientSecret], cred[vault.AzureTenantID])
-	authorizer, err := clientCredentialConfig.Authorizer()
+	authorizer, err := newAuthorizer(cred)
 	if err != nil {
-		log.Print("Error while getting authorizer: ", err)
+		logger.Errorf("Error while getting authorizer: %v", err)
 	}
 	m := &MetricsCollectorAzureRmHealth{}
 	m.conf = config
-	m.authorizer = authorizer
-	m.cred = cred
+	m.log = logger
+	m.eventsClient = NewResourceHealthClient(resourceManagerURI(), config.Azure.SubscriptionID)
+	m.eventsClient.SetAuth(authorizer)
+	m.availabilityClient = NewAvailabilityStatusesClientWrapper(resourceManagerURI(), config.Azure.SubscriptionID)
+	m.availabilityClient.SetAuth(authorizer)
+	m.resourcesClient = NewResourcesClientWrapper(resourceManagerURI(), config.Azure.SubscriptionID)
+	m.resourcesClient.SetAuth(authorizer)
 
 	eventLabel := []string{
 		"eventID",
@@ -54,119 +61,186 @@ func NewMetricsCollectorAzureRmHealth(config *config.Config, cred vault.CloudCre
 	eventOpenTotalLabel := []string{"eventType"}
 	eventCloseTotalLabel := []string{"eventType"}
 	common.InitHealthCounterVec("Azure", eventLabel, entityLabel, eventOpenTotalLabel, eventCloseTotalLabel)
+	m.poller = common.NewHealthPoller(m, config, logger)
+	m.poller.SetFilter(config.Azure.HealthFilter)
+
+	m.availability = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: constant.HealthResourceAvailability,
+			Help: strings.Join([]string{"Azure", constant.HelpHealthResourceAvailability}, " "),
+		}, []string{"resourceID", "resourceGroup", "resourceType", "location", "availabilityState", "reasonType"},
+	)
 	return m
 }
 
 func (m *MetricsCollectorAzureRmHealth) Describe(ch chan<- *prometheus.Desc) {
-	common.HealthEvent.Describe(ch)
-	common.AffectedEntity.Describe(ch)
-	common.HealthOpenedTotal.Describe(ch)
-	common.HealthClosedTotal.Describe(ch)
+	m.poller.Describe(ch)
+	m.availability.Describe(ch)
 }
 
 func (m *MetricsCollectorAzureRmHealth) Collect(ch chan<- prometheus.Metric) {
-	common.HealthOpenedTotal.Reset()
-	common.HealthClosedTotal.Reset()
-	common.HealthEvent.Reset()
-	common.AffectedEntity.Reset()
-	url := fmt.Sprintf("https://management.azure.com/subscriptions/%s/providers/Microsoft.ResourceHealth/events?api-version=2018-07-01", m.conf.Azure.SubscriptionID)
-	client := &http.Client{}
+	m.poller.Collect(ch)
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	m.availability.Reset()
+	for _, labels := range m.statuses {
+		m.availability.WithLabelValues(labels...).Set(1)
+	}
+	m.availability.Collect(ch)
+}
 
-	token, err := getToken(m.cred[vault.AzureTenantID], m.cred[vault.AzureClientID], m.cred[vault.AzureClientSecret])
-	if token == "" || err != nil {
-		log.Fatal("An error occurred during get token:", err)
+// Run polls the service health events, and the resource availability statuses if enabled, until ctx is cancelled.
+func (m *MetricsCollectorAzureRmHealth) Run(ctx context.Context) {
+	if m.conf.Azure.AvailabilityStatuses {
+		go m.runAvailability(ctx)
 	}
+	m.poller.Run(ctx)
+}
 
-	request, err := http.NewRequest(http.MethodGet, url, nil)
-	if err != nil {
-		log.Fatal("An Error occurred during create http request:", err)
+func (m *MetricsCollectorAzureRmHealth) runAvailability(ctx context.Context) {
+	ticker := time.NewTicker(m.poller.Interval())
+	defer ticker.Stop()
+	for {
+		if err := m.pollAvailability(ctx); err != nil {
+			m.log.Errorf("Error while listing availability statuses: %v", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
 	}
-	request.Header.Set("Authorization", fmt.Sprintf("Bearer %s", token))
-	request.Header.Set("Content-Type", "application/json")
-	resp, err := client.Do(request)
+}
+
+// pollAvailability replaces the stored availability statuses with the current ones of all resources in the subscription.
+func (m *MetricsCollectorAzureRmHealth) pollAvailability(ctx context.Context) error {
+	var statuses [][]string
+	it, err := m.availabilityClient.ListBySubscriptionIDComplete(ctx, "", "")
 	if err != nil {
-		log.Fatal("An error occurred during send http request:", err)
+		return err
 	}
-
-	defer func(Body io.ReadCloser) {
-		err := Body.Close()
+	for ; it.NotDone(); err = it.NextWithContext(ctx) {
 		if err != nil {
-			log.Errorf("An error occured: %v", err)
+			return err
+		}
+		status := it.Value()
+		resourceID := resourceIDOfStatus(to.String(status.ID))
+		var resourceGroup, resourceType string
+		if resource, err := azure.ParseResourceID(resourceID); err == nil {
+			resourceGroup = resource.ResourceGroup
+			resourceType = resource.Provider + "/" + resource.ResourceType
 		}
-	}(resp.Body)
-	respbody, err := ioutil.ReadAll(resp.Body)
+		var state, reasonType string
+		if status.Properties != nil {
+			state = string(status.Properties.AvailabilityState)
+			reasonType = to.String(status.Properties.ReasonType)
+		}
+		statuses = append(statuses, []string{resourceID, resourceGroup, resourceType, to.String(status.Location), state, reasonType})
+	}
 	if err != nil {
-		log.Fatal("An error occurred during read response body", err)
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.statuses = statuses
+	m.log.Infof("%d resource availability statuses listed", len(statuses))
+	return nil
+}
+
+// resourceIDOfStatus strips the availabilityStatuses suffix off the ID of an availability status.
+func resourceIDOfStatus(statusID string) string {
+	if i := strings.Index(strings.ToLower(statusID), "/providers/microsoft.resourcehealth/availabilitystatuses"); i > 0 {
+		return statusID[:i]
 	}
+	return statusID
+}
 
-	result := make(map[string]interface{})
-	err = json.Unmarshal(respbody, &result)
+func (m *MetricsCollectorAzureRmHealth) FetchEvents(ctx context.Context, since time.Time) ([]*common.HealthEventRecord, error) {
+	var queryStartTime string
+	if !since.IsZero() {
+		// queryStartTime filters on lastUpdateTime with a granularity of days
+		queryStartTime = since.UTC().Format("01/02/2006")
+	}
+	events, err := m.eventsClient.ListEvents(ctx, queryStartTime)
 	if err != nil {
-		log.Fatal("An error occurred during unmarshal response body", err)
-	}
-	for _, val := range result["value"].([]interface{}) {
-		eventID := val.(map[string]interface{})["id"]
-		property := val.(map[string]interface{})["properties"]
-		status := property.(map[string]interface{})["status"]
-		lastUpdateTime := property.(map[string]interface{})["lastUpdateTime"]
-		eventType := property.(map[string]interface{})["eventType"]
-		title := property.(map[string]interface{})["title"]
-		level := property.(map[string]interface{})["level"]
-		impactStartTime := property.(map[string]interface{})["impactStartTime"]
-
-		common.HealthEvent.WithLabelValues(eventID.(string), title.(string), eventType.(string), lastUpdateTime.(string), impactStartTime.(string), status.(string), level.(string)).Inc()
-
-		if status.(string) == "Resolved" {
-			common.HealthClosedTotal.WithLabelValues(eventType.(string)).Inc()
-		} else {
-			common.HealthOpenedTotal.WithLabelValues(eventType.(string)).Inc()
+		return nil, err
+	}
+
+	affectsOwnResources := m.conf.Azure.HealthFilter != nil && m.conf.Azure.HealthFilter.AffectsOwnResources
+	records := make([]*common.HealthEventRecord, 0, len(events))
+	for _, event := range events {
+		if event.Properties == nil {
+			continue
 		}
-		impact := property.(map[string]interface{})["impact"]
-		for _, imp := range impact.([]interface{}) {
-			service := imp.(map[string]interface{})["impactedService"]
-			regions := make([]string, 0)
-			for _, region := range imp.(map[string]interface{})["impactedRegions"].([]interface{}) {
-				regions = append(regions, region.(map[string]interface{})["impactedRegion"].(string))
+		p := event.Properties
+		eventID := to.String(event.ID)
+		status := to.String(p.Status)
+		eventType := to.String(p.EventType)
+		record := &common.HealthEventRecord{
+			ID:              eventID,
+			Category:        eventType,
+			Closed:          status == "Resolved",
+			StartTime:       parseTime(to.String(p.ImpactStartTime)),
+			EndTime:         parseTime(to.String(p.ImpactMitigationTime)),
+			LastUpdatedTime: parseTime(to.String(p.LastUpdateTime)),
+			EventLabels: []string{eventID, to.String(p.Title), eventType, to.String(p.LastUpdateTime), to.String(p.ImpactStartTime),
+				status, to.String(p.Level)},
+			CounterLabels: []string{eventType},
+		}
+		if p.Impact != nil {
+			for _, impact := range *p.Impact {
+				service := to.String(impact.ImpactedService)
+				regions := make([]string, 0)
+				if impact.ImpactedRegions != nil {
+					for _, region := range *impact.ImpactedRegions {
+						regions = append(regions, to.String(region.ImpactedRegion))
+						// the display names of the regions are filtered like locations
+						record.Regions = append(record.Regions, normalizeLocation(to.String(region.ImpactedRegion)))
+					}
+				}
+				record.Services = append(record.Services, service)
+				record.Entities = append(record.Entities, []string{eventID, service, strings.Join(regions, ",")})
+			}
+		}
+		record.Service = strings.Join(record.Services, ",")
+		if affectsOwnResources {
+			resources, err := m.eventsClient.ListImpactedResources(ctx, to.String(event.Name))
+			if err != nil {
+				return nil, err
+			}
+			for _, resource := range resources {
+				if resource.Properties != nil {
+					record.Resources = append(record.Resources, to.String(resource.Properties.TargetResourceID))
+				}
 			}
-			common.AffectedEntity.WithLabelValues(eventID.(string), service.(string), strings.Join(regions, ",")).Inc()
 		}
+		records = append(records, record)
 	}
-	common.HealthEvent.Collect(ch)
-	common.AffectedEntity.Collect(ch)
-	common.HealthClosedTotal.Collect(ch)
-	common.HealthOpenedTotal.Collect(ch)
+	return records, nil
 }
 
-func getToken(tenantId, clientId, clientSecret string) (string, error) {
-	if ht, ok := http.DefaultTransport.(*http.Transport); ok {
-		ht.TLSClientConfig = &tls.Config{InsecureSkipVerify: true}
-	}
-	url := fmt.Sprintf("https://login.microsoftonline.com/%s/oauth2/v2.0/token", tenantId)
-	scope := "https://management.azure.com/.default"
-
-	postBody := []byte(fmt.Sprintf("client_id=%s&client_secret=%s&scope=%s&grant_type=client_credentials", clientId, clientSecret, scope))
-
-	body := bytes.NewBuffer(postBody)
-
-	resp, err := http.Post(url, "", body)
+// ResourceMatcher reports the resources that currently exist in the subscription as ours, the impacted resources
+// of an event are always in the subscription but may have been deleted since.
+func (m *MetricsCollectorAzureRmHealth) ResourceMatcher(ctx context.Context) (func(resource string) bool, error) {
+	ids, err := m.resourcesClient.ListResourceIDs(ctx)
 	if err != nil {
-		log.Error("An Error Occured", err)
-		return "", err
+		return nil, err
 	}
-	defer resp.Body.Close()
-	respbody, err := ioutil.ReadAll(resp.Body)
-	if err != nil {
-		log.Error("An Error Occurred", err)
-		return "", err
+	existing := make(map[string]struct{}, len(ids))
+	for _, id := range ids {
+		existing[strings.ToLower(id)] = struct{}{}
 	}
-	obj := make(map[string]interface{})
-	err = json.Unmarshal(respbody, &obj)
+	return func(resource string) bool {
+		_, ok := existing[strings.ToLower(resource)]
+		return ok
+	}, nil
+}
+
+func parseTime(value string) time.Time {
+	t, err := time.Parse(time.RFC3339Nano, value)
 	if err != nil {
-		log.Error("An Error Occurred", err)
-		return "", err
-	}
-	if val, ok := obj["access_token"]; ok && val != nil {
-		return val.(string), nil
+		return time.Time{}
 	}
-	return "", errors.New("can not get access_token")
+	return t
 }
@@ -0,0 +1,212 @@
+package azure
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/Azure/azure-sdk-for-go/profiles/latest/resources/mgmt/resources"
+	"github.com/Azure/azure-sdk-for-go/services/resourcehealth/mgmt/2020-05-01/resourcehealth"
+	"github.com/Azure/go-autorest/autorest"
+	"github.com/Azure/go-autorest/autorest/azure"
+	"github.com/Azure/go-autorest/autorest/to"
+)
+
+// The service health events are missing from the resourcehealth SDK, ResourceHealthClient covers them
+// with the same autorest plumbing the generated clients use.
+const resourceHealthAPIVersion = "2022-10-01"
+
+type HealthEventsClient interface {
+	ListEvents(ctx context.Context, queryStartTime string) ([]ServiceHealthEvent, error)
+	ListImpactedResources(ctx context.Context, eventTrackingID string) ([]ImpactedResource, error)
+	SetAuth(auth autorest.Authorizer)
+}
+
+type AvailabilityStatusesClient interface {
+	ListBySubscriptionIDComplete(ctx context.Context, filter string, expand string) (resourcehealth.AvailabilityStatusListResultIterator, error)
+	SetAuth(auth autorest.Authorizer)
+}
+
+// ResourcesClient lists the IDs of the resources that exist in the subscription
+type ResourcesClient interface {
+	ListResourceIDs(ctx context.Context) ([]string, error)
+	SetAuth(auth autorest.Authorizer)
+}
+
+type ServiceHealthEventList struct {
+	Value    *[]ServiceHealthEvent `json:"value,omitempty"`
+	NextLink *string               `json:"nextLink,omitempty"`
+}
+
+type ServiceHealthEvent struct {
+	ID         *string                       `json:"id,omitempty"`
+	Name       *string                       `json:"name,omitempty"`
+	Properties *ServiceHealthEventProperties `json:"properties,omitempty"`
+}
+
+type ServiceHealthEventProperties struct {
+	EventType            *string   `json:"eventType,omitempty"`
+	EventSource          *string   `json:"eventSource,omitempty"`
+	Status               *string   `json:"status,omitempty"`
+	Title                *string   `json:"title,omitempty"`
+	Level                *string   `json:"level,omitempty"`
+	ImpactStartTime      *string   `json:"impactStartTime,omitempty"`
+	ImpactMitigationTime *string   `json:"impactMitigationTime,omitempty"`
+	LastUpdateTime       *string   `json:"lastUpdateTime,omitempty"`
+	Impact               *[]Impact `json:"impact,omitempty"`
+}
+
+type Impact struct {
+	ImpactedService *string           `json:"impactedService,omitempty"`
+	ImpactedRegions *[]ImpactedRegion `json:"impactedRegions,omitempty"`
+}
+
+type ImpactedRegion struct {
+	ImpactedRegion *string `json:"impactedRegion,omitempty"`
+	Status         *string `json:"status,omitempty"`
+}
+
+type ImpactedResourceList struct {
+	Value    *[]ImpactedResource `json:"value,omitempty"`
+	NextLink *string             `json:"nextLink,omitempty"`
+}
+
+type ImpactedResource struct {
+	ID         *string `json:"id,omitempty"`
+	Properties *struct {
+		TargetResourceType *string `json:"targetResourceType,omitempty"`
+		TargetResourceID   *string `json:"targetResourceId,omitempty"`
+		TargetRegion       *string `json:"targetRegion,omitempty"`
+	} `json:"properties,omitempty"`
+}
+
+type ResourceHealthClient struct {
+	autorest.Client
+	BaseURI        string
+	SubscriptionID string
+}
+
+func NewResourceHealthClient(baseURI, subscriptionID string) *ResourceHealthClient {
+	return &ResourceHealthClient{
+		Client:         autorest.NewClientWithUserAgent(resourcehealth.UserAgent()),
+		BaseURI:        baseURI,
+		SubscriptionID: subscriptionID,
+	}
+}
+
+func (c *ResourceHealthClient) SetAuth(auth autorest.Authorizer) {
+	c.Authorizer = auth
+}
+
+// ListEvents returns the service health events of the subscription, updated since queryStartTime (MM/DD/YYYY) if set.
+func (c *ResourceHealthClient) ListEvents(ctx context.Context, queryStartTime string) ([]ServiceHealthEvent, error) {
+	queryParameters := map[string]interface{}{"api-version": resourceHealthAPIVersion}
+	if queryStartTime != "" {
+		queryParameters["queryStartTime"] = autorest.Encode("query", queryStartTime)
+	}
+	req, err := autorest.CreatePreparer(
+		autorest.AsGet(),
+		autorest.WithBaseURL(c.BaseURI),
+		autorest.WithPathParameters("/subscriptions/{subscriptionId}/providers/Microsoft.ResourceHealth/events",
+			map[string]interface{}{"subscriptionId": autorest.Encode("path", c.SubscriptionID)}),
+		autorest.WithQueryParameters(queryParameters)).Prepare((&http.Request{}).WithContext(ctx))
+	if err != nil {
+		return nil, autorest.NewErrorWithError(err, "azure.ResourceHealthClient", "ListEvents", nil, "Failure preparing request")
+	}
+
+	var events []ServiceHealthEvent
+	err = c.list(ctx, req, "ListEvents", func(resp *http.Response) (*string, error) {
+		page := ServiceHealthEventList{}
+		err := autorest.Respond(resp, azure.WithErrorUnlessStatusCode(http.StatusOK), autorest.ByUnmarshallingJSON(&page), autorest.ByClosing())
+		if page.Value != nil {
+			events = append(events, *page.Value...)
+		}
+		return page.NextLink, err
+	})
+	return events, err
+}
+
+// ListImpactedResources returns the resources of the subscription affected by an event.
+func (c *ResourceHealthClient) ListImpactedResources(ctx context.Context, eventTrackingID string) ([]ImpactedResource, error) {
+	req, err := autorest.CreatePreparer(
+		autorest.AsGet(),
+		autorest.WithBaseURL(c.BaseURI),
+		autorest.WithPathParameters("/subscriptions/{subscriptionId}/providers/Microsoft.ResourceHealth/events/{eventTrackingId}/impactedResources",
+			map[string]interface{}{
+				"subscriptionId":  autorest.Encode("path", c.SubscriptionID),
+				"eventTrackingId": autorest.Encode("path", eventTrackingID),
+			}),
+		autorest.WithQueryParameters(map[string]interface{}{"api-version": resourceHealthAPIVersion})).Prepare((&http.Request{}).WithContext(ctx))
+	if err != nil {
+		return nil, autorest.NewErrorWithError(err, "azure.ResourceHealthClient", "ListImpactedResources", nil, "Failure preparing request")
+	}
+
+	var resources []ImpactedResource
+	err = c.list(ctx, req, "ListImpactedResources", func(resp *http.Response) (*string, error) {
+		page := ImpactedResourceList{}
+		err := autorest.Respond(resp, azure.WithErrorUnlessStatusCode(http.StatusOK), autorest.ByUnmarshallingJSON(&page), autorest.ByClosing())
+		if page.Value != nil {
+			resources = append(resources, *page.Value...)
+		}
+		return page.NextLink, err
+	})
+	return resources, err
+}
+
+// list sends req and follows the nextLink returned by respond until the last page.
+func (c *ResourceHealthClient) list(ctx context.Context, req *http.Request, method string, respond func(*http.Response) (*string, error)) error {
+	for {
+		resp, err := c.Send(req, azure.DoRetryWithRegistration(c.Client))
+		if err != nil {
+			return autorest.NewErrorWithError(err, "azure.ResourceHealthClient", method, resp, "Failure sending request")
+		}
+		nextLink, err := respond(resp)
+		if err != nil {
+			return autorest.NewErrorWithError(err, "azure.ResourceHealthClient", method, resp, "Failure responding to request")
+		}
+		if to.String(nextLink) == "" {
+			return nil
+		}
+		req, err = autorest.Prepare((&http.Request{}).WithContext(ctx), autorest.AsJSON(), autorest.AsGet(), autorest.WithBaseURL(to.String(nextLink)))
+		if err != nil {
+			return autorest.NewErrorWithError(err, "azure.ResourceHealthClient", method, nil, "Failure preparing next results request")
+		}
+	}
+}
+
+type AvailabilityStatusesClientWrapper struct {
+	client resourcehealth.AvailabilityStatusesClient
+}
+
+func (cw *AvailabilityStatusesClientWrapper) ListBySubscriptionIDComplete(ctx context.Context, filter string, expand string) (resourcehealth.AvailabilityStatusListResultIterator, error) {
+	return cw.client.ListBySubscriptionIDComplete(ctx, filter, expand)
+}
+
+func (cw *AvailabilityStatusesClientWrapper) SetAuth(auth autorest.Authorizer) {
+	cw.client.Authorizer = auth
+}
+
+func NewAvailabilityStatusesClientWrapper(baseURI, id string) *AvailabilityStatusesClientWrapper {
+	cw := &AvailabilityStatusesClientWrapper{client: resourcehealth.NewAvailabilityStatusesClientWithBaseURI(baseURI, id)}
+	return cw
+}
+
+type ResourcesClientWrapper struct {
+	client resources.Client
+}
+
+func (cw *ResourcesClientWrapper) ListResourceIDs(ctx context.Context) ([]string, error) {
+	var ids []string
+	it, err := cw.client.ListComplete(ctx, "", "", nil)
+	for ; err == nil && it.NotDone(); err = it.NextWithContext(ctx) {
+		ids = append(ids, to.String(it.Value().ID))
+	}
+	return ids, err
+}
+
+func (cw *ResourcesClientWrapper) SetAuth(auth autorest.Authorizer) {
+	cw.client.Authorizer = auth
+}
+
+func NewResourcesClientWrapper(baseURI, id string) *ResourcesClientWrapper {
+	return &ResourcesClientWrapper{client: resources.NewClientWithBaseURI(baseURI, id)}
+}
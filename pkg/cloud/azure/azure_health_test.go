@@ -1,6 +1,7 @@
 package azure
 
 import (
+	"context"
 	"github.com/jarcoal/httpmock"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
@@ -9,10 +10,49 @@ import (
 	"github.wdf.sap.corp/DBaaS/cloud-provider-exporter/pkg/config"
 	"github.wdf.sap.corp/DBaaS/cloud-provider-exporter/pkg/vault"
 	"net/http"
+	"strings"
 	"testing"
 )
 
-var events = "{\n    \"value\": [\n      {\n        \"id\": \"dummyID\",\n        \"name\": \"JVJC-V88\",\n        \"type\": \"Microsoft.ResourceHealth/events\",\n        \"properties\": {\n          \"eventType\": \"ServiceIssue\",\n          \"eventSource\": \"ServiceHealth\",\n          \"status\": \"Resolved\",\n          \"title\": \"dummyTitle\",\n          \"summary\": \"dummySummary\",\n          \"description\": \"description\",\n          \"platformInitiated\": true,\n          \"header\": \"Your service might have been impacted by an Azure service issue\",\n          \"level\": \"Warning\",\n          \"eventLevel\": \"Informational\",\n          \"impactStartTime\": \"2022-09-23T07:17:01.197Z\",\n          \"impactMitigationTime\": \"2022-09-23T11:00:37Z\",\n          \"impact\": [\n            {\n              \"impactedService\": \"Azure Active Directory\",\n              \"impactedRegions\": [\n                {\n                  \"impactedRegion\": \"Global\",\n                  \"status\": \"Resolved\",\n                  \"impactedSubscriptions\": [\n                    \"a68ae472-1849-4ed9-a700-24f5070acd2d\"\n                  ],\n                  \"impactedTenants\": [],\n                  \"lastUpdateTime\": \"2022-10-06T23:34:40.7648539Z\",\n                  \"updates\": [\n                    {\n                      \"summary\": \"summary\",\n                      \"updateDateTime\": \"2022-10-06T23:34:40.7648539Z\"\n                    }\n                  ]\n                }\n              ]\n            }\n          ],\n          \"isHIR\": false,\n          \"priority\": 19,\n          \"lastUpdateTime\": \"2022-10-06T23:34:40.7648539Z\"\n        }\n      }\n    ]\n  }"
+var events = "{\n    \"value\": [\n      {\n        \"id\": \"dummyID\",\n        \"name\": \"JVJC-V88\",\n        \"type\": \"Microsoft.ResourceHealth/events\",\n        \"properties\": {\n          \"eventType\": \"ServiceIssue\",\n          \"eventSource\": \"ServiceHealth\",\n          \"status\": \"Resolved\",\n          \"title\": \"dummyTitle\",\n          \"summary\": \"dummySummary\",\n          \"description\": \"description\",\n          \"platformInitiated\": true,\n          \"header\": \"Your service might have been impacted by an Azure service issue\",\n          \"level\": \"Warning\",\n          \"eventLevel\": \"Informational\",\n          \"impactStartTime\": \"2022-09-23T07:17:01.197Z\",\n          \"impactMitigationTime\": \"2022-09-23T11:00:37Z\",\n          \"impact\": [\n            {\n              \"impactedService\": \"Azure Active Directory\",\n              \"impactedRegions\": [\n                {\n                  \"impactedRegion\": \"Global\",\n                  \"status\": \"Resolved\",\n                  \"impactedSubscriptions\": [\n                    \"a68ae472-1849-4ed9-a700-24f5070acd2d\"\n                  ],\n                  \"impactedTenants\": [],\n                  \"lastUpdateTime\": \"2022-10-06T23:34:40.7648539Z\",\n                  \"updates\": [\n                    {\n                      \"summary\": \"summary\",\n                      \"updateDateTime\": \"2022-10-06T23:34:40.7648539Z\"\n                    }\n                  ]\n                }\n              ]\n            }\n          ],\n          \"isHIR\": false,\n          \"priority\": 19,\n          \"lastUpdateTime\": \"2022-10-06T23:34:40.7648539Z\"\n        }\n      }\n    ],\n    \"nextLink\": \"https://management.azure.com/subscriptions/a68ae472-1849-4ed9-a700-24f5070acd2d/providers/Microsoft.ResourceHealth/events?api-version=2022-10-01&$skiptoken=1\"\n  }"
+
+var eventsNextPage = `{
+  "value": [
+    {
+      "id": "/subscriptions/a68ae472-1849-4ed9-a700-24f5070acd2d/providers/Microsoft.ResourceHealth/events/KTL8-1R0",
+      "name": "KTL8-1R0",
+      "type": "Microsoft.ResourceHealth/events",
+      "properties": {
+        "eventType": "PlannedMaintenance",
+        "status": "Active",
+        "title": "dummyMaintenance",
+        "level": null,
+        "impactStartTime": "2022-10-10T02:00:00Z",
+        "impactMitigationTime": null,
+        "lastUpdateTime": "2022-10-07T08:00:00Z",
+        "impact": [{"impactedService": "Virtual Machines", "impactedRegions": null}]
+      }
+    }
+  ]
+}`
+
+var availabilityStatuses = `{
+  "value": [
+    {
+      "id": "/subscriptions/a68ae472-1849-4ed9-a700-24f5070acd2d/resourceGroups/dummyGroup/providers/Microsoft.Compute/virtualMachines/dummyVM/providers/Microsoft.ResourceHealth/availabilityStatuses/current",
+      "name": "current",
+      "type": "Microsoft.ResourceHealth/AvailabilityStatuses",
+      "location": "westeurope",
+      "properties": {
+        "availabilityState": "Unavailable",
+        "summary": "We're sorry, your virtual machine isn't available because an unexpected failure on the host server",
+        "reasonType": "Unplanned",
+        "occurredTime": "2022-10-07T08:00:00Z",
+        "reportedTime": "2022-10-07T08:05:00Z"
+      }
+    }
+  ]
+}`
 
 func TestAzureHealth(t *testing.T) {
 	uri := "/metrics"
@@ -32,14 +72,23 @@ func TestAzureHealth(t *testing.T) {
 	httpmock.Activate()
 	defer httpmock.DeactivateAndReset()
 
-	responderGetToken := httpmock.NewStringResponder(http.StatusOK, `{"access_token": "dummyToken"}`)
-	httpmock.RegisterResponder("POST", "https://login.microsoftonline.com/tenantID/oauth2/v2.0/token", responderGetToken)
-
 	responderGetMetrics := httpmock.NewStringResponder(http.StatusOK, events)
-	httpmock.RegisterResponder("GET", "https://management.azure.com/subscriptions/a68ae472-1849-4ed9-a700-24f5070acd2d/providers/Microsoft.ResourceHealth/events?api-version=2018-07-01", responderGetMetrics)
+	httpmock.RegisterResponder("GET", "https://management.azure.com/subscriptions/a68ae472-1849-4ed9-a700-24f5070acd2d/providers/Microsoft.ResourceHealth/events?api-version=2022-10-01", responderGetMetrics)
+	responderGetNextPage := httpmock.NewStringResponder(http.StatusOK, eventsNextPage)
+	httpmock.RegisterResponder("GET", "https://management.azure.com/subscriptions/a68ae472-1849-4ed9-a700-24f5070acd2d/providers/Microsoft.ResourceHealth/events?%24skiptoken=1&api-version=2022-10-01", responderGetNextPage)
+	responderGetStatuses := httpmock.NewStringResponder(http.StatusOK, availabilityStatuses)
+	httpmock.RegisterResponder("GET", "https://management.azure.com/subscriptions/a68ae472-1849-4ed9-a700-24f5070acd2d/providers/Microsoft.ResourceHealth/availabilityStatuses?api-version=2020-05-01", responderGetStatuses)
 
 	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		healthCollector := NewMetricsCollectorAzureRmHealth(conf, cred, &log.Logger{})
+		eventsClient := NewResourceHealthClient(resourceManagerURI(), conf.Azure.SubscriptionID)
+		eventsClient.Sender = http.DefaultClient
+		healthCollector.eventsClient = eventsClient
+		availabilityClient := NewAvailabilityStatusesClientWrapper(resourceManagerURI(), conf.Azure.SubscriptionID)
+		availabilityClient.client.Sender = http.DefaultClient
+		healthCollector.availabilityClient = availabilityClient
+		assert.NoError(t, healthCollector.poller.Poll(context.TODO()))
+		assert.NoError(t, healthCollector.pollAvailability(context.TODO()))
 		registry := prometheus.NewRegistry()
 		registry.MustRegister(healthCollector)
 		h := promhttp.HandlerFor(registry, promhttp.HandlerOpts{})
@@ -48,4 +97,43 @@ func TestAzureHealth(t *testing.T) {
 
 	assert.HTTPBodyContains(t, handler, "GET", uri, nil, "cpe_health_events{eventID=\"dummyID\",eventType=\"ServiceIssue\",lastUpdatedTime=\"2022-10-06T23:34:40.7648539Z\",level=\"Warning\",startTime=\"2022-09-23T07:17:01.197Z\",status=\"Resolved\",title=\"dummyTitle\"} 1")
 	assert.HTTPBodyContains(t, handler, "GET", uri, nil, "cpe_health_events_affected{affectedRegions=\"Global\",affectedService=\"Azure Active Directory\",eventID=\"dummyID\"} 1")
+	assert.HTTPBodyContains(t, handler, "GET", uri, nil, "cpe_health_events{eventID=\"/subscriptions/a68ae472-1849-4ed9-a700-24f5070acd2d/providers/Microsoft.ResourceHealth/events/KTL8-1R0\",eventType=\"PlannedMaintenance\",lastUpdatedTime=\"2022-10-07T08:00:00Z\",level=\"\",startTime=\"2022-10-10T02:00:00Z\",status=\"Active\",title=\"dummyMaintenance\"} 1")
+	assert.HTTPBodyContains(t, handler, "GET", uri, nil, "cpe_health_resource_availability{availabilityState=\"Unavailable\",location=\"westeurope\",reasonType=\"Unplanned\",resourceGroup=\"dummyGroup\",resourceID=\"/subscriptions/a68ae472-1849-4ed9-a700-24f5070acd2d/resourceGroups/dummyGroup/providers/Microsoft.Compute/virtualMachines/dummyVM\",resourceType=\"Microsoft.Compute/virtualMachines\"} 1")
+}
+
+func TestAzureHealthOwnResources(t *testing.T) {
+	conf := &config.Config{
+		Region: "dummy_region",
+		Azure: &config.AzureConfig{
+			SubscriptionID: "a68ae472-1849-4ed9-a700-24f5070acd2d",
+			HealthFilter:   &config.HealthFilterConfig{AffectsOwnResources: true},
+		},
+	}
+	subscription := "https://management.azure.com/subscriptions/a68ae472-1849-4ed9-a700-24f5070acd2d"
+	vm := "/subscriptions/a68ae472-1849-4ed9-a700-24f5070acd2d/resourceGroups/dummyGroup/providers/Microsoft.Compute/virtualMachines/dummyVM"
+	deleted := "/subscriptions/a68ae472-1849-4ed9-a700-24f5070acd2d/resourceGroups/dummyGroup/providers/Microsoft.Compute/virtualMachines/deletedVM"
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+	httpmock.RegisterResponder("GET", subscription+"/providers/Microsoft.ResourceHealth/events?api-version=2022-10-01", httpmock.NewStringResponder(http.StatusOK, events))
+	httpmock.RegisterResponder("GET", subscription+"/providers/Microsoft.ResourceHealth/events?%24skiptoken=1&api-version=2022-10-01", httpmock.NewStringResponder(http.StatusOK, eventsNextPage))
+	httpmock.RegisterResponder("GET", subscription+"/providers/Microsoft.ResourceHealth/events/JVJC-V88/impactedResources?api-version=2022-10-01",
+		httpmock.NewStringResponder(http.StatusOK, `{"value": [{"properties": {"targetResourceId": "`+strings.ToUpper(vm)+`"}}]}`))
+	httpmock.RegisterResponder("GET", subscription+"/providers/Microsoft.ResourceHealth/events/KTL8-1R0/impactedResources?api-version=2022-10-01",
+		httpmock.NewStringResponder(http.StatusOK, `{"value": [{"properties": {"targetResourceId": "`+deleted+`"}}]}`))
+	httpmock.RegisterResponder("GET", subscription+"/resources?api-version=2020-10-01",
+		httpmock.NewStringResponder(http.StatusOK, `{"value": [{"id": "`+vm+`"}]}`))
+
+	healthCollector := NewMetricsCollectorAzureRmHealth(conf, vault.CloudCredentials{}, &log.Logger{})
+	eventsClient := NewResourceHealthClient(resourceManagerURI(), conf.Azure.SubscriptionID)
+	eventsClient.Sender = http.DefaultClient
+	healthCollector.eventsClient = eventsClient
+	resourcesClient := NewResourcesClientWrapper(resourceManagerURI(), conf.Azure.SubscriptionID)
+	resourcesClient.client.Sender = http.DefaultClient
+	healthCollector.resourcesClient = resourcesClient
+	assert.NoError(t, healthCollector.poller.Poll(context.TODO()))
+
+	// the impacted resource of the maintenance does not exist anymore
+	events := healthCollector.poller.Events()
+	assert.Len(t, events, 1)
+	assert.Equal(t, "dummyID", events[0].ID)
 }
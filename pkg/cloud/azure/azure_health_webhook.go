@@ -0,0 +1,160 @@
+package azure
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.wdf.sap.corp/DBaaS/cloud-provider-exporter/pkg/cloud/common"
+)
+
+const serviceHealthSource = "ServiceHealth"
+
+// actionGroupAlert is an activity log alert posted by an action group, in the common alert
+// schema (data.essentials, data.alertContext) or the legacy one (data.context.activityLog).
+type actionGroupAlert struct {
+	SchemaID string `json:"schemaId"`
+	Data     struct {
+		Essentials struct {
+			MonitoringService string `json:"monitoringService"`
+		} `json:"essentials"`
+		AlertContext activityLogEvent `json:"alertContext"`
+		Context      struct {
+			ActivityLog activityLogEvent `json:"activityLog"`
+		} `json:"context"`
+	} `json:"data"`
+}
+
+type activityLogEvent struct {
+	EventSource    string                  `json:"eventSource"`
+	EventTimestamp string                  `json:"eventTimestamp"`
+	Level          string                  `json:"level"`
+	SubscriptionID string                  `json:"subscriptionId"`
+	Properties     serviceHealthProperties `json:"properties"`
+}
+
+type serviceHealthProperties struct {
+	Title                string `json:"title"`
+	IncidentType         string `json:"incidentType"`
+	TrackingID           string `json:"trackingId"`
+	ImpactStartTime      string `json:"impactStartTime"`
+	ImpactMitigationTime string `json:"impactMitigationTime"`
+	Stage                string `json:"stage"`
+	// ImpactedServices is a JSON encoded list of impactedService
+	ImpactedServices string `json:"impactedServices"`
+}
+
+type impactedService struct {
+	ServiceName     string `json:"ServiceName"`
+	ImpactedRegions []struct {
+		RegionName string `json:"RegionName"`
+	} `json:"ImpactedRegions"`
+}
+
+// incidentTypes maps the incident types of service health alerts to the event types of the Resource Health API.
+var incidentTypes = map[string]string{
+	"Incident":       "ServiceIssue",
+	"Maintenance":    "PlannedMaintenance",
+	"Informational":  "HealthAdvisory",
+	"ActionRequired": "HealthAdvisory",
+	"Security":       "SecurityAdvisory",
+}
+
+// WebhookHandler receives the Service Health alerts of an action group and merges them into the
+// collected events. Action groups cannot sign requests, so a webhook token is required.
+func (m *MetricsCollectorAzureRmHealth) WebhookHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conf := common.WebhookConfig(m.conf)
+		if conf == nil || conf.Token == "" {
+			m.log.Errorf("Service health alert rejected, no webhook token configured")
+			common.WebhookDone(w, "actiongroup", common.WebhookRejected, http.StatusUnauthorized)
+			return
+		}
+		body, ok := common.ReadWebhookRequest(w, r, conf, "actiongroup")
+		if !ok {
+			return
+		}
+		alert := &actionGroupAlert{}
+		if err := json.Unmarshal(body, alert); err != nil {
+			m.log.Errorf("Invalid action group payload: %v", err)
+			common.WebhookDone(w, "actiongroup", common.WebhookInvalid, http.StatusBadRequest)
+			return
+		}
+		event := alert.Data.AlertContext
+		source := alert.Data.Essentials.MonitoringService
+		if alert.Data.Context.ActivityLog.Properties.TrackingID != "" {
+			event = alert.Data.Context.ActivityLog
+			source = event.EventSource
+		}
+		if source != serviceHealthSource {
+			// acknowledge other alerts of the action group so they are not retried
+			common.WebhookDone(w, "actiongroup", common.WebhookIgnored, http.StatusOK)
+			return
+		}
+		record, err := m.alertRecord(event)
+		if err != nil {
+			m.log.Errorf("Invalid service health alert: %v", err)
+			common.WebhookDone(w, "actiongroup", common.WebhookInvalid, http.StatusBadRequest)
+			return
+		}
+		m.poller.Merge([]*common.HealthEventRecord{record})
+		m.log.Infof("Service health event %s received through action group", record.ID)
+		common.WebhookDone(w, "actiongroup", common.WebhookAccepted, http.StatusOK)
+	})
+}
+
+// alertRecord converts a service health alert to the record FetchEvents builds for the same tracking ID.
+func (m *MetricsCollectorAzureRmHealth) alertRecord(event activityLogEvent) (*common.HealthEventRecord, error) {
+	p := event.Properties
+	if p.TrackingID == "" {
+		return nil, fmt.Errorf("alert without trackingId")
+	}
+	subscriptionID := event.SubscriptionID
+	if subscriptionID == "" {
+		subscriptionID = m.conf.Azure.SubscriptionID
+	}
+	eventID := fmt.Sprintf("/subscriptions/%s/providers/Microsoft.ResourceHealth/events/%s", subscriptionID, p.TrackingID)
+	eventType, ok := incidentTypes[p.IncidentType]
+	if !ok {
+		eventType = p.IncidentType
+	}
+	status := "Active"
+	if p.Stage == "Resolved" || p.Stage == "RCA" || p.Stage == "Complete" {
+		status = "Resolved"
+	}
+
+	var services []impactedService
+	if p.ImpactedServices != "" {
+		if err := json.Unmarshal([]byte(p.ImpactedServices), &services); err != nil {
+			return nil, fmt.Errorf("invalid impactedServices: %w", err)
+		}
+	}
+	// the alert carries no update time of the event, the time it fired is later than the lastUpdateTime of the
+	// Resource Health API and would keep the polled record of the same update from replacing the pushed one
+	lastUpdated := p.ImpactStartTime
+	if parseTime(p.ImpactMitigationTime).After(parseTime(lastUpdated)) {
+		lastUpdated = p.ImpactMitigationTime
+	}
+	record := &common.HealthEventRecord{
+		ID:              eventID,
+		Category:        eventType,
+		Closed:          status == "Resolved",
+		StartTime:       parseTime(p.ImpactStartTime),
+		EndTime:         parseTime(p.ImpactMitigationTime),
+		LastUpdatedTime: parseTime(lastUpdated),
+		EventLabels:     []string{eventID, p.Title, eventType, lastUpdated, p.ImpactStartTime, status, event.Level},
+		CounterLabels:   []string{eventType},
+	}
+	for _, s := range services {
+		regions := make([]string, 0, len(s.ImpactedRegions))
+		for _, region := range s.ImpactedRegions {
+			regions = append(regions, region.RegionName)
+			record.Regions = append(record.Regions, normalizeLocation(region.RegionName))
+		}
+		record.Services = append(record.Services, s.ServiceName)
+		record.Entities = append(record.Entities, []string{eventID, s.ServiceName, strings.Join(regions, ",")})
+	}
+	record.Service = strings.Join(record.Services, ",")
+	return record, nil
+}
@@ -0,0 +1,104 @@
+package azure
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	log "github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.wdf.sap.corp/DBaaS/cloud-provider-exporter/pkg/cloud/common"
+	"github.wdf.sap.corp/DBaaS/cloud-provider-exporter/pkg/config"
+	"github.wdf.sap.corp/DBaaS/cloud-provider-exporter/pkg/vault"
+)
+
+var serviceHealthAlert = `{
+  "schemaId": "azureMonitorCommonAlertSchema",
+  "data": {
+    "essentials": {
+      "alertId": "/subscriptions/a68ae472-1849-4ed9-a700-24f5070acd2d/providers/Microsoft.AlertsManagement/alerts/b9569717-bc32-442f-add5-83a997729330",
+      "alertRule": "service-health",
+      "severity": "Sev4",
+      "signalType": "Activity Log",
+      "monitorCondition": "Fired",
+      "monitoringService": "ServiceHealth",
+      "alertTargetIDs": ["/subscriptions/a68ae472-1849-4ed9-a700-24f5070acd2d"],
+      "firedDateTime": "2022-09-23T07:30:11.9375474Z"
+    },
+    "alertContext": {
+      "channels": "Admin",
+      "correlationId": "a1be61fd-37ur-ba05-b827-cb874708babf",
+      "eventSource": "ServiceHealth",
+      "eventTimestamp": "2022-09-23T07:29:43.5475103+00:00",
+      "level": "Warning",
+      "operationName": "Microsoft.ServiceHealth/incident/action",
+      "subscriptionId": "a68ae472-1849-4ed9-a700-24f5070acd2d",
+      "properties": {
+        "title": "dummyTitle",
+        "service": "Virtual Machines",
+        "region": "West Europe",
+        "communication": "dummyCommunication",
+        "incidentType": "Incident",
+        "trackingId": "JVJC-V88",
+        "impactStartTime": "2022-09-23T07:17:01.197Z",
+        "impactedServices": "[{\"ImpactedRegions\":[{\"RegionName\":\"West Europe\"},{\"RegionName\":\"North Europe\"}],\"ServiceName\":\"Virtual Machines\"}]",
+        "defaultLanguageTitle": "dummyTitle",
+        "stage": "Active",
+        "communicationId": "11000001466525",
+        "version": "0.1.1"
+      },
+      "status": "Active"
+    }
+  }
+}`
+
+func TestAzureHealthWebhook(t *testing.T) {
+	cred := vault.CloudCredentials{
+		vault.AzureClientSecret: "clientSecret",
+		vault.AzureClientID:     "clientID",
+		vault.AzureTenantID:     "tenantID",
+	}
+	conf := &config.Config{
+		Azure: &config.AzureConfig{
+			SubscriptionID: "a68ae472-1849-4ed9-a700-24f5070acd2d",
+			// the display names of the impacted regions match the location names
+			HealthFilter: &config.HealthFilterConfig{Regions: []string{"westeurope"}},
+		},
+		Health: &config.HealthConfig{Webhook: &config.HealthWebhookConfig{Token: "secret"}},
+	}
+	healthCollector := NewMetricsCollectorAzureRmHealth(conf, cred, &log.Logger{})
+	post := func(uri, body string) int {
+		recorder := httptest.NewRecorder()
+		healthCollector.WebhookHandler().ServeHTTP(recorder, httptest.NewRequest(http.MethodPost, uri, strings.NewReader(body)))
+		return recorder.Code
+	}
+
+	assert.Equal(t, http.StatusUnauthorized, post("/webhook/azure/health", serviceHealthAlert))
+	assert.Equal(t, http.StatusOK, post("/webhook/azure/health?token=secret", serviceHealthAlert))
+	resolved := strings.Replace(serviceHealthAlert, `"stage": "Active"`, `"stage": "Resolved"`, 1)
+	resolved = strings.Replace(resolved, "07:29:43.5475103", "11:05:12.0000000", 1)
+	resolved = strings.Replace(resolved, `"impactStartTime"`, `"impactMitigationTime": "2022-09-23T11:00:37Z", "impactStartTime"`, 1)
+	assert.Equal(t, http.StatusOK, post("/webhook/azure/health?token=secret", resolved))
+	// the resolution is dated by its mitigation instead of the alert, a polled update from before the alert fired
+	// replaces it
+	events := healthCollector.poller.Events()
+	assert.Len(t, events, 1)
+	assert.Equal(t, time.Date(2022, time.September, 23, 11, 0, 37, 0, time.UTC), events[0].LastUpdatedTime)
+	polled := *events[0]
+	polled.LastUpdatedTime = time.Date(2022, time.September, 23, 11, 2, 0, 0, time.UTC)
+	healthCollector.poller.Merge([]*common.HealthEventRecord{&polled})
+	assert.Equal(t, polled.LastUpdatedTime, healthCollector.poller.Events()[0].LastUpdatedTime)
+
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(healthCollector)
+	handler := promhttp.HandlerFor(registry, promhttp.HandlerOpts{})
+	assert.HTTPBodyContains(t, handler.ServeHTTP, "GET", "/metrics", nil, "cpe_health_events{eventID=\"/subscriptions/a68ae472-1849-4ed9-a700-24f5070acd2d/providers/Microsoft.ResourceHealth/events/JVJC-V88\",eventType=\"ServiceIssue\",lastUpdatedTime=\"2022-09-23T11:00:37Z\",level=\"Warning\",startTime=\"2022-09-23T07:17:01.197Z\",status=\"Resolved\",title=\"dummyTitle\"} 1")
+	assert.HTTPBodyContains(t, handler.ServeHTTP, "GET", "/metrics", nil, "cpe_health_events_affected{affectedRegions=\"West Europe,North Europe\",affectedService=\"Virtual Machines\",eventID=\"/subscriptions/a68ae472-1849-4ed9-a700-24f5070acd2d/providers/Microsoft.ResourceHealth/events/JVJC-V88\"} 1")
+	assert.HTTPBodyContains(t, handler.ServeHTTP, "GET", "/metrics", nil, "cpe_health_events_opened_total{eventType=\"ServiceIssue\"} 1")
+	assert.HTTPBodyContains(t, handler.ServeHTTP, "GET", "/metrics", nil, "cpe_health_events_closed_total{eventType=\"ServiceIssue\"} 1")
+	assert.HTTPBodyContains(t, handler.ServeHTTP, "GET", "/metrics", nil, "cpe_health_webhook_requests_total{result=\"rejected\",source=\"actiongroup\"} 1")
+}
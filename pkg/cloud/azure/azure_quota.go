@@ -7,7 +7,6 @@ import (
 	"github.com/Azure/azure-sdk-for-go/profiles/latest/resources/mgmt/subscriptions"
 	"github.com/Azure/azure-sdk-for-go/profiles/latest/storage/mgmt/storage"
 	"github.com/Azure/go-autorest/autorest"
-	"github.com/Azure/go-autorest/autorest/azure/auth"
 	"github.com/Azure/go-autorest/autorest/to"
 	"github.com/patrickmn/go-cache"
 	"github.com/prometheus/client_golang/prometheus"
@@ -16,7 +15,10 @@ import (
 	"github.wdf.sap.corp/DBaaS/cloud-provider-exporter/pkg/config"
 	"github.wdf.sap.corp/DBaaS/cloud-provider-exporter/pkg/constant"
 	"github.wdf.sap.corp/DBaaS/cloud-provider-exporter/pkg/vault"
+	"regexp"
+	"strings"
 	"sync"
+	"time"
 )
 
 type StorageClient interface {
@@ -36,9 +38,9 @@ type ComputeClient interface {
 
 // To add authorizer into UsageClient, we have to encapsulate storage.UsagesClient, network.UsagesClient, and compute.UsageClient
 
-var (
-	subscriptionID   string
-	subscriptionName string
+const (
+	subscriptionRefreshInterval = time.Hour
+	maxConcurrentUsageLists     = 8
 )
 
 type StorageClientWrapper struct {
@@ -53,8 +55,8 @@ func (cw *StorageClientWrapper) SetAuth(auth autorest.Authorizer) {
 	cw.client.Authorizer = auth
 }
 
-func NewStorageClientWrapper(id string) *StorageClientWrapper {
-	cw := &StorageClientWrapper{client: storage.NewUsagesClient(id)}
+func NewStorageClientWrapper(baseURI, id string) *StorageClientWrapper {
+	cw := &StorageClientWrapper{client: storage.NewUsagesClientWithBaseURI(baseURI, id)}
 	return cw
 }
 
@@ -70,8 +72,8 @@ func (cw *NetworkClientWrapper) SetAuth(auth autorest.Authorizer) {
 	cw.client.Authorizer = auth
 }
 
-func NewNetworkClientWrapper(id string) *NetworkClientWrapper {
-	cw := &NetworkClientWrapper{client: network.NewUsagesClient(id)}
+func NewNetworkClientWrapper(baseURI, id string) *NetworkClientWrapper {
+	cw := &NetworkClientWrapper{client: network.NewUsagesClientWithBaseURI(baseURI, id)}
 	return cw
 }
 
@@ -87,173 +89,275 @@ func (cw *ComputeClientWrapper) SetAuth(auth autorest.Authorizer) {
 	cw.client.Authorizer = auth
 }
 
-func NewComputeClientWrapper(id string) *ComputeClientWrapper {
-	cw := &ComputeClientWrapper{client: compute.NewUsageClient(id)}
+func NewComputeClientWrapper(baseURI, id string) *ComputeClientWrapper {
+	cw := &ComputeClientWrapper{client: compute.NewUsageClientWithBaseURI(baseURI, id)}
 	return cw
 }
 
-type SubscriptionInfo interface {
-	GetSubscriptionInfo(logger log.FieldLogger) (subscriptionID, subscriptionName string)
+type SubscriptionsClient interface {
+	ListComplete(ctx context.Context) (result subscriptions.ListResultIterator, err error)
+	SetAuth(auth autorest.Authorizer)
 }
 
-type SubscriptionInfoWrapper struct {
-	config *config.Config
-	cred   vault.CloudCredentials
+type SubscriptionsClientWrapper struct {
+	client subscriptions.Client
 }
 
-func (s *SubscriptionInfoWrapper) GetSubscriptionInfo(logger log.FieldLogger) (subscriptionID, subscriptionName string) {
-	clientCredentialConfig := auth.NewClientCredentialsConfig(s.cred[vault.AzureClientID], s.cred[vault.AzureClientSecret], s.cred[vault.AzureTenantID])
-	authorizer, err := clientCredentialConfig.Authorizer()
-	if err != nil {
-		logger.Errorf("Error while getting authorizer: ", err)
-	}
-	subscriptionClient := subscriptions.NewClient()
-	subscriptionClient.Authorizer = authorizer
-	subscriptionInfo, err := subscriptionClient.Get(context.Background(), s.config.Azure.SubscriptionID)
-	if err != nil {
-		logger.Errorf("Error while getting subscription Info: ", err)
-		return "", ""
-	}
-	subscriptionID = s.config.Azure.SubscriptionID
-	subscriptionName = *subscriptionInfo.DisplayName
-	return subscriptionID, subscriptionName
+func (cw *SubscriptionsClientWrapper) ListComplete(ctx context.Context) (result subscriptions.ListResultIterator, err error) {
+	return cw.client.ListComplete(ctx)
+}
+
+func (cw *SubscriptionsClientWrapper) SetAuth(auth autorest.Authorizer) {
+	cw.client.Authorizer = auth
+}
+
+func NewSubscriptionsClientWrapper(baseURI string) *SubscriptionsClientWrapper {
+	cw := &SubscriptionsClientWrapper{client: subscriptions.NewClientWithBaseURI(baseURI)}
+	return cw
+}
+
+// subscription holds the usage clients of a subscription found by discovery, by the name of their source
+type subscription struct {
+	id           string
+	name         string
+	usageClients map[string]UsageClient
+}
+
+type Result struct {
+	quotaResult      *common.QuotaResult
+	subscriptionID   string
+	subscriptionName string
+	location         string
 }
 
 type MetricsCollectorAzureRmQuota struct {
-	conf               *config.Config
-	log                log.FieldLogger
-	storageUsageClient StorageClient
-	networkUsageClient NetworkClient
-	computeUsageClient ComputeClient
-	subscriptionInfo   SubscriptionInfo
+	conf                *config.Config
+	log                 log.FieldLogger
+	authorizer          autorest.Authorizer
+	baseURI             string
+	subscriptionsClient SubscriptionsClient
+	newSubscription     func(id, name string) *subscription
+	defaultSubscription string
+	nameRegex           *regexp.Regexp
+	locations           []string
+	usageSources        []string
+	quotaProviders      []string
+	exportZeroUsage     bool
+
+	subscriptions []*subscription
+	discoveredAt  time.Time
 }
 
 func NewMetricsCollectorAzureRmQuota(config *config.Config, cred vault.CloudCredentials, logger log.FieldLogger) *MetricsCollectorAzureRmQuota {
-	clientCredentialConfig := auth.NewClientCredentialsConfig(cred[vault.AzureClientID], cred[vault.AzureClientSecret], cred[vault.AzureTenantID])
-	authorizer, err := clientCredentialConfig.Authorizer()
+	authorizer, err := newAuthorizer(cred)
 	if err != nil {
-		logger.Errorf("Error while getting authorizer: ", err)
+		logger.Errorf("Error while getting authorizer: %v", err)
 	}
 	m := &MetricsCollectorAzureRmQuota{}
 	m.conf = config
 	m.log = logger
-	m.log.Infof("Initialize Azure storage client")
-	m.storageUsageClient = NewStorageClientWrapper(m.conf.Azure.SubscriptionID)
-	m.storageUsageClient.SetAuth(authorizer)
-
-	m.log.Infof("Initialize Azure Network client")
-	m.networkUsageClient = NewNetworkClientWrapper(m.conf.Azure.SubscriptionID)
-	m.networkUsageClient.SetAuth(authorizer)
-
-	m.log.Infof("Initialize Azure compute client")
-	m.computeUsageClient = NewComputeClientWrapper(m.conf.Azure.SubscriptionID)
-	m.computeUsageClient.SetAuth(authorizer)
-
-	m.subscriptionInfo = &SubscriptionInfoWrapper{config, cred}
+	m.authorizer = authorizer
+	m.baseURI = resourceManagerURI()
+	m.log.Infof("Initialize Azure subscriptions client")
+	m.subscriptionsClient = NewSubscriptionsClientWrapper(resourceManagerURI())
+	m.subscriptionsClient.SetAuth(authorizer)
+	m.newSubscription = m.newSubscriptionClients
+
+	// the configured subscription, or the one stored with the credentials, is used when discovery finds nothing
+	m.defaultSubscription = cred[vault.AzureSubscriptionID]
+	m.locations = []string{config.Region}
+	m.usageSources = defaultUsageSources
+	if config.Azure != nil {
+		if config.Azure.SubscriptionID != "" {
+			m.defaultSubscription = config.Azure.SubscriptionID
+		}
+		if config.Azure.SubscriptionNameRegex != "" {
+			m.nameRegex, err = regexp.Compile(config.Azure.SubscriptionNameRegex)
+			if err != nil {
+				logger.Errorf("Error while compiling subscription name regex, all subscriptions are used: %v", err)
+			}
+		}
+		if len(config.Azure.Locations) > 0 {
+			m.locations = config.Azure.Locations
+		}
+		if len(config.Azure.UsageSources) > 0 {
+			m.usageSources = config.Azure.UsageSources
+		}
+		m.quotaProviders = config.Azure.QuotaProviders
+		m.exportZeroUsage = config.Azure.ExportZeroUsage
+	}
 
 	common.InitQuotaGaugeVec("Azure", []string{constant.LabelRegion, constant.LabelQuotaCode, constant.LabelQuotaName, constant.LabelSubscriptionID, constant.LabelSubscriptionName, constant.LabelUnit})
 	return m
 }
 
+// newSubscriptionClients builds the usage clients of the configured sources, and a Microsoft.Quota client per
+// configured resource provider.
+func (m *MetricsCollectorAzureRmQuota) newSubscriptionClients(id, name string) *subscription {
+	s := &subscription{id: id, name: name, usageClients: make(map[string]UsageClient)}
+	for _, source := range m.usageSources {
+		switch source {
+		case SourceCompute:
+			client := NewComputeClientWrapper(m.baseURI, id)
+			client.SetAuth(m.authorizer)
+			s.usageClients[source] = &ComputeUsages{client}
+		case SourceNetwork:
+			client := NewNetworkClientWrapper(m.baseURI, id)
+			client.SetAuth(m.authorizer)
+			s.usageClients[source] = &NetworkUsages{client}
+		case SourceStorage:
+			client := NewStorageClientWrapper(m.baseURI, id)
+			client.SetAuth(m.authorizer)
+			s.usageClients[source] = &StorageUsages{client}
+		case SourcePublicIPPrefix:
+			prefixClient := NewPublicIPPrefixClientWrapper(m.baseURI, id)
+			prefixClient.SetAuth(m.authorizer)
+			networkClient := NewNetworkClientWrapper(m.baseURI, id)
+			networkClient.SetAuth(m.authorizer)
+			s.usageClients[source] = &PublicIPPrefixUsages{prefixClient, networkClient}
+		case SourceBatch:
+			client := NewBatchUsagesClient(m.baseURI, id)
+			client.SetAuth(m.authorizer)
+			s.usageClients[source] = client
+		default:
+			rest, ok := restUsageSources[source]
+			if !ok {
+				m.log.Errorf("Unknown usage source %s", source)
+				continue
+			}
+			client := NewProviderUsagesClient(m.baseURI, id, rest.provider, rest.apiVersion)
+			client.SetAuth(m.authorizer)
+			s.usageClients[source] = client
+		}
+	}
+	for _, provider := range m.quotaProviders {
+		client := NewQuotaAPIClient(m.baseURI, id, provider)
+		client.SetAuth(m.authorizer)
+		s.usageClients["quota/"+provider] = client
+	}
+	return s
+}
+
 func (m *MetricsCollectorAzureRmQuota) Describe(ch chan<- *prometheus.Desc) {
 	common.QuotaLimit.Describe(ch)
 	common.QuotaCurrent.Describe(ch)
 }
 
-func (m *MetricsCollectorAzureRmQuota) collectCompute(ctx context.Context, wg *sync.WaitGroup) {
-	defer wg.Done()
-	m.log.Infof("Start collect Azure compute metrics")
-	var currentValue, limitValue float64
-	for usage, err := m.computeUsageClient.ListComplete(ctx, m.conf.Region); usage.NotDone(); err = usage.NextWithContext(ctx) {
-		if err != nil {
-			m.log.Errorf("Error while traversing compute resource list: ", err)
-			return
-		}
-		i := usage.Value()
-		currentValue = float64(to.Int32(i.CurrentValue))
-		limitValue = float64(to.Int64(i.Limit))
-		if limitValue >= 2147483647 { // unlimited resource
+// discoverSubscriptions lists the enabled subscriptions the service principal can see, filtered by the configured
+// name regex and tags. The subscriptions and their display names are kept until the refresh interval has passed.
+func (m *MetricsCollectorAzureRmQuota) discoverSubscriptions(ctx context.Context) []*subscription {
+	if m.subscriptions != nil && time.Since(m.discoveredAt) < subscriptionRefreshInterval {
+		return m.subscriptions
+	}
+	known := make(map[string]*subscription)
+	for _, s := range m.subscriptions {
+		known[s.id] = s
+	}
+
+	var found []*subscription
+	it, err := m.subscriptionsClient.ListComplete(ctx)
+	for ; err == nil && it.NotDone(); err = it.NextWithContext(ctx) {
+		s := it.Value()
+		if s.State == subscriptions.StateDisabled || s.State == subscriptions.StateDeleted || !m.selected(s) {
 			continue
 		}
-		code := to.String(i.Name.Value)
-		name := to.String(i.Name.LocalizedValue)
-		if currentValue > 0 {
-			result := &common.QuotaResult{QuotaCode: code, QuotaName: name, CurrentValue: currentValue, LimitValue: limitValue, Unit: to.String(i.Unit)}
-			common.QuotaCache.Set(code, result, cache.DefaultExpiration)
+		id, name := to.String(s.SubscriptionID), to.String(s.DisplayName)
+		if k, ok := known[id]; ok && k.name == name {
+			found = append(found, k)
+		} else {
+			found = append(found, m.newSubscription(id, name))
 		}
 	}
-	m.log.Infof("End collect Azure compute metrics")
+	if err != nil {
+		m.log.Errorf("Error while listing subscriptions: %v", err)
+		if m.subscriptions != nil {
+			return m.subscriptions
+		}
+		found = nil
+	}
+	if len(found) == 0 && m.defaultSubscription != "" {
+		m.log.Infof("No subscription discovered, using %s", m.defaultSubscription)
+		found = []*subscription{m.newSubscription(m.defaultSubscription, "")}
+	}
+	m.subscriptions = found
+	m.discoveredAt = time.Now()
+	m.log.Infof("%d subscriptions discovered", len(found))
+	return found
 }
 
-func (m *MetricsCollectorAzureRmQuota) collectStorage(ctx context.Context, wg *sync.WaitGroup) {
-	defer wg.Done()
-	m.log.Infof("Start collect Azure storage metrics")
-	var currentValue, limitValue float64
-	storageUsageList, err := m.storageUsageClient.ListByLocation(ctx, m.conf.Region)
-	if err != nil {
-		m.log.Errorf("Error while traversing storage resource list: ", err)
-		return
+func (m *MetricsCollectorAzureRmQuota) selected(s subscriptions.Subscription) bool {
+	if m.nameRegex != nil && !m.nameRegex.MatchString(to.String(s.DisplayName)) {
+		return false
 	}
-	for _, i := range *storageUsageList.Value {
-		currentValue = float64(to.Int32(i.CurrentValue))
-		limitValue = float64(to.Int32(i.Limit))
-		if limitValue >= 2147483647 { // unlimited resource
-			continue
-		}
-		code := to.String(i.Name.Value)
-		name := to.String(i.Name.LocalizedValue)
-		if currentValue > 0 {
-			result := &common.QuotaResult{QuotaCode: code, QuotaName: name, CurrentValue: currentValue, LimitValue: limitValue, Unit: string(i.Unit)}
-			common.QuotaCache.Set(code, result, cache.DefaultExpiration)
+	if m.conf.Azure == nil {
+		return true
+	}
+	for key, value := range m.conf.Azure.SubscriptionTags {
+		tag, ok := s.Tags[key]
+		if !ok || (value != "" && to.String(tag) != value) {
+			return false
 		}
 	}
-	m.log.Infof("End collect Azure storage metrics")
+	return true
 }
 
-func (m *MetricsCollectorAzureRmQuota) collectNetwork(ctx context.Context, wg *sync.WaitGroup) {
-	defer wg.Done()
-	m.log.Infof("Start collect Azure network metrics")
-	var currentValue, limitValue float64
-	for usage, err := m.networkUsageClient.ListComplete(ctx, m.conf.Region); usage.NotDone(); err = usage.NextWithContext(ctx) {
-		if err != nil {
-			m.log.Errorf("Error while traversing network resource list: ", err)
-			return
-		}
-		i := usage.Value()
-		currentValue = float64(to.Int64(i.CurrentValue))
-		limitValue = float64(to.Int64(i.Limit))
-		if limitValue >= 2147483647 { // unlimited resource
-			continue
-		}
-		code := to.String(i.Name.Value)
-		name := to.String(i.Name.LocalizedValue)
-		if currentValue > 0 {
-			result := &common.QuotaResult{QuotaCode: code, QuotaName: name, CurrentValue: currentValue, LimitValue: limitValue, Unit: to.String(i.Unit)}
-			common.QuotaCache.Set(code, result, cache.DefaultExpiration)
-		}
+// cacheUsage caches a usage under its normalized ID, so a quota reported by several sources is exported once.
+// Unlimited quotas are skipped, and so are unused ones unless zero usages are exported to check that a new
+// location has the quotas needed.
+func (m *MetricsCollectorAzureRmQuota) cacheUsage(s *subscription, location string, usage Usage) {
+	if unlimited(usage.LimitValue) || usage.CurrentValue < 0 || (usage.CurrentValue == 0 && !m.exportZeroUsage) {
+		return
+	}
+	result := &Result{
+		quotaResult:      &common.QuotaResult{QuotaCode: usage.ID, QuotaName: usage.Name, CurrentValue: usage.CurrentValue, LimitValue: usage.LimitValue, Unit: usage.Unit},
+		subscriptionID:   s.id,
+		subscriptionName: s.name,
+		location:         location,
+	}
+	common.QuotaCache.Set(strings.Join([]string{s.id, location, usage.ID}, "/"), result, cache.DefaultExpiration)
+}
+
+func (m *MetricsCollectorAzureRmQuota) collectUsages(ctx context.Context, s *subscription, source, location string) {
+	m.log.Infof("Start collect Azure %s metrics of %s in %s", source, s.id, location)
+	usages, err := s.usageClients[source].ListUsages(ctx, location)
+	if err != nil {
+		m.log.Errorf("Error while listing %s usages of %s in %s: %v", source, s.id, location, err)
+		return
 	}
-	m.log.Infof("End collect Azure network metrics")
+	for _, usage := range usages {
+		m.cacheUsage(s, location, usage)
+	}
+	m.log.Infof("End collect Azure %s metrics of %s in %s", source, s.id, location)
 }
 
 func (m *MetricsCollectorAzureRmQuota) scrape(ctx context.Context) {
 	m.log.Infof("Start collect Azure metrics")
 	var waitGroup sync.WaitGroup
-	waitGroup.Add(3)
-	go m.collectNetwork(ctx, &waitGroup)
-	go m.collectStorage(ctx, &waitGroup)
-	go m.collectCompute(ctx, &waitGroup)
+	limit := make(chan struct{}, maxConcurrentUsageLists)
+	for _, s := range m.discoverSubscriptions(ctx) {
+		for _, location := range m.locations {
+			for source := range s.usageClients {
+				waitGroup.Add(1)
+				go func(s *subscription, source, location string) {
+					defer waitGroup.Done()
+					limit <- struct{}{}
+					defer func() { <-limit }()
+					m.collectUsages(ctx, s, source, location)
+				}(s, source, location)
+			}
+		}
+	}
 	waitGroup.Wait()
 	m.log.Infof("End collect Azure metrics")
 }
 
 func (m *MetricsCollectorAzureRmQuota) Collect(ch chan<- prometheus.Metric) {
 	m.log.Infof("Start retrieve data from cache")
-	subscriptionID, subscriptionName = m.subscriptionInfo.GetSubscriptionInfo(m.log)
 	for _, item := range common.QuotaCache.Items() {
-		result := item.Object.(*common.QuotaResult)
-		m.log.WithFields(log.Fields{"region": m.conf.Region, "quotaCode": result.QuotaCode, "subscriptionID": subscriptionID, "subscriptionName": subscriptionName, "current": result.CurrentValue, "limit": result.LimitValue}).Infof("retrieve data from cache")
-		common.QuotaCurrent.WithLabelValues(m.conf.Region, result.QuotaCode, result.QuotaName, subscriptionID, subscriptionName, result.Unit).Set(result.CurrentValue)
-		common.QuotaLimit.WithLabelValues(m.conf.Region, result.QuotaCode, result.QuotaName, subscriptionID, subscriptionName, result.Unit).Set(result.LimitValue)
+		result := item.Object.(*Result)
+		m.log.WithFields(log.Fields{"region": result.location, "quotaCode": result.quotaResult.QuotaCode, "subscriptionID": result.subscriptionID, "subscriptionName": result.subscriptionName, "current": result.quotaResult.CurrentValue, "limit": result.quotaResult.LimitValue}).Infof("retrieve data from cache")
+		common.QuotaCurrent.WithLabelValues(result.location, result.quotaResult.QuotaCode, result.quotaResult.QuotaName, result.subscriptionID, result.subscriptionName, result.quotaResult.Unit).Set(result.quotaResult.CurrentValue)
+		common.QuotaLimit.WithLabelValues(result.location, result.quotaResult.QuotaCode, result.quotaResult.QuotaName, result.subscriptionID, result.subscriptionName, result.quotaResult.Unit).Set(result.quotaResult.LimitValue)
 	}
 	common.QuotaCurrent.Collect(ch)
 	common.QuotaLimit.Collect(ch)
@@ -4,9 +4,11 @@ import (
 	"context"
 	"github.com/Azure/azure-sdk-for-go/profiles/latest/compute/mgmt/compute"
 	"github.com/Azure/azure-sdk-for-go/profiles/latest/network/mgmt/network"
+	"github.com/Azure/azure-sdk-for-go/profiles/latest/resources/mgmt/subscriptions"
 	"github.com/Azure/azure-sdk-for-go/profiles/latest/storage/mgmt/storage"
 	"github.com/Azure/go-autorest/autorest"
 	"github.com/Azure/go-autorest/autorest/to"
+	"github.com/jarcoal/httpmock"
 	"github.com/patrickmn/go-cache"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
@@ -15,6 +17,7 @@ import (
 	"github.wdf.sap.corp/DBaaS/cloud-provider-exporter/pkg/cloud/common"
 	"github.wdf.sap.corp/DBaaS/cloud-provider-exporter/pkg/config"
 	"github.wdf.sap.corp/DBaaS/cloud-provider-exporter/pkg/vault"
+	"math"
 	"net/http"
 	"testing"
 	"time"
@@ -24,12 +27,14 @@ type MockStorageClient struct {
 }
 
 type MockNetworkClient struct {
+	usages []network.Usage
 }
 
 type MockComputeClient struct {
 }
 
-type MockSubscriptionInfo struct {
+type MockSubscriptionsClient struct {
+	calls int
 }
 
 func (m *MockStorageClient) SetAuth(auth autorest.Authorizer) {
@@ -50,11 +55,16 @@ func (m *MockQuotaCache) Items() map[string]cache.Item {
 	var result = map[string]cache.Item{
 		"1": {
 			Expiration: 0,
-			Object: &common.QuotaResult{
-				QuotaCode:    "code",
-				QuotaName:    "name",
-				LimitValue:   100,
-				CurrentValue: 30,
+			Object: &Result{
+				quotaResult: &common.QuotaResult{
+					QuotaCode:    "code",
+					QuotaName:    "name",
+					LimitValue:   100,
+					CurrentValue: 30,
+				},
+				subscriptionID:   "mock_subscriptionID",
+				subscriptionName: "mock_subscriptionName",
+				location:         "dummy_region",
 			},
 		},
 	}
@@ -68,7 +78,7 @@ func (m *MockStorageClient) ListByLocation(ctx context.Context, location string)
 		CurrentValue: to.Int32Ptr(50),
 		Limit:        to.Int32Ptr(100),
 		Name: &storage.UsageName{
-			Value:          to.StringPtr("dummy_value"),
+			Value:          to.StringPtr("dummy_storage_value"),
 			LocalizedValue: to.StringPtr("dummy_storage"),
 		},
 	})
@@ -77,13 +87,13 @@ func (m *MockStorageClient) ListByLocation(ctx context.Context, location string)
 }
 
 func (m *MockNetworkClient) ListComplete(ctx context.Context, location string) (result network.UsagesListResultIterator, err error) {
-	var value []network.Usage
+	value := m.usages
 	value = append(value, network.Usage{
 		Unit:         to.StringPtr("UsageUnitCount"),
 		CurrentValue: to.Int64Ptr(60),
 		Limit:        to.Int64Ptr(100),
 		Name: &network.UsageName{
-			Value:          to.StringPtr("dummy_value"),
+			Value:          to.StringPtr("dummy_network_value"),
 			LocalizedValue: to.StringPtr("dummy_network"),
 		},
 	})
@@ -106,7 +116,7 @@ func (m *MockComputeClient) ListComplete(ctx context.Context, location string) (
 		CurrentValue: to.Int32Ptr(70),
 		Limit:        to.Int64Ptr(100),
 		Name: &compute.UsageName{
-			Value:          to.StringPtr("dummy_value"),
+			Value:          to.StringPtr("dummy_compute_value"),
 			LocalizedValue: to.StringPtr("dummy_compute"),
 		},
 	})
@@ -122,8 +132,34 @@ func (m *MockComputeClient) ListComplete(ctx context.Context, location string) (
 	return outputs, nil
 }
 
-func (m *MockSubscriptionInfo) GetSubscriptionInfo(logger log.FieldLogger) (subscriptionID, subscriptionName string) {
-	return "mock_subscriptionID", "mock_subscriptionName"
+func (m *MockSubscriptionsClient) SetAuth(auth autorest.Authorizer) {
+}
+
+func (m *MockSubscriptionsClient) ListComplete(ctx context.Context) (result subscriptions.ListResultIterator, err error) {
+	m.calls++
+	value := []subscriptions.Subscription{
+		{SubscriptionID: to.StringPtr("sub-1"), DisplayName: to.StringPtr("hana-prod"), State: subscriptions.StateEnabled,
+			Tags: map[string]*string{"owner": to.StringPtr("dbaas")}},
+		{SubscriptionID: to.StringPtr("sub-2"), DisplayName: to.StringPtr("hana-dev"), State: subscriptions.StateEnabled,
+			Tags: map[string]*string{"owner": to.StringPtr("dbaas")}},
+		{SubscriptionID: to.StringPtr("sub-3"), DisplayName: to.StringPtr("hana-old"), State: subscriptions.StateDisabled,
+			Tags: map[string]*string{"owner": to.StringPtr("dbaas")}},
+		{SubscriptionID: to.StringPtr("sub-4"), DisplayName: to.StringPtr("hana-other"), State: subscriptions.StateEnabled},
+		{SubscriptionID: to.StringPtr("sub-5"), DisplayName: to.StringPtr("analytics"), State: subscriptions.StateEnabled,
+			Tags: map[string]*string{"owner": to.StringPtr("dbaas")}},
+	}
+	page := subscriptions.NewListResultPage(subscriptions.ListResult{Value: &value}, func(ctx context.Context, result subscriptions.ListResult) (subscriptions.ListResult, error) {
+		return subscriptions.ListResult{}, nil
+	})
+	return subscriptions.NewListResultIterator(page), nil
+}
+
+func mockSubscription(id, name string) *subscription {
+	return &subscription{id, name, map[string]UsageClient{
+		SourceStorage: &StorageUsages{&MockStorageClient{}},
+		SourceNetwork: &NetworkUsages{&MockNetworkClient{}},
+		SourceCompute: &ComputeUsages{&MockComputeClient{}},
+	}}
 }
 
 func TestAzureQuota(t *testing.T) {
@@ -151,10 +187,8 @@ func TestAzureQuota(t *testing.T) {
 
 	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		quotaCollector := NewMetricsCollectorAzureRmQuota(conf, cred, &log.Logger{})
-		quotaCollector.storageUsageClient = &MockStorageClient{}
-		quotaCollector.networkUsageClient = &MockNetworkClient{}
-		quotaCollector.computeUsageClient = &MockComputeClient{}
-		quotaCollector.subscriptionInfo = &MockSubscriptionInfo{}
+		quotaCollector.subscriptionsClient = &MockSubscriptionsClient{}
+		quotaCollector.newSubscription = mockSubscription
 		quotaCollector.scrape(context.TODO())
 		registry := prometheus.NewRegistry()
 		registry.MustRegister(quotaCollector)
@@ -165,3 +199,153 @@ func TestAzureQuota(t *testing.T) {
 	assert.HTTPBodyContains(t, handler, "GET", uri, nil, "cpe_quota_current{QuotaCode=\"code\",QuotaName=\"name\",Region=\"dummy_region\",SubscriptionID=\"mock_subscriptionID\",SubscriptionName=\"mock_subscriptionName\",Unit=\"\"} 30")
 	assert.HTTPBodyContains(t, handler, "GET", uri, nil, "cpe_quota_limit{QuotaCode=\"code\",QuotaName=\"name\",Region=\"dummy_region\",SubscriptionID=\"mock_subscriptionID\",SubscriptionName=\"mock_subscriptionName\",Unit=\"\"} 100")
 }
+
+func TestAzureQuotaSubscriptions(t *testing.T) {
+	uri := "/metrics"
+	conf := &config.Config{
+		Region: "dummy_region",
+		Azure: &config.AzureConfig{
+			SubscriptionNameRegex: "^hana-",
+			SubscriptionTags:      map[string]string{"owner": ""},
+			Locations:             []string{"westeurope", "northeurope"},
+		},
+	}
+	common.QuotaCache = cache.New(time.Minute, time.Minute)
+	quotaCollector := NewMetricsCollectorAzureRmQuota(conf, vault.CloudCredentials{}, &log.Logger{})
+	subscriptionsClient := &MockSubscriptionsClient{}
+	quotaCollector.subscriptionsClient = subscriptionsClient
+	quotaCollector.newSubscription = mockSubscription
+	quotaCollector.scrape(context.TODO())
+	quotaCollector.scrape(context.TODO())
+	assert.Equal(t, 1, subscriptionsClient.calls)
+	assert.Len(t, quotaCollector.subscriptions, 2)
+	// 3 usages in 2 locations of 2 subscriptions
+	assert.Len(t, common.QuotaCache.Items(), 12)
+
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(quotaCollector)
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		h := promhttp.HandlerFor(registry, promhttp.HandlerOpts{})
+		h.ServeHTTP(w, r)
+	})
+	assert.HTTPBodyContains(t, handler, "GET", uri, nil, "cpe_quota_current{QuotaCode=\"microsoft.compute/dummy_compute_value\",QuotaName=\"dummy_compute\",Region=\"northeurope\",SubscriptionID=\"sub-2\",SubscriptionName=\"hana-dev\",Unit=\"UsageUnitCount\"} 70")
+	assert.HTTPBodyContains(t, handler, "GET", uri, nil, "cpe_quota_limit{QuotaCode=\"microsoft.storage/dummy_storage_value\",QuotaName=\"dummy_storage\",Region=\"westeurope\",SubscriptionID=\"sub-1\",SubscriptionName=\"hana-prod\",Unit=\"UsageUnitCount\"} 100")
+	assert.HTTPBodyNotContains(t, handler, "GET", uri, nil, "sub-3")
+	assert.HTTPBodyNotContains(t, handler, "GET", uri, nil, "sub-4")
+	assert.HTTPBodyNotContains(t, handler, "GET", uri, nil, "sub-5")
+}
+
+const (
+	sqlUsages = `{"value": [
+		{"name": "ServerQuota", "type": "Microsoft.Sql/locations/usages",
+		 "properties": {"displayName": "Regional Server Quota for westeurope", "currentValue": 3, "limit": 20, "unit": "Count"}},
+		{"name": "VCoreQuota", "type": "Microsoft.Sql/locations/usages",
+		 "properties": {"displayName": "Regional vCore Quota for SQL DB and DW for westeurope", "currentValue": 0, "limit": 250, "unit": "VCores"}}
+	]}`
+	batchQuota    = `{"accountQuota": 3}`
+	batchAccounts = `{"value": [{"location": "westeurope"}, {"location": "West Europe"}, {"location": "northeurope"}]}`
+	quotaUsages   = `{"value": [
+		{"properties": {"name": {"value": "standardDSv3Family", "localizedValue": "Standard DSv3 Family vCPUs"}, "unit": "Count", "isQuotaApplicable": true, "usages": {"value": 16}}},
+		{"properties": {"name": {"value": "dummy_compute_value", "localizedValue": "dummy compute"}, "unit": "Count", "isQuotaApplicable": true, "usages": {"value": 70}}},
+		{"properties": {"name": {"value": "availabilitySets", "localizedValue": "Availability Sets"}, "unit": "Count", "isQuotaApplicable": false, "usages": {"value": 2}}}
+	], "nextLink": "https://management.azure.com/subscriptions/sub-1/providers/Microsoft.Compute/locations/westeurope/providers/Microsoft.Quota/usages?api-version=2023-02-01&$skiptoken=1"}`
+	quotaUsagesNextPage = `{"value": [
+		{"properties": {"name": {"value": "cores", "localizedValue": "Total Regional vCPUs"}, "unit": "Count", "isQuotaApplicable": true, "usages": {"value": 0}}}
+	]}`
+	quotaLimits = `{"value": [
+		{"properties": {"name": {"value": "standardDSv3Family"}, "limit": {"limitObjectType": "LimitValue", "value": 100}}},
+		{"properties": {"name": {"value": "dummy_compute_value"}, "limit": {"limitObjectType": "LimitValue", "value": 100}}},
+		{"properties": {"name": {"value": "cores"}, "limit": {"limitObjectType": "LimitValue", "value": 350}}}
+	]}`
+)
+
+func TestAzureQuotaSources(t *testing.T) {
+	uri := "/metrics"
+	base := "https://management.azure.com/subscriptions/sub-1/providers/"
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+	httpmock.RegisterResponder("GET", base+"Microsoft.Sql/locations/westeurope/usages?api-version=2021-11-01", httpmock.NewStringResponder(http.StatusOK, sqlUsages))
+	httpmock.RegisterResponder("GET", base+"Microsoft.Batch/locations/westeurope/quotas?api-version=2022-10-01", httpmock.NewStringResponder(http.StatusOK, batchQuota))
+	httpmock.RegisterResponder("GET", base+"Microsoft.Batch/batchAccounts?api-version=2022-10-01", httpmock.NewStringResponder(http.StatusOK, batchAccounts))
+	httpmock.RegisterResponder("GET", base+"Microsoft.Compute/locations/westeurope/providers/Microsoft.Quota/usages?api-version=2023-02-01", httpmock.NewStringResponder(http.StatusOK, quotaUsages))
+	httpmock.RegisterResponder("GET", base+"Microsoft.Compute/locations/westeurope/providers/Microsoft.Quota/usages?%24skiptoken=1&api-version=2023-02-01", httpmock.NewStringResponder(http.StatusOK, quotaUsagesNextPage))
+	httpmock.RegisterResponder("GET", base+"Microsoft.Compute/locations/westeurope/providers/Microsoft.Quota/quotas?api-version=2023-02-01", httpmock.NewStringResponder(http.StatusOK, quotaLimits))
+
+	conf := &config.Config{
+		Region: "westeurope",
+		Azure: &config.AzureConfig{
+			SubscriptionNameRegex: "^hana-prod$",
+			UsageSources:          []string{SourceCompute, SourceSQL, SourceBatch},
+			QuotaProviders:        []string{"Microsoft.Compute"},
+			ExportZeroUsage:       true,
+		},
+	}
+	common.QuotaCache = cache.New(time.Minute, time.Minute)
+	quotaCollector := NewMetricsCollectorAzureRmQuota(conf, vault.CloudCredentials{}, &log.Logger{})
+	quotaCollector.subscriptionsClient = &MockSubscriptionsClient{}
+	quotaCollector.newSubscription = func(id, name string) *subscription {
+		s := quotaCollector.newSubscriptionClients(id, name)
+		s.usageClients[SourceCompute] = &ComputeUsages{&MockComputeClient{}}
+		s.usageClients[SourceSQL].(*ProviderUsagesClient).Sender = http.DefaultClient
+		s.usageClients[SourceBatch].(*BatchUsagesClient).Sender = http.DefaultClient
+		s.usageClients["quota/Microsoft.Compute"].(*QuotaAPIClient).Sender = http.DefaultClient
+		return s
+	}
+	quotaCollector.scrape(context.TODO())
+	// the compute usage reported by the usages and the Microsoft.Quota API is cached once
+	assert.Len(t, common.QuotaCache.Items(), 6)
+
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(quotaCollector)
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		h := promhttp.HandlerFor(registry, promhttp.HandlerOpts{})
+		h.ServeHTTP(w, r)
+	})
+	assert.HTTPBodyContains(t, handler, "GET", uri, nil, "cpe_quota_current{QuotaCode=\"microsoft.sql/serverquota\",QuotaName=\"Regional Server Quota for westeurope\",Region=\"westeurope\",SubscriptionID=\"sub-1\",SubscriptionName=\"hana-prod\",Unit=\"Count\"} 3")
+	assert.HTTPBodyContains(t, handler, "GET", uri, nil, "cpe_quota_current{QuotaCode=\"microsoft.sql/vcorequota\",QuotaName=\"Regional vCore Quota for SQL DB and DW for westeurope\",Region=\"westeurope\",SubscriptionID=\"sub-1\",SubscriptionName=\"hana-prod\",Unit=\"VCores\"} 0")
+	assert.HTTPBodyContains(t, handler, "GET", uri, nil, "cpe_quota_current{QuotaCode=\"microsoft.batch/batchaccounts\",QuotaName=\"Batch Accounts\",Region=\"westeurope\",SubscriptionID=\"sub-1\",SubscriptionName=\"hana-prod\",Unit=\"Count\"} 2")
+	assert.HTTPBodyContains(t, handler, "GET", uri, nil, "cpe_quota_limit{QuotaCode=\"microsoft.compute/standarddsv3family\",QuotaName=\"Standard DSv3 Family vCPUs\",Region=\"westeurope\",SubscriptionID=\"sub-1\",SubscriptionName=\"hana-prod\",Unit=\"Count\"} 100")
+	assert.HTTPBodyContains(t, handler, "GET", uri, nil, "cpe_quota_limit{QuotaCode=\"microsoft.compute/cores\",QuotaName=\"Total Regional vCPUs\",Region=\"westeurope\",SubscriptionID=\"sub-1\",SubscriptionName=\"hana-prod\",Unit=\"Count\"} 350")
+	assert.HTTPBodyNotContains(t, handler, "GET", uri, nil, "microsoft.compute/availabilitysets")
+}
+
+type MockPublicIPPrefixClient struct {
+	prefixes []network.PublicIPPrefix
+}
+
+func (m *MockPublicIPPrefixClient) SetAuth(auth autorest.Authorizer) {
+}
+
+func (m *MockPublicIPPrefixClient) ListAllComplete(ctx context.Context) (result network.PublicIPPrefixListResultIterator, err error) {
+	page := network.NewPublicIPPrefixListResultPage(network.PublicIPPrefixListResult{Value: &m.prefixes},
+		func(ctx context.Context, result network.PublicIPPrefixListResult) (network.PublicIPPrefixListResult, error) {
+			return network.PublicIPPrefixListResult{}, nil
+		})
+	return network.NewPublicIPPrefixListResultIterator(page), nil
+}
+
+func TestPublicIPPrefixUsages(t *testing.T) {
+	usage := func(name string, current, limit int64) network.Usage {
+		return network.Usage{Name: &network.UsageName{Value: to.StringPtr(name)}, CurrentValue: to.Int64Ptr(current), Limit: to.Int64Ptr(limit)}
+	}
+	prefix := func(location string, length int32) network.PublicIPPrefix {
+		return network.PublicIPPrefix{Location: to.StringPtr(location), PublicIPPrefixPropertiesFormat: &network.PublicIPPrefixPropertiesFormat{PrefixLength: to.Int32Ptr(length)}}
+	}
+	u := &PublicIPPrefixUsages{
+		prefixClient: &MockPublicIPPrefixClient{[]network.PublicIPPrefix{prefix("West Europe", 28), prefix("westeurope", 31), prefix("northeurope", 28)}},
+		networkClient: &MockNetworkClient{usages: []network.Usage{
+			usage("StandardPublicIPAddresses", 20, 1000),
+			usage("PublicIPPrefixes", 2, math.MaxInt32),
+		}},
+	}
+	usages, err := u.ListUsages(context.TODO(), "westeurope")
+	assert.NoError(t, err)
+	assert.Equal(t, []Usage{
+		{"microsoft.network/publicipprefixaddresses", "Public IP Addresses in Prefixes", "Count", 18, 1000},
+		{"microsoft.network/publicipprefixes", "Public IP Prefixes", "Count", 2, math.MaxInt32},
+	}, usages)
+
+	// only -1 stands for no limit
+	assert.True(t, unlimited(-1))
+	assert.False(t, unlimited(math.MaxInt32))
+}
@@ -0,0 +1,140 @@
+package azure
+
+import (
+	"context"
+	"fmt"
+	"github.com/Azure/azure-sdk-for-go/profiles/latest/storage/mgmt/storage"
+	"github.com/Azure/go-autorest/autorest"
+	"github.com/Azure/go-autorest/autorest/to"
+	"github.wdf.sap.corp/DBaaS/cloud-provider-exporter/pkg/cloud/objectstore"
+	"net/http"
+	"strings"
+)
+
+// StorageAccountClient reads the settings of the storage accounts of a subscription, the blob data plane does not
+// expose versioning or lifecycle management.
+type StorageAccountClient interface {
+	// GetAccount returns the storage account of the subscription with the name and its resource group
+	GetAccount(ctx context.Context, name string) (storage.Account, string, error)
+	GetBlobServiceProperties(ctx context.Context, resourceGroup, account string) (storage.BlobServiceProperties, error)
+	GetManagementPolicy(ctx context.Context, resourceGroup, account string) (storage.ManagementPolicy, error)
+	GetContainer(ctx context.Context, resourceGroup, account, container string) (storage.BlobContainer, error)
+	SetAuth(auth autorest.Authorizer)
+}
+
+type StorageAccountClientWrapper struct {
+	accounts     storage.AccountsClient
+	blobServices storage.BlobServicesClient
+	policies     storage.ManagementPoliciesClient
+	containers   storage.BlobContainersClient
+}
+
+func (cw *StorageAccountClientWrapper) GetAccount(ctx context.Context, name string) (storage.Account, string, error) {
+	it, err := cw.accounts.ListComplete(ctx)
+	for ; err == nil && it.NotDone(); err = it.NextWithContext(ctx) {
+		account := it.Value()
+		if to.String(account.Name) == name {
+			return account, resourceGroupOf(to.String(account.ID)), nil
+		}
+	}
+	if err != nil {
+		return storage.Account{}, "", err
+	}
+	return storage.Account{}, "", fmt.Errorf("storage account %s not found", name)
+}
+
+func (cw *StorageAccountClientWrapper) GetBlobServiceProperties(ctx context.Context, resourceGroup, account string) (storage.BlobServiceProperties, error) {
+	return cw.blobServices.GetServiceProperties(ctx, resourceGroup, account)
+}
+
+func (cw *StorageAccountClientWrapper) GetManagementPolicy(ctx context.Context, resourceGroup, account string) (storage.ManagementPolicy, error) {
+	return cw.policies.Get(ctx, resourceGroup, account)
+}
+
+func (cw *StorageAccountClientWrapper) GetContainer(ctx context.Context, resourceGroup, account, container string) (storage.BlobContainer, error) {
+	return cw.containers.Get(ctx, resourceGroup, account, container)
+}
+
+func (cw *StorageAccountClientWrapper) SetAuth(auth autorest.Authorizer) {
+	cw.accounts.Authorizer = auth
+	cw.blobServices.Authorizer = auth
+	cw.policies.Authorizer = auth
+	cw.containers.Authorizer = auth
+}
+
+func NewStorageAccountClientWrapper(baseURI, id string) *StorageAccountClientWrapper {
+	return &StorageAccountClientWrapper{
+		accounts:     storage.NewAccountsClientWithBaseURI(baseURI, id),
+		blobServices: storage.NewBlobServicesClientWithBaseURI(baseURI, id),
+		policies:     storage.NewManagementPoliciesClientWithBaseURI(baseURI, id),
+		containers:   storage.NewBlobContainersClientWithBaseURI(baseURI, id),
+	}
+}
+
+// resourceGroupOf returns the resource group of a resource ID like /subscriptions/x/resourceGroups/y/providers/...
+func resourceGroupOf(id string) string {
+	parts := strings.Split(id, "/")
+	for i := 0; i+1 < len(parts); i++ {
+		if strings.EqualFold(parts[i], "resourceGroups") {
+			return parts[i+1]
+		}
+	}
+	return ""
+}
+
+func notFound(err error) bool {
+	detailed, ok := err.(autorest.DetailedError)
+	return ok && detailed.StatusCode == http.StatusNotFound
+}
+
+// InspectBucket checks the settings of a container and the storage account of the same name.
+func (l *BlobLister) InspectBucket(ctx context.Context, bucket string) (map[string]bool, error) {
+	if l.accounts == nil {
+		return nil, nil
+	}
+	account, resourceGroup, err := l.accounts.GetAccount(ctx, bucket)
+	if err != nil {
+		return nil, err
+	}
+	checks := make(map[string]bool)
+	publicAccessAllowed := true // the default of the storage accounts
+	if properties := account.AccountProperties; properties != nil {
+		encryption := properties.Encryption
+		checks[objectstore.CheckEncryption] = encryption != nil && encryption.Services != nil && encryption.Services.Blob != nil && to.Bool(encryption.Services.Blob.Enabled)
+		if properties.AllowBlobPublicAccess != nil {
+			publicAccessAllowed = *properties.AllowBlobPublicAccess
+		}
+	}
+
+	services, err := l.accounts.GetBlobServiceProperties(ctx, resourceGroup, bucket)
+	if err != nil {
+		return checks, err
+	}
+	var retention bool
+	if properties := services.BlobServicePropertiesProperties; properties != nil {
+		checks[objectstore.CheckVersioning] = to.Bool(properties.IsVersioningEnabled)
+		retention = properties.DeleteRetentionPolicy != nil && to.Bool(properties.DeleteRetentionPolicy.Enabled)
+	}
+
+	policy, err := l.accounts.GetManagementPolicy(ctx, resourceGroup, bucket)
+	if err != nil && !notFound(err) {
+		return checks, err
+	}
+	if err == nil && policy.ManagementPolicyProperties != nil && policy.Policy != nil && policy.Policy.Rules != nil {
+		for _, rule := range *policy.Policy.Rules {
+			retention = retention || to.Bool(rule.Enabled)
+		}
+	}
+	checks[objectstore.CheckLifecycle] = retention
+
+	container, err := l.accounts.GetContainer(ctx, resourceGroup, bucket, bucket)
+	if err != nil {
+		return checks, err
+	}
+	if properties := container.ContainerProperties; properties != nil {
+		immutable := properties.ImmutableStorageWithVersioning != nil && to.Bool(properties.ImmutableStorageWithVersioning.Enabled)
+		checks[objectstore.CheckObjectLock] = immutable || to.Bool(properties.HasImmutabilityPolicy) || to.Bool(properties.HasLegalHold)
+		checks[objectstore.CheckPublicAccessBlocked] = !publicAccessAllowed || properties.PublicAccess == storage.PublicAccessNone || properties.PublicAccess == ""
+	}
+	return checks, nil
+}
@@ -0,0 +1,87 @@
+package azure
+
+import (
+	"github.com/Azure/go-autorest/autorest/azure"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	log "github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.wdf.sap.corp/DBaaS/cloud-provider-exporter/pkg/cloud/objectstore"
+	"github.wdf.sap.corp/DBaaS/cloud-provider-exporter/pkg/config"
+	"github.wdf.sap.corp/DBaaS/cloud-provider-exporter/pkg/vault"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestAzureEnvironment(t *testing.T) {
+	env, err := newEnvironment(nil)
+	assert.Nil(t, err)
+	assert.Equal(t, azure.PublicCloud.ResourceManagerEndpoint, env.ResourceManagerEndpoint)
+
+	env, err = newEnvironment(&config.AzureConfig{Environment: "china"})
+	assert.Nil(t, err)
+	assert.Equal(t, "https://login.chinacloudapi.cn/", env.ActiveDirectoryEndpoint)
+	assert.Equal(t, "https://management.chinacloudapi.cn/", env.ResourceManagerEndpoint)
+	assert.Equal(t, "core.chinacloudapi.cn", env.StorageEndpointSuffix)
+
+	env, err = newEnvironment(&config.AzureConfig{Environment: "usGovernment"})
+	assert.Nil(t, err)
+	assert.Equal(t, "https://management.usgovcloudapi.net/", env.ResourceManagerEndpoint)
+
+	_, err = newEnvironment(&config.AzureConfig{Environment: "custom", Endpoints: &config.AzureEndpoints{ResourceManager: "https://arm.local/"}})
+	assert.EqualError(t, err, "the activeDirectory, resourceManager and storageSuffix endpoints are required by a custom environment")
+
+	env, err = newEnvironment(&config.AzureConfig{Environment: "custom", Endpoints: &config.AzureEndpoints{
+		ActiveDirectory: "https://login.local/",
+		ResourceManager: "https://arm.local/",
+		StorageSuffix:   "storage.local",
+	}})
+	assert.Nil(t, err)
+	assert.Equal(t, "https://login.local/", env.ActiveDirectoryEndpoint)
+	assert.Equal(t, "https://arm.local/", env.TokenAudience)
+	assert.Equal(t, "storage.local", env.StorageEndpointSuffix)
+
+	_, err = newEnvironment(&config.AzureConfig{Environment: "mars"})
+	assert.NotNil(t, err)
+
+	AzureEnv = nil
+	assert.Nil(t, initAzureEnv(&config.Config{Azure: &config.AzureConfig{Environment: "china"}}))
+	assert.Equal(t, "https://management.chinacloudapi.cn", resourceManagerURI())
+	AzureEnv = nil
+	assert.Equal(t, "https://management.azure.com", resourceManagerURI())
+}
+
+func TestRegisterHealth(t *testing.T) {
+	conf := &config.Config{Azure: &config.AzureConfig{SubscriptionID: "a68ae472-1849-4ed9-a700-24f5070acd2d"}}
+
+	// without a webhook only the collector is registered
+	e := &AzureExporter{healthCollector: NewMetricsCollectorAzureRmHealth(conf, vault.CloudCredentials{}, &log.Logger{})}
+	mux := http.NewServeMux()
+	e.registerHealth(conf, mux, prometheus.NewRegistry())
+	_, pattern := mux.Handler(httptest.NewRequest(http.MethodPost, "/webhook/azure/health", nil))
+	assert.Empty(t, pattern)
+
+	conf.Health = &config.HealthConfig{Webhook: &config.HealthWebhookConfig{Token: "secret"}}
+	e = &AzureExporter{healthCollector: NewMetricsCollectorAzureRmHealth(conf, vault.CloudCredentials{}, &log.Logger{})}
+	mux = http.NewServeMux()
+	registry := prometheus.NewRegistry()
+	e.registerHealth(conf, mux, registry)
+	recorder := httptest.NewRecorder()
+	mux.ServeHTTP(recorder, httptest.NewRequest(http.MethodPost, "/webhook/azure/health?token=secret", strings.NewReader(serviceHealthAlert)))
+	assert.Equal(t, http.StatusOK, recorder.Code)
+
+	handler := promhttp.HandlerFor(registry, promhttp.HandlerOpts{})
+	assert.HTTPBodyContains(t, handler.ServeHTTP, "GET", "/metrics", nil, "cpe_health_events_affected{affectedRegions=\"West Europe,North Europe\",affectedService=\"Virtual Machines\",eventID=\"/subscriptions/a68ae472-1849-4ed9-a700-24f5070acd2d/providers/Microsoft.ResourceHealth/events/JVJC-V88\"} 1")
+}
+
+func TestRegisterVaultBucket(t *testing.T) {
+	objectstore.InitDesc("AZURE")
+	e := &AzureExporter{bucketCollector: &MetricsCollectorAzureVaultBucket{conf: &config.Config{}}}
+	mux := http.NewServeMux()
+	e.registerVaultBucket(mux)
+	recorder := httptest.NewRecorder()
+	mux.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/bucket", nil))
+	assert.Equal(t, http.StatusOK, recorder.Code)
+}
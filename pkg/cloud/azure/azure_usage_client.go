@@ -0,0 +1,412 @@
+package azure
+
+import (
+	"context"
+	"encoding/json"
+	"math"
+	"net/http"
+	"strings"
+
+	"github.com/Azure/azure-sdk-for-go/profiles/latest/network/mgmt/network"
+	"github.com/Azure/azure-sdk-for-go/profiles/latest/resources/mgmt/subscriptions"
+	"github.com/Azure/go-autorest/autorest"
+	"github.com/Azure/go-autorest/autorest/azure"
+	"github.com/Azure/go-autorest/autorest/to"
+)
+
+// Names of the usage sources which can be enabled with AzureConfig.UsageSources
+const (
+	SourceCompute           = "compute"
+	SourceNetwork           = "network"
+	SourceStorage           = "storage"
+	SourceAppService        = "appService"
+	SourceSQL               = "sql"
+	SourceContainerInstance = "containerInstance"
+	SourceBatch             = "batch"
+	SourceCognitiveServices = "cognitiveServices"
+	SourcePublicIPPrefix    = "publicIPPrefix"
+)
+
+var defaultUsageSources = []string{SourceCompute, SourceNetwork, SourceStorage}
+
+// restUsageSources are the resource providers listing their usages with the common usages API
+var restUsageSources = map[string]struct {
+	provider   string
+	apiVersion string
+}{
+	SourceAppService:        {"Microsoft.Web", "2022-03-01"},
+	SourceSQL:               {"Microsoft.Sql", "2021-11-01"},
+	SourceContainerInstance: {"Microsoft.ContainerInstance", "2022-09-01"},
+	SourceCognitiveServices: {"Microsoft.CognitiveServices", "2023-05-01"},
+}
+
+const (
+	quotaAPIVersion = "2023-02-01"
+	batchAPIVersion = "2022-10-01"
+)
+
+// Usage is a quota of any source, with its ID normalized to <provider>/<name>
+type Usage struct {
+	ID           string
+	Name         string
+	Unit         string
+	CurrentValue float64
+	LimitValue   float64
+}
+
+// UsageClient lists the usages of one source in a location
+type UsageClient interface {
+	ListUsages(ctx context.Context, location string) ([]Usage, error)
+}
+
+// NormalizeQuotaID builds the ID of a quota that is the same whichever source reports it.
+func NormalizeQuotaID(provider, name string) string {
+	return strings.ToLower(provider + "/" + strings.ReplaceAll(name, " ", ""))
+}
+
+func normalizeLocation(location string) string {
+	return strings.ToLower(strings.ReplaceAll(location, " ", ""))
+}
+
+// unlimited tells if a limit stands for no limit, which the APIs report as -1. A limit at the maximum of the
+// integer type of an API is a real quota that can be raised, and is exported.
+func unlimited(limit float64) bool {
+	return limit < 0
+}
+
+type ComputeUsages struct {
+	client ComputeClient
+}
+
+func (u *ComputeUsages) ListUsages(ctx context.Context, location string) ([]Usage, error) {
+	var usages []Usage
+	it, err := u.client.ListComplete(ctx, location)
+	for ; err == nil && it.NotDone(); err = it.NextWithContext(ctx) {
+		i := it.Value()
+		usages = append(usages, Usage{NormalizeQuotaID("Microsoft.Compute", to.String(i.Name.Value)), to.String(i.Name.LocalizedValue), to.String(i.Unit),
+			float64(to.Int32(i.CurrentValue)), float64(to.Int64(i.Limit))})
+	}
+	return usages, err
+}
+
+type NetworkUsages struct {
+	client NetworkClient
+}
+
+func (u *NetworkUsages) ListUsages(ctx context.Context, location string) ([]Usage, error) {
+	var usages []Usage
+	it, err := u.client.ListComplete(ctx, location)
+	for ; err == nil && it.NotDone(); err = it.NextWithContext(ctx) {
+		i := it.Value()
+		usages = append(usages, Usage{NormalizeQuotaID("Microsoft.Network", to.String(i.Name.Value)), to.String(i.Name.LocalizedValue), to.String(i.Unit),
+			float64(to.Int64(i.CurrentValue)), float64(to.Int64(i.Limit))})
+	}
+	return usages, err
+}
+
+type StorageUsages struct {
+	client StorageClient
+}
+
+func (u *StorageUsages) ListUsages(ctx context.Context, location string) ([]Usage, error) {
+	result, err := u.client.ListByLocation(ctx, location)
+	if err != nil || result.Value == nil {
+		return nil, err
+	}
+	var usages []Usage
+	for _, i := range *result.Value {
+		usages = append(usages, Usage{NormalizeQuotaID("Microsoft.Storage", to.String(i.Name.Value)), to.String(i.Name.LocalizedValue), string(i.Unit),
+			float64(to.Int32(i.CurrentValue)), float64(to.Int32(i.Limit))})
+	}
+	return usages, nil
+}
+
+type PublicIPPrefixClient interface {
+	ListAllComplete(ctx context.Context) (result network.PublicIPPrefixListResultIterator, err error)
+	SetAuth(auth autorest.Authorizer)
+}
+
+type PublicIPPrefixClientWrapper struct {
+	client network.PublicIPPrefixesClient
+}
+
+func (cw *PublicIPPrefixClientWrapper) ListAllComplete(ctx context.Context) (result network.PublicIPPrefixListResultIterator, err error) {
+	return cw.client.ListAllComplete(ctx)
+}
+
+func (cw *PublicIPPrefixClientWrapper) SetAuth(auth autorest.Authorizer) {
+	cw.client.Authorizer = auth
+}
+
+func NewPublicIPPrefixClientWrapper(baseURI, id string) *PublicIPPrefixClientWrapper {
+	cw := &PublicIPPrefixClientWrapper{client: network.NewPublicIPPrefixesClientWithBaseURI(baseURI, id)}
+	return cw
+}
+
+// PublicIPPrefixUsages reports the public IP prefixes of the location against their own quota, and the addresses
+// reserved by them, which count against the standard public IP addresses of the location.
+type PublicIPPrefixUsages struct {
+	prefixClient  PublicIPPrefixClient
+	networkClient NetworkClient
+}
+
+func (u *PublicIPPrefixUsages) ListUsages(ctx context.Context, location string) ([]Usage, error) {
+	networkUsages, err := (&NetworkUsages{u.networkClient}).ListUsages(ctx, location)
+	if err != nil {
+		return nil, err
+	}
+	prefixLimit, addressLimit := -1.0, -1.0
+	for _, usage := range networkUsages {
+		switch usage.ID {
+		case NormalizeQuotaID("Microsoft.Network", "PublicIPPrefixes"):
+			prefixLimit = usage.LimitValue
+		case NormalizeQuotaID("Microsoft.Network", "StandardPublicIPAddresses"):
+			addressLimit = usage.LimitValue
+		}
+	}
+
+	var prefixes, addresses float64
+	it, err := u.prefixClient.ListAllComplete(ctx)
+	for ; err == nil && it.NotDone(); err = it.NextWithContext(ctx) {
+		prefix := it.Value()
+		if normalizeLocation(to.String(prefix.Location)) != normalizeLocation(location) || prefix.PublicIPPrefixPropertiesFormat == nil {
+			continue
+		}
+		prefixes++
+		if length := to.Int32(prefix.PrefixLength); length > 0 && length <= 32 {
+			addresses += math.Exp2(float64(32 - length))
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	return []Usage{
+		{NormalizeQuotaID("Microsoft.Network", "PublicIPPrefixAddresses"), "Public IP Addresses in Prefixes", "Count", addresses, addressLimit},
+		{NormalizeQuotaID("Microsoft.Network", "PublicIPPrefixes"), "Public IP Prefixes", "Count", prefixes, prefixLimit},
+	}, nil
+}
+
+// restClient calls the ARM APIs which have no SDK client. Unlike the generated clients it does not register
+// resource providers on the fly, an unregistered provider is reported as an error.
+type restClient struct {
+	autorest.Client
+	BaseURI        string
+	SubscriptionID string
+}
+
+func newRestClient(baseURI, subscriptionID string) restClient {
+	return restClient{
+		Client:         autorest.NewClientWithUserAgent(subscriptions.UserAgent()),
+		BaseURI:        baseURI,
+		SubscriptionID: subscriptionID,
+	}
+}
+
+func (c *restClient) SetAuth(auth autorest.Authorizer) {
+	c.Authorizer = auth
+}
+
+// list gets path and follows the nextLink of the pages decoded into page by respond until the last page.
+func (c *restClient) list(ctx context.Context, path, apiVersion string, respond func(resp *http.Response) (*string, error)) error {
+	req, err := autorest.CreatePreparer(
+		autorest.AsGet(),
+		autorest.WithBaseURL(c.BaseURI),
+		autorest.WithPath(path),
+		autorest.WithQueryParameters(map[string]interface{}{"api-version": apiVersion})).Prepare((&http.Request{}).WithContext(ctx))
+	if err != nil {
+		return autorest.NewErrorWithError(err, "azure.restClient", path, nil, "Failure preparing request")
+	}
+	for {
+		resp, err := c.Send(req, autorest.DoRetryForStatusCodes(c.RetryAttempts, c.RetryDuration, autorest.StatusCodesForRetry...))
+		if err != nil {
+			return autorest.NewErrorWithError(err, "azure.restClient", path, resp, "Failure sending request")
+		}
+		nextLink, err := respond(resp)
+		if err != nil {
+			return autorest.NewErrorWithError(err, "azure.restClient", path, resp, "Failure responding to request")
+		}
+		if to.String(nextLink) == "" {
+			return nil
+		}
+		req, err = autorest.Prepare((&http.Request{}).WithContext(ctx), autorest.AsJSON(), autorest.AsGet(), autorest.WithBaseURL(to.String(nextLink)))
+		if err != nil {
+			return autorest.NewErrorWithError(err, "azure.restClient", path, nil, "Failure preparing next results request")
+		}
+	}
+}
+
+func (c *restClient) locationPath(provider, location, resource string) string {
+	return "/subscriptions/" + autorest.Encode("path", c.SubscriptionID) + "/providers/" + provider + "/locations/" + autorest.Encode("path", location) + "/" + resource
+}
+
+// usageName is either a name object, or the plain name of the SQL usages
+type usageName struct {
+	Value          string `json:"value"`
+	LocalizedValue string `json:"localizedValue"`
+}
+
+func (n *usageName) UnmarshalJSON(data []byte) error {
+	if len(data) > 0 && data[0] == '"' {
+		return json.Unmarshal(data, &n.Value)
+	}
+	type plain usageName
+	return json.Unmarshal(data, (*plain)(n))
+}
+
+type usageList struct {
+	Value []struct {
+		Name         usageName `json:"name"`
+		Unit         *string   `json:"unit"`
+		CurrentValue *float64  `json:"currentValue"`
+		Limit        *float64  `json:"limit"`
+		Properties   *struct {
+			DisplayName  *string  `json:"displayName"`
+			Unit         *string  `json:"unit"`
+			CurrentValue *float64 `json:"currentValue"`
+			Limit        *float64 `json:"limit"`
+		} `json:"properties"`
+	} `json:"value"`
+	NextLink *string `json:"nextLink"`
+}
+
+// ProviderUsagesClient lists the usages of a resource provider from its locations/{location}/usages API
+type ProviderUsagesClient struct {
+	restClient
+	provider   string
+	apiVersion string
+}
+
+func NewProviderUsagesClient(baseURI, subscriptionID, provider, apiVersion string) *ProviderUsagesClient {
+	return &ProviderUsagesClient{newRestClient(baseURI, subscriptionID), provider, apiVersion}
+}
+
+func (c *ProviderUsagesClient) ListUsages(ctx context.Context, location string) ([]Usage, error) {
+	var usages []Usage
+	err := c.list(ctx, c.locationPath(c.provider, location, "usages"), c.apiVersion, func(resp *http.Response) (*string, error) {
+		page := usageList{}
+		err := autorest.Respond(resp, azure.WithErrorUnlessStatusCode(http.StatusOK), autorest.ByUnmarshallingJSON(&page), autorest.ByClosing())
+		for _, v := range page.Value {
+			usage := Usage{ID: NormalizeQuotaID(c.provider, v.Name.Value), Name: v.Name.LocalizedValue, Unit: to.String(v.Unit),
+				CurrentValue: valueOf(v.CurrentValue), LimitValue: valueOf(v.Limit)}
+			if p := v.Properties; p != nil {
+				usage.Name, usage.Unit = to.String(p.DisplayName), to.String(p.Unit)
+				usage.CurrentValue, usage.LimitValue = valueOf(p.CurrentValue), valueOf(p.Limit)
+			}
+			usages = append(usages, usage)
+		}
+		return page.NextLink, err
+	})
+	return usages, err
+}
+
+func valueOf(v *float64) float64 {
+	if v == nil {
+		return 0
+	}
+	return *v
+}
+
+// BatchUsagesClient reports the Batch account quota of a location, whose usage is the number of accounts in it
+type BatchUsagesClient struct {
+	restClient
+}
+
+func NewBatchUsagesClient(baseURI, subscriptionID string) *BatchUsagesClient {
+	return &BatchUsagesClient{newRestClient(baseURI, subscriptionID)}
+}
+
+func (c *BatchUsagesClient) ListUsages(ctx context.Context, location string) ([]Usage, error) {
+	quota := struct {
+		AccountQuota *float64 `json:"accountQuota"`
+	}{}
+	err := c.list(ctx, c.locationPath("Microsoft.Batch", location, "quotas"), batchAPIVersion, func(resp *http.Response) (*string, error) {
+		return nil, autorest.Respond(resp, azure.WithErrorUnlessStatusCode(http.StatusOK), autorest.ByUnmarshallingJSON(&quota), autorest.ByClosing())
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var accounts float64
+	err = c.list(ctx, "/subscriptions/"+autorest.Encode("path", c.SubscriptionID)+"/providers/Microsoft.Batch/batchAccounts", batchAPIVersion, func(resp *http.Response) (*string, error) {
+		page := struct {
+			Value []struct {
+				Location string `json:"location"`
+			} `json:"value"`
+			NextLink *string `json:"nextLink"`
+		}{}
+		err := autorest.Respond(resp, azure.WithErrorUnlessStatusCode(http.StatusOK), autorest.ByUnmarshallingJSON(&page), autorest.ByClosing())
+		for _, account := range page.Value {
+			if normalizeLocation(account.Location) == normalizeLocation(location) {
+				accounts++
+			}
+		}
+		return page.NextLink, err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return []Usage{{NormalizeQuotaID("Microsoft.Batch", "BatchAccounts"), "Batch Accounts", "Count", accounts, valueOf(quota.AccountQuota)}}, nil
+}
+
+type quotaAPIList struct {
+	Value []struct {
+		Properties struct {
+			Name              usageName `json:"name"`
+			Unit              string    `json:"unit"`
+			IsQuotaApplicable *bool     `json:"isQuotaApplicable"`
+			Usages            *struct {
+				Value float64 `json:"value"`
+			} `json:"usages"`
+			Limit *struct {
+				LimitObjectType string  `json:"limitObjectType"`
+				Value           float64 `json:"value"`
+			} `json:"limit"`
+		} `json:"properties"`
+	} `json:"value"`
+	NextLink *string `json:"nextLink"`
+}
+
+// QuotaAPIClient lists the quotas of a resource provider from the Microsoft.Quota API, joining their usages and limits
+type QuotaAPIClient struct {
+	restClient
+	provider string
+}
+
+func NewQuotaAPIClient(baseURI, subscriptionID, provider string) *QuotaAPIClient {
+	return &QuotaAPIClient{newRestClient(baseURI, subscriptionID), provider}
+}
+
+func (c *QuotaAPIClient) ListUsages(ctx context.Context, location string) ([]Usage, error) {
+	scope := c.locationPath(c.provider, location, "providers/Microsoft.Quota/")
+	var usages []Usage
+	index := make(map[string]int)
+	err := c.list(ctx, scope+"usages", quotaAPIVersion, func(resp *http.Response) (*string, error) {
+		page := quotaAPIList{}
+		err := autorest.Respond(resp, azure.WithErrorUnlessStatusCode(http.StatusOK), autorest.ByUnmarshallingJSON(&page), autorest.ByClosing())
+		for _, v := range page.Value {
+			p := v.Properties
+			if (p.IsQuotaApplicable != nil && !*p.IsQuotaApplicable) || p.Usages == nil {
+				continue
+			}
+			id := NormalizeQuotaID(c.provider, p.Name.Value)
+			index[id] = len(usages)
+			usages = append(usages, Usage{id, p.Name.LocalizedValue, p.Unit, p.Usages.Value, -1})
+		}
+		return page.NextLink, err
+	})
+	if err != nil {
+		return nil, err
+	}
+	err = c.list(ctx, scope+"quotas", quotaAPIVersion, func(resp *http.Response) (*string, error) {
+		page := quotaAPIList{}
+		err := autorest.Respond(resp, azure.WithErrorUnlessStatusCode(http.StatusOK), autorest.ByUnmarshallingJSON(&page), autorest.ByClosing())
+		for _, v := range page.Value {
+			p := v.Properties
+			if i, ok := index[NormalizeQuotaID(c.provider, p.Name.Value)]; ok && p.Limit != nil && p.Limit.LimitObjectType == "LimitValue" {
+				usages[i].LimitValue = p.Limit.Value
+			}
+		}
+		return page.NextLink, err
+	})
+	return usages, err
+}
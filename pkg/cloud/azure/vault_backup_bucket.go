@@ -2,13 +2,15 @@ package azure
 
 import (
 	"context"
+	"fmt"
 	"github.com/Azure/azure-storage-blob-go/azblob"
+	"github.com/Azure/go-autorest/autorest/to"
 	"github.com/prometheus/client_golang/prometheus"
 	log "github.com/sirupsen/logrus"
-	"github.wdf.sap.corp/DBaaS/cloud-provider-exporter/pkg/cloud/common"
+	"github.wdf.sap.corp/DBaaS/cloud-provider-exporter/pkg/cloud/objectstore"
 	"github.wdf.sap.corp/DBaaS/cloud-provider-exporter/pkg/config"
 	"github.wdf.sap.corp/DBaaS/cloud-provider-exporter/pkg/vault"
-	"time"
+	"io"
 )
 
 type IAzureClient interface {
@@ -20,106 +22,98 @@ type ContainerWrapper struct {
 }
 
 func (cw *ContainerWrapper) ContainerService(bucketName string, accountName string) (IContainer, error) {
-	return cw.client.ContainerService(bucketName, accountName)
+	containerURL, err := cw.client.ContainerService(bucketName, accountName)
+	if err != nil {
+		return nil, err
+	}
+	return &ContainerURLWrapper{*containerURL}, nil
 }
 
 type IContainer interface {
 	ListBlobsFlatSegment(ctx context.Context, marker azblob.Marker, o azblob.ListBlobsSegmentOptions) (*azblob.ListBlobsFlatSegmentResponse, error)
+	DownloadBlob(ctx context.Context, blobName string, offset, count int64) (io.ReadCloser, error)
+}
+
+type ContainerURLWrapper struct {
+	azblob.ContainerURL
+}
+
+func (cw *ContainerURLWrapper) DownloadBlob(ctx context.Context, blobName string, offset, count int64) (io.ReadCloser, error) {
+	resp, err := cw.NewBlobURL(blobName).Download(ctx, offset, count, azblob.BlobAccessConditions{}, false, azblob.ClientProvidedKeyOptions{})
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body(azblob.RetryReaderOptions{}), nil
 }
 
 type MetricsCollectorAzureVaultBucket struct {
-	conf   *config.Config
-	client IAzureClient
+	conf     *config.Config
+	client   IAzureClient
+	accounts StorageAccountClient
 }
 
 func NewMetricsCollectorAzureVaultBucket(config *config.Config, cred vault.CloudCredentials) *MetricsCollectorAzureVaultBucket {
 	m := &MetricsCollectorAzureVaultBucket{}
-	storageClient, err := NewAzureClient(cred[vault.AzureClientID], cred[vault.AzureClientSecret], cred[vault.AzureTenantID], cred[vault.AzureSubscriptionID], config.VaultBackupBucket.Bucket)
+	storageClient, err := NewAzureClient(cred[vault.AzureClientID], cred[vault.AzureClientSecret], cred[vault.AzureTenantID], cred[vault.AzureSubscriptionID], "")
 	if err != nil {
 		log.Fatal("Error while getting storage client: ", err)
 	}
 	c := ContainerWrapper{client: storageClient}
 	m.conf = config
 	m.client = &c
+	if authorizer, err := newAuthorizer(cred); err != nil {
+		log.Errorf("Error while getting authorizer, the bucket compliance is not checked: %v", err)
+	} else {
+		accounts := NewStorageAccountClientWrapper(resourceManagerURI(), cred[vault.AzureSubscriptionID])
+		accounts.SetAuth(authorizer)
+		m.accounts = accounts
+	}
 
-	common.InitVaultBackupBucketDesc("", "AZURE", []string{"bucket", "prefix"})
+	objectstore.InitDesc("AZURE")
 	return m
 }
 
 func (m *MetricsCollectorAzureVaultBucket) Describe(ch chan<- *prometheus.Desc) {
-	ch <- common.ListSuccess
-	ch <- common.LastModifiedObjectDate
-	ch <- common.LastModifiedObjectSize
-	ch <- common.ObjectTotal
-	ch <- common.SumSize
-	ch <- common.BiggestSize
+	objectstore.Describe(ch)
 }
 
 func (m *MetricsCollectorAzureVaultBucket) Collect(ch chan<- prometheus.Metric) {
-	var lastModified time.Time
-	var createdTime time.Time
-	var numberOfObjects float64
-	var totalSize int64
-	var biggestObjectSize int64
-	var lastObjectSize int64
+	targets := objectstore.NewTargets(objectstore.Targets(m.conf), &BlobLister{m.client, m.accounts})
+	objectstore.Collect(context.Background(), ch, targets, log.StandardLogger())
+}
 
-	bucketName := m.conf.VaultBackupBucket.Bucket
-	accountName := bucketName
-	prefix := m.conf.VaultBackupBucket.Prefix
+// BlobLister lists the blobs of containers, each in the storage account of the same name
+type BlobLister struct {
+	client   IAzureClient
+	accounts StorageAccountClient
+}
 
-	containerService, err := m.client.ContainerService(bucketName, accountName)
+func (l *BlobLister) ListObjects(ctx context.Context, bucket, prefix string, fn func(objectstore.Object)) error {
+	containerService, err := l.client.ContainerService(bucket, bucket)
 	if err != nil {
-		log.Fatalf("could not create container service for bucket %s: %v", bucketName, err)
+		return fmt.Errorf("could not create container service for bucket %s: %w", bucket, err)
 	}
-
 	options := azblob.ListBlobsSegmentOptions{
 		Details: azblob.BlobListingDetails{Snapshots: false, Metadata: true},
 		Prefix:  prefix,
 	}
-
 	for marker := (azblob.Marker{}); marker.NotDone(); {
-		var listBlob *azblob.ListBlobsFlatSegmentResponse
-
-		listBlob, err = containerService.ListBlobsFlatSegment(context.Background(), marker, options)
-
+		listBlob, err := containerService.ListBlobsFlatSegment(ctx, marker, options)
 		if err != nil {
-			log.Fatalf("could not list objects in bucket %s: %v", bucketName, err)
-			ch <- prometheus.MustNewConstMetric(
-				common.ListSuccess, prometheus.GaugeValue, 0, bucketName, prefix,
-			)
+			return err
 		}
-
 		marker = listBlob.NextMarker
-
 		for _, blobInfo := range listBlob.Segment.BlobItems {
-			numberOfObjects++
-			totalSize = totalSize + *blobInfo.Properties.ContentLength
-			if blobInfo.Properties.LastModified.After(lastModified) {
-				lastModified = blobInfo.Properties.LastModified
-				lastObjectSize = *blobInfo.Properties.ContentLength
-			}
-			if *blobInfo.Properties.ContentLength > biggestObjectSize {
-				biggestObjectSize = *blobInfo.Properties.ContentLength
-			}
+			fn(objectstore.Object{Key: blobInfo.Name, Size: to.Int64(blobInfo.Properties.ContentLength), LastModified: blobInfo.Properties.LastModified, MD5: blobInfo.Properties.ContentMD5})
 		}
 	}
+	return nil
+}
 
-	ch <- prometheus.MustNewConstMetric(
-		common.ListSuccess, prometheus.GaugeValue, 1, bucketName, prefix,
-	)
-	ch <- prometheus.MustNewConstMetric(
-		common.LastModifiedObjectDate, prometheus.GaugeValue, float64(createdTime.UnixNano()/1e9), bucketName, prefix,
-	)
-	ch <- prometheus.MustNewConstMetric(
-		common.LastModifiedObjectSize, prometheus.GaugeValue, float64(lastObjectSize), bucketName, prefix,
-	)
-	ch <- prometheus.MustNewConstMetric(
-		common.ObjectTotal, prometheus.GaugeValue, numberOfObjects, bucketName, prefix,
-	)
-	ch <- prometheus.MustNewConstMetric(
-		common.BiggestSize, prometheus.GaugeValue, float64(biggestObjectSize), bucketName, prefix,
-	)
-	ch <- prometheus.MustNewConstMetric(
-		common.SumSize, prometheus.GaugeValue, float64(totalSize), bucketName, prefix,
-	)
+func (l *BlobLister) ReadObject(ctx context.Context, bucket, key string, offset, length int64) (io.ReadCloser, error) {
+	containerService, err := l.client.ContainerService(bucket, bucket)
+	if err != nil {
+		return nil, fmt.Errorf("could not create container service for bucket %s: %w", bucket, err)
+	}
+	return containerService.DownloadBlob(ctx, key, offset, length)
 }
@@ -1,16 +1,20 @@
 package azure
 
 import (
+	"bytes"
 	"context"
+	"github.com/Azure/azure-sdk-for-go/profiles/latest/storage/mgmt/storage"
 	"github.com/Azure/azure-storage-blob-go/azblob"
+	"github.com/Azure/go-autorest/autorest"
 	"github.com/Azure/go-autorest/autorest/to"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/stretchr/testify/assert"
-	"github.wdf.sap.corp/DBaaS/cloud-provider-exporter/pkg/cloud/common"
+	"github.wdf.sap.corp/DBaaS/cloud-provider-exporter/pkg/cloud/objectstore"
 	"github.wdf.sap.corp/DBaaS/cloud-provider-exporter/pkg/config"
 	"github.wdf.sap.corp/DBaaS/cloud-provider-exporter/pkg/constant"
 	"github.wdf.sap.corp/DBaaS/cloud-provider-exporter/pkg/vault"
+	"io"
 	"net/http"
 	"testing"
 	"time"
@@ -51,11 +55,51 @@ func (m *MockContainer) ListBlobsFlatSegment(ctx context.Context, marker azblob.
 	}, nil
 }
 
+func (m *MockContainer) DownloadBlob(ctx context.Context, blobName string, offset, count int64) (io.ReadCloser, error) {
+	return io.NopCloser(bytes.NewReader(make([]byte, count))), nil
+}
+
+type MockStorageAccountClient struct {
+}
+
+func (m *MockStorageAccountClient) GetAccount(ctx context.Context, name string) (storage.Account, string, error) {
+	return storage.Account{
+		ID:   to.StringPtr("/subscriptions/subscriptionID/resourceGroups/backup/providers/Microsoft.Storage/storageAccounts/" + name),
+		Name: to.StringPtr(name),
+		AccountProperties: &storage.AccountProperties{
+			Encryption:            &storage.Encryption{Services: &storage.EncryptionServices{Blob: &storage.EncryptionService{Enabled: to.BoolPtr(true)}}},
+			AllowBlobPublicAccess: to.BoolPtr(true),
+		},
+	}, "backup", nil
+}
+
+func (m *MockStorageAccountClient) GetBlobServiceProperties(ctx context.Context, resourceGroup, account string) (storage.BlobServiceProperties, error) {
+	return storage.BlobServiceProperties{BlobServicePropertiesProperties: &storage.BlobServicePropertiesProperties{
+		IsVersioningEnabled:   to.BoolPtr(true),
+		DeleteRetentionPolicy: &storage.DeleteRetentionPolicy{Enabled: to.BoolPtr(false)},
+	}}, nil
+}
+
+func (m *MockStorageAccountClient) GetManagementPolicy(ctx context.Context, resourceGroup, account string) (storage.ManagementPolicy, error) {
+	return storage.ManagementPolicy{}, autorest.DetailedError{StatusCode: http.StatusNotFound}
+}
+
+func (m *MockStorageAccountClient) GetContainer(ctx context.Context, resourceGroup, account, container string) (storage.BlobContainer, error) {
+	return storage.BlobContainer{ContainerProperties: &storage.ContainerProperties{
+		PublicAccess:          storage.PublicAccessBlob,
+		HasImmutabilityPolicy: to.BoolPtr(true),
+	}}, nil
+}
+
+func (m *MockStorageAccountClient) SetAuth(auth autorest.Authorizer) {
+}
+
 func NewMockMetricsCollectorAzureVaultBucket(config *config.Config, cred vault.CloudCredentials) *MetricsCollectorAzureVaultBucket {
 	m := &MetricsCollectorAzureVaultBucket{}
 	m.conf = config
 	m.client = &MockAzureClient{}
-	common.InitVaultBackupBucketDesc("", "AZURE", []string{"bucket", "prefix"})
+	m.accounts = &MockStorageAccountClient{}
+	objectstore.InitDesc("AZURE")
 	return m
 }
 
@@ -84,9 +128,17 @@ func TestAzureVaultBucket(t *testing.T) {
 		h.ServeHTTP(w, r)
 	})
 
-	assert.HTTPBodyContains(t, handler, "GET", uri, nil, "cpe_vault_object_list_success{bucket=\"mock_bucket\",prefix=\"mock_prefix\"} 1")
-	assert.HTTPBodyContains(t, handler, "GET", uri, nil, "cpe_vault_object_last_modified_size_bytes{bucket=\"mock_bucket\",prefix=\"mock_prefix\"} 200")
-	assert.HTTPBodyContains(t, handler, "GET", uri, nil, "cpe_vault_object_count{bucket=\"mock_bucket\",prefix=\"mock_prefix\"} 2")
-	assert.HTTPBodyContains(t, handler, "GET", uri, nil, "cpe_vault_object_max_size_bytes{bucket=\"mock_bucket\",prefix=\"mock_prefix\"} 200")
-	assert.HTTPBodyContains(t, handler, "GET", uri, nil, "cpe_vault_object_size_bytes_total{bucket=\"mock_bucket\",prefix=\"mock_prefix\"} 300")
+	assert.HTTPBodyContains(t, handler, "GET", uri, nil, "cpe_vault_object_list_success{bucket=\"mock_bucket\",group=\"\",prefix=\"mock_prefix\"} 1")
+	// the last modification of the blobs, 2020-06-13T21:00:00Z
+	assert.HTTPBodyContains(t, handler, "GET", uri, nil, "cpe_vault_object_last_modified_date{bucket=\"mock_bucket\",group=\"\",prefix=\"mock_prefix\"} 1.592082e+09")
+	assert.HTTPBodyContains(t, handler, "GET", uri, nil, "cpe_vault_object_last_modified_size_bytes{bucket=\"mock_bucket\",group=\"\",prefix=\"mock_prefix\"} 200")
+	assert.HTTPBodyContains(t, handler, "GET", uri, nil, "cpe_vault_object_count{bucket=\"mock_bucket\",group=\"\",prefix=\"mock_prefix\"} 2")
+	assert.HTTPBodyContains(t, handler, "GET", uri, nil, "cpe_vault_object_max_size_bytes{bucket=\"mock_bucket\",group=\"\",prefix=\"mock_prefix\"} 200")
+	assert.HTTPBodyContains(t, handler, "GET", uri, nil, "cpe_vault_object_size_bytes_total{bucket=\"mock_bucket\",group=\"\",prefix=\"mock_prefix\"} 300")
+	assert.HTTPBodyContains(t, handler, "GET", uri, nil, "cpe_bucket_compliance{bucket=\"mock_bucket\",check=\"versioning\",group=\"\",prefix=\"mock_prefix\"} 1")
+	assert.HTTPBodyContains(t, handler, "GET", uri, nil, "cpe_bucket_compliance{bucket=\"mock_bucket\",check=\"encryption\",group=\"\",prefix=\"mock_prefix\"} 1")
+	assert.HTTPBodyContains(t, handler, "GET", uri, nil, "cpe_bucket_compliance{bucket=\"mock_bucket\",check=\"object_lock\",group=\"\",prefix=\"mock_prefix\"} 1")
+	// neither soft delete nor a management policy
+	assert.HTTPBodyContains(t, handler, "GET", uri, nil, "cpe_bucket_compliance{bucket=\"mock_bucket\",check=\"lifecycle\",group=\"\",prefix=\"mock_prefix\"} 0")
+	assert.HTTPBodyContains(t, handler, "GET", uri, nil, "cpe_bucket_compliance{bucket=\"mock_bucket\",check=\"public_access_blocked\",group=\"\",prefix=\"mock_prefix\"} 0")
 }
@@ -12,10 +12,14 @@ var (
 	QuotaCurrent *prometheus.GaugeVec
 	QuotaLimit   *prometheus.GaugeVec
 
-	HealthEvent       *prometheus.CounterVec
-	AffectedEntity    *prometheus.CounterVec
+	HealthEvent       *prometheus.Desc
+	AffectedEntity    *prometheus.Desc
 	HealthOpenedTotal *prometheus.CounterVec
 	HealthClosedTotal *prometheus.CounterVec
+	HealthUpdateTotal *prometheus.CounterVec
+	HealthSuppressed  *prometheus.CounterVec
+	HealthWebhook     *prometheus.CounterVec
+	HealthDuration    *prometheus.HistogramVec
 
 	ListSuccess            *prometheus.Desc
 	LastModifiedObjectDate *prometheus.Desc
@@ -23,6 +27,15 @@ var (
 	ObjectTotal            *prometheus.Desc
 	SumSize                *prometheus.Desc
 	BiggestSize            *prometheus.Desc
+	BackupAge              *prometheus.Desc
+	ExpectedInterval       *prometheus.Desc
+	FreshnessViolation     *prometheus.Desc
+	SizeDelta              *prometheus.Desc
+	BackupAnomaly          *prometheus.Desc
+	VerifySuccess          *prometheus.Desc
+	VerifyDuration         *prometheus.Desc
+	BucketCompliance       *prometheus.Desc
+	OldestObjectAge        *prometheus.Desc
 	QuotaCache             ICache
 )
 
@@ -56,18 +69,16 @@ func InitQuotaGaugeVec(cloudProvider string, labels []string) {
 }
 
 func InitHealthCounterVec(cloudProvider string, eventLabels, entityLabels, openedTotalLabels, closedTotalLabels []string) {
-	HealthEvent = prometheus.NewCounterVec(
-		prometheus.CounterOpts{
-			Name: constant.HealthEvent,
-			Help: strings.Join([]string{cloudProvider, constant.HelpHealthEvent}, " "),
-		}, eventLabels,
+	HealthEvent = prometheus.NewDesc(
+		constant.HealthEvent,
+		strings.Join([]string{cloudProvider, constant.HelpHealthEvent}, " "),
+		eventLabels, nil,
 	)
 
-	AffectedEntity = prometheus.NewCounterVec(
-		prometheus.CounterOpts{
-			Name: constant.HealthAffected,
-			Help: strings.Join([]string{cloudProvider, constant.HelpHealthAffected}, " "),
-		}, entityLabels,
+	AffectedEntity = prometheus.NewDesc(
+		constant.HealthAffected,
+		strings.Join([]string{cloudProvider, constant.HelpHealthAffected}, " "),
+		entityLabels, nil,
 	)
 
 	HealthOpenedTotal = prometheus.NewCounterVec(
@@ -83,6 +94,35 @@ func InitHealthCounterVec(cloudProvider string, eventLabels, entityLabels, opene
 			Help: strings.Join([]string{cloudProvider, constant.HelpHealthEventClosedTotal}, " "),
 		}, closedTotalLabels,
 	)
+
+	HealthUpdateTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: constant.HealthEventUpdateTotal,
+			Help: strings.Join([]string{cloudProvider, constant.HelpHealthEventUpdatedTotal}, " "),
+		}, openedTotalLabels,
+	)
+
+	HealthSuppressed = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: constant.HealthEventSuppressedTotal,
+			Help: strings.Join([]string{cloudProvider, constant.HelpHealthEventSuppressedTotal}, " "),
+		}, []string{"reason"},
+	)
+
+	HealthWebhook = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: constant.HealthWebhookRequestTotal,
+			Help: strings.Join([]string{cloudProvider, constant.HelpHealthWebhookRequestTotal}, " "),
+		}, []string{"source", "result"},
+	)
+
+	HealthDuration = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    constant.HealthEventDuration,
+			Help:    strings.Join([]string{cloudProvider, constant.HelpHealthEventDuration}, " "),
+			Buckets: []float64{300, 900, 1800, 3600, 7200, 14400, 28800, 86400, 259200, 604800},
+		}, []string{"cloudService", "eventType"},
+	)
 }
 
 func InitVaultBackupBucketDesc(namespace, cloudProvider string, labels []string) {
@@ -116,4 +156,49 @@ func InitVaultBackupBucketDesc(namespace, cloudProvider string, labels []string)
 		cloudProvider+constant.HelpVaultBackupBucketBiggestSize,
 		labels, nil,
 	)
+	BackupAge = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "", constant.VaultBackupAge),
+		cloudProvider+constant.HelpVaultBackupBucketBackupAge,
+		labels, nil,
+	)
+	ExpectedInterval = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "", constant.VaultExpectedInterval),
+		cloudProvider+constant.HelpVaultBackupBucketExpectedInterval,
+		labels, nil,
+	)
+	FreshnessViolation = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "", constant.VaultFreshnessViolation),
+		cloudProvider+constant.HelpVaultBackupBucketFreshnessViolation,
+		labels, nil,
+	)
+	SizeDelta = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "", constant.VaultSizeDelta),
+		cloudProvider+constant.HelpVaultBackupBucketSizeDelta,
+		labels, nil,
+	)
+	BackupAnomaly = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "", constant.VaultAnomaly),
+		cloudProvider+constant.HelpVaultBackupBucketAnomaly,
+		labels, nil,
+	)
+	VerifySuccess = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "", constant.BackupVerifySuccess),
+		cloudProvider+constant.HelpBackupVerifySuccess,
+		labels, nil,
+	)
+	VerifyDuration = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "", constant.BackupVerifyDuration),
+		cloudProvider+constant.HelpBackupVerifyDuration,
+		labels, nil,
+	)
+	BucketCompliance = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "", constant.BucketCompliance),
+		cloudProvider+constant.HelpBucketCompliance,
+		append(append([]string{}, labels...), constant.LabelCheck), nil,
+	)
+	OldestObjectAge = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "", constant.VaultOldestAge),
+		cloudProvider+constant.HelpVaultBackupBucketOldestAge,
+		labels, nil,
+	)
 }
@@ -0,0 +1,78 @@
+package common
+
+import (
+	"context"
+	"strings"
+
+	"github.wdf.sap.corp/DBaaS/cloud-provider-exporter/pkg/config"
+)
+
+const (
+	SuppressedByService  = "service"
+	SuppressedByRegion   = "region"
+	SuppressedByResource = "resource"
+)
+
+// HealthResourceOwner is implemented by health sources that can tell whether an affected
+// resource belongs to us. The returned matcher is only valid for one poll.
+type HealthResourceOwner interface {
+	ResourceMatcher(ctx context.Context) (func(resource string) bool, error)
+}
+
+// HealthEventFilter drops the events that do not concern us before they reach the HealthPoller store.
+type HealthEventFilter struct {
+	services            map[string]struct{}
+	regions             map[string]struct{}
+	affectsOwnResources bool
+}
+
+func NewHealthEventFilter(conf *config.HealthFilterConfig) *HealthEventFilter {
+	f := &HealthEventFilter{
+		services:            toLowerSet(conf.Services),
+		regions:             toLowerSet(conf.Regions),
+		affectsOwnResources: conf.AffectsOwnResources,
+	}
+	return f
+}
+
+// Suppress returns the reason why r is filtered out, or an empty string if r is kept.
+// Events without region information are global and pass the region filter, events without
+// affected resources concern the whole account and pass the resource filter.
+func (f *HealthEventFilter) Suppress(r *HealthEventRecord, owns func(resource string) bool) string {
+	services := r.Services
+	if len(services) == 0 {
+		services = []string{r.Service}
+	}
+	if len(f.services) > 0 && !containsAny(f.services, services) {
+		return SuppressedByService
+	}
+	if len(f.regions) > 0 && len(r.Regions) > 0 && !containsAny(f.regions, r.Regions) {
+		return SuppressedByRegion
+	}
+	if f.affectsOwnResources && owns != nil && len(r.Resources) > 0 {
+		for _, resource := range r.Resources {
+			if owns(resource) {
+				return ""
+			}
+		}
+		return SuppressedByResource
+	}
+	return ""
+}
+
+func toLowerSet(values []string) map[string]struct{} {
+	set := make(map[string]struct{}, len(values))
+	for _, v := range values {
+		set[strings.ToLower(v)] = struct{}{}
+	}
+	return set
+}
+
+func containsAny(set map[string]struct{}, values []string) bool {
+	for _, v := range values {
+		if _, ok := set[strings.ToLower(v)]; ok {
+			return true
+		}
+	}
+	return false
+}
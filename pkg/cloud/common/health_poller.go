@@ -0,0 +1,309 @@
+package common
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	log "github.com/sirupsen/logrus"
+	"github.wdf.sap.corp/DBaaS/cloud-provider-exporter/pkg/config"
+)
+
+const (
+	defaultHealthPollInterval = 60 * time.Minute
+	defaultHealthRetention    = 7 * 24 * time.Hour
+)
+
+// HealthEventRecord is the provider neutral view of a health event kept by the HealthPoller.
+type HealthEventRecord struct {
+	ID string
+	// SourceID is the event of the provider a record copies, when the same event is recorded once per account.
+	// The duration of an event is observed once per SourceID, or per ID without it.
+	SourceID        string
+	Service         string
+	Category        string
+	Closed          bool
+	StartTime       time.Time
+	EndTime         time.Time
+	LastUpdatedTime time.Time
+	// Services, Regions and Resources are only used by the HealthEventFilter.
+	Services  []string
+	Regions   []string
+	Resources []string
+	// EventLabels, CounterLabels and Entities hold the label values of HealthEvent,
+	// HealthOpenedTotal/HealthClosedTotal and AffectedEntity in the provider's label order.
+	EventLabels   []string
+	CounterLabels []string
+	Entities      [][]string
+
+	closedSeen time.Time
+}
+
+// HealthEventSource returns all events updated after since; a zero since asks for a full listing.
+type HealthEventSource interface {
+	FetchEvents(ctx context.Context, since time.Time) ([]*HealthEventRecord, error)
+}
+
+// HealthPoller keeps the health events of a provider keyed by event ID and turns the
+// differences between polls into monotonic opened/updated/closed counters.
+type HealthPoller struct {
+	source    HealthEventSource
+	log       log.FieldLogger
+	interval  time.Duration
+	retention time.Duration
+	filter    *HealthEventFilter
+
+	mu         sync.RWMutex
+	events     map[string]*HealthEventRecord
+	suppressed map[string]time.Time
+	// durations are the end times of the observed event durations by source ID
+	durations map[string]observedDuration
+	watermark time.Time
+}
+
+type observedDuration struct {
+	end  time.Time
+	seen time.Time
+}
+
+func NewHealthPoller(source HealthEventSource, conf *config.Config, logger log.FieldLogger) *HealthPoller {
+	p := &HealthPoller{
+		source:     source,
+		log:        logger,
+		interval:   defaultHealthPollInterval,
+		retention:  defaultHealthRetention,
+		events:     make(map[string]*HealthEventRecord),
+		suppressed: make(map[string]time.Time),
+		durations:  make(map[string]observedDuration),
+	}
+	if conf.ScrapingDuration > 0 {
+		p.interval = time.Duration(conf.ScrapingDuration) * time.Minute
+	}
+	if conf.Health != nil {
+		if conf.Health.PollInterval > 0 {
+			p.interval = time.Duration(conf.Health.PollInterval) * time.Minute
+		}
+		if conf.Health.Retention > 0 {
+			p.retention = time.Duration(conf.Health.Retention) * time.Hour
+		}
+	}
+	return p
+}
+
+// SetFilter restricts the stored events to the ones passing conf; a nil conf keeps all events.
+func (p *HealthPoller) SetFilter(conf *config.HealthFilterConfig) {
+	if conf == nil {
+		p.filter = nil
+		return
+	}
+	p.filter = NewHealthEventFilter(conf)
+}
+
+// Interval is the time between two polls.
+func (p *HealthPoller) Interval() time.Duration {
+	return p.interval
+}
+
+// Retention is how long closed events are kept.
+func (p *HealthPoller) Retention() time.Duration {
+	return p.retention
+}
+
+// Run polls the source until ctx is cancelled.
+func (p *HealthPoller) Run(ctx context.Context) {
+	ticker := time.NewTicker(p.interval)
+	defer ticker.Stop()
+	for {
+		if err := p.Poll(ctx); err != nil {
+			p.log.Errorf("Error while polling health events: %v", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Poll fetches the events updated since the last watermark and merges them into the store.
+func (p *HealthPoller) Poll(ctx context.Context) error {
+	p.mu.RLock()
+	since := p.watermark
+	p.mu.RUnlock()
+
+	records, err := p.source.FetchEvents(ctx, since)
+	if err != nil {
+		return err
+	}
+	var owns func(resource string) bool
+	if owner, ok := p.source.(HealthResourceOwner); ok && p.filter != nil && p.filter.affectsOwnResources {
+		if owns, err = owner.ResourceMatcher(ctx); err != nil {
+			return err
+		}
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, r := range records {
+		if r.LastUpdatedTime.After(p.watermark) {
+			p.watermark = r.LastUpdatedTime
+		}
+	}
+	p.merge(p.filterRecords(records, owns, time.Now()))
+	return nil
+}
+
+// Merge applies pushed records to the store, counting the transitions they cause. Pushed records
+// pass the service and region filters but not the resource filter, and leave the poll watermark untouched.
+func (p *HealthPoller) Merge(records []*HealthEventRecord) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.merge(p.filterRecords(records, nil, time.Now()))
+}
+
+// filterRecords returns the records passing the filter. Every suppressed event is counted once.
+func (p *HealthPoller) filterRecords(records []*HealthEventRecord, owns func(resource string) bool, now time.Time) []*HealthEventRecord {
+	if p.filter == nil {
+		return records
+	}
+	kept := make([]*HealthEventRecord, 0, len(records))
+	for _, r := range records {
+		reason := p.filter.Suppress(r, owns)
+		if reason == "" {
+			kept = append(kept, r)
+			continue
+		}
+		if _, ok := p.suppressed[r.ID]; !ok {
+			HealthSuppressed.WithLabelValues(reason).Inc()
+		}
+		p.suppressed[r.ID] = now
+	}
+	return kept
+}
+
+func (p *HealthPoller) merge(records []*HealthEventRecord) {
+	now := time.Now()
+	for _, r := range records {
+		old, ok := p.events[r.ID]
+		if ok && r.LastUpdatedTime.Before(old.LastUpdatedTime) {
+			// a late push or a poll racing with a push must not roll the event back
+			continue
+		}
+		switch {
+		case !ok:
+			HealthOpenedTotal.WithLabelValues(r.CounterLabels...).Inc()
+			if r.Closed {
+				p.close(r, now)
+			}
+		case !old.Closed && r.Closed:
+			p.close(r, now)
+		case old.Closed && !r.Closed:
+			HealthOpenedTotal.WithLabelValues(r.CounterLabels...).Inc()
+		case r.LastUpdatedTime.After(old.LastUpdatedTime):
+			HealthUpdateTotal.WithLabelValues(r.CounterLabels...).Inc()
+		}
+		if r.Closed {
+			r.closedSeen = now
+			if ok && old.Closed {
+				r.closedSeen = old.closedSeen
+			}
+		}
+		p.events[r.ID] = r
+	}
+	p.evict(now)
+	p.log.Infof("%d health events merged, %d events tracked", len(records), len(p.events))
+}
+
+func (p *HealthPoller) close(r *HealthEventRecord, now time.Time) {
+	HealthClosedTotal.WithLabelValues(r.CounterLabels...).Inc()
+	end := r.EndTime
+	if end.IsZero() {
+		end = r.LastUpdatedTime
+	}
+	sourceID := r.SourceID
+	if sourceID == "" {
+		sourceID = r.ID
+	}
+	if observed, ok := p.durations[sourceID]; ok && observed.end.Equal(end) {
+		// another copy of the event closed already
+		return
+	}
+	p.durations[sourceID] = observedDuration{end, now}
+	if d := end.Sub(r.StartTime); !r.StartTime.IsZero() && d >= 0 {
+		HealthDuration.WithLabelValues(r.Service, r.Category).Observe(d.Seconds())
+	}
+}
+
+// evict drops events that were seen closed, or last seen suppressed, longer than the retention period ago.
+func (p *HealthPoller) evict(now time.Time) {
+	for id, r := range p.events {
+		if r.Closed && now.Sub(r.closedSeen) > p.retention {
+			delete(p.events, id)
+		}
+	}
+	for id, seen := range p.suppressed {
+		if now.Sub(seen) > p.retention {
+			delete(p.suppressed, id)
+		}
+	}
+	for id, observed := range p.durations {
+		if now.Sub(observed.seen) > p.retention {
+			delete(p.durations, id)
+		}
+	}
+}
+
+// Events returns a snapshot of the tracked events.
+func (p *HealthPoller) Events() []*HealthEventRecord {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	events := make([]*HealthEventRecord, 0, len(p.events))
+	for _, r := range p.events {
+		events = append(events, r)
+	}
+	return events
+}
+
+func (p *HealthPoller) Describe(ch chan<- *prometheus.Desc) {
+	ch <- HealthEvent
+	ch <- AffectedEntity
+	HealthOpenedTotal.Describe(ch)
+	HealthClosedTotal.Describe(ch)
+	HealthUpdateTotal.Describe(ch)
+	HealthSuppressed.Describe(ch)
+	HealthWebhook.Describe(ch)
+	HealthDuration.Describe(ch)
+}
+
+// Collect renders a snapshot of the tracked events; the transition counters are never reset.
+func (p *HealthPoller) Collect(ch chan<- prometheus.Metric) {
+	var events, entities [][]string
+	for _, r := range p.Events() {
+		events = append(events, r.EventLabels)
+		entities = append(entities, r.Entities...)
+	}
+	collectCounts(ch, HealthEvent, events)
+	collectCounts(ch, AffectedEntity, entities)
+	HealthOpenedTotal.Collect(ch)
+	HealthClosedTotal.Collect(ch)
+	HealthUpdateTotal.Collect(ch)
+	HealthSuppressed.Collect(ch)
+	HealthWebhook.Collect(ch)
+	HealthDuration.Collect(ch)
+}
+
+// collectCounts sends how often each set of label values occurs
+func collectCounts(ch chan<- prometheus.Metric, desc *prometheus.Desc, labelValues [][]string) {
+	counts := make(map[string]float64)
+	values := make(map[string][]string)
+	for _, labels := range labelValues {
+		key := strings.Join(labels, "\xff")
+		counts[key]++
+		values[key] = labels
+	}
+	for key, count := range counts {
+		ch <- prometheus.MustNewConstMetric(desc, prometheus.CounterValue, count, values[key]...)
+	}
+}
@@ -0,0 +1,162 @@
+package common
+
+import (
+	"context"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	log "github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.wdf.sap.corp/DBaaS/cloud-provider-exporter/pkg/config"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+type MockHealthEventSource struct {
+	polls [][]*HealthEventRecord
+	since []time.Time
+}
+
+func (m *MockHealthEventSource) FetchEvents(ctx context.Context, since time.Time) ([]*HealthEventRecord, error) {
+	m.since = append(m.since, since)
+	records := m.polls[0]
+	m.polls = m.polls[1:]
+	return records, nil
+}
+
+func newMockRecord(id string, closed bool, updated time.Time) *HealthEventRecord {
+	return &HealthEventRecord{
+		ID:              id,
+		Service:         "EC2",
+		Category:        "issue",
+		Closed:          closed,
+		StartTime:       updated.Add(-time.Hour),
+		LastUpdatedTime: updated,
+		EventLabels:     []string{id},
+		CounterLabels:   []string{"issue"},
+		Entities:        [][]string{{id, "entity"}},
+	}
+}
+
+func TestHealthPollerTransitions(t *testing.T) {
+	t0 := time.Date(2022, time.October, 7, 13, 0, 0, 0, time.UTC)
+	source := &MockHealthEventSource{
+		polls: [][]*HealthEventRecord{
+			{newMockRecord("a", false, t0), newMockRecord("b", false, t0)},
+			{newMockRecord("a", false, t0.Add(time.Minute)), newMockRecord("b", false, t0)},
+			{newMockRecord("a", true, t0.Add(2*time.Hour))},
+		},
+	}
+	InitHealthCounterVec("Mock", []string{"eventID"}, []string{"eventID", "entity"}, []string{"eventType"}, []string{"eventType"})
+	poller := NewHealthPoller(source, &config.Config{}, &log.Logger{})
+
+	for i := 0; i < 3; i++ {
+		assert.NoError(t, poller.Poll(context.TODO()))
+	}
+
+	assert.Equal(t, []time.Time{{}, t0, t0.Add(time.Minute)}, source.since)
+	assert.Equal(t, float64(2), testutil.ToFloat64(HealthOpenedTotal.WithLabelValues("issue")))
+	assert.Equal(t, float64(1), testutil.ToFloat64(HealthUpdateTotal.WithLabelValues("issue")))
+	assert.Equal(t, float64(1), testutil.ToFloat64(HealthClosedTotal.WithLabelValues("issue")))
+	assert.Equal(t, 1, testutil.CollectAndCount(HealthDuration))
+	assert.Len(t, poller.Events(), 2)
+}
+
+type MockOwnerSource struct {
+	MockHealthEventSource
+}
+
+func (m *MockOwnerSource) ResourceMatcher(ctx context.Context) (func(resource string) bool, error) {
+	return func(resource string) bool { return resource == "arn:owned" }, nil
+}
+
+func TestHealthPollerFilter(t *testing.T) {
+	t0 := time.Date(2022, time.October, 7, 13, 0, 0, 0, time.UTC)
+	owned := newMockRecord("owned", false, t0)
+	owned.Regions = []string{"eu-central-1"}
+	owned.Resources = []string{"arn:foreign", "arn:owned"}
+	foreign := newMockRecord("foreign", false, t0)
+	foreign.Resources = []string{"arn:foreign"}
+	otherRegion := newMockRecord("otherRegion", false, t0)
+	otherRegion.Regions = []string{"us-east-1"}
+	otherService := newMockRecord("otherService", false, t0)
+	otherService.Service = "RDS"
+	// events without affected resources concern the whole account
+	accountWide := newMockRecord("accountWide", false, t0)
+	source := &MockOwnerSource{MockHealthEventSource{
+		polls: [][]*HealthEventRecord{
+			{owned, foreign, otherRegion, otherService, accountWide},
+			{foreign},
+		},
+	}}
+	InitHealthCounterVec("Mock", []string{"eventID"}, []string{"eventID", "entity"}, []string{"eventType"}, []string{"eventType"})
+	poller := NewHealthPoller(source, &config.Config{}, &log.Logger{})
+	poller.SetFilter(&config.HealthFilterConfig{
+		Services:            []string{"ec2"},
+		Regions:             []string{"eu-central-1"},
+		AffectsOwnResources: true,
+	})
+
+	assert.NoError(t, poller.Poll(context.TODO()))
+	assert.NoError(t, poller.Poll(context.TODO()))
+
+	events := poller.Events()
+	assert.Len(t, events, 2)
+	assert.ElementsMatch(t, []string{"owned", "accountWide"}, []string{events[0].ID, events[1].ID})
+	assert.Equal(t, float64(1), testutil.ToFloat64(HealthSuppressed.WithLabelValues(SuppressedByResource)))
+	assert.Equal(t, float64(1), testutil.ToFloat64(HealthSuppressed.WithLabelValues(SuppressedByRegion)))
+	assert.Equal(t, float64(1), testutil.ToFloat64(HealthSuppressed.WithLabelValues(SuppressedByService)))
+	assert.Equal(t, float64(2), testutil.ToFloat64(HealthOpenedTotal.WithLabelValues("issue")))
+}
+
+func TestHealthPollerMerge(t *testing.T) {
+	t0 := time.Date(2022, time.October, 7, 13, 0, 0, 0, time.UTC)
+	source := &MockHealthEventSource{
+		polls: [][]*HealthEventRecord{
+			{newMockRecord("a", false, t0)},
+			{newMockRecord("a", false, t0)},
+		},
+	}
+	InitHealthCounterVec("Mock", []string{"eventID"}, []string{"eventID", "entity"}, []string{"eventType"}, []string{"eventType"})
+	poller := NewHealthPoller(source, &config.Config{}, &log.Logger{})
+
+	assert.NoError(t, poller.Poll(context.TODO()))
+	poller.Merge([]*HealthEventRecord{newMockRecord("a", true, t0.Add(time.Hour)), newMockRecord("b", false, t0.Add(time.Hour))})
+	assert.NoError(t, poller.Poll(context.TODO()))
+
+	// pushed events leave the watermark alone and are not rolled back by older polled state
+	assert.Equal(t, []time.Time{{}, t0}, source.since)
+	assert.Equal(t, float64(2), testutil.ToFloat64(HealthOpenedTotal.WithLabelValues("issue")))
+	assert.Equal(t, float64(1), testutil.ToFloat64(HealthClosedTotal.WithLabelValues("issue")))
+	assert.Len(t, poller.Events(), 2)
+}
+
+func TestHealthPollerCollect(t *testing.T) {
+	t0 := time.Date(2022, time.October, 7, 13, 0, 0, 0, time.UTC)
+	// events with the same label values are counted together
+	b := newMockRecord("b", false, t0)
+	b.EventLabels = []string{"a"}
+	source := &MockHealthEventSource{polls: [][]*HealthEventRecord{{newMockRecord("a", false, t0), b}}}
+	InitHealthCounterVec("Mock", []string{"eventID"}, []string{"eventID", "entity"}, []string{"eventType"}, []string{"eventType"})
+	poller := NewHealthPoller(source, &config.Config{}, &log.Logger{})
+	assert.NoError(t, poller.Poll(context.TODO()))
+
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(poller)
+	expected := `# HELP cpe_health_events Mock Resource health event information
+# TYPE cpe_health_events counter
+cpe_health_events{eventID="a"} 2
+`
+	// concurrent scrapes see the same snapshot
+	wg := sync.WaitGroup{}
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			assert.NoError(t, testutil.GatherAndCompare(registry, strings.NewReader(expected), "cpe_health_events"))
+		}()
+	}
+	wg.Wait()
+	assert.Equal(t, 2, testutil.CollectAndCount(poller, "cpe_health_events_affected"))
+}
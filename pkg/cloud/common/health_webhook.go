@@ -0,0 +1,70 @@
+package common
+
+import (
+	"crypto/subtle"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.wdf.sap.corp/DBaaS/cloud-provider-exporter/pkg/config"
+)
+
+const (
+	WebhookAccepted = "accepted"
+	WebhookIgnored  = "ignored"
+	WebhookRejected = "rejected"
+	WebhookInvalid  = "invalid"
+
+	WebhookTokenHeader = "X-Webhook-Token"
+	maxWebhookBodySize = 1 << 20
+)
+
+// ReadWebhookRequest checks the method and the shared token of a pushed health notification and
+// returns its body. When the request must not be processed it is answered here and ok is false.
+func ReadWebhookRequest(w http.ResponseWriter, r *http.Request, conf *config.HealthWebhookConfig, source string) (body []byte, ok bool) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return nil, false
+	}
+	if conf != nil && conf.Token != "" && !validWebhookToken(r, conf.Token) {
+		WebhookDone(w, source, WebhookRejected, http.StatusUnauthorized)
+		return nil, false
+	}
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxWebhookBodySize))
+	if err != nil {
+		WebhookDone(w, source, WebhookInvalid, http.StatusBadRequest)
+		return nil, false
+	}
+	return body, true
+}
+
+// WebhookConfig returns the webhook settings of conf, or nil when none are configured.
+func WebhookConfig(conf *config.Config) *config.HealthWebhookConfig {
+	if conf.Health == nil {
+		return nil
+	}
+	return conf.Health.Webhook
+}
+
+// WebhookDone counts the request and answers it with status.
+func WebhookDone(w http.ResponseWriter, source, result string, status int) {
+	HealthWebhook.WithLabelValues(source, result).Inc()
+	w.WriteHeader(status)
+}
+
+// validWebhookToken accepts the token as header, bearer token or "token" query parameter, since
+// action groups can only carry it in the URL while EventBridge API destinations send a header.
+func validWebhookToken(r *http.Request, token string) bool {
+	candidates := []string{
+		r.Header.Get(WebhookTokenHeader),
+		strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer "),
+		r.URL.Query().Get("token"),
+	}
+	for _, c := range candidates {
+		if c != "" && subtle.ConstantTimeCompare([]byte(c), []byte(token)) == 1 {
+			return true
+		}
+	}
+	return false
+}
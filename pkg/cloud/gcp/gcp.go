@@ -3,9 +3,10 @@ package gcp
 import (
 	"context"
 	"github.com/prometheus/client_golang/prometheus"
-	"github.com/prometheus/client_golang/prometheus/promhttp"
 	log "github.com/sirupsen/logrus"
+	"github.wdf.sap.corp/DBaaS/cloud-provider-exporter/pkg/cloud/objectstore"
 	"github.wdf.sap.corp/DBaaS/cloud-provider-exporter/pkg/config"
+	"github.wdf.sap.corp/DBaaS/cloud-provider-exporter/pkg/constant"
 	"github.wdf.sap.corp/DBaaS/cloud-provider-exporter/pkg/vault"
 	"net/http"
 )
@@ -13,26 +14,26 @@ import (
 type GcpExporter struct {
 	quotaCollector  *MetricsCollectorGcpRmQuota
 	healthCollector *MetricsCollectorGcpRmHealth
+	bucketCollector *MetricsCollectorGcpVaultBucket
 }
 
 func (e *GcpExporter) StartExporter(ctx context.Context, config *config.Config, credential vault.CloudCredentials, logger log.FieldLogger) {
 	e.quotaCollector = NewMetricsCollectorGcpRmQuota(config, credential, logger)
 	prometheus.MustRegister(e.quotaCollector)
-	//e.healthCollector = NewMetricsCollectorGcpRmHealth(config, credential, logger)
-	//prometheus.MustRegister(e.healthCollector)
-	//http.HandleFunc(constant.VaultMonitorPath, func(w http.ResponseWriter, r *http.Request) {
-	//	vaultBackupMonitorHandler(w, r, config, cred)
-	//})
+	e.healthCollector = NewMetricsCollectorGcpRmHealth(config, credential, logger)
+	prometheus.MustRegister(e.healthCollector)
+	go e.healthCollector.Run(ctx)
+	if len(objectstore.Targets(config)) > 0 {
+		e.bucketCollector = NewMetricsCollectorGcpVaultBucket(config, credential)
+		e.registerVaultBucket(http.DefaultServeMux)
+	}
 }
 
-func (e *GcpExporter) Scrape(ctx context.Context) {
-	e.quotaCollector.scrape(ctx)
+// registerVaultBucket mounts the metrics of the object store targets
+func (e *GcpExporter) registerVaultBucket(mux *http.ServeMux) {
+	mux.Handle(constant.VaultMonitorPath, objectstore.Handler(e.bucketCollector))
 }
 
-func vaultBackupMonitorHandler(w http.ResponseWriter, r *http.Request, config *config.Config, credential vault.CloudCredentials) {
-	vaultBucketCollector := NewMetricsCollectorGcpVaultBucket(config, credential)
-	registry := prometheus.NewRegistry()
-	registry.MustRegister(vaultBucketCollector)
-	h := promhttp.HandlerFor(registry, promhttp.HandlerOpts{})
-	h.ServeHTTP(w, r)
+func (e *GcpExporter) Scrape(ctx context.Context) {
+	e.quotaCollector.scrape(ctx)
 }
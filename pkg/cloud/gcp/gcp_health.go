@@ -1,27 +1,50 @@
 package gcp
 
 import (
-	"encoding/json"
+	"context"
+	"fmt"
 	"github.com/prometheus/client_golang/prometheus"
 	log "github.com/sirupsen/logrus"
 	"github.wdf.sap.corp/DBaaS/cloud-provider-exporter/pkg/cloud/common"
 	"github.wdf.sap.corp/DBaaS/cloud-provider-exporter/pkg/config"
+	"github.wdf.sap.corp/DBaaS/cloud-provider-exporter/pkg/constant"
 	"github.wdf.sap.corp/DBaaS/cloud-provider-exporter/pkg/vault"
-	"io"
-	"io/ioutil"
-	"net/http"
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/google"
+	"path"
 	"strings"
+	"time"
 )
 
 type MetricsCollectorGcpRmHealth struct {
-	conf *config.Config
-	cred vault.CloudCredentials
+	conf                *config.Config
+	serviceHealthClient ServiceHealthClient
+	statusFeedClient    StatusFeedClient
+	poller              *common.HealthPoller
+	log                 log.FieldLogger
+	// serviceHealthReached is set once the Service Health API answered, its errors are not hidden by the feed anymore
+	serviceHealthReached bool
 }
 
+// The sources of the GCP health events
+const (
+	healthSourceServiceHealth = "serviceHealth"
+	healthSourceStatusFeed    = "statusFeed"
+)
+
 func NewMetricsCollectorGcpRmHealth(config *config.Config, cred vault.CloudCredentials, logger log.FieldLogger) *MetricsCollectorGcpRmHealth {
 	m := &MetricsCollectorGcpRmHealth{}
 	m.conf = config
-	m.cred = cred
+	m.log = logger
+	m.statusFeedClient = NewStatusFeedClientWrapper()
+	if m.healthSource() != healthSourceStatusFeed {
+		credential, err := google.CredentialsFromJSON(context.Background(), []byte(cred[vault.GcpServiceAccount]), constant.GCPHealthScope)
+		if err != nil {
+			m.log.Errorf("Error while getting credential, only the public status feed is used: %v", err)
+		} else {
+			m.serviceHealthClient = NewServiceHealthClientWrapper(oauth2.NewClient(context.Background(), credential.TokenSource), cred[vault.GcpProjectID])
+		}
+	}
 
 	eventLabel := []string{
 		"eventID",
@@ -45,77 +68,190 @@ func NewMetricsCollectorGcpRmHealth(config *config.Config, cred vault.CloudCrede
 	eventOpenTotalLabel := []string{"eventType"}
 	eventCloseTotalLabel := []string{"eventType"}
 	common.InitHealthCounterVec("Gcp", eventLabel, entityLabel, eventOpenTotalLabel, eventCloseTotalLabel)
+	m.poller = common.NewHealthPoller(m, config, logger)
+	if config.Gcp != nil && config.Gcp.HealthFilter != nil {
+		m.poller.SetFilter(locationFilter(config.Gcp.HealthFilter, config.Region))
+	}
 	return m
 }
 
+// locationFilter returns a copy of filter whose regions also cover the multi-regions and the global
+// location containing them. Without configured regions the project's region is used.
+func locationFilter(filter *config.HealthFilterConfig, projectRegion string) *config.HealthFilterConfig {
+	f := *filter
+	regions := filter.Regions
+	if len(regions) == 0 && projectRegion != "" {
+		regions = []string{projectRegion}
+	}
+	f.Regions = nil
+	if len(regions) > 0 {
+		f.Regions = append(f.Regions, "global")
+	}
+	for _, region := range regions {
+		f.Regions = append(f.Regions, region)
+		switch {
+		case strings.HasPrefix(region, "us-"):
+			f.Regions = append(f.Regions, "us")
+		case strings.HasPrefix(region, "europe-"):
+			f.Regions = append(f.Regions, "eu", "europe")
+		case strings.HasPrefix(region, "asia-"):
+			f.Regions = append(f.Regions, "asia")
+		}
+	}
+	return &f
+}
+
 func (m *MetricsCollectorGcpRmHealth) Describe(ch chan<- *prometheus.Desc) {
-	common.HealthEvent.Describe(ch)
-	common.AffectedEntity.Describe(ch)
-	common.HealthOpenedTotal.Describe(ch)
-	common.HealthClosedTotal.Describe(ch)
+	m.poller.Describe(ch)
 }
 
 func (m *MetricsCollectorGcpRmHealth) Collect(ch chan<- prometheus.Metric) {
-	common.HealthOpenedTotal.Reset()
-	common.HealthClosedTotal.Reset()
-	common.HealthEvent.Reset()
-	common.AffectedEntity.Reset()
-	url := "https://status.cloud.google.com/incidents.json"
-	resp, err := http.Get(url)
-	if err != nil {
-		log.Fatal("An error occurred during send http request:", err)
+	m.poller.Collect(ch)
+}
+
+// Run polls the GCP health events in the background until ctx is cancelled.
+func (m *MetricsCollectorGcpRmHealth) Run(ctx context.Context) {
+	m.poller.Run(ctx)
+}
+
+func (m *MetricsCollectorGcpRmHealth) healthSource() string {
+	if m.conf.Gcp == nil {
+		return ""
 	}
+	return m.conf.Gcp.HealthSource
+}
 
-	defer func(Body io.ReadCloser) {
-		err := Body.Close()
-		if err != nil {
-			log.Errorf("An error occured: %v", err)
+// FetchEvents lists the project's events from the Service Health API. The public incident feed is used
+// when it is configured, or when the API has never been reached, e.g. because it is not enabled for the
+// project. Later errors of the API are returned, the events of the feed would replace the project's ones.
+// The first poll only returns open events and the ones updated within the retention period.
+func (m *MetricsCollectorGcpRmHealth) FetchEvents(ctx context.Context, since time.Time) ([]*common.HealthEventRecord, error) {
+	initial := since.IsZero()
+	if initial {
+		since = time.Now().Add(-m.poller.Retention())
+	}
+	if m.serviceHealthClient != nil {
+		records, err := m.fetchServiceHealthEvents(ctx, since, initial)
+		if err == nil {
+			m.serviceHealthReached = true
+			return records, nil
+		}
+		if m.serviceHealthReached || m.healthSource() == healthSourceServiceHealth {
+			return nil, err
 		}
-	}(resp.Body)
+		m.log.Errorf("Error while listing Service Health events, falling back to the public status feed: %v", err)
+	}
+	return m.fetchStatusFeed(ctx, since)
+}
 
-	respBody, err := ioutil.ReadAll(resp.Body)
+func (m *MetricsCollectorGcpRmHealth) fetchServiceHealthEvents(ctx context.Context, since time.Time, initial bool) ([]*common.HealthEventRecord, error) {
+	filter := fmt.Sprintf("update_time >= %q", since.UTC().Format(time.RFC3339))
+	if initial {
+		filter = "state = ACTIVE OR " + filter
+	}
+	events, err := m.serviceHealthClient.ListEvents(ctx, filter)
 	if err != nil {
-		log.Fatal("An error occurred during read response body", err)
+		return nil, err
 	}
 
-	var results []map[string]interface{}
-	err = json.Unmarshal(respBody, &results)
+	records := make([]*common.HealthEventRecord, 0, len(events))
+	for _, event := range events {
+		eventID := path.Base(event.Name)
+		var products, productIDs, locations []string
+		for _, impact := range event.EventImpacts {
+			products = appendUnique(products, impact.Product.ProductName)
+			productIDs = appendUnique(productIDs, impact.Product.ID)
+			locations = appendUnique(locations, impact.Location.LocationName)
+		}
+		serviceKey, serviceName := "", ""
+		if len(products) == 1 {
+			serviceKey, serviceName = productIDs[0], products[0]
+		} else if len(products) > 1 {
+			serviceName = "Multiple Products"
+		}
+		records = append(records, &common.HealthEventRecord{
+			ID:              eventID,
+			Service:         serviceName,
+			Category:        event.Category,
+			Closed:          event.State == "CLOSED",
+			StartTime:       parseTime(event.StartTime),
+			EndTime:         parseTime(event.EndTime),
+			LastUpdatedTime: parseTime(event.UpdateTime),
+			Services:        append(append(products, productIDs...), serviceName),
+			Regions:         locations,
+			EventLabels: []string{eventID, event.Title, event.StartTime, event.UpdateTime, event.State, event.Category,
+				event.Relevance, event.Name, serviceKey, serviceName},
+			CounterLabels: []string{event.Category},
+			Entities:      [][]string{{eventID, strings.Join(products, ","), strings.Join(locations, ",")}},
+		})
+	}
+	return records, nil
+}
+
+// fetchStatusFeed downloads the public incident feed, which has no server side filter,
+// and drops the closed incidents that were not modified since the given time.
+func (m *MetricsCollectorGcpRmHealth) fetchStatusFeed(ctx context.Context, since time.Time) ([]*common.HealthEventRecord, error) {
+	incidents, err := m.statusFeedClient.ListIncidents(ctx)
 	if err != nil {
-		log.Fatal("An error occurred during unmarshal response body", err)
-	}
-
-	for _, result := range results {
-		status := result["most_recent_update"].(map[string]interface{})["status"].(string)
-		eventID := result["id"].(string)
-		title := result["external_desc"].(string)
-		startTime := result["begin"].(string)
-		lastUpdateTime := result["modified"].(string)
-		statusImpact := result["status_impact"].(string)
-		level := result["severity"].(string)
-		uri := result["uri"].(string)
-		serviceKey := result["service_key"].(string)
-		serviceName := result["service_name"].(string)
-		common.HealthEvent.WithLabelValues(eventID, title, startTime, lastUpdateTime, status, statusImpact,
-			level, uri, serviceKey, serviceName).Inc()
-		if status == "AVAILABLE" {
-			common.HealthClosedTotal.WithLabelValues(statusImpact).Inc()
-		} else {
-			common.HealthOpenedTotal.WithLabelValues(statusImpact).Inc()
+		return nil, err
+	}
+
+	records := make([]*common.HealthEventRecord, 0)
+	for _, incident := range incidents {
+		status := ""
+		if incident.MostRecentUpdate != nil {
+			status = incident.MostRecentUpdate.Status
 		}
-		products := result["affected_products"].([]interface{})
-		affectedProducts := make([]string, 0)
-		for _, product := range products {
-			affectedProducts = append(affectedProducts, product.(map[string]interface{})["title"].(string))
+		closed := status == "AVAILABLE"
+		modified := parseTime(incident.Modified)
+		if closed && modified.Before(since) {
+			continue
 		}
-		locations := result["previously_affected_locations"].([]interface{})
-		affectedLocations := make([]string, 0)
-		for _, location := range locations {
-			affectedLocations = append(affectedLocations, location.(map[string]interface{})["title"].(string))
+
+		var affectedProducts, productIDs, affectedLocations, locationIDs []string
+		for _, product := range incident.AffectedProducts {
+			affectedProducts = append(affectedProducts, product.Title)
+			productIDs = append(productIDs, product.ID)
+		}
+		for _, location := range incident.Locations() {
+			affectedLocations = append(affectedLocations, location.Title)
+			locationIDs = append(locationIDs, location.ID)
 		}
-		common.AffectedEntity.WithLabelValues(eventID, strings.Join(affectedProducts, ","), strings.Join(affectedLocations, ",")).Inc()
+		records = append(records, &common.HealthEventRecord{
+			ID:              incident.ID,
+			Service:         incident.ServiceName,
+			Category:        incident.StatusImpact,
+			Closed:          closed,
+			StartTime:       parseTime(incident.Begin),
+			EndTime:         parseTime(incident.End),
+			LastUpdatedTime: modified,
+			Services:        append(append(affectedProducts, productIDs...), incident.ServiceName),
+			Regions:         locationIDs,
+			EventLabels: []string{incident.ID, incident.ExternalDesc, incident.Begin, incident.Modified, status, incident.StatusImpact,
+				incident.Severity, incident.URI, incident.ServiceKey, incident.ServiceName},
+			CounterLabels: []string{incident.StatusImpact},
+			Entities:      [][]string{{incident.ID, strings.Join(affectedProducts, ","), strings.Join(affectedLocations, ",")}},
+		})
+	}
+	return records, nil
+}
+
+func appendUnique(values []string, value string) []string {
+	if value == "" {
+		return values
+	}
+	for _, v := range values {
+		if v == value {
+			return values
+		}
+	}
+	return append(values, value)
+}
+
+func parseTime(value string) time.Time {
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return time.Time{}
 	}
-	common.HealthEvent.Collect(ch)
-	common.AffectedEntity.Collect(ch)
-	common.HealthOpenedTotal.Collect(ch)
-	common.HealthClosedTotal.Collect(ch)
+	return t
 }
@@ -0,0 +1,154 @@
+package gcp
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+)
+
+const (
+	serviceHealthBaseURL = "https://servicehealth.googleapis.com/v1"
+	statusFeedURL        = "https://status.cloud.google.com/incidents.json"
+)
+
+// ServiceHealthClient lists the Personalized Service Health events of a project.
+type ServiceHealthClient interface {
+	ListEvents(ctx context.Context, filter string) ([]ServiceHealthEvent, error)
+}
+
+// StatusFeedClient downloads the public incident feed of Google Cloud.
+type StatusFeedClient interface {
+	ListIncidents(ctx context.Context) ([]StatusIncident, error)
+}
+
+type ServiceHealthEvent struct {
+	Name             string        `json:"name"`
+	Title            string        `json:"title"`
+	Category         string        `json:"category"`
+	DetailedCategory string        `json:"detailedCategory"`
+	State            string        `json:"state"`
+	DetailedState    string        `json:"detailedState"`
+	Relevance        string        `json:"relevance"`
+	EventImpacts     []EventImpact `json:"eventImpacts"`
+	StartTime        string        `json:"startTime"`
+	EndTime          string        `json:"endTime"`
+	UpdateTime       string        `json:"updateTime"`
+}
+
+type EventImpact struct {
+	Product struct {
+		ProductName string `json:"productName"`
+		ID          string `json:"id"`
+	} `json:"product"`
+	Location struct {
+		LocationName string `json:"locationName"`
+	} `json:"location"`
+}
+
+type serviceHealthEventList struct {
+	Events        []ServiceHealthEvent `json:"events"`
+	NextPageToken string               `json:"nextPageToken"`
+}
+
+type StatusIncident struct {
+	ID                          string           `json:"id"`
+	Begin                       string           `json:"begin"`
+	End                         string           `json:"end"`
+	Modified                    string           `json:"modified"`
+	ExternalDesc                string           `json:"external_desc"`
+	MostRecentUpdate            *IncidentUpdate  `json:"most_recent_update"`
+	StatusImpact                string           `json:"status_impact"`
+	Severity                    string           `json:"severity"`
+	ServiceKey                  string           `json:"service_key"`
+	ServiceName                 string           `json:"service_name"`
+	URI                         string           `json:"uri"`
+	AffectedProducts            []IncidentEntity `json:"affected_products"`
+	CurrentlyAffectedLocations  []IncidentEntity `json:"currently_affected_locations"`
+	PreviouslyAffectedLocations []IncidentEntity `json:"previously_affected_locations"`
+}
+
+type IncidentUpdate struct {
+	Status string `json:"status"`
+}
+
+// IncidentEntity is a product or a location of an incident.
+type IncidentEntity struct {
+	Title string `json:"title"`
+	ID    string `json:"id"`
+}
+
+// Locations returns the currently affected locations, or the previously affected ones once the incident is over.
+func (i *StatusIncident) Locations() []IncidentEntity {
+	if len(i.CurrentlyAffectedLocations) > 0 {
+		return i.CurrentlyAffectedLocations
+	}
+	return i.PreviouslyAffectedLocations
+}
+
+type ServiceHealthClientWrapper struct {
+	client  *http.Client
+	baseURL string
+	project string
+}
+
+func NewServiceHealthClientWrapper(client *http.Client, project string) *ServiceHealthClientWrapper {
+	return &ServiceHealthClientWrapper{client: client, baseURL: serviceHealthBaseURL, project: project}
+}
+
+func (cw *ServiceHealthClientWrapper) ListEvents(ctx context.Context, filter string) ([]ServiceHealthEvent, error) {
+	var events []ServiceHealthEvent
+	pageToken := ""
+	for {
+		query := url.Values{}
+		if filter != "" {
+			query.Set("filter", filter)
+		}
+		if pageToken != "" {
+			query.Set("pageToken", pageToken)
+		}
+		page := &serviceHealthEventList{}
+		if err := getJSON(ctx, cw.client, fmt.Sprintf("%s/projects/%s/locations/global/events?%s", cw.baseURL, cw.project, query.Encode()), page); err != nil {
+			return nil, err
+		}
+		events = append(events, page.Events...)
+		if page.NextPageToken == "" {
+			return events, nil
+		}
+		pageToken = page.NextPageToken
+	}
+}
+
+type StatusFeedClientWrapper struct {
+	client *http.Client
+	url    string
+}
+
+func NewStatusFeedClientWrapper() *StatusFeedClientWrapper {
+	return &StatusFeedClientWrapper{client: http.DefaultClient, url: statusFeedURL}
+}
+
+func (cw *StatusFeedClientWrapper) ListIncidents(ctx context.Context) ([]StatusIncident, error) {
+	var incidents []StatusIncident
+	if err := getJSON(ctx, cw.client, cw.url, &incidents); err != nil {
+		return nil, err
+	}
+	return incidents, nil
+}
+
+func getJSON(ctx context.Context, client *http.Client, rawURL string, v interface{}) error {
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, rawURL, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := client.Do(request)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status %d from %s", resp.StatusCode, request.URL.Path)
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
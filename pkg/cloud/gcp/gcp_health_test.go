@@ -1,6 +1,7 @@
 package gcp
 
 import (
+	"context"
 	"github.com/jarcoal/httpmock"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
@@ -10,10 +11,11 @@ import (
 	"github.wdf.sap.corp/DBaaS/cloud-provider-exporter/pkg/vault"
 	"net/http"
 	"testing"
+	"time"
 )
 
 var events = "[{\"id\":\"5Qmw8CdU6NxVRDFohwwT\",\"begin\":\"2022-10-07T13:13:48+00:00\",\"modified\":\"2022-10-07T21:30:03+00:00\",\"external_desc\":\"Connecting GitHub repository is not working\",\"most_recent_update\":{\"text\":\"The issue\",\"status\":\"UNAVAILABLE\"},\"status_impact\":\"SERVICE_INFORMATION\",\"severity\":\"low\",\"service_key\":\"zall\",\"service_name\":\"Multiple Products\",\"affected_products\":[{\"title\":\"Cloud Developer Tools\",\"id\":\"BGJQ6jbGK4kUuBTQFZ1G\"},{\"title\":\"Cloud Build\",\"id\":\"fw8GzBdZdqy4THau7e1y\"}],\"uri\":\"incidents/5Qmw8CdU6NxVRDFohwwT\",\"previously_affected_locations\":[{\"title\":\"Taiwan (asia-east1)\",\"id\":\"asia-east1\"},{\"title\":\"Hong Kong (asia-east2)\",\"id\":\"asia-east2\"},{\"title\":\"Tokyo (asia-northeast1)\",\"id\":\"asia-northeast1\"}]}," +
-	"{\"id\":\"5Qmw8CdU6NxVRDFohwwT\",\"begin\":\"2022-10-07T13:13:48+00:00\",\"modified\":\"2022-10-07T21:30:03+00:00\",\"external_desc\":\"Connecting GitHub repository is not working\",\"most_recent_update\":{\"text\":\"The issue\",\"status\":\"AVAILABLE\"},\"status_impact\":\"SERVICE_INFORMATION\",\"severity\":\"low\",\"service_key\":\"zall\",\"service_name\":\"Multiple Products\",\"affected_products\":[{\"title\":\"Cloud Developer Tools\",\"id\":\"BGJQ6jbGK4kUuBTQFZ1G\"},{\"title\":\"Cloud Build\",\"id\":\"fw8GzBdZdqy4THau7e1y\"}],\"uri\":\"incidents/5Qmw8CdU6NxVRDFohwwT\",\"previously_affected_locations\":[{\"title\":\"Taiwan (asia-east1)\",\"id\":\"asia-east1\"},{\"title\":\"Hong Kong (asia-east2)\",\"id\":\"asia-east2\"}]}]"
+	"{\"id\":\"9UPRhKcnh2bD6sEKTNiG\",\"begin\":\"2022-10-07T13:13:48+00:00\",\"modified\":\"2022-10-07T21:30:03+00:00\",\"external_desc\":\"Connecting GitHub repository is not working\",\"most_recent_update\":{\"text\":\"The issue\",\"status\":\"AVAILABLE\"},\"status_impact\":\"SERVICE_INFORMATION\",\"severity\":\"low\",\"service_key\":\"zall\",\"service_name\":\"Multiple Products\",\"affected_products\":[{\"title\":\"Cloud Developer Tools\",\"id\":\"BGJQ6jbGK4kUuBTQFZ1G\"},{\"title\":\"Cloud Build\",\"id\":\"fw8GzBdZdqy4THau7e1y\"}],\"uri\":\"incidents/5Qmw8CdU6NxVRDFohwwT\",\"previously_affected_locations\":[{\"title\":\"Taiwan (asia-east1)\",\"id\":\"asia-east1\"},{\"title\":\"Hong Kong (asia-east2)\",\"id\":\"asia-east2\"}]}]"
 
 func TestGcpHealth(t *testing.T) {
 	uri := "/metrics"
@@ -37,6 +39,7 @@ func TestGcpHealth(t *testing.T) {
 
 	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		healthCollector := NewMetricsCollectorGcpRmHealth(conf, cred, &log.Logger{})
+		_ = healthCollector.poller.Poll(context.TODO())
 		registry := prometheus.NewRegistry()
 		registry.MustRegister(healthCollector)
 		h := promhttp.HandlerFor(registry, promhttp.HandlerOpts{})
@@ -46,3 +49,141 @@ func TestGcpHealth(t *testing.T) {
 	assert.HTTPBodyContains(t, handler, "GET", uri, nil, "cpe_health_events{eventID=\"5Qmw8CdU6NxVRDFohwwT\",eventType=\"SERVICE_INFORMATION\",lastUpdatedTime=\"2022-10-07T21:30:03+00:00\",level=\"low\",serviceKey=\"zall\",serviceName=\"Multiple Products\",startTime=\"2022-10-07T13:13:48+00:00\",status=\"UNAVAILABLE\",title=\"Connecting GitHub repository is not working\",uri=\"incidents/5Qmw8CdU6NxVRDFohwwT\"} 1")
 	assert.HTTPBodyContains(t, handler, "GET", uri, nil, "cpe_health_events_affected{affectedRegions=\"Taiwan (asia-east1),Hong Kong (asia-east2),Tokyo (asia-northeast1)\",affectedService=\"Cloud Developer Tools,Cloud Build\",eventID=\"5Qmw8CdU6NxVRDFohwwT\"} 1")
 }
+
+var serviceHealthEvents = `{
+  "events": [
+    {
+      "name": "projects/projectID/locations/global/events/7d2a1b3c",
+      "title": "Increased latency for Compute Engine instance creation",
+      "category": "INCIDENT",
+      "detailedCategory": "CONFIRMED_INCIDENT",
+      "state": "ACTIVE",
+      "detailedState": "CONFIRMED",
+      "relevance": "RELATED",
+      "eventImpacts": [
+        {"product": {"productName": "Google Compute Engine", "id": "L3MkI8K6Sxb8"}, "location": {"locationName": "us-central1"}},
+        {"product": {"productName": "Google Compute Engine", "id": "L3MkI8K6Sxb8"}, "location": {"locationName": "us-east1"}}
+      ],
+      "startTime": "2022-10-07T13:13:48Z",
+      "updateTime": "2022-10-07T14:00:00Z"
+    }
+  ],
+  "nextPageToken": "page2"
+}`
+
+var serviceHealthEventsPage2 = `{
+  "events": [
+    {
+      "name": "projects/projectID/locations/global/events/9f8e7d6c",
+      "title": "Cloud SQL connectivity issues",
+      "category": "INCIDENT",
+      "state": "ACTIVE",
+      "relevance": "PARTIALLY_RELATED",
+      "eventImpacts": [
+        {"product": {"productName": "Cloud SQL", "id": "hV87iK5DcEXKgWU2kDri"}, "location": {"locationName": "europe-west1"}}
+      ],
+      "startTime": "2022-10-07T15:00:00Z",
+      "updateTime": "2022-10-07T15:30:00Z"
+    }
+  ]
+}`
+
+var currentIncident = `[{"id":"ow4wUGfHRTwfhvnmUSyt","begin":"2022-10-07T13:13:48+00:00","modified":"2022-10-07T21:30:03+00:00","external_desc":"Compute Engine instances unreachable","most_recent_update":{"status":"SERVICE_OUTAGE"},"status_impact":"SERVICE_OUTAGE","severity":"high","service_key":"L3MkI8K6Sxb8","service_name":"Google Compute Engine","affected_products":[{"title":"Google Compute Engine","id":"L3MkI8K6Sxb8"}],"uri":"incidents/ow4wUGfHRTwfhvnmUSyt","currently_affected_locations":[{"title":"Iowa (us-central1)","id":"us-central1"}],"previously_affected_locations":[{"title":"Iowa (us-central1)","id":"us-central1"},{"title":"Oregon (us-west1)","id":"us-west1"}]}]`
+
+func TestGcpServiceHealth(t *testing.T) {
+	cred := vault.CloudCredentials{
+		vault.GcpServiceAccount: "{\"type\": \"service_account\"}",
+		vault.GcpProjectID:      "projectID",
+	}
+	conf := &config.Config{
+		Region: "us-central1",
+		Gcp: &config.GcpConfig{
+			HealthFilter: &config.HealthFilterConfig{Services: []string{"Google Compute Engine", "Cloud SQL"}},
+		},
+	}
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+	httpmock.RegisterResponder("GET", "https://servicehealth.googleapis.com/v1/projects/projectID/locations/global/events",
+		func(req *http.Request) (*http.Response, error) {
+			if req.URL.Query().Get("pageToken") == "page2" {
+				return httpmock.NewStringResponse(http.StatusOK, serviceHealthEventsPage2), nil
+			}
+			assert.Contains(t, req.URL.Query().Get("filter"), "state = ACTIVE OR update_time >= ")
+			return httpmock.NewStringResponse(http.StatusOK, serviceHealthEvents), nil
+		})
+
+	healthCollector := NewMetricsCollectorGcpRmHealth(conf, cred, &log.Logger{})
+	healthCollector.serviceHealthClient = NewServiceHealthClientWrapper(http.DefaultClient, "projectID")
+	assert.NoError(t, healthCollector.poller.Poll(context.TODO()))
+
+	// the Cloud SQL event only affects europe-west1 and is dropped by the region filter
+	events := healthCollector.poller.Events()
+	assert.Len(t, events, 1)
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(healthCollector)
+	handler := promhttp.HandlerFor(registry, promhttp.HandlerOpts{})
+	assert.HTTPBodyContains(t, handler.ServeHTTP, "GET", "/metrics", nil, "cpe_health_events{eventID=\"7d2a1b3c\",eventType=\"INCIDENT\",lastUpdatedTime=\"2022-10-07T14:00:00Z\",level=\"RELATED\",serviceKey=\"L3MkI8K6Sxb8\",serviceName=\"Google Compute Engine\",startTime=\"2022-10-07T13:13:48Z\",status=\"ACTIVE\",title=\"Increased latency for Compute Engine instance creation\",uri=\"projects/projectID/locations/global/events/7d2a1b3c\"} 1")
+	assert.HTTPBodyContains(t, handler.ServeHTTP, "GET", "/metrics", nil, "cpe_health_events_affected{affectedRegions=\"us-central1,us-east1\",affectedService=\"Google Compute Engine\",eventID=\"7d2a1b3c\"} 1")
+}
+
+func TestGcpHealthFeedFallback(t *testing.T) {
+	cred := vault.CloudCredentials{
+		vault.GcpServiceAccount: "{\"type\": \"service_account\"}",
+		vault.GcpProjectID:      "projectID",
+	}
+	conf := &config.Config{
+		Region: "us-central1",
+		Gcp:    &config.GcpConfig{HealthFilter: &config.HealthFilterConfig{}},
+	}
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+	httpmock.RegisterResponder("GET", "https://servicehealth.googleapis.com/v1/projects/projectID/locations/global/events",
+		httpmock.NewStringResponder(http.StatusForbidden, `{"error": {"code": 403, "status": "PERMISSION_DENIED"}}`))
+	httpmock.RegisterResponder("GET", "https://status.cloud.google.com/incidents.json", httpmock.NewStringResponder(http.StatusOK, currentIncident))
+
+	healthCollector := NewMetricsCollectorGcpRmHealth(conf, cred, &log.Logger{})
+	healthCollector.serviceHealthClient = NewServiceHealthClientWrapper(http.DefaultClient, "projectID")
+	assert.NoError(t, healthCollector.poller.Poll(context.TODO()))
+
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(healthCollector)
+	handler := promhttp.HandlerFor(registry, promhttp.HandlerOpts{})
+	assert.HTTPBodyContains(t, handler.ServeHTTP, "GET", "/metrics", nil, "cpe_health_events_affected{affectedRegions=\"Iowa (us-central1)\",affectedService=\"Google Compute Engine\",eventID=\"ow4wUGfHRTwfhvnmUSyt\"} 1")
+}
+
+func TestGcpHealthSource(t *testing.T) {
+	cred := vault.CloudCredentials{
+		vault.GcpServiceAccount: "{\"type\": \"service_account\"}",
+		vault.GcpProjectID:      "projectID",
+	}
+	conf := &config.Config{Region: "us-central1", Gcp: &config.GcpConfig{}}
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+	eventsURL := "https://servicehealth.googleapis.com/v1/projects/projectID/locations/global/events"
+	httpmock.RegisterResponder("GET", eventsURL, httpmock.NewStringResponder(http.StatusOK, serviceHealthEventsPage2))
+	httpmock.RegisterResponder("GET", "https://status.cloud.google.com/incidents.json", httpmock.NewStringResponder(http.StatusOK, currentIncident))
+
+	healthCollector := NewMetricsCollectorGcpRmHealth(conf, cred, &log.Logger{})
+	healthCollector.serviceHealthClient = NewServiceHealthClientWrapper(http.DefaultClient, "projectID")
+	_, err := healthCollector.FetchEvents(context.TODO(), time.Time{})
+	assert.NoError(t, err)
+
+	// once the API was reached its errors are returned instead of the events of the feed
+	httpmock.RegisterResponder("GET", eventsURL, httpmock.NewStringResponder(http.StatusServiceUnavailable, `{"error": {"code": 503, "status": "UNAVAILABLE"}}`))
+	_, err = healthCollector.FetchEvents(context.TODO(), time.Now())
+	assert.Error(t, err)
+	assert.Equal(t, 0, httpmock.GetCallCountInfo()["GET https://status.cloud.google.com/incidents.json"])
+
+	// a configured source is used without falling back
+	conf.Gcp.HealthSource = healthSourceServiceHealth
+	healthCollector = NewMetricsCollectorGcpRmHealth(conf, cred, &log.Logger{})
+	healthCollector.serviceHealthClient = NewServiceHealthClientWrapper(http.DefaultClient, "projectID")
+	_, err = healthCollector.FetchEvents(context.TODO(), time.Time{})
+	assert.Error(t, err)
+	conf.Gcp.HealthSource = healthSourceStatusFeed
+	healthCollector = NewMetricsCollectorGcpRmHealth(conf, cred, &log.Logger{})
+	assert.Nil(t, healthCollector.serviceHealthClient)
+	records, err := healthCollector.FetchEvents(context.TODO(), time.Time{})
+	assert.NoError(t, err)
+	assert.Equal(t, "ow4wUGfHRTwfhvnmUSyt", records[0].ID)
+}
@@ -0,0 +1,152 @@
+package gcp
+
+import (
+	"context"
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/oauth2/google"
+	crmv1 "google.golang.org/api/cloudresourcemanager/v1"
+	crmv2 "google.golang.org/api/cloudresourcemanager/v2"
+	"google.golang.org/api/option"
+	"strings"
+	"time"
+)
+
+const (
+	projectRefreshInterval = time.Hour
+	maxConcurrentProjects  = 8
+)
+
+type ProjectsClient interface {
+	// ListProjects returns the projects directly under a folder or organization
+	ListProjects(ctx context.Context, parentType, parentID string) ([]*crmv1.Project, error)
+	// ListFolders returns the IDs of the folders directly under a folder or organization, like folders/123
+	ListFolders(ctx context.Context, parent string) ([]string, error)
+}
+
+type ProjectsClientWrapper struct {
+	projects *crmv1.Service
+	folders  *crmv2.Service
+}
+
+func (cw *ProjectsClientWrapper) ListProjects(ctx context.Context, parentType, parentID string) ([]*crmv1.Project, error) {
+	var projects []*crmv1.Project
+	err := cw.projects.Projects.List().Filter("parent.type:"+parentType+" parent.id:"+parentID).Pages(ctx, func(page *crmv1.ListProjectsResponse) error {
+		projects = append(projects, page.Projects...)
+		return nil
+	})
+	return projects, err
+}
+
+func (cw *ProjectsClientWrapper) ListFolders(ctx context.Context, parent string) ([]string, error) {
+	var folders []string
+	err := cw.folders.Folders.List().Parent(parent).Pages(ctx, func(page *crmv2.ListFoldersResponse) error {
+		for _, folder := range page.Folders {
+			folders = append(folders, folder.Name)
+		}
+		return nil
+	})
+	return folders, err
+}
+
+func NewProjectsClientWrapper(creds *google.Credentials, logger log.FieldLogger) *ProjectsClientWrapper {
+	projects, err := crmv1.NewService(context.Background(), option.WithCredentials(creds))
+	if err != nil {
+		logger.Errorf("Error while getting projects client: %v", err)
+	}
+	folders, err := crmv2.NewService(context.Background(), option.WithCredentials(creds))
+	if err != nil {
+		logger.Errorf("Error while getting folders client: %v", err)
+	}
+	return &ProjectsClientWrapper{projects: projects, folders: folders}
+}
+
+// discoverProjects lists the active projects under the configured folders, and their subfolders, and organizations,
+// filtered by the configured ID regex and labels. The projects are kept until the refresh interval has passed, the
+// project of the credentials is used when none is configured or found.
+func (m *MetricsCollectorGcpRmQuota) discoverProjects(ctx context.Context) []string {
+	if m.projectsClient == nil {
+		return []string{m.project}
+	}
+	if m.projects != nil && time.Since(m.discoveredAt) < projectRefreshInterval {
+		return m.projects
+	}
+
+	var found []string
+	var err error
+	seen := make(map[string]struct{})
+	add := func(projects []*crmv1.Project) {
+		for _, p := range projects {
+			if _, ok := seen[p.ProjectId]; ok || p.LifecycleState != "ACTIVE" || !m.projectSelected(p) {
+				continue
+			}
+			seen[p.ProjectId] = struct{}{}
+			found = append(found, p.ProjectId)
+		}
+	}
+	// the folders of the organizations are walked like the configured ones
+	folders := append([]string(nil), m.conf.Gcp.Folders...)
+	for _, organization := range m.conf.Gcp.Organizations {
+		var projects []*crmv1.Project
+		if projects, err = m.projectsClient.ListProjects(ctx, "organization", organization); err != nil {
+			break
+		}
+		add(projects)
+		var orgFolders []string
+		if orgFolders, err = m.projectsClient.ListFolders(ctx, "organizations/"+organization); err != nil {
+			break
+		}
+		for _, folder := range orgFolders {
+			folders = append(folders, strings.TrimPrefix(folder, "folders/"))
+		}
+	}
+	visited := make(map[string]struct{})
+	for len(folders) > 0 && err == nil {
+		folder := folders[0]
+		folders = folders[1:]
+		if _, ok := visited[folder]; ok {
+			// a configured folder may also be in a configured organization
+			continue
+		}
+		visited[folder] = struct{}{}
+		var projects []*crmv1.Project
+		if projects, err = m.projectsClient.ListProjects(ctx, "folder", folder); err != nil {
+			break
+		}
+		add(projects)
+		var subfolders []string
+		if subfolders, err = m.projectsClient.ListFolders(ctx, "folders/"+folder); err != nil {
+			break
+		}
+		for _, subfolder := range subfolders {
+			folders = append(folders, strings.TrimPrefix(subfolder, "folders/"))
+		}
+	}
+	if err != nil {
+		m.log.Errorf("Error while discovering projects: %v", err)
+		if m.projects != nil {
+			return m.projects
+		}
+		found = nil
+	}
+	if len(found) == 0 && m.project != "" {
+		m.log.Infof("No project discovered, using %s", m.project)
+		found = []string{m.project}
+	}
+	m.projects = found
+	m.discoveredAt = time.Now()
+	m.log.Infof("%d projects discovered", len(found))
+	return found
+}
+
+func (m *MetricsCollectorGcpRmQuota) projectSelected(p *crmv1.Project) bool {
+	if m.projectRegex != nil && !m.projectRegex.MatchString(p.ProjectId) {
+		return false
+	}
+	for key, value := range m.conf.Gcp.ProjectLabels {
+		label, ok := p.Labels[key]
+		if !ok || (value != "" && label != value) {
+			return false
+		}
+	}
+	return true
+}
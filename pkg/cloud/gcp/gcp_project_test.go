@@ -0,0 +1,150 @@
+package gcp
+
+import (
+	"context"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	log "github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.wdf.sap.corp/DBaaS/cloud-provider-exporter/pkg/cloud/common"
+	"github.wdf.sap.corp/DBaaS/cloud-provider-exporter/pkg/config"
+	"github.wdf.sap.corp/DBaaS/cloud-provider-exporter/pkg/vault"
+	crmv1 "google.golang.org/api/cloudresourcemanager/v1"
+	"google.golang.org/api/compute/v1"
+	"net/http"
+	"sync"
+	"testing"
+)
+
+type MockProjectsClient struct {
+	calls int
+}
+
+func (m *MockProjectsClient) ListProjects(ctx context.Context, parentType, parentID string) ([]*crmv1.Project, error) {
+	m.calls++
+	switch parentType + "/" + parentID {
+	case "folder/100":
+		return []*crmv1.Project{
+			{ProjectId: "hana-prod", LifecycleState: "ACTIVE", Labels: map[string]string{"owner": "dbaas"}},
+			{ProjectId: "hana-deleted", LifecycleState: "DELETE_REQUESTED", Labels: map[string]string{"owner": "dbaas"}},
+			{ProjectId: "analytics", LifecycleState: "ACTIVE", Labels: map[string]string{"owner": "dbaas"}},
+		}, nil
+	case "folder/101":
+		return []*crmv1.Project{
+			{ProjectId: "hana-dev", LifecycleState: "ACTIVE", Labels: map[string]string{"owner": "dbaas"}},
+			{ProjectId: "hana-other", LifecycleState: "ACTIVE"},
+		}, nil
+	case "organization/200":
+		return []*crmv1.Project{
+			{ProjectId: "hana-prod", LifecycleState: "ACTIVE", Labels: map[string]string{"owner": "dbaas"}},
+		}, nil
+	case "folder/501":
+		return []*crmv1.Project{{ProjectId: "hana-qa", LifecycleState: "ACTIVE"}}, nil
+	case "folder/502":
+		return []*crmv1.Project{{ProjectId: "hana-nested", LifecycleState: "ACTIVE"}}, nil
+	}
+	return nil, nil
+}
+
+func (m *MockProjectsClient) ListFolders(ctx context.Context, parent string) ([]string, error) {
+	switch parent {
+	case "folders/100":
+		return []string{"folders/101"}, nil
+	case "organizations/500":
+		return []string{"folders/501"}, nil
+	case "folders/501":
+		return []string{"folders/502"}, nil
+	}
+	return nil, nil
+}
+
+// MockProjectServiceClient returns the same quotas for every project, named after it
+type MockProjectServiceClient struct {
+	mu       sync.Mutex
+	projects []string
+}
+
+func (m *MockProjectServiceClient) GetProject(project string) (*compute.Project, error) {
+	m.mu.Lock()
+	m.projects = append(m.projects, project)
+	m.mu.Unlock()
+	return &compute.Project{
+		Quotas: []*compute.Quota{{Usage: 5, Limit: 10, Metric: "NETWORKS"}},
+		Id:     uint64(len(project)),
+		Name:   project,
+	}, nil
+}
+
+func (m *MockProjectServiceClient) GetRegionList(project string) (*compute.RegionList, error) {
+	return &compute.RegionList{Items: []*compute.Region{
+		{Quotas: []*compute.Quota{{Usage: 8, Limit: 24, Metric: "CPUS"}}, Name: "europe-west3"},
+	}}, nil
+}
+
+func TestGcpQuotaProjects(t *testing.T) {
+	uri := "/metrics"
+	cred := vault.CloudCredentials{
+		vault.GcpServiceAccount: "{\"type\": \"service_account\"}",
+		vault.GcpProjectID:      "projectID",
+	}
+	conf := &config.Config{
+		Region: "europe-west3",
+		Gcp: &config.GcpConfig{
+			Folders:        []string{"100"},
+			Organizations:  []string{"200"},
+			ProjectIDRegex: "^hana-",
+			ProjectLabels:  map[string]string{"owner": ""},
+		},
+	}
+	quotaCollector := NewMetricsCollectorGcpRmQuota(conf, cred, &log.Logger{})
+	projectsClient := &MockProjectsClient{}
+	serviceClient := &MockProjectServiceClient{}
+	quotaCollector.projectsClient = projectsClient
+	quotaCollector.client = serviceClient
+	quotaCollector.scrape(context.TODO())
+	quotaCollector.scrape(context.TODO())
+	// the organization and both folders are listed once
+	assert.Equal(t, 3, projectsClient.calls)
+	assert.ElementsMatch(t, []string{"hana-prod", "hana-dev"}, quotaCollector.projects)
+	assert.ElementsMatch(t, []string{"hana-prod", "hana-dev", "hana-prod", "hana-dev"}, serviceClient.projects)
+	// 2 quotas of 2 projects
+	assert.Len(t, common.QuotaCache.Items(), 4)
+
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(quotaCollector)
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		h := promhttp.HandlerFor(registry, promhttp.HandlerOpts{})
+		h.ServeHTTP(w, r)
+	})
+	assert.HTTPBodyContains(t, handler, "GET", uri, nil, "cpe_quota_current{Dimensions=\"\",ProjectID=\"9\",ProjectName=\"hana-prod\",QuotaCode=\"NETWORKS\",QuotaName=\"NETWORKS\",Region=\"global\",Regional=\"false\",ServiceName=\"compute.googleapis.com\",Unit=\"\"} 5\n")
+	assert.HTTPBodyContains(t, handler, "GET", uri, nil, "cpe_quota_current{Dimensions=\"\",ProjectID=\"8\",ProjectName=\"hana-dev\",QuotaCode=\"CPUS\",QuotaName=\"CPUS\",Region=\"europe-west3\",Regional=\"true\",ServiceName=\"compute.googleapis.com\",Unit=\"\"} 8\n")
+	assert.HTTPBodyNotContains(t, handler, "GET", uri, nil, "analytics")
+	assert.HTTPBodyNotContains(t, handler, "GET", uri, nil, "hana-other")
+	assert.HTTPBodyNotContains(t, handler, "GET", uri, nil, "hana-deleted")
+}
+
+func TestGcpQuotaProjectsOrganization(t *testing.T) {
+	cred := vault.CloudCredentials{
+		vault.GcpServiceAccount: "{\"type\": \"service_account\"}",
+		vault.GcpProjectID:      "projectID",
+	}
+	// the projects are in the folders of the organization, folder 501 is configured as well
+	conf := &config.Config{Region: "europe-west3", Gcp: &config.GcpConfig{Organizations: []string{"500"}, Folders: []string{"501"}}}
+	quotaCollector := NewMetricsCollectorGcpRmQuota(conf, cred, &log.Logger{})
+	projectsClient := &MockProjectsClient{}
+	quotaCollector.projectsClient = projectsClient
+	assert.ElementsMatch(t, []string{"hana-qa", "hana-nested"}, quotaCollector.discoverProjects(context.TODO()))
+	// the organization and both folders are listed once
+	assert.Equal(t, 3, projectsClient.calls)
+}
+
+func TestGcpQuotaProjectsFallback(t *testing.T) {
+	cred := vault.CloudCredentials{
+		vault.GcpServiceAccount: "{\"type\": \"service_account\"}",
+		vault.GcpProjectID:      "projectID",
+	}
+	conf := &config.Config{Region: "europe-west3", Gcp: &config.GcpConfig{Folders: []string{"300"}}}
+	quotaCollector := NewMetricsCollectorGcpRmQuota(conf, cred, &log.Logger{})
+	quotaCollector.projectsClient = &MockProjectsClient{}
+	assert.Equal(t, []string{"projectID"}, quotaCollector.discoverProjects(context.TODO()))
+}
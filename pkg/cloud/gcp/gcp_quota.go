@@ -13,7 +13,9 @@ import (
 	"golang.org/x/oauth2/google"
 	"google.golang.org/api/compute/v1"
 	"google.golang.org/api/option"
+	"regexp"
 	"strings"
+	"sync"
 	"time"
 )
 
@@ -43,11 +45,28 @@ func NewServiceClientWrapper(creds *google.Credentials, logger log.FieldLogger)
 	return cw
 }
 
+const (
+	// globalRegion is the region label of the quotas of the project
+	globalRegion   = "global"
+	computeService = "compute.googleapis.com"
+)
+
 type MetricsCollectorGcpRmQuota struct {
-	conf    *config.Config
-	client  ServiceClient
-	project string
-	log     log.FieldLogger
+	conf            *config.Config
+	client          ServiceClient
+	project         string
+	regions         map[string]struct{}
+	exportZeroUsage bool
+	// services whose quotas are read from Service Usage
+	services           []string
+	serviceUsageClient ServiceUsageClient
+	quotaUsageClient   QuotaUsageClient
+	// projects found under the configured folders and organizations
+	projectsClient ProjectsClient
+	projectRegex   *regexp.Regexp
+	projects       []string
+	discoveredAt   time.Time
+	log            log.FieldLogger
 }
 
 type Result struct {
@@ -55,6 +74,10 @@ type Result struct {
 	region      string
 	project     *compute.Project
 	regional    bool
+	service     string
+	dimensions  string
+	// usageUnknown is set when the usage could not be read, only the limit is exported
+	usageUnknown bool
 }
 
 func (m *MetricsCollectorGcpRmQuota) Describe(ch chan<- *prometheus.Desc) {
@@ -64,43 +87,82 @@ func (m *MetricsCollectorGcpRmQuota) Describe(ch chan<- *prometheus.Desc) {
 
 func (m *MetricsCollectorGcpRmQuota) scrape(ctx context.Context) {
 	m.log.Infof("Start collect GCP metrics")
-	m.log.Infof("Start collect GCP project metrics")
-	project, err := m.client.GetProject(m.project)
+	var waitGroup sync.WaitGroup
+	limit := make(chan struct{}, maxConcurrentProjects)
+	for _, projectID := range m.discoverProjects(ctx) {
+		waitGroup.Add(1)
+		go func(projectID string) {
+			defer waitGroup.Done()
+			limit <- struct{}{}
+			defer func() { <-limit }()
+			m.scrapeProject(ctx, projectID)
+		}(projectID)
+	}
+	waitGroup.Wait()
+	m.log.Infof("End collect GCP metrics")
+}
+
+func (m *MetricsCollectorGcpRmQuota) scrapeProject(ctx context.Context, projectID string) {
+	m.log.Infof("Start collect GCP project metrics of %s", projectID)
+	project, err := m.client.GetProject(projectID)
 	if err != nil {
-		m.log.Errorf("Error while getting project: ", err)
+		m.log.Errorf("Error while getting project %s: %v", projectID, err)
+		return
 	}
 	for _, quota := range project.Quotas {
-		if quota.Usage != 0 {
-			quotaResult := &common.QuotaResult{QuotaCode: quota.Metric, QuotaName: strings.ReplaceAll(quota.Metric, "_", " "), LimitValue: quota.Limit, CurrentValue: quota.Usage}
-			result := &Result{project: project, quotaResult: quotaResult, regional: false, region: m.conf.Region}
-			common.QuotaCache.Set(quota.Metric, result, cache.DefaultExpiration)
-
-		}
+		m.cacheQuota(project, quota, globalRegion, false)
 	}
-	m.log.Infof("Start collect GCP regional metrics")
-	regionList, err := m.client.GetRegionList(m.project)
+	m.log.Infof("Start collect GCP regional metrics of %s", projectID)
+	regionList, err := m.client.GetRegionList(projectID)
 	if err != nil {
-		m.log.Errorf("Error while getting region list: ", err)
+		m.log.Errorf("Error while getting region list of %s: %v", projectID, err)
+		return
 	}
 	for _, region := range regionList.Items {
+		if !m.selected(region.Name) {
+			continue
+		}
 		for _, quota := range region.Quotas {
-			if quota.Usage != 0 {
-				quotaResult := &common.QuotaResult{QuotaCode: quota.Metric, QuotaName: strings.ReplaceAll(quota.Metric, "_", " "), LimitValue: quota.Limit, CurrentValue: quota.Usage}
-				result := &Result{project: project, quotaResult: quotaResult, regional: true, region: m.conf.Region}
-				common.QuotaCache.Set(quota.Metric, result, cache.DefaultExpiration)
-			}
+			m.cacheQuota(project, quota, region.Name, true)
 		}
 	}
-	m.log.Infof("End collect GCP metrics")
+	if len(m.services) > 0 {
+		m.scrapeServiceQuotas(ctx, projectID, project)
+	}
+}
+
+// selected tells if the quotas of a region, or of a zone of it, are collected, all regions are unless an
+// allowlist is configured
+func (m *MetricsCollectorGcpRmQuota) selected(location string) bool {
+	if len(m.regions) == 0 {
+		return true
+	}
+	_, ok := m.regions[location]
+	if i := strings.LastIndex(location, "-"); !ok && i > 0 {
+		_, ok = m.regions[location[:i]]
+	}
+	return ok
+}
+
+// cacheQuota caches a quota by project, region and metric, as the regional quotas share their metrics across regions.
+func (m *MetricsCollectorGcpRmQuota) cacheQuota(project *compute.Project, quota *compute.Quota, region string, regional bool) {
+	if quota.Usage == 0 && !m.exportZeroUsage {
+		return
+	}
+	quotaResult := &common.QuotaResult{QuotaCode: quota.Metric, QuotaName: strings.ReplaceAll(quota.Metric, "_", " "), LimitValue: quota.Limit, CurrentValue: quota.Usage}
+	result := &Result{project: project, quotaResult: quotaResult, regional: regional, region: region, service: computeService}
+	common.QuotaCache.Set(strings.Join([]string{project.Name, region, quota.Metric}, "/"), result, cache.DefaultExpiration)
 }
 
 func (m *MetricsCollectorGcpRmQuota) Collect(ch chan<- prometheus.Metric) {
 	m.log.Infof("Start retrieve data from cache")
 	for _, item := range common.QuotaCache.Items() {
 		result := item.Object.(*Result)
-		m.log.WithFields(log.Fields{"regional": result.regional, "region": result.region, "quotaCode": result.quotaResult.QuotaCode, "quotaName": result.quotaResult.QuotaName, "projectId": result.project.Id, "projectName": result.project.Name, "current": result.quotaResult.CurrentValue, "limit": result.quotaResult.LimitValue}).Infof("retrieve data from cache")
-		common.QuotaCurrent.WithLabelValues(fmt.Sprintf("%v", result.regional), result.region, result.quotaResult.QuotaCode, result.quotaResult.QuotaName, fmt.Sprintf("%d", result.project.Id), result.project.Name).Set(result.quotaResult.CurrentValue)
-		common.QuotaLimit.WithLabelValues(fmt.Sprintf("%v", result.regional), result.region, result.quotaResult.QuotaCode, result.quotaResult.QuotaName, fmt.Sprintf("%d", result.project.Id), result.project.Name).Set(result.quotaResult.LimitValue)
+		m.log.WithFields(log.Fields{"regional": result.regional, "region": result.region, "quotaCode": result.quotaResult.QuotaCode, "quotaName": result.quotaResult.QuotaName, "projectId": result.project.Id, "projectName": result.project.Name, "service": result.service, "dimensions": result.dimensions, "current": result.quotaResult.CurrentValue, "limit": result.quotaResult.LimitValue}).Infof("retrieve data from cache")
+		if !result.usageUnknown {
+			common.QuotaCurrent.WithLabelValues(fmt.Sprintf("%v", result.regional), result.region, result.quotaResult.QuotaCode, result.quotaResult.QuotaName, fmt.Sprintf("%d", result.project.Id), result.project.Name, result.service, result.dimensions, result.quotaResult.Unit).Set(result.quotaResult.CurrentValue)
+		}
+		common.QuotaLimit.WithLabelValues(fmt.Sprintf("%v", result.regional), result.region, result.quotaResult.QuotaCode, result.quotaResult.QuotaName, fmt.Sprintf("%d", result.project.Id), result.project.Name, result.service, result.dimensions, result.quotaResult.Unit).Set(result.quotaResult.LimitValue)
 	}
 	common.QuotaLimit.Collect(ch)
 	common.QuotaCurrent.Collect(ch)
@@ -110,7 +172,7 @@ func NewMetricsCollectorGcpRmQuota(config *config.Config, cred vault.CloudCreden
 	m := &MetricsCollectorGcpRmQuota{}
 	m.conf = config
 	m.log = logger
-	credential, err := google.CredentialsFromJSON(context.Background(), []byte(cred[vault.GcpServiceAccount]), constant.GCPQuotaScope)
+	credential, err := google.CredentialsFromJSON(context.Background(), []byte(cred[vault.GcpServiceAccount]), constant.GCPQuotaScope, constant.GCPServiceUsageScope)
 	if err != nil {
 		m.log.Errorf("Error while getting credential: ", err)
 	}
@@ -118,8 +180,29 @@ func NewMetricsCollectorGcpRmQuota(config *config.Config, cred vault.CloudCreden
 	c := NewServiceClientWrapper(credential, m.log)
 	m.client = c
 	m.project = cred[vault.GcpProjectID]
+	if config.Gcp != nil {
+		m.regions = make(map[string]struct{})
+		for _, region := range config.Gcp.Regions {
+			m.regions[region] = struct{}{}
+		}
+		m.exportZeroUsage = config.Gcp.ExportZeroUsage
+		m.services = config.Gcp.QuotaServices
+		if len(config.Gcp.Folders) > 0 || len(config.Gcp.Organizations) > 0 {
+			m.projectsClient = NewProjectsClientWrapper(credential, m.log)
+		}
+		if config.Gcp.ProjectIDRegex != "" {
+			m.projectRegex, err = regexp.Compile(config.Gcp.ProjectIDRegex)
+			if err != nil {
+				m.log.Errorf("Error while compiling project ID regex, all projects are used: %v", err)
+			}
+		}
+	}
+	if len(m.services) > 0 {
+		m.serviceUsageClient = NewServiceUsageClientWrapper(credential, m.log)
+		m.quotaUsageClient = NewQuotaUsageClientWrapper(credential, m.log)
+	}
 
 	common.QuotaCache = cache.New(6*time.Minute, 10*time.Minute)
-	common.InitQuotaGaugeVec("GCP", []string{constant.LabelRegional, constant.LabelRegion, constant.LabelQuotaCode, constant.LabelQuotaName, constant.LabelProjectID, constant.LabelProjectName})
+	common.InitQuotaGaugeVec("GCP", []string{constant.LabelRegional, constant.LabelRegion, constant.LabelQuotaCode, constant.LabelQuotaName, constant.LabelProjectID, constant.LabelProjectName, constant.LabelServiceName, constant.LabelDimensions, constant.LabelUnit})
 	return m
 }
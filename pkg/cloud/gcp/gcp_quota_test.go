@@ -42,7 +42,14 @@ func (m *MockServiceClient) GetRegionList(project string) (*compute.RegionList,
 		Metric: "CPUS",
 	})
 	var regions []*compute.Region
-	regions = append(regions, &compute.Region{Quotas: quotas, Name: "Europe"})
+	regions = append(regions, &compute.Region{Quotas: quotas, Name: "europe-west3"})
+	regions = append(regions, &compute.Region{Quotas: []*compute.Quota{
+		{Usage: float64(20), Limit: float64(24), Metric: "CPUS"},
+		{Usage: float64(0), Limit: float64(10), Metric: "GPUS"},
+	}, Name: "europe-west4"})
+	regions = append(regions, &compute.Region{Quotas: []*compute.Quota{
+		{Usage: float64(8), Limit: float64(24), Metric: "CPUS"},
+	}, Name: "us-central1"})
 	regionList := &compute.RegionList{Items: regions}
 	return regionList, nil
 }
@@ -100,6 +107,39 @@ func TestGcpQuota(t *testing.T) {
 		h.ServeHTTP(w, r)
 	})
 
-	assert.HTTPBodyContains(t, handler, "GET", uri, nil, "cpe_quota_current{ProjectID=\"23\",ProjectName=\"projectName\",QuotaCode=\"code\",QuotaName=\"name\",Region=\"region\",Regional=\"false\"} 30\n")
-	assert.HTTPBodyContains(t, handler, "GET", uri, nil, "cpe_quota_limit{ProjectID=\"23\",ProjectName=\"projectName\",QuotaCode=\"code\",QuotaName=\"name\",Region=\"region\",Regional=\"false\"} 100\n")
+	assert.HTTPBodyContains(t, handler, "GET", uri, nil, "cpe_quota_current{Dimensions=\"\",ProjectID=\"23\",ProjectName=\"projectName\",QuotaCode=\"code\",QuotaName=\"name\",Region=\"region\",Regional=\"false\",ServiceName=\"\",Unit=\"\"} 30\n")
+	assert.HTTPBodyContains(t, handler, "GET", uri, nil, "cpe_quota_limit{Dimensions=\"\",ProjectID=\"23\",ProjectName=\"projectName\",QuotaCode=\"code\",QuotaName=\"name\",Region=\"region\",Regional=\"false\",ServiceName=\"\",Unit=\"\"} 100\n")
+}
+
+func TestGcpQuotaRegions(t *testing.T) {
+	uri := "/metrics"
+	cred := vault.CloudCredentials{
+		vault.GcpServiceAccount: "{\"type\": \"service_account\"}",
+		vault.GcpProjectID:      "projectID",
+	}
+	conf := &config.Config{
+		Region: "europe-west3",
+		Gcp: &config.GcpConfig{
+			Regions:         []string{"europe-west3", "europe-west4"},
+			ExportZeroUsage: true,
+		},
+	}
+	quotaCollector := NewMetricsCollectorGcpRmQuota(conf, cred, &log.Logger{})
+	quotaCollector.client = &MockServiceClient{}
+	quotaCollector.scrape(context.TODO())
+	// CPUS_ALL_REGIONS, CPUS of both regions and the unused GPUS, us-central1 is not in the allowlist
+	assert.Len(t, common.QuotaCache.Items(), 4)
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(quotaCollector)
+
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		h := promhttp.HandlerFor(registry, promhttp.HandlerOpts{})
+		h.ServeHTTP(w, r)
+	})
+
+	assert.HTTPBodyContains(t, handler, "GET", uri, nil, "cpe_quota_current{Dimensions=\"\",ProjectID=\"1\",ProjectName=\"mock_project\",QuotaCode=\"CPUS_ALL_REGIONS\",QuotaName=\"CPUS ALL REGIONS\",Region=\"global\",Regional=\"false\",ServiceName=\"compute.googleapis.com\",Unit=\"\"} 50\n")
+	assert.HTTPBodyContains(t, handler, "GET", uri, nil, "cpe_quota_current{Dimensions=\"\",ProjectID=\"1\",ProjectName=\"mock_project\",QuotaCode=\"CPUS\",QuotaName=\"CPUS\",Region=\"europe-west3\",Regional=\"true\",ServiceName=\"compute.googleapis.com\",Unit=\"\"} 80\n")
+	assert.HTTPBodyContains(t, handler, "GET", uri, nil, "cpe_quota_current{Dimensions=\"\",ProjectID=\"1\",ProjectName=\"mock_project\",QuotaCode=\"CPUS\",QuotaName=\"CPUS\",Region=\"europe-west4\",Regional=\"true\",ServiceName=\"compute.googleapis.com\",Unit=\"\"} 20\n")
+	assert.HTTPBodyContains(t, handler, "GET", uri, nil, "cpe_quota_limit{Dimensions=\"\",ProjectID=\"1\",ProjectName=\"mock_project\",QuotaCode=\"GPUS\",QuotaName=\"GPUS\",Region=\"europe-west4\",Regional=\"true\",ServiceName=\"compute.googleapis.com\",Unit=\"\"} 10\n")
+	assert.HTTPBodyNotContains(t, handler, "GET", uri, nil, "us-central1")
 }
@@ -0,0 +1,212 @@
+package gcp
+
+import (
+	"context"
+	"fmt"
+	"github.com/patrickmn/go-cache"
+	log "github.com/sirupsen/logrus"
+	"github.wdf.sap.corp/DBaaS/cloud-provider-exporter/pkg/cloud/common"
+	"golang.org/x/oauth2/google"
+	"google.golang.org/api/compute/v1"
+	"google.golang.org/api/monitoring/v3"
+	"google.golang.org/api/option"
+	"google.golang.org/api/serviceusage/v1beta1"
+	"math"
+	"sort"
+	"strings"
+	"time"
+)
+
+// The quota usage of the services is only reported to Cloud Monitoring, the consumer quota metrics
+// of Service Usage carry the limits. The usage of allocation and rate quotas are different series.
+const (
+	allocationUsageMetricType = "serviceruntime.googleapis.com/quota/allocation/usage"
+	rateUsageMetricType       = "serviceruntime.googleapis.com/quota/rate/net_usage"
+)
+
+var quotaUsageMetricTypes = []string{allocationUsageMetricType, rateUsageMetricType}
+
+// allocation usages are only written when they change, so the latest point within a day is the current usage
+const quotaUsageWindow = 24 * time.Hour
+
+type ServiceUsageClient interface {
+	ListConsumerQuotaMetrics(ctx context.Context, project, service string) ([]*serviceusage.ConsumerQuotaMetric, error)
+}
+
+type ServiceUsageClientWrapper struct {
+	client *serviceusage.APIService
+}
+
+func (cw *ServiceUsageClientWrapper) ListConsumerQuotaMetrics(ctx context.Context, project, service string) ([]*serviceusage.ConsumerQuotaMetric, error) {
+	var metrics []*serviceusage.ConsumerQuotaMetric
+	parent := fmt.Sprintf("projects/%s/services/%s", project, service)
+	// the BASIC view leaves out the buckets of regions and zones without an override
+	err := cw.client.Services.ConsumerQuotaMetrics.List(parent).View("FULL").Pages(ctx, func(page *serviceusage.ListConsumerQuotaMetricsResponse) error {
+		metrics = append(metrics, page.Metrics...)
+		return nil
+	})
+	return metrics, err
+}
+
+func NewServiceUsageClientWrapper(creds *google.Credentials, logger log.FieldLogger) *ServiceUsageClientWrapper {
+	c, err := serviceusage.NewService(context.Background(), option.WithCredentials(creds))
+	if err != nil {
+		logger.Errorf("Error while getting service usage client: %v", err)
+	}
+	return &ServiceUsageClientWrapper{client: c}
+}
+
+// QuotaUsages are the latest usages of the quotas of a project by quotaUsageKey, and by the limit_name of their series
+type QuotaUsages map[string]map[string]float64
+
+func (u QuotaUsages) add(key, limitName string, value float64) {
+	if u[key] == nil {
+		u[key] = make(map[string]float64)
+	}
+	u[key][limitName] += value
+}
+
+// usage returns the usage of a quota limit. Service Usage does not tell the limit_name of its limits, the limits
+// of a quota metric count the same usage so the highest one reported for any of them is used.
+func (u QuotaUsages) usage(key string) (float64, bool) {
+	byLimit, ok := u[key]
+	if !ok {
+		return 0, false
+	}
+	usage := 0.0
+	for _, value := range byLimit {
+		usage = math.Max(usage, value)
+	}
+	return usage, true
+}
+
+type QuotaUsageClient interface {
+	ListQuotaUsages(ctx context.Context, project string) (QuotaUsages, error)
+}
+
+type QuotaUsageClientWrapper struct {
+	client *monitoring.Service
+}
+
+func (cw *QuotaUsageClientWrapper) ListQuotaUsages(ctx context.Context, project string) (QuotaUsages, error) {
+	usages := make(QuotaUsages)
+	end := time.Now().UTC()
+	for _, metricType := range quotaUsageMetricTypes {
+		call := cw.client.Projects.TimeSeries.List("projects/" + project).
+			Filter(fmt.Sprintf(`metric.type = "%s" AND resource.type = "consumer_quota"`, metricType)).
+			IntervalStartTime(end.Add(-quotaUsageWindow).Format(time.RFC3339)).
+			IntervalEndTime(end.Format(time.RFC3339))
+		err := call.Pages(ctx, func(page *monitoring.ListTimeSeriesResponse) error {
+			for _, series := range page.TimeSeries {
+				if len(series.Points) == 0 || series.Resource == nil || series.Metric == nil {
+					continue
+				}
+				// the points are ordered from the newest, the series of the methods of a rate quota add up
+				key := quotaUsageKey(metricType, series.Resource.Labels["service"], series.Metric.Labels["quota_metric"], series.Resource.Labels["location"])
+				usages.add(key, series.Metric.Labels["limit_name"], pointValue(series.Points[0]))
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return usages, nil
+}
+
+func pointValue(point *monitoring.Point) float64 {
+	if point.Value == nil {
+		return 0
+	}
+	if point.Value.Int64Value != nil {
+		return float64(*point.Value.Int64Value)
+	}
+	if point.Value.DoubleValue != nil {
+		return *point.Value.DoubleValue
+	}
+	return 0
+}
+
+func NewQuotaUsageClientWrapper(creds *google.Credentials, logger log.FieldLogger) *QuotaUsageClientWrapper {
+	c, err := monitoring.NewService(context.Background(), option.WithCredentials(creds))
+	if err != nil {
+		logger.Errorf("Error while getting monitoring client: %v", err)
+	}
+	return &QuotaUsageClientWrapper{client: c}
+}
+
+func quotaUsageKey(metricType, service, quotaMetric, location string) string {
+	return strings.Join([]string{metricType, service, quotaMetric, location}, "|")
+}
+
+// usageMetricType returns the series of the usage of a limit, rate limits have a time unit like 1/min/{project}
+func usageMetricType(unit string) string {
+	parts := strings.Split(unit, "/")
+	if len(parts) > 2 && !strings.HasPrefix(parts[1], "{") {
+		return rateUsageMetricType
+	}
+	return allocationUsageMetricType
+}
+
+// bucketLocation returns the region or zone a quota bucket applies to, global for the project wide buckets
+func bucketLocation(dimensions map[string]string) string {
+	if region, ok := dimensions["region"]; ok {
+		return region
+	}
+	if zone, ok := dimensions["zone"]; ok {
+		return zone
+	}
+	return globalRegion
+}
+
+func formatDimensions(dimensions map[string]string) string {
+	pairs := make([]string, 0, len(dimensions))
+	for k, v := range dimensions {
+		pairs = append(pairs, k+"="+v)
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, ",")
+}
+
+// scrapeServiceQuotas caches the quota buckets of the configured services, with the usage reported to Cloud Monitoring.
+// Without the usages the limits are still cached.
+func (m *MetricsCollectorGcpRmQuota) scrapeServiceQuotas(ctx context.Context, projectID string, project *compute.Project) {
+	m.log.Infof("Start collect GCP service quota metrics of %s", projectID)
+	usages, err := m.quotaUsageClient.ListQuotaUsages(ctx, projectID)
+	if err != nil {
+		m.log.Errorf("Error while listing quota usages of %s, only the limits are exported: %v", projectID, err)
+	}
+	for _, service := range m.services {
+		metrics, err := m.serviceUsageClient.ListConsumerQuotaMetrics(ctx, projectID, service)
+		if err != nil {
+			m.log.Errorf("Error while listing quota metrics of %s in %s: %v", service, projectID, err)
+			continue
+		}
+		for _, metric := range metrics {
+			for _, limit := range metric.ConsumerQuotaLimits {
+				for _, bucket := range limit.QuotaBuckets {
+					m.cacheServiceQuota(project, service, metric, limit, bucket, usages)
+				}
+			}
+		}
+	}
+	m.log.Infof("End collect GCP service quota metrics of %s", projectID)
+}
+
+func (m *MetricsCollectorGcpRmQuota) cacheServiceQuota(project *compute.Project, service string, metric *serviceusage.ConsumerQuotaMetric,
+	limit *serviceusage.ConsumerQuotaLimit, bucket *serviceusage.QuotaBucket, usages QuotaUsages) {
+	location := bucketLocation(bucket.Dimensions)
+	regional := location != globalRegion
+	if bucket.EffectiveLimit < 0 || (regional && !m.selected(location)) { // unlimited or not an allowed region
+		return
+	}
+	usage, _ := usages.usage(quotaUsageKey(usageMetricType(limit.Unit), service, metric.Metric, location))
+	if usages != nil && usage == 0 && !m.exportZeroUsage {
+		return
+	}
+	dimensions := formatDimensions(bucket.Dimensions)
+	quotaResult := &common.QuotaResult{QuotaCode: metric.Metric, QuotaName: metric.DisplayName, LimitValue: float64(bucket.EffectiveLimit), CurrentValue: usage, Unit: limit.Unit}
+	result := &Result{project: project, quotaResult: quotaResult, regional: regional, region: location, service: service, dimensions: dimensions,
+		usageUnknown: usages == nil}
+	common.QuotaCache.Set(strings.Join([]string{project.Name, location, limit.Name, dimensions}, "/"), result, cache.DefaultExpiration)
+}
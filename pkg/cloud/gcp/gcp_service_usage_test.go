@@ -0,0 +1,186 @@
+package gcp
+
+import (
+	"context"
+	"errors"
+	"github.com/jarcoal/httpmock"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	log "github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.wdf.sap.corp/DBaaS/cloud-provider-exporter/pkg/cloud/common"
+	"github.wdf.sap.corp/DBaaS/cloud-provider-exporter/pkg/config"
+	"github.wdf.sap.corp/DBaaS/cloud-provider-exporter/pkg/vault"
+	"google.golang.org/api/option"
+	"google.golang.org/api/serviceusage/v1beta1"
+	"net/http"
+	"testing"
+)
+
+type MockServiceUsageClient struct {
+}
+
+func (m *MockServiceUsageClient) ListConsumerQuotaMetrics(ctx context.Context, project, service string) ([]*serviceusage.ConsumerQuotaMetric, error) {
+	if service != "container.googleapis.com" {
+		return nil, nil
+	}
+	return []*serviceusage.ConsumerQuotaMetric{
+		{
+			Metric:      "container.googleapis.com/clusters",
+			DisplayName: "Clusters",
+			ConsumerQuotaLimits: []*serviceusage.ConsumerQuotaLimit{{
+				Name: "projects/1/services/container.googleapis.com/consumerQuotaMetrics/container.googleapis.com%2Fclusters/limits/%2Fproject%2Fregion",
+				Unit: "1/{project}/{region}",
+				QuotaBuckets: []*serviceusage.QuotaBucket{
+					{EffectiveLimit: 50, Dimensions: map[string]string{"region": "europe-west3"}},
+					{EffectiveLimit: 50, Dimensions: map[string]string{"region": "europe-west4"}},
+					{EffectiveLimit: 50, Dimensions: map[string]string{"region": "us-central1"}},
+				},
+			}},
+		},
+		{
+			Metric:      "container.googleapis.com/requests",
+			DisplayName: "Requests",
+			ConsumerQuotaLimits: []*serviceusage.ConsumerQuotaLimit{{
+				Name:         "projects/1/services/container.googleapis.com/consumerQuotaMetrics/container.googleapis.com%2Frequests/limits/%2Fmin%2Fproject",
+				Unit:         "1/min/{project}",
+				QuotaBuckets: []*serviceusage.QuotaBucket{{EffectiveLimit: 3000}},
+			}},
+		},
+		{
+			Metric:      "container.googleapis.com/unlimited",
+			DisplayName: "Unlimited",
+			ConsumerQuotaLimits: []*serviceusage.ConsumerQuotaLimit{{
+				Name:         "projects/1/services/container.googleapis.com/consumerQuotaMetrics/container.googleapis.com%2Funlimited/limits/%2Fproject",
+				Unit:         "1/{project}",
+				QuotaBuckets: []*serviceusage.QuotaBucket{{EffectiveLimit: -1}},
+			}},
+		},
+	}, nil
+}
+
+type MockQuotaUsageClient struct {
+	err error
+}
+
+func (m *MockQuotaUsageClient) ListQuotaUsages(ctx context.Context, project string) (QuotaUsages, error) {
+	if m.err != nil {
+		return nil, m.err
+	}
+	usages := make(QuotaUsages)
+	usages.add(quotaUsageKey(allocationUsageMetricType, "container.googleapis.com", "container.googleapis.com/clusters", "europe-west3"), "Clusters-per-region", 4)
+	usages.add(quotaUsageKey(allocationUsageMetricType, "container.googleapis.com", "container.googleapis.com/clusters", "us-central1"), "Clusters-per-region", 2)
+	// the rate usages of the methods add up, the limits of a metric count the same usage
+	usages.add(quotaUsageKey(rateUsageMetricType, "container.googleapis.com", "container.googleapis.com/requests", "global"), "Requests-per-minute", 100)
+	usages.add(quotaUsageKey(rateUsageMetricType, "container.googleapis.com", "container.googleapis.com/requests", "global"), "Requests-per-minute", 20)
+	usages.add(quotaUsageKey(rateUsageMetricType, "container.googleapis.com", "container.googleapis.com/requests", "global"), "", 120)
+	// an allocation usage of a rate quota metric is not its usage
+	usages.add(quotaUsageKey(allocationUsageMetricType, "container.googleapis.com", "container.googleapis.com/requests", "global"), "", 5)
+	usages.add(quotaUsageKey(allocationUsageMetricType, "container.googleapis.com", "container.googleapis.com/unlimited", "global"), "", 7)
+	return usages, nil
+}
+
+func TestGcpServiceQuotas(t *testing.T) {
+	uri := "/metrics"
+	cred := vault.CloudCredentials{
+		vault.GcpServiceAccount: "{\"type\": \"service_account\"}",
+		vault.GcpProjectID:      "projectID",
+	}
+	conf := &config.Config{
+		Region: "europe-west3",
+		Gcp: &config.GcpConfig{
+			Regions:       []string{"europe-west3", "europe-west4"},
+			QuotaServices: []string{"container.googleapis.com", "file.googleapis.com"},
+		},
+	}
+	quotaCollector := NewMetricsCollectorGcpRmQuota(conf, cred, &log.Logger{})
+	quotaCollector.client = &MockServiceClient{}
+	quotaCollector.serviceUsageClient = &MockServiceUsageClient{}
+	quotaCollector.quotaUsageClient = &MockQuotaUsageClient{}
+	quotaCollector.scrape(context.TODO())
+	// 3 used compute quotas, the clusters of europe-west3 and the requests
+	assert.Len(t, common.QuotaCache.Items(), 5)
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(quotaCollector)
+
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		h := promhttp.HandlerFor(registry, promhttp.HandlerOpts{})
+		h.ServeHTTP(w, r)
+	})
+
+	assert.HTTPBodyContains(t, handler, "GET", uri, nil, "cpe_quota_current{Dimensions=\"region=europe-west3\",ProjectID=\"1\",ProjectName=\"mock_project\",QuotaCode=\"container.googleapis.com/clusters\",QuotaName=\"Clusters\",Region=\"europe-west3\",Regional=\"true\",ServiceName=\"container.googleapis.com\",Unit=\"1/{project}/{region}\"} 4\n")
+	assert.HTTPBodyContains(t, handler, "GET", uri, nil, "cpe_quota_limit{Dimensions=\"region=europe-west3\",ProjectID=\"1\",ProjectName=\"mock_project\",QuotaCode=\"container.googleapis.com/clusters\",QuotaName=\"Clusters\",Region=\"europe-west3\",Regional=\"true\",ServiceName=\"container.googleapis.com\",Unit=\"1/{project}/{region}\"} 50\n")
+	assert.HTTPBodyContains(t, handler, "GET", uri, nil, "cpe_quota_current{Dimensions=\"\",ProjectID=\"1\",ProjectName=\"mock_project\",QuotaCode=\"container.googleapis.com/requests\",QuotaName=\"Requests\",Region=\"global\",Regional=\"false\",ServiceName=\"container.googleapis.com\",Unit=\"1/min/{project}\"} 120\n")
+	assert.HTTPBodyNotContains(t, handler, "GET", uri, nil, "region=europe-west4")
+	assert.HTTPBodyNotContains(t, handler, "GET", uri, nil, "region=us-central1")
+	assert.HTTPBodyNotContains(t, handler, "GET", uri, nil, "Unlimited")
+}
+
+func TestGcpServiceQuotasWithoutUsage(t *testing.T) {
+	cred := vault.CloudCredentials{
+		vault.GcpServiceAccount: "{\"type\": \"service_account\"}",
+		vault.GcpProjectID:      "projectID",
+	}
+	conf := &config.Config{
+		Region: "europe-west3",
+		Gcp: &config.GcpConfig{
+			Regions:       []string{"europe-west3"},
+			QuotaServices: []string{"container.googleapis.com"},
+		},
+	}
+	quotaCollector := NewMetricsCollectorGcpRmQuota(conf, cred, &log.Logger{})
+	quotaCollector.client = &MockServiceClient{}
+	quotaCollector.serviceUsageClient = &MockServiceUsageClient{}
+	quotaCollector.quotaUsageClient = &MockQuotaUsageClient{err: errors.New("permission denied")}
+	quotaCollector.scrape(context.TODO())
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(quotaCollector)
+	handler := promhttp.HandlerFor(registry, promhttp.HandlerOpts{})
+
+	// the limits are exported without a usage
+	assert.HTTPBodyContains(t, handler.ServeHTTP, "GET", "/metrics", nil, "cpe_quota_limit{Dimensions=\"\",ProjectID=\"1\",ProjectName=\"mock_project\",QuotaCode=\"container.googleapis.com/requests\",QuotaName=\"Requests\",Region=\"global\",Regional=\"false\",ServiceName=\"container.googleapis.com\",Unit=\"1/min/{project}\"} 3000\n")
+	assert.HTTPBodyNotContains(t, handler.ServeHTTP, "GET", "/metrics", nil, "cpe_quota_current{Dimensions=\"\",ProjectID=\"1\",ProjectName=\"mock_project\",QuotaCode=\"container.googleapis.com/requests\"")
+}
+
+func TestUsageMetricType(t *testing.T) {
+	assert.Equal(t, rateUsageMetricType, usageMetricType("1/min/{project}"))
+	assert.Equal(t, rateUsageMetricType, usageMetricType("1/100s/{project}/{user}"))
+	assert.Equal(t, allocationUsageMetricType, usageMetricType("1/{project}/{region}"))
+	assert.Equal(t, allocationUsageMetricType, usageMetricType("1/{project}"))
+}
+
+var consumerQuotaMetrics = `{
+  "metrics": [
+    {
+      "name": "projects/1/services/container.googleapis.com/consumerQuotaMetrics/container.googleapis.com%2Fclusters",
+      "displayName": "Clusters",
+      "metric": "container.googleapis.com/clusters",
+      "consumerQuotaLimits": [
+        {
+          "name": "projects/1/services/container.googleapis.com/consumerQuotaMetrics/container.googleapis.com%2Fclusters/limits/%2Fproject%2Fregion",
+          "unit": "1/{project}/{region}",
+          "quotaBuckets": [
+            {"effectiveLimit": "50", "defaultLimit": "50"},
+            {"effectiveLimit": "50", "defaultLimit": "50", "dimensions": {"region": "europe-west3"}}
+          ]
+        }
+      ]
+    }
+  ]
+}`
+
+func TestServiceUsageClientWrapper(t *testing.T) {
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+	// the buckets of the regions are only listed in the full view
+	httpmock.RegisterResponderWithQuery("GET", "https://serviceusage.googleapis.com/v1beta1/projects/1/services/container.googleapis.com/consumerQuotaMetrics",
+		map[string]string{"view": "FULL", "alt": "json", "prettyPrint": "false"}, httpmock.NewStringResponder(http.StatusOK, consumerQuotaMetrics))
+	client, err := serviceusage.NewService(context.TODO(), option.WithHTTPClient(http.DefaultClient))
+	assert.NoError(t, err)
+	metrics, err := (&ServiceUsageClientWrapper{client}).ListConsumerQuotaMetrics(context.TODO(), "1", "container.googleapis.com")
+	assert.NoError(t, err)
+	assert.Len(t, metrics, 1)
+	buckets := metrics[0].ConsumerQuotaLimits[0].QuotaBuckets
+	assert.Len(t, buckets, 2)
+	assert.Equal(t, "europe-west3", bucketLocation(buckets[1].Dimensions))
+}
@@ -5,13 +5,13 @@ import (
 	"context"
 	"github.com/prometheus/client_golang/prometheus"
 	log "github.com/sirupsen/logrus"
-	"github.wdf.sap.corp/DBaaS/cloud-provider-exporter/pkg/cloud/common"
+	"github.wdf.sap.corp/DBaaS/cloud-provider-exporter/pkg/cloud/objectstore"
 	"github.wdf.sap.corp/DBaaS/cloud-provider-exporter/pkg/config"
 	"github.wdf.sap.corp/DBaaS/cloud-provider-exporter/pkg/vault"
 	"golang.org/x/oauth2/google"
 	"google.golang.org/api/iterator"
 	"google.golang.org/api/option"
-	"time"
+	"io"
 )
 
 type MetricsCollectorGcpVaultBucket struct {
@@ -21,6 +21,10 @@ type MetricsCollectorGcpVaultBucket struct {
 
 type IClient interface {
 	GetObjects(bucketName string, prefix string, ctx context.Context) IIter
+	ReadObject(bucketName string, objectName string, offset, length int64, ctx context.Context) (io.ReadCloser, error)
+	GetBucketAttrs(bucketName string, ctx context.Context) (*storage.BucketAttrs, error)
+	// GetBucketMembers returns the members of all roles of the IAM policy of the bucket
+	GetBucketMembers(bucketName string, ctx context.Context) ([]string, error)
 }
 
 type ClientWrapper struct {
@@ -33,6 +37,26 @@ func (cw *ClientWrapper) GetObjects(bucketName string, prefix string, ctx contex
 	return bucket.Objects(ctx, query)
 }
 
+func (cw *ClientWrapper) ReadObject(bucketName string, objectName string, offset, length int64, ctx context.Context) (io.ReadCloser, error) {
+	return cw.Client.Bucket(bucketName).Object(objectName).NewRangeReader(ctx, offset, length)
+}
+
+func (cw *ClientWrapper) GetBucketAttrs(bucketName string, ctx context.Context) (*storage.BucketAttrs, error) {
+	return cw.Client.Bucket(bucketName).Attrs(ctx)
+}
+
+func (cw *ClientWrapper) GetBucketMembers(bucketName string, ctx context.Context) ([]string, error) {
+	policy, err := cw.Client.Bucket(bucketName).IAM().Policy(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var members []string
+	for _, role := range policy.Roles() {
+		members = append(members, policy.Members(role)...)
+	}
+	return members, nil
+}
+
 type IIter interface {
 	Next() (*storage.ObjectAttrs, error)
 }
@@ -48,69 +72,67 @@ func NewMetricsCollectorGcpVaultBucket(config *config.Config, cred vault.CloudCr
 	client := ClientWrapper{storageClient}
 	m.client = &client
 	m.conf = config
-	common.InitVaultBackupBucketDesc("", "GCP", []string{"bucket", "prefix"})
+	objectstore.InitDesc("GCP")
 	return m
 }
 
 func (m *MetricsCollectorGcpVaultBucket) Describe(ch chan<- *prometheus.Desc) {
-	ch <- common.ListSuccess
-	ch <- common.LastModifiedObjectDate
-	ch <- common.LastModifiedObjectSize
-	ch <- common.ObjectTotal
-	ch <- common.SumSize
-	ch <- common.BiggestSize
+	objectstore.Describe(ch)
 }
 
 func (m *MetricsCollectorGcpVaultBucket) Collect(ch chan<- prometheus.Metric) {
-	var createdTime time.Time
-	var numberOfObjects float64
-	var totalSize int64
-	var biggestObjectSize int64
-	var lastObjectSize int64
+	targets := objectstore.NewTargets(objectstore.Targets(m.conf), &GcsLister{m.client})
+	objectstore.Collect(context.Background(), ch, targets, log.StandardLogger())
+}
 
-	ctx := context.Background()
-	bucketName := m.conf.VaultBackupBucket.Bucket
-	prefix := m.conf.VaultBackupBucket.Prefix
-	it := m.client.GetObjects(bucketName, prefix, ctx)
+// GcsLister lists the objects of Cloud Storage buckets
+type GcsLister struct {
+	client IClient
+}
+
+func (l *GcsLister) ListObjects(ctx context.Context, bucket, prefix string, fn func(objectstore.Object)) error {
+	it := l.client.GetObjects(bucket, prefix, ctx)
 	for {
 		attrs, err := it.Next()
 		if err == iterator.Done {
-			break
+			return nil
 		}
 		if err != nil {
-			log.Errorln(err)
-			ch <- prometheus.MustNewConstMetric(
-				common.ListSuccess, prometheus.GaugeValue, 0, bucketName, prefix,
-			)
-			return
-		}
-		numberOfObjects++
-		totalSize = totalSize + attrs.Size
-		if attrs.Created.After(createdTime) {
-			createdTime = attrs.Created
-			lastObjectSize = attrs.Size
-		}
-		if attrs.Size > biggestObjectSize {
-			biggestObjectSize = attrs.Size
+			return err
 		}
+		// objects are immutable, a new generation is created on each write
+		fn(objectstore.Object{Key: attrs.Name, Size: attrs.Size, LastModified: attrs.Created, MD5: attrs.MD5})
 	}
+}
 
-	ch <- prometheus.MustNewConstMetric(
-		common.ListSuccess, prometheus.GaugeValue, 1, bucketName, prefix,
-	)
-	ch <- prometheus.MustNewConstMetric(
-		common.LastModifiedObjectDate, prometheus.GaugeValue, float64(createdTime.UnixNano()/1e9), bucketName, prefix,
-	)
-	ch <- prometheus.MustNewConstMetric(
-		common.LastModifiedObjectSize, prometheus.GaugeValue, float64(lastObjectSize), bucketName, prefix,
-	)
-	ch <- prometheus.MustNewConstMetric(
-		common.ObjectTotal, prometheus.GaugeValue, numberOfObjects, bucketName, prefix,
-	)
-	ch <- prometheus.MustNewConstMetric(
-		common.BiggestSize, prometheus.GaugeValue, float64(biggestObjectSize), bucketName, prefix,
-	)
-	ch <- prometheus.MustNewConstMetric(
-		common.SumSize, prometheus.GaugeValue, float64(totalSize), bucketName, prefix,
-	)
+func (l *GcsLister) ReadObject(ctx context.Context, bucket, key string, offset, length int64) (io.ReadCloser, error) {
+	return l.client.ReadObject(bucket, key, offset, length, ctx)
+}
+
+// InspectBucket checks the settings of a bucket. Objects are always encrypted at rest, with the key of the bucket
+// if it has one.
+func (l *GcsLister) InspectBucket(ctx context.Context, bucket string) (map[string]bool, error) {
+	attrs, err := l.client.GetBucketAttrs(bucket, ctx)
+	if err != nil {
+		return nil, err
+	}
+	checks := map[string]bool{
+		objectstore.CheckVersioning: attrs.VersioningEnabled,
+		objectstore.CheckEncryption: true,
+		objectstore.CheckObjectLock: attrs.RetentionPolicy != nil && attrs.RetentionPolicy.IsLocked,
+		objectstore.CheckLifecycle:  len(attrs.Lifecycle.Rules) > 0 || attrs.RetentionPolicy != nil,
+	}
+	members, err := l.client.GetBucketMembers(bucket, ctx)
+	if err != nil {
+		return checks, err
+	}
+	// without uniform access the ACLs of the objects may still make them public
+	blocked := attrs.UniformBucketLevelAccess.Enabled
+	for _, member := range members {
+		if member == "allUsers" || member == "allAuthenticatedUsers" {
+			blocked = false
+		}
+	}
+	checks[objectstore.CheckPublicAccessBlocked] = blocked
+	return checks, nil
 }
@@ -1,6 +1,7 @@
 package gcp
 
 import (
+	"bytes"
 	"cloud.google.com/go/storage"
 	"context"
 	"github.com/prometheus/client_golang/prometheus"
@@ -10,6 +11,7 @@ import (
 	"github.wdf.sap.corp/DBaaS/cloud-provider-exporter/pkg/constant"
 	"github.wdf.sap.corp/DBaaS/cloud-provider-exporter/pkg/vault"
 	"google.golang.org/api/iterator"
+	"io"
 	"net/http"
 	"testing"
 	"time"
@@ -25,6 +27,23 @@ func (m *MockClient) GetObjects(bucketName string, prefix string, ctx context.Co
 	return &iter
 }
 
+func (m *MockClient) ReadObject(bucketName string, objectName string, offset, length int64, ctx context.Context) (io.ReadCloser, error) {
+	return io.NopCloser(bytes.NewReader(make([]byte, length))), nil
+}
+
+func (m *MockClient) GetBucketAttrs(bucketName string, ctx context.Context) (*storage.BucketAttrs, error) {
+	return &storage.BucketAttrs{
+		Name:                     bucketName,
+		VersioningEnabled:        true,
+		RetentionPolicy:          &storage.RetentionPolicy{RetentionPeriod: 30 * 24 * time.Hour},
+		UniformBucketLevelAccess: storage.UniformBucketLevelAccess{Enabled: true},
+	}, nil
+}
+
+func (m *MockClient) GetBucketMembers(bucketName string, ctx context.Context) ([]string, error) {
+	return []string{"projectViewer:projectID", "allAuthenticatedUsers"}, nil
+}
+
 type MockIterator struct {
 }
 
@@ -79,9 +98,13 @@ func TestGcpVaultBucket(t *testing.T) {
 		h.ServeHTTP(w, r)
 	})
 
-	assert.HTTPBodyContains(t, handler, "GET", uri, nil, "cpe_vault_object_list_success{bucket=\"mock_bucket\",prefix=\"mock_prefix\"} 1")
-	assert.HTTPBodyContains(t, handler, "GET", uri, nil, "cpe_vault_object_last_modified_size_bytes{bucket=\"mock_bucket\",prefix=\"mock_prefix\"} 200")
-	assert.HTTPBodyContains(t, handler, "GET", uri, nil, "cpe_vault_object_count{bucket=\"mock_bucket\",prefix=\"mock_prefix\"} 2")
-	assert.HTTPBodyContains(t, handler, "GET", uri, nil, "cpe_vault_object_max_size_bytes{bucket=\"mock_bucket\",prefix=\"mock_prefix\"} 200")
-	assert.HTTPBodyContains(t, handler, "GET", uri, nil, "cpe_vault_object_size_bytes_total{bucket=\"mock_bucket\",prefix=\"mock_prefix\"} 300")
+	assert.HTTPBodyContains(t, handler, "GET", uri, nil, "cpe_vault_object_list_success{bucket=\"mock_bucket\",group=\"\",prefix=\"mock_prefix\"} 1")
+	assert.HTTPBodyContains(t, handler, "GET", uri, nil, "cpe_vault_object_last_modified_size_bytes{bucket=\"mock_bucket\",group=\"\",prefix=\"mock_prefix\"} 200")
+	assert.HTTPBodyContains(t, handler, "GET", uri, nil, "cpe_vault_object_count{bucket=\"mock_bucket\",group=\"\",prefix=\"mock_prefix\"} 2")
+	assert.HTTPBodyContains(t, handler, "GET", uri, nil, "cpe_vault_object_max_size_bytes{bucket=\"mock_bucket\",group=\"\",prefix=\"mock_prefix\"} 200")
+	assert.HTTPBodyContains(t, handler, "GET", uri, nil, "cpe_vault_object_size_bytes_total{bucket=\"mock_bucket\",group=\"\",prefix=\"mock_prefix\"} 300")
+	assert.HTTPBodyContains(t, handler, "GET", uri, nil, "cpe_bucket_compliance{bucket=\"mock_bucket\",check=\"versioning\",group=\"\",prefix=\"mock_prefix\"} 1")
+	assert.HTTPBodyContains(t, handler, "GET", uri, nil, "cpe_bucket_compliance{bucket=\"mock_bucket\",check=\"object_lock\",group=\"\",prefix=\"mock_prefix\"} 0")
+	assert.HTTPBodyContains(t, handler, "GET", uri, nil, "cpe_bucket_compliance{bucket=\"mock_bucket\",check=\"lifecycle\",group=\"\",prefix=\"mock_prefix\"} 1")
+	assert.HTTPBodyContains(t, handler, "GET", uri, nil, "cpe_bucket_compliance{bucket=\"mock_bucket\",check=\"public_access_blocked\",group=\"\",prefix=\"mock_prefix\"} 0")
 }
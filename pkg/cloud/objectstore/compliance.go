@@ -0,0 +1,65 @@
+package objectstore
+
+import (
+	"context"
+	"github.com/prometheus/client_golang/prometheus"
+	log "github.com/sirupsen/logrus"
+	"github.wdf.sap.corp/DBaaS/cloud-provider-exporter/pkg/cloud/common"
+	"sort"
+)
+
+// The compliance checks of the bucket settings
+const (
+	CheckVersioning          = "versioning"
+	CheckEncryption          = "encryption"
+	CheckObjectLock          = "object_lock" // object lock, WORM or immutability policy
+	CheckLifecycle           = "lifecycle"   // lifecycle or retention rules
+	CheckPublicAccessBlocked = "public_access_blocked"
+)
+
+// BucketInspector returns the compliance of the settings of a bucket by check. Listers implementing it allow the
+// compliance checks, checks a store does not support are left out.
+type BucketInspector interface {
+	InspectBucket(ctx context.Context, bucket string) (map[string]bool, error)
+}
+
+// compliance inspects the buckets of the targets once per scrape.
+type compliance struct {
+	results map[inspection]map[string]bool
+}
+
+// inspection is a bucket of a store, buckets of the same name may exist in several accounts or stores
+type inspection struct {
+	inspector BucketInspector
+	bucket    string
+}
+
+func newCompliance() *compliance {
+	return &compliance{results: make(map[inspection]map[string]bool)}
+}
+
+func (c *compliance) collect(ctx context.Context, ch chan<- prometheus.Metric, target *Target, logger log.FieldLogger) {
+	inspector, ok := target.Lister.(BucketInspector)
+	if !ok {
+		return
+	}
+	key := inspection{inspector, target.Bucket}
+	checks, ok := c.results[key]
+	if !ok {
+		var err error
+		// the checks known before an error are still reported
+		if checks, err = inspector.InspectBucket(ctx, target.Bucket); err != nil {
+			logger.Errorf("Error while inspecting the settings of %s: %v", target.Bucket, err)
+		}
+		c.results[key] = checks
+	}
+	names := make([]string, 0, len(checks))
+	for check := range checks {
+		names = append(names, check)
+	}
+	sort.Strings(names)
+	for _, check := range names {
+		labels := append(target.labelValues(""), check)
+		ch <- prometheus.MustNewConstMetric(common.BucketCompliance, prometheus.GaugeValue, boolValue(checks[check]), labels...)
+	}
+}
@@ -0,0 +1,353 @@
+package objectstore
+
+import (
+	"context"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	log "github.com/sirupsen/logrus"
+	"github.wdf.sap.corp/DBaaS/cloud-provider-exporter/pkg/cloud/common"
+	"github.wdf.sap.corp/DBaaS/cloud-provider-exporter/pkg/config"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	// the size of the newest backup is compared to the median of this many previous ones
+	sizeMedianWindow         = 7
+	defaultSizeDropThreshold = 0.5
+)
+
+// Labels are the labels of the object store metrics, the collectors may append their own
+var Labels = []string{"bucket", "prefix", "group"}
+
+// Object is an object of any object store
+type Object struct {
+	Key          string
+	Size         int64
+	LastModified time.Time
+	// MD5 is the declared digest of the content, if the store provides it
+	MD5 []byte
+}
+
+// ObjectLister lists the objects of a bucket under a prefix, calling fn for each of them
+type ObjectLister interface {
+	ListObjects(ctx context.Context, bucket, prefix string, fn func(Object)) error
+}
+
+// Target is a monitored bucket and prefix, with the lister reading it
+type Target struct {
+	Bucket string
+	Prefix string
+	// GroupDepth is the number of path segments below the prefix the objects are grouped by, 0 aggregates all of them
+	GroupDepth int
+	// ExpectedInterval is the maximum age of the newest backup of each group, 0 disables the freshness SLO
+	ExpectedInterval time.Duration
+	// SizeDropThreshold is the fraction of the median size below which the newest backup is an anomaly
+	SizeDropThreshold float64
+	// Verify verifies the newest backup of each group if the lister is an ObjectReader
+	Verify *Verification
+	Lister ObjectLister
+	// LabelValues are the values of the labels the collector appended to Labels
+	LabelValues []string
+}
+
+// Targets returns the configured targets, the vault backup bucket first.
+func Targets(conf *config.Config) []*config.VaultBackupBucketConfig {
+	var targets []*config.VaultBackupBucketConfig
+	if conf.VaultBackupBucket != nil {
+		targets = append(targets, conf.VaultBackupBucket)
+	}
+	return uniqueBuckets(append(targets, conf.ObjectStoreTargets...))
+}
+
+// Handler serves the metrics of a bucket collector on a registry of its own. The collector lives as long as the
+// exporter, the buckets are listed on every scrape of it.
+func Handler(collector prometheus.Collector) http.Handler {
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(collector)
+	return promhttp.HandlerFor(registry, promhttp.HandlerOpts{})
+}
+
+// uniqueBuckets leaves out the buckets configured again with the same prefix, their metrics would have the same
+// labels.
+func uniqueBuckets(buckets []*config.VaultBackupBucketConfig) []*config.VaultBackupBucketConfig {
+	seen := make(map[[2]string]bool, len(buckets))
+	unique := make([]*config.VaultBackupBucketConfig, 0, len(buckets))
+	for _, b := range buckets {
+		key := [2]string{b.Bucket, b.Prefix}
+		if seen[key] {
+			log.Warnf("The bucket %s with prefix %s is configured more than once, only the first is monitored", b.Bucket, b.Prefix)
+			continue
+		}
+		seen[key] = true
+		unique = append(unique, b)
+	}
+	return unique
+}
+
+// NewTargets returns the targets of the configured buckets read by lister.
+func NewTargets(buckets []*config.VaultBackupBucketConfig, lister ObjectLister, labelValues ...string) []*Target {
+	buckets = uniqueBuckets(buckets)
+	targets := make([]*Target, 0, len(buckets))
+	for _, b := range buckets {
+		threshold := b.SizeDropThreshold
+		if threshold <= 0 {
+			threshold = defaultSizeDropThreshold
+		}
+		var verify *Verification
+		if b.Verify != nil {
+			verify = &Verification{Format: b.Verify.Format, MaxBytes: b.Verify.MaxBytes}
+			if verify.MaxBytes <= 0 {
+				verify.MaxBytes = defaultVerifyMaxBytes
+			}
+		}
+		targets = append(targets, &Target{
+			Bucket:            b.Bucket,
+			Prefix:            b.Prefix,
+			GroupDepth:        b.GroupDepth,
+			ExpectedInterval:  time.Duration(b.ExpectedInterval) * time.Minute,
+			SizeDropThreshold: threshold,
+			Verify:            verify,
+			Lister:            lister,
+			LabelValues:       labelValues,
+		})
+	}
+	return targets
+}
+
+// Summary aggregates the objects of a group
+type Summary struct {
+	Objects          float64
+	TotalSize        int64
+	BiggestSize      int64
+	LastModified     time.Time
+	LastModifiedSize int64
+	Oldest           time.Time
+	// Recent are the most recently modified objects, the newest first
+	Recent []Object
+}
+
+func (s *Summary) add(o Object) {
+	i := sort.Search(len(s.Recent), func(i int) bool { return s.Recent[i].LastModified.Before(o.LastModified) })
+	if i <= sizeMedianWindow {
+		s.Recent = append(s.Recent, Object{})
+		copy(s.Recent[i+1:], s.Recent[i:])
+		s.Recent[i] = o
+		if len(s.Recent) > sizeMedianWindow+1 {
+			s.Recent = s.Recent[:sizeMedianWindow+1]
+		}
+	}
+	s.Objects++
+	s.TotalSize += o.Size
+	if o.LastModified.After(s.LastModified) {
+		s.LastModified = o.LastModified
+		s.LastModifiedSize = o.Size
+	}
+	if s.Oldest.IsZero() || o.LastModified.Before(s.Oldest) {
+		s.Oldest = o.LastModified
+	}
+	if o.Size > s.BiggestSize {
+		s.BiggestSize = o.Size
+	}
+}
+
+// lastModifiedDate returns the Unix time of the last modification, 0 without objects
+func (s *Summary) lastModifiedDate() float64 {
+	if s.LastModified.IsZero() {
+		return 0
+	}
+	return float64(s.LastModified.Unix())
+}
+
+// MedianSize returns the median size of the backups before the newest one, false without them
+func (s *Summary) MedianSize() (float64, bool) {
+	if len(s.Recent) < 2 {
+		return 0, false
+	}
+	sizes := make([]int64, 0, len(s.Recent)-1)
+	for _, o := range s.Recent[1:] {
+		sizes = append(sizes, o.Size)
+	}
+	sort.Slice(sizes, func(i, j int) bool { return sizes[i] < sizes[j] })
+	n := len(sizes)
+	if n%2 == 1 {
+		return float64(sizes[n/2]), true
+	}
+	return float64(sizes[n/2-1]+sizes[n/2]) / 2, true
+}
+
+// Anomaly is true when the newest backup is empty or smaller than threshold times the median size of the previous ones
+func (s *Summary) Anomaly(threshold float64) bool {
+	if len(s.Recent) == 0 {
+		return false
+	}
+	newest := float64(s.Recent[0].Size)
+	if newest == 0 {
+		return true
+	}
+	median, ok := s.MedianSize()
+	return ok && newest < threshold*median
+}
+
+// Aggregator summarizes the objects of a target by group
+type Aggregator struct {
+	prefix string
+	depth  int
+	Groups map[string]*Summary
+}
+
+func NewAggregator(prefix string, depth int) *Aggregator {
+	a := &Aggregator{prefix: prefix, depth: depth, Groups: make(map[string]*Summary)}
+	if depth == 0 {
+		// a single group is reported even without objects
+		a.Groups[""] = &Summary{}
+	}
+	return a
+}
+
+func (a *Aggregator) Add(o Object) {
+	group := Group(a.prefix, o.Key, a.depth)
+	s, ok := a.Groups[group]
+	if !ok {
+		s = &Summary{}
+		a.Groups[group] = s
+	}
+	s.add(o)
+}
+
+// Group returns the first depth directories of key below prefix, fewer if the object is not that deep.
+func Group(prefix, key string, depth int) string {
+	if depth <= 0 {
+		return ""
+	}
+	dirs := strings.Split(strings.TrimPrefix(strings.TrimPrefix(key, prefix), "/"), "/")
+	dirs = dirs[:len(dirs)-1]
+	if len(dirs) > depth {
+		dirs = dirs[:depth]
+	}
+	return strings.Join(dirs, "/")
+}
+
+// InitDesc initializes the descriptions of the object store metrics of a cloud provider with the labels it appends.
+func InitDesc(cloudProvider string, labels ...string) {
+	common.InitVaultBackupBucketDesc("", cloudProvider, append(append([]string{}, Labels...), labels...))
+}
+
+func Describe(ch chan<- *prometheus.Desc) {
+	ch <- common.ListSuccess
+	ch <- common.LastModifiedObjectDate
+	ch <- common.LastModifiedObjectSize
+	ch <- common.ObjectTotal
+	ch <- common.SumSize
+	ch <- common.BiggestSize
+	ch <- common.BackupAge
+	ch <- common.ExpectedInterval
+	ch <- common.FreshnessViolation
+	ch <- common.SizeDelta
+	ch <- common.BackupAnomaly
+	ch <- common.VerifySuccess
+	ch <- common.VerifyDuration
+	ch <- common.BucketCompliance
+	ch <- common.OldestObjectAge
+}
+
+// Collect lists the objects of every target and sends their summaries by group. The list success is reported
+// per target, without group. The age, freshness and anomaly of the newest backup are reported for every group
+// with objects, the freshness also for the empty group of a target without grouping. The compliance of the bucket
+// settings is reported per target as well.
+func Collect(ctx context.Context, ch chan<- prometheus.Metric, targets []*Target, logger log.FieldLogger) {
+	inspected := newCompliance()
+	for _, target := range targets {
+		inspected.collect(ctx, ch, target, logger)
+		aggregator := NewAggregator(target.Prefix, target.GroupDepth)
+		err := target.Lister.ListObjects(ctx, target.Bucket, target.Prefix, aggregator.Add)
+		success := 1.0
+		if err != nil {
+			logger.Errorf("Error while listing objects of %s/%s: %v", target.Bucket, target.Prefix, err)
+			success = 0
+		}
+		ch <- prometheus.MustNewConstMetric(common.ListSuccess, prometheus.GaugeValue, success, target.labelValues("")...)
+		if err != nil {
+			continue
+		}
+		for group, s := range aggregator.Groups {
+			labels := target.labelValues(group)
+			ch <- prometheus.MustNewConstMetric(common.LastModifiedObjectDate, prometheus.GaugeValue, s.lastModifiedDate(), labels...)
+			ch <- prometheus.MustNewConstMetric(common.LastModifiedObjectSize, prometheus.GaugeValue, float64(s.LastModifiedSize), labels...)
+			ch <- prometheus.MustNewConstMetric(common.ObjectTotal, prometheus.GaugeValue, s.Objects, labels...)
+			ch <- prometheus.MustNewConstMetric(common.BiggestSize, prometheus.GaugeValue, float64(s.BiggestSize), labels...)
+			ch <- prometheus.MustNewConstMetric(common.SumSize, prometheus.GaugeValue, float64(s.TotalSize), labels...)
+			collectFreshness(ch, target, s, labels)
+		}
+		collectVerification(ctx, ch, target, aggregator, logger)
+	}
+}
+
+func collectFreshness(ch chan<- prometheus.Metric, target *Target, s *Summary, labels []string) {
+	var age time.Duration
+	if len(s.Recent) > 0 {
+		age = time.Since(s.LastModified)
+		ch <- prometheus.MustNewConstMetric(common.BackupAge, prometheus.GaugeValue, age.Seconds(), labels...)
+		// the age of the oldest backup shows whether the retention rules are enforced
+		ch <- prometheus.MustNewConstMetric(common.OldestObjectAge, prometheus.GaugeValue, time.Since(s.Oldest).Seconds(), labels...)
+		ch <- prometheus.MustNewConstMetric(common.BackupAnomaly, prometheus.GaugeValue, boolValue(s.Anomaly(target.SizeDropThreshold)), labels...)
+		if median, ok := s.MedianSize(); ok {
+			ch <- prometheus.MustNewConstMetric(common.SizeDelta, prometheus.GaugeValue, float64(s.LastModifiedSize)-median, labels...)
+		}
+	}
+	if target.ExpectedInterval > 0 {
+		// no backup at all violates the SLO as well
+		violation := len(s.Recent) == 0 || age > target.ExpectedInterval
+		ch <- prometheus.MustNewConstMetric(common.ExpectedInterval, prometheus.GaugeValue, target.ExpectedInterval.Seconds(), labels...)
+		ch <- prometheus.MustNewConstMetric(common.FreshnessViolation, prometheus.GaugeValue, boolValue(violation), labels...)
+	}
+}
+
+// collectVerification verifies the newest backup of each group, in the order of the groups, until the bytes
+// the target may read per scrape are used up.
+func collectVerification(ctx context.Context, ch chan<- prometheus.Metric, target *Target, aggregator *Aggregator, logger log.FieldLogger) {
+	reader, ok := target.Lister.(ObjectReader)
+	if target.Verify == nil || !ok {
+		return
+	}
+	groups := make([]string, 0, len(aggregator.Groups))
+	for group := range aggregator.Groups {
+		groups = append(groups, group)
+	}
+	sort.Strings(groups)
+	budget := target.Verify.MaxBytes
+	for _, group := range groups {
+		s := aggregator.Groups[group]
+		if len(s.Recent) == 0 || budget <= 0 {
+			continue
+		}
+		newest := s.Recent[0]
+		start := time.Now()
+		err := VerifyObject(ctx, reader, target.Bucket, newest, target.Verify.Format, budget)
+		duration := time.Since(start)
+		if newest.Size < budget {
+			budget -= newest.Size
+		} else {
+			budget = 0
+		}
+		if err != nil {
+			logger.Errorf("Error while verifying %s/%s: %v", target.Bucket, newest.Key, err)
+		}
+		labels := target.labelValues(group)
+		ch <- prometheus.MustNewConstMetric(common.VerifySuccess, prometheus.GaugeValue, boolValue(err == nil), labels...)
+		ch <- prometheus.MustNewConstMetric(common.VerifyDuration, prometheus.GaugeValue, duration.Seconds(), labels...)
+	}
+}
+
+func boolValue(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+func (t *Target) labelValues(group string) []string {
+	return append([]string{t.Bucket, t.Prefix, group}, t.LabelValues...)
+}
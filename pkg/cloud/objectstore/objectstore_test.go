@@ -0,0 +1,180 @@
+package objectstore
+
+import (
+	"context"
+	"errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	log "github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.wdf.sap.corp/DBaaS/cloud-provider-exporter/pkg/config"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+type MockLister struct {
+	objects map[string][]Object
+}
+
+func (m *MockLister) ListObjects(ctx context.Context, bucket, prefix string, fn func(Object)) error {
+	objects, ok := m.objects[bucket]
+	if !ok {
+		return errors.New("bucket not found")
+	}
+	for _, o := range objects {
+		if strings.HasPrefix(o.Key, prefix) {
+			fn(o)
+		}
+	}
+	return nil
+}
+
+type mockCollector struct {
+	targets []*Target
+}
+
+func (m *mockCollector) Describe(ch chan<- *prometheus.Desc) {
+	Describe(ch)
+}
+
+func (m *mockCollector) Collect(ch chan<- prometheus.Metric) {
+	Collect(context.TODO(), ch, m.targets, &log.Logger{})
+}
+
+func TestGroup(t *testing.T) {
+	assert.Equal(t, "", Group("backup/", "backup/db1/2023/full.tar", 0))
+	assert.Equal(t, "db1", Group("backup/", "backup/db1/2023/full.tar", 1))
+	assert.Equal(t, "db1/2023", Group("backup", "backup/db1/2023/full.tar", 2))
+	assert.Equal(t, "db1/2023", Group("backup/", "backup/db1/2023/full.tar", 3))
+	assert.Equal(t, "", Group("backup/", "backup/full.tar", 1))
+}
+
+func TestTargets(t *testing.T) {
+	vault := &config.VaultBackupBucketConfig{Bucket: "vault", Prefix: "vault"}
+	etcd := &config.VaultBackupBucketConfig{Bucket: "etcd", Prefix: "etcd.backup", GroupDepth: 1}
+	assert.Equal(t, []*config.VaultBackupBucketConfig{vault, etcd}, Targets(&config.Config{VaultBackupBucket: vault, ObjectStoreTargets: []*config.VaultBackupBucketConfig{etcd}}))
+	assert.Equal(t, []*config.VaultBackupBucketConfig{etcd}, Targets(&config.Config{ObjectStoreTargets: []*config.VaultBackupBucketConfig{etcd}}))
+
+	// the vault backup bucket listed as a target again and a target repeated with other settings are left out
+	again := &config.VaultBackupBucketConfig{Bucket: "etcd", Prefix: "etcd.backup", GroupDepth: 2}
+	assert.Equal(t, []*config.VaultBackupBucketConfig{vault, etcd}, Targets(&config.Config{VaultBackupBucket: vault, ObjectStoreTargets: []*config.VaultBackupBucketConfig{vault, etcd, again}}))
+
+	targets := NewTargets([]*config.VaultBackupBucketConfig{vault, etcd, again}, &MockLister{}, "111")
+	assert.Len(t, targets, 2)
+	assert.Equal(t, 1, targets[1].GroupDepth)
+	assert.Equal(t, []string{"etcd", "etcd.backup", "db1", "111"}, targets[1].labelValues("db1"))
+}
+
+func TestHandler(t *testing.T) {
+	lister := &MockLister{objects: map[string][]Object{"vault": {{Key: "vault/one", Size: 100, LastModified: time.Now()}}}}
+	InitDesc("TEST")
+	handler := Handler(&mockCollector{targets: NewTargets([]*config.VaultBackupBucketConfig{{Bucket: "vault", Prefix: "vault"}}, lister)})
+
+	// the same collector serves every scrape
+	for i := 0; i < 2; i++ {
+		assert.HTTPBodyContains(t, handler.ServeHTTP, "GET", "/bucket", nil, "cpe_vault_object_count{bucket=\"vault\",group=\"\",prefix=\"vault\"} 1")
+	}
+}
+
+func TestCollect(t *testing.T) {
+	uri := "/metrics"
+	lister := &MockLister{objects: map[string][]Object{
+		"vault": {
+			{Key: "vault/one", Size: 100, LastModified: time.Date(2019, time.June, 13, 21, 0, 0, 0, time.UTC)},
+			{Key: "vault/two", Size: 200, LastModified: time.Date(2020, time.June, 13, 21, 0, 0, 0, time.UTC)},
+		},
+		"db": {
+			{Key: "backup/db1/2023/full", Size: 500, LastModified: time.Date(2023, time.June, 13, 21, 0, 0, 0, time.UTC)},
+			{Key: "backup/db1/2023/log", Size: 50, LastModified: time.Date(2023, time.June, 14, 21, 0, 0, 0, time.UTC)},
+			{Key: "backup/db2/2023/full", Size: 700, LastModified: time.Date(2023, time.June, 12, 21, 0, 0, 0, time.UTC)},
+		},
+		"empty": {},
+	}}
+	InitDesc("TEST")
+	collector := &mockCollector{targets: []*Target{
+		{Bucket: "vault", Prefix: "vault", Lister: lister},
+		{Bucket: "db", Prefix: "backup/", GroupDepth: 1, Lister: lister},
+		{Bucket: "empty", Prefix: "etcd", Lister: lister},
+		{Bucket: "missing", Prefix: "etcd", Lister: lister},
+	}}
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(collector)
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		h := promhttp.HandlerFor(registry, promhttp.HandlerOpts{})
+		h.ServeHTTP(w, r)
+	})
+
+	assert.HTTPBodyContains(t, handler, "GET", uri, nil, "cpe_vault_object_count{bucket=\"vault\",group=\"\",prefix=\"vault\"} 2\n")
+	assert.HTTPBodyContains(t, handler, "GET", uri, nil, "cpe_vault_object_last_modified_size_bytes{bucket=\"vault\",group=\"\",prefix=\"vault\"} 200\n")
+	assert.HTTPBodyContains(t, handler, "GET", uri, nil, "cpe_vault_object_list_success{bucket=\"db\",group=\"\",prefix=\"backup/\"} 1\n")
+	assert.HTTPBodyContains(t, handler, "GET", uri, nil, "cpe_vault_object_count{bucket=\"db\",group=\"db1\",prefix=\"backup/\"} 2\n")
+	assert.HTTPBodyContains(t, handler, "GET", uri, nil, "cpe_vault_object_last_modified_size_bytes{bucket=\"db\",group=\"db1\",prefix=\"backup/\"} 50\n")
+	assert.HTTPBodyContains(t, handler, "GET", uri, nil, "cpe_vault_object_max_size_bytes{bucket=\"db\",group=\"db1\",prefix=\"backup/\"} 500\n")
+	assert.HTTPBodyContains(t, handler, "GET", uri, nil, "cpe_vault_object_size_bytes_total{bucket=\"db\",group=\"db2\",prefix=\"backup/\"} 700\n")
+	assert.HTTPBodyContains(t, handler, "GET", uri, nil, "cpe_vault_object_count{bucket=\"empty\",group=\"\",prefix=\"etcd\"} 0\n")
+	assert.HTTPBodyContains(t, handler, "GET", uri, nil, "cpe_vault_object_last_modified_date{bucket=\"empty\",group=\"\",prefix=\"etcd\"} 0\n")
+	assert.HTTPBodyContains(t, handler, "GET", uri, nil, "cpe_vault_object_list_success{bucket=\"missing\",group=\"\",prefix=\"etcd\"} 0\n")
+	assert.HTTPBodyNotContains(t, handler, "GET", uri, nil, "cpe_vault_object_count{bucket=\"missing\"")
+	assert.HTTPBodyNotContains(t, handler, "GET", uri, nil, "cpe_vault_object_count{bucket=\"db\",group=\"\"")
+}
+
+func TestSummaryAnomaly(t *testing.T) {
+	start := time.Date(2023, time.June, 1, 0, 0, 0, 0, time.UTC)
+	s := &Summary{}
+	for i, size := range []int64{100, 120, 90, 110, 1000, 105, 95, 100, 115, 40} {
+		s.add(Object{Key: "backup", Size: size, LastModified: start.Add(time.Duration(i) * time.Hour)})
+	}
+	assert.Len(t, s.Recent, sizeMedianWindow+1)
+	assert.Equal(t, int64(40), s.Recent[0].Size)
+	// the 1000 bytes of the fifth backup are outside the window
+	median, ok := s.MedianSize()
+	assert.True(t, ok)
+	assert.Equal(t, float64(105), median)
+	assert.True(t, s.Anomaly(0.5))
+	assert.False(t, s.Anomaly(0.3))
+
+	s = &Summary{}
+	s.add(Object{Key: "backup", Size: 0, LastModified: start})
+	_, ok = s.MedianSize()
+	assert.False(t, ok)
+	assert.True(t, s.Anomaly(0.5))
+}
+
+func TestCollectFreshness(t *testing.T) {
+	uri := "/metrics"
+	now := time.Now()
+	lister := &MockLister{objects: map[string][]Object{
+		"db": {
+			{Key: "backup/db1/full-1", Size: 500, LastModified: now.Add(-50 * time.Hour)},
+			{Key: "backup/db1/full-2", Size: 520, LastModified: now.Add(-26 * time.Hour)},
+			{Key: "backup/db1/full-3", Size: 510, LastModified: now.Add(-2 * time.Hour)},
+			{Key: "backup/db2/full-1", Size: 700, LastModified: now.Add(-72 * time.Hour)},
+			{Key: "backup/db2/full-2", Size: 10, LastModified: now.Add(-48 * time.Hour)},
+		},
+		"empty": {},
+	}}
+	targets := NewTargets([]*config.VaultBackupBucketConfig{
+		{Bucket: "db", Prefix: "backup/", GroupDepth: 1, ExpectedInterval: 25 * 60},
+		{Bucket: "empty", Prefix: "etcd", ExpectedInterval: 60},
+	}, lister)
+	InitDesc("TEST")
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(&mockCollector{targets: targets})
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		h := promhttp.HandlerFor(registry, promhttp.HandlerOpts{})
+		h.ServeHTTP(w, r)
+	})
+
+	assert.HTTPBodyContains(t, handler, "GET", uri, nil, "cpe_vault_object_expected_interval_seconds{bucket=\"db\",group=\"db1\",prefix=\"backup/\"} 90000\n")
+	assert.HTTPBodyContains(t, handler, "GET", uri, nil, "cpe_vault_object_freshness_violation{bucket=\"db\",group=\"db1\",prefix=\"backup/\"} 0\n")
+	assert.HTTPBodyContains(t, handler, "GET", uri, nil, "cpe_vault_object_freshness_violation{bucket=\"db\",group=\"db2\",prefix=\"backup/\"} 1\n")
+	assert.HTTPBodyContains(t, handler, "GET", uri, nil, "cpe_vault_object_size_delta_bytes{bucket=\"db\",group=\"db1\",prefix=\"backup/\"} 0\n")
+	assert.HTTPBodyContains(t, handler, "GET", uri, nil, "cpe_vault_object_size_delta_bytes{bucket=\"db\",group=\"db2\",prefix=\"backup/\"} -690\n")
+	assert.HTTPBodyContains(t, handler, "GET", uri, nil, "cpe_vault_object_anomaly{bucket=\"db\",group=\"db1\",prefix=\"backup/\"} 0\n")
+	assert.HTTPBodyContains(t, handler, "GET", uri, nil, "cpe_vault_object_anomaly{bucket=\"db\",group=\"db2\",prefix=\"backup/\"} 1\n")
+	assert.HTTPBodyContains(t, handler, "GET", uri, nil, "cpe_vault_object_backup_age_seconds{bucket=\"db\",group=\"db1\",prefix=\"backup/\"} 72")
+	assert.HTTPBodyContains(t, handler, "GET", uri, nil, "cpe_vault_object_freshness_violation{bucket=\"empty\",group=\"\",prefix=\"etcd\"} 1\n")
+	assert.HTTPBodyNotContains(t, handler, "GET", uri, nil, "cpe_vault_object_backup_age_seconds{bucket=\"empty\"")
+}
@@ -0,0 +1,262 @@
+package objectstore
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"path"
+	"strings"
+)
+
+const (
+	FormatEtcd  = "etcd"  // bbolt database written by etcdctl snapshot save, optionally gzipped
+	FormatVault = "vault" // gzipped tar written by vault operator raft snapshot save
+	FormatGzip  = "gzip"
+	FormatTar   = "tar"
+
+	defaultVerifyMaxBytes = 64 << 20
+
+	boltMagic    = 0xED0CDAED
+	boltPageSize = 4096
+)
+
+// ObjectReader reads length bytes of an object from offset on. Listers implementing it allow the verification
+// of the newest backups.
+type ObjectReader interface {
+	ReadObject(ctx context.Context, bucket, key string, offset, length int64) (io.ReadCloser, error)
+}
+
+// Verification configures the verification of the newest backup of each group of a target
+type Verification struct {
+	// Format is the expected format of the backups, only the checksum and size are checked without
+	Format string
+	// MaxBytes caps the bytes read from the target per scrape, larger objects are verified as far as they were read
+	MaxBytes int64
+}
+
+// MD5Resolver is implemented by the listers whose listed MD5 is not the digest of every object. ObjectMD5 returns
+// the digest the content of the object is checked against, nil to skip the check.
+type MD5Resolver interface {
+	ObjectMD5(ctx context.Context, bucket string, o Object) ([]byte, error)
+}
+
+// ETagMD5 returns the MD5 digest an ETag looks like, nil for the ETags of multipart uploads. The ETags of objects
+// encrypted with SSE-KMS or SSE-C look the same without being their MD5, their listers implement MD5Resolver.
+func ETagMD5(etag string) []byte {
+	etag = strings.Trim(etag, "\"")
+	if len(etag) != 2*md5.Size {
+		return nil
+	}
+	digest, err := hex.DecodeString(etag)
+	if err != nil {
+		return nil
+	}
+	return digest
+}
+
+// VerifyObject reads at most limit bytes of the object and checks its declared checksum, when read completely, and
+// its format.
+func VerifyObject(ctx context.Context, reader ObjectReader, bucket string, o Object, format string, limit int64) error {
+	if o.Size == 0 {
+		return errors.New("the object is empty")
+	}
+	length := o.Size
+	if length > limit {
+		length = limit
+	}
+	body, err := reader.ReadObject(ctx, bucket, o.Key, 0, length)
+	if err != nil {
+		return err
+	}
+	defer body.Close()
+	data, err := io.ReadAll(io.LimitReader(body, length))
+	if err != nil {
+		return err
+	}
+	if int64(len(data)) < length {
+		return fmt.Errorf("read %d of %d bytes", len(data), length)
+	}
+	if resolver, ok := reader.(MD5Resolver); ok && length == o.Size && len(o.MD5) > 0 {
+		if o.MD5, err = resolver.ObjectMD5(ctx, bucket, o); err != nil {
+			return err
+		}
+	}
+	return Verify(data, o, format)
+}
+
+// Verify checks the content of an object, data is a prefix of it when the object was not read completely.
+func Verify(data []byte, o Object, format string) error {
+	complete := int64(len(data)) == o.Size
+	if len(data) == 0 {
+		return errors.New("the object is empty")
+	}
+	if complete && len(o.MD5) > 0 {
+		if sum := md5.Sum(data); !bytes.Equal(sum[:], o.MD5) {
+			return fmt.Errorf("the MD5 %x does not match the declared %x", sum, o.MD5)
+		}
+	}
+	var err error
+	switch format {
+	case "":
+	case FormatEtcd:
+		err = checkEtcd(data, complete)
+	case FormatVault:
+		err = checkVault(data, complete)
+	case FormatGzip:
+		err = checkGzip(data, complete)
+	case FormatTar:
+		err = checkTar(bytes.NewReader(data), complete)
+	default:
+		err = fmt.Errorf("unknown backup format %s", format)
+	}
+	return err
+}
+
+// truncated ignores the errors of a stream that ends early because the object was not read completely
+func truncated(err error, complete bool) error {
+	if !complete && (errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, io.EOF)) {
+		return nil
+	}
+	return err
+}
+
+func checkGzip(data []byte, complete bool) error {
+	zr, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return truncated(err, complete)
+	}
+	// the gzip reader checks the CRC and size of the trailer
+	_, err = io.Copy(io.Discard, zr)
+	return truncated(err, complete)
+}
+
+func checkTar(r io.Reader, complete bool) error {
+	tr := tar.NewReader(r)
+	for {
+		_, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err == nil {
+			_, err = io.Copy(io.Discard, tr)
+		}
+		if err != nil {
+			return truncated(err, complete)
+		}
+	}
+}
+
+// trailerHash hashes everything written except the trailing digest
+type trailerHash struct {
+	hash.Hash
+	tail []byte
+	size int64
+}
+
+func (t *trailerHash) Write(p []byte) (int, error) {
+	t.size += int64(len(p))
+	t.tail = append(t.tail, p...)
+	if n := len(t.tail) - sha256.Size; n > 0 {
+		t.Hash.Write(t.tail[:n])
+		t.tail = append(t.tail[:0], t.tail[n:]...)
+	}
+	return len(p), nil
+}
+
+// checkEtcd checks the magic of the first bbolt meta page and, for complete snapshots, the SHA-256 etcdctl appends
+// to the database.
+func checkEtcd(data []byte, complete bool) error {
+	var r io.Reader = bytes.NewReader(data)
+	if len(data) > 2 && data[0] == 0x1f && data[1] == 0x8b {
+		zr, err := gzip.NewReader(r)
+		if err != nil {
+			return truncated(err, complete)
+		}
+		r = zr
+	}
+	// the meta follows the 16 bytes header of the page
+	header := make([]byte, 24)
+	if _, err := io.ReadFull(r, header); err != nil {
+		if truncated(err, complete) == nil {
+			return nil
+		}
+		return errors.New("the etcd snapshot is shorter than its header")
+	}
+	if binary.LittleEndian.Uint32(header[16:20]) != boltMagic {
+		return errors.New("the etcd snapshot does not start with a bolt meta page")
+	}
+	h := &trailerHash{Hash: sha256.New()}
+	_, _ = h.Write(header)
+	if _, err := io.Copy(h, r); err != nil {
+		return truncated(err, complete)
+	}
+	if !complete || h.size%boltPageSize != sha256.Size {
+		// without the digest the snapshot was not written by etcdctl
+		return nil
+	}
+	if !bytes.Equal(h.Sum(nil), h.tail) {
+		return errors.New("the SHA-256 of the etcd snapshot does not match its trailer")
+	}
+	return nil
+}
+
+// checkVault checks the files of a Raft snapshot against its SHA256SUMS, as far as they were read.
+func checkVault(data []byte, complete bool) error {
+	zr, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return truncated(err, complete)
+	}
+	sums := make(map[string]string)
+	var declared []byte
+	tr := tar.NewReader(zr)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return truncated(err, complete)
+		}
+		name := path.Base(header.Name)
+		if name == "SHA256SUMS" {
+			if declared, err = io.ReadAll(tr); err != nil {
+				return truncated(err, complete)
+			}
+			continue
+		}
+		h := sha256.New()
+		if _, err := io.Copy(h, tr); err != nil {
+			return truncated(err, complete)
+		}
+		sums[name] = hex.EncodeToString(h.Sum(nil))
+	}
+	for _, file := range []string{"meta.json", "state.bin"} {
+		if _, ok := sums[file]; !ok {
+			return fmt.Errorf("the vault snapshot has no %s", file)
+		}
+	}
+	if declared == nil {
+		return errors.New("the vault snapshot has no SHA256SUMS")
+	}
+	scanner := bufio.NewScanner(bytes.NewReader(declared))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) != 2 {
+			continue
+		}
+		if sum, ok := sums[fields[1]]; ok && sum != fields[0] {
+			return fmt.Errorf("the SHA-256 of %s does not match SHA256SUMS", fields[1])
+		}
+	}
+	return nil
+}
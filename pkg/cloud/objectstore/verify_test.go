@@ -0,0 +1,112 @@
+package objectstore
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"testing"
+)
+
+// MockReader serves the content of a single object
+type MockReader struct {
+	content []byte
+	read    int64
+}
+
+func (m *MockReader) ReadObject(ctx context.Context, bucket, key string, offset, length int64) (io.ReadCloser, error) {
+	m.read += length
+	return io.NopCloser(bytes.NewReader(m.content[offset : offset+length])), nil
+}
+
+func etcdSnapshot() []byte {
+	db := make([]byte, 2*boltPageSize)
+	binary.LittleEndian.PutUint32(db[16:20], boltMagic)
+	sum := sha256.Sum256(db)
+	return append(db, sum[:]...)
+}
+
+func vaultSnapshot(sums func(meta, state []byte) string) []byte {
+	meta, state := []byte(`{"ID":"snapshot"}`), []byte("raft state")
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(zw)
+	for _, file := range []struct {
+		name    string
+		content []byte
+	}{{"meta.json", meta}, {"state.bin", state}, {"SHA256SUMS", []byte(sums(meta, state))}} {
+		_ = tw.WriteHeader(&tar.Header{Name: file.name, Mode: 0600, Size: int64(len(file.content))})
+		_, _ = tw.Write(file.content)
+	}
+	_ = tw.Close()
+	_ = zw.Close()
+	return buf.Bytes()
+}
+
+func validSums(meta, state []byte) string {
+	return fmt.Sprintf("%x  meta.json\n%x  state.bin\n", sha256.Sum256(meta), sha256.Sum256(state))
+}
+
+func object(content []byte) Object {
+	sum := md5.Sum(content)
+	return Object{Key: "backup", Size: int64(len(content)), MD5: sum[:]}
+}
+
+func TestETagMD5(t *testing.T) {
+	assert.Equal(t, []byte{0xd4, 0x1d, 0x8c, 0xd9, 0x8f, 0x00, 0xb2, 0x04, 0xe9, 0x80, 0x09, 0x98, 0xec, 0xf8, 0x42, 0x7e}, ETagMD5("\"d41d8cd98f00b204e9800998ecf8427e\""))
+	assert.Nil(t, ETagMD5("\"d41d8cd98f00b204e9800998ecf8427e-2\""))
+	assert.Nil(t, ETagMD5("0x8DB4F1C2A3B4C5D"))
+}
+
+func TestVerify(t *testing.T) {
+	etcd := etcdSnapshot()
+	assert.NoError(t, Verify(etcd, object(etcd), FormatEtcd))
+	// databases without the digest are not written by etcdctl
+	assert.NoError(t, Verify(etcd[:boltPageSize], object(etcd[:boltPageSize]), FormatEtcd))
+	corrupted := append([]byte(nil), etcd...)
+	corrupted[boltPageSize] = 1
+	assert.EqualError(t, Verify(corrupted, Object{Size: int64(len(corrupted))}, FormatEtcd), "the SHA-256 of the etcd snapshot does not match its trailer")
+	assert.EqualError(t, Verify(make([]byte, 100), Object{Size: 100}, FormatEtcd), "the etcd snapshot does not start with a bolt meta page")
+	assert.EqualError(t, Verify(etcd, Object{Size: int64(len(etcd)), MD5: make([]byte, md5.Size)}, ""),
+		fmt.Sprintf("the MD5 %x does not match the declared %x", md5.Sum(etcd), make([]byte, md5.Size)))
+
+	var gzipped bytes.Buffer
+	zw := gzip.NewWriter(&gzipped)
+	_, _ = zw.Write(etcd)
+	_ = zw.Close()
+	assert.NoError(t, Verify(gzipped.Bytes(), object(gzipped.Bytes()), FormatEtcd))
+	assert.NoError(t, Verify(gzipped.Bytes(), object(gzipped.Bytes()), FormatGzip))
+	// a truncated object is only an error if it was read completely
+	truncated := gzipped.Bytes()[:gzipped.Len()-10]
+	assert.Error(t, Verify(truncated, object(truncated), FormatGzip))
+	assert.NoError(t, Verify(truncated, Object{Size: int64(gzipped.Len())}, FormatGzip))
+
+	snapshot := vaultSnapshot(validSums)
+	assert.NoError(t, Verify(snapshot, object(snapshot), FormatVault))
+	broken := vaultSnapshot(func(meta, state []byte) string {
+		return fmt.Sprintf("%x  meta.json\n%x  state.bin\n", sha256.Sum256(meta), sha256.Sum256(meta))
+	})
+	assert.EqualError(t, Verify(broken, object(broken), FormatVault), "the SHA-256 of state.bin does not match SHA256SUMS")
+	assert.Error(t, Verify(etcd, object(etcd), FormatVault))
+	assert.EqualError(t, Verify(snapshot, object(snapshot), "zip"), "unknown backup format zip")
+}
+
+func TestVerifyObject(t *testing.T) {
+	etcd := etcdSnapshot()
+	reader := &MockReader{content: etcd}
+	assert.NoError(t, VerifyObject(context.TODO(), reader, "bucket", object(etcd), FormatEtcd, 1<<20))
+	assert.Equal(t, int64(len(etcd)), reader.read)
+
+	// the header is checked with a ranged read
+	reader = &MockReader{content: etcd}
+	assert.NoError(t, VerifyObject(context.TODO(), reader, "bucket", Object{Key: "backup", Size: int64(len(etcd)), MD5: make([]byte, md5.Size)}, FormatEtcd, 100))
+	assert.Equal(t, int64(100), reader.read)
+
+	assert.EqualError(t, VerifyObject(context.TODO(), reader, "bucket", Object{Key: "backup"}, FormatEtcd, 100), "the object is empty")
+}
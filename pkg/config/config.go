@@ -9,25 +9,109 @@ import (
 )
 
 type VaultBackupBucketConfig struct {
-	Bucket string `yaml:"bucket"`
-	Prefix string `yaml:"prefix"`
+	Bucket     string `yaml:"bucket"`
+	Prefix     string `yaml:"prefix"`
+	GroupDepth int    `yaml:"groupDepth"` // path segments below the prefix the objects are grouped by, none by default
+	// the newest backup of each group violates the freshness SLO once it is older than the expected interval
+	ExpectedInterval int32 `yaml:"expectedInterval"` // minutes, no SLO by default
+	// the newest backup is an anomaly when it is smaller than this fraction of the median of the previous ones
+	SizeDropThreshold float64             `yaml:"sizeDropThreshold"` // 0.5 by default
+	Verify            *BackupVerifyConfig `yaml:"verify"`            // verifies the newest backup of each group when set
+	S3                *S3EndpointConfig   `yaml:"s3"`                // S3 compatible store of the bucket, AWS by default
+}
+
+// S3EndpointConfig is an S3 compatible store like MinIO or Ceph. Without its own access key the exporter's
+// AWS credentials are used.
+type S3EndpointConfig struct {
+	Endpoint        string `yaml:"endpoint"`
+	Region          string `yaml:"region"` // us-east-1 by default
+	PathStyle       bool   `yaml:"pathStyle"`
+	AccessKeyID     string `yaml:"accessKeyID"`
+	SecretAccessKey string `yaml:"secretAccessKey"`
+	Anonymous       bool   `yaml:"anonymous"`
+	CABundle        string `yaml:"caBundle"` // PEM file of the CAs of the endpoint
+}
+
+type BackupVerifyConfig struct {
+	Format   string `yaml:"format"`   // etcd, vault, gzip or tar, only the checksum is checked by default
+	MaxBytes int64  `yaml:"maxBytes"` // read per target and scrape, 64 MiB by default
+}
+
+type HealthFilterConfig struct {
+	Services            []string `yaml:"services,flow"`
+	Regions             []string `yaml:"regions,flow"`
+	AffectsOwnResources bool     `yaml:"affectsOwnResources"`
 }
 
 type AwsConfig struct {
+	Partition                 string                `yaml:"partition"` // aws, aws-cn or aws-us-gov
+	Endpoints                 map[string]string     `yaml:"endpoints"` // URL by service ID, or default for all services
 	HealthEventStatusCodes    []string              `yaml:"healthEventStatusCodes,flow"`
 	HealthEventTypeCategories []string              `yaml:"healthEventTypeCategories,flow"`
+	HealthFilter              *HealthFilterConfig   `yaml:"healthFilter"`
 	CloudWatchMetricsConf     CloudWatchMetricsConf `yaml:"cloudwatchMetricsConf"`
 }
 
 type GcpConfig struct {
+	HealthFilter    *HealthFilterConfig `yaml:"healthFilter"`
+	HealthSource    string              `yaml:"healthSource"` // serviceHealth or statusFeed, the feed until the API was reached by default
+	Regions         []string            `yaml:"regions,flow"` // quota regions, all by default
+	ExportZeroUsage bool                `yaml:"exportZeroUsage"`
+	QuotaServices   []string            `yaml:"quotaServices,flow"` // read from Service Usage, e.g. container.googleapis.com
+	// projects are discovered under the folders, with their subfolders, and organizations, by their numeric IDs
+	Folders        []string          `yaml:"folders,flow"`
+	Organizations  []string          `yaml:"organizations,flow"`
+	ProjectIDRegex string            `yaml:"projectIDRegex"`
+	ProjectLabels  map[string]string `yaml:"projectLabels"`
 }
 
 type AliCloudConfig struct {
-	Endpoint string `yaml:"endpoint"`
+	Endpoint        string                  `yaml:"endpoint"`
+	HealthRegions   []string                `yaml:"healthRegions,flow"`
+	HealthWindow    int32                   `yaml:"healthWindow"`
+	QuotaProducts   []string                `yaml:"quotaProducts,flow"`
+	AccountID       string                  `yaml:"accountID"`
+	RoleArn         string                  `yaml:"roleArn"`
+	SessionDuration int32                   `yaml:"sessionDuration"` // seconds
+	Accounts        []AliCloudAccountConfig `yaml:"accounts"`
+}
+
+type AliCloudAccountConfig struct {
+	AccountID         string                   `yaml:"accountID"`
+	RoleArn           string                   `yaml:"roleArn"`
+	VaultBackupBucket *VaultBackupBucketConfig `yaml:"vaultBackupBucket"`
 }
 
 type AzureConfig struct {
-	SubscriptionID string `yaml:"subscriptionID"`
+	Environment           string              `yaml:"environment"` // public, china, usGovernment or custom
+	Endpoints             *AzureEndpoints     `yaml:"endpoints"`
+	SubscriptionID        string              `yaml:"subscriptionID"`
+	SubscriptionNameRegex string              `yaml:"subscriptionNameRegex"`
+	SubscriptionTags      map[string]string   `yaml:"subscriptionTags"`
+	Locations             []string            `yaml:"locations,flow"`
+	HealthFilter          *HealthFilterConfig `yaml:"healthFilter"`
+	AvailabilityStatuses  bool                `yaml:"availabilityStatuses"`
+	UsageSources          []string            `yaml:"usageSources,flow"`
+	QuotaProviders        []string            `yaml:"quotaProviders,flow"`
+	ExportZeroUsage       bool                `yaml:"exportZeroUsage"`
+}
+
+// AzureEndpoints overrides the endpoints of the Azure environment, all of them are required for a custom environment
+type AzureEndpoints struct {
+	ActiveDirectory string `yaml:"activeDirectory"`
+	ResourceManager string `yaml:"resourceManager"`
+	StorageSuffix   string `yaml:"storageSuffix"`
+}
+
+type HealthConfig struct {
+	PollInterval int32                `yaml:"pollInterval"` // minutes
+	Retention    int32                `yaml:"retention"`    // hours
+	Webhook      *HealthWebhookConfig `yaml:"webhook"`
+}
+
+type HealthWebhookConfig struct {
+	Token     string   `yaml:"token"`
+	TopicArns []string `yaml:"topicArns,flow"`
 }
 
 type VaultConfig struct {
@@ -38,19 +122,21 @@ type VaultConfig struct {
 }
 
 type Config struct {
-	Provider                         string                   `yaml:"provider"`
-	Project                          string                   `yaml:"project"`
-	Region                           string                   `yaml:"region"`
-	ScrapingDuration                 int32                    `yaml:"scrapingDuration"`
-	CacheExpiration                  int32                    `yaml:"cacheExpiration"`
-	CacheCleanupInterval             int32                    `yaml:"cacheCleanupInterval"`
-	CloudProviderAccountVaultSubpath string                   `yaml:"cloudProviderAccountVaultSubpath"`
-	Aws                              *AwsConfig               `yaml:"AwsConfig"`
-	Gcp                              *GcpConfig               `yaml:"GcpConfig"`
-	Azure                            *AzureConfig             `yaml:"AzureConfig"`
-	AliCloud                         *AliCloudConfig          `yaml:"AliCloudConfig"`
-	Vault                            *VaultConfig             `yaml:"VaultConfig"`
-	VaultBackupBucket                *VaultBackupBucketConfig `yaml:"vaultBackupBucket"`
+	Provider                         string                     `yaml:"provider"`
+	Project                          string                     `yaml:"project"`
+	Region                           string                     `yaml:"region"`
+	ScrapingDuration                 int32                      `yaml:"scrapingDuration"`
+	CacheExpiration                  int32                      `yaml:"cacheExpiration"`
+	CacheCleanupInterval             int32                      `yaml:"cacheCleanupInterval"`
+	CloudProviderAccountVaultSubpath string                     `yaml:"cloudProviderAccountVaultSubpath"`
+	Aws                              *AwsConfig                 `yaml:"AwsConfig"`
+	Gcp                              *GcpConfig                 `yaml:"GcpConfig"`
+	Azure                            *AzureConfig               `yaml:"AzureConfig"`
+	AliCloud                         *AliCloudConfig            `yaml:"AliCloudConfig"`
+	Vault                            *VaultConfig               `yaml:"VaultConfig"`
+	Health                           *HealthConfig              `yaml:"HealthConfig"`
+	VaultBackupBucket                *VaultBackupBucketConfig   `yaml:"vaultBackupBucket"`
+	ObjectStoreTargets               []*VaultBackupBucketConfig `yaml:"objectStoreTargets"` // monitored with the vault backup bucket
 }
 
 type ExportedTagsOnMetrics map[string][]string
@@ -63,11 +149,28 @@ type Metric struct {
 	Delay                  int64    `yaml:"delay"`
 	NilToZero              *bool    `yaml:"nilToZero"`
 	AddCloudwatchTimestamp *bool    `yaml:"addCloudwatchTimestamp"`
+	// Aggregation of the data points of the window: latest, max, min, average, sum or all
+	Aggregation string `yaml:"aggregation"`
 }
 
 type CloudWatchMetricsConf struct {
 	ExportedTagsOnMetrics ExportedTagsOnMetrics `yaml:"exportedTagsOnMetrics"`
 	Jobs                  []*Job                `yaml:"jobs"`
+	Static                []*Static             `yaml:"static"`
+	// GetMetricDataConcurrency bounds the concurrent GetMetricData calls of all jobs
+	GetMetricDataConcurrency int `yaml:"getMetricDataConcurrency"`
+	// Services extend or override the built-in service definitions of the discovery jobs
+	Services []*Service `yaml:"services"`
+}
+
+// Service defines how the resources of a CloudWatch namespace are discovered, the named groups of the dimension
+// regexps match the dimensions in the ARNs of the resources.
+type Service struct {
+	Namespace        string   `yaml:"namespace"`
+	Alias            string   `yaml:"alias"`
+	IgnoreLength     bool     `yaml:"ignoreLength"`
+	ResourceFilters  []string `yaml:"resourceFilters"`
+	DimensionRegexps []string `yaml:"dimensionRegexps"`
 }
 
 type Dimension struct {
@@ -93,6 +196,16 @@ type Job struct {
 	Statistics                []string  `yaml:"statistics"`
 	AddCloudwatchTimestamp    *bool     `yaml:"addCloudwatchTimestamp"`
 	NilToZero                 *bool     `yaml:"nilToZero"`
+	Aggregation               string    `yaml:"aggregation"`
+}
+
+// Static queries the metrics of fixed dimensions, without the discovery of tagged resources
+type Static struct {
+	Name       string      `yaml:"name"`
+	Namespace  string      `yaml:"namespace"`
+	CustomTags []Tag       `yaml:"customTags"`
+	Dimensions []Dimension `yaml:"dimensions"`
+	Metrics    []*Metric   `yaml:"metrics"`
 }
 
 func ReadConf(filename string) (*Config, error) {
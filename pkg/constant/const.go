@@ -8,20 +8,40 @@ const (
 	ErrUnknownProvider                          = "unknown cloud provider: %s"
 	QuotaCurrent                                = "cpe_quota_current"
 	QuotaLimit                                  = "cpe_quota_limit"
+	QuotaApplication                            = "cpe_quota_application_desired_value"
 	VaultListSuccess                            = "cpe_vault_object_list_success"
 	VaultMaxSize                                = "cpe_vault_object_max_size_bytes"
 	VaultLastModifyDate                         = "cpe_vault_object_last_modified_date"
 	VaultLastModifySize                         = "cpe_vault_object_last_modified_size_bytes"
 	VaultObjectCount                            = "cpe_vault_object_count"
 	VaultObjectSizeTotal                        = "cpe_vault_object_size_bytes_total"
+	VaultBackupAge                              = "cpe_vault_object_backup_age_seconds"
+	VaultExpectedInterval                       = "cpe_vault_object_expected_interval_seconds"
+	VaultFreshnessViolation                     = "cpe_vault_object_freshness_violation"
+	VaultSizeDelta                              = "cpe_vault_object_size_delta_bytes"
+	VaultAnomaly                                = "cpe_vault_object_anomaly"
+	BackupVerifySuccess                         = "cpe_backup_verify_success"
+	BackupVerifyDuration                        = "cpe_backup_verify_duration_seconds"
+	BucketCompliance                            = "cpe_bucket_compliance"
+	VaultOldestAge                              = "cpe_vault_object_oldest_age_seconds"
 	HealthEvent                                 = "cpe_health_events"
 	HealthAffected                              = "cpe_health_events_affected"
 	HealthEventOpenTotal                        = "cpe_health_events_opened_total"
 	HealthEventCloseTotal                       = "cpe_health_events_closed_total"
+	HealthEventUpdateTotal                      = "cpe_health_events_updated_total"
+	HealthEventDuration                         = "cpe_health_event_duration_seconds"
+	HealthEventSuppressedTotal                  = "cpe_health_events_suppressed_total"
+	HealthWebhookRequestTotal                   = "cpe_health_webhook_requests_total"
+	HealthResourceAvailability                  = "cpe_health_resource_availability"
 	HelpHealthAffected                          = "Resource health affected information"
 	HelpHealthEvent                             = "Resource health event information"
 	HelpHealthEventOpenedTotal                  = "Resource health opened total"
 	HelpHealthEventClosedTotal                  = "Resource health closed total"
+	HelpHealthEventUpdatedTotal                 = "Resource health updated total"
+	HelpHealthEventDuration                     = "Time from start to resolution of resource health events"
+	HelpHealthEventSuppressedTotal              = "Resource health events dropped by the health filter"
+	HelpHealthWebhookRequestTotal               = "Health webhook requests by result"
+	HelpHealthResourceAvailability              = "Current availability state of a resource reported by Resource Health"
 	LabelServiceName                            = "ServiceName"
 	LabelServiceCode                            = "ServiceCode"
 	LabelQuotaCode                              = "QuotaCode"
@@ -37,15 +57,33 @@ const (
 	LabelSubscriptionName                       = "SubscriptionName"
 	LabelProductCode                            = "ProductCode"
 	LabelQuotaDescription                       = "QuotaDescription"
+	LabelDimensions                             = "Dimensions"
+	LabelApplicationID                          = "ApplicationID"
+	LabelStatus                                 = "Status"
+	LabelCheck                                  = "check"
 	HelpQuotaCurrent                            = "Current usage value of quota"
 	HelpQuotaLimit                              = "Limit value of quota"
+	HelpQuotaApplication                        = "Requested value and status of the latest quota increase application"
 	HelpVaultBackupBucketListSuccess            = "If the ListObjects operation was a success"
 	HelpVaultBackupBucketLastModifiedObjectDate = "The last modified date of the object that was modified most recently"
 	HelpVaultBackupBucketLastModifiedObjectSize = "The size of the object that was modified most recently"
 	HelpVaultBackupBucketObjectTotal            = "The total number of objects for the bucket/prefix combination"
 	HelpVaultBackupBucketSumSize                = "The total size of all objects summed"
 	HelpVaultBackupBucketBiggestSize            = "The size of the biggest object"
+	HelpVaultBackupBucketBackupAge              = "The seconds since the object that was modified most recently"
+	HelpVaultBackupBucketExpectedInterval       = "The configured seconds between two backups"
+	HelpVaultBackupBucketFreshnessViolation     = "If the newest backup is older than the expected interval"
+	HelpVaultBackupBucketSizeDelta              = "The size of the newest object minus the median size of the previous ones"
+	HelpVaultBackupBucketAnomaly                = "If the newest object is empty or much smaller than the median size of the previous ones"
+	HelpBackupVerifySuccess                     = "If the checksum and format of the newest object were verified"
+	HelpBackupVerifyDuration                    = "The seconds the verification of the newest object took"
+	HelpBucketCompliance                        = "If the bucket setting of the check is compliant"
+	HelpVaultBackupBucketOldestAge              = "The seconds since the object that was modified least recently"
 	VaultMonitorPath                            = "/bucket"
 	MetricsMonitorPath                          = "/monitor"
+	HealthWebhookAwsPath                        = "/webhook/aws/health"
+	HealthWebhookAzurePath                      = "/webhook/azure/health"
 	GCPQuotaScope                               = "https://www.googleapis.com/auth/compute.readonly"
+	GCPServiceUsageScope                        = "https://www.googleapis.com/auth/cloud-platform.read-only"
+	GCPHealthScope                              = "https://www.googleapis.com/auth/cloud-platform"
 )